@@ -0,0 +1,107 @@
+package flightrecorder
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ErrForbidden is returned when the client address is not in the allowlist
+var ErrForbidden = errors.New("client address is not allowed")
+
+// loopbackCIDRs are the loopback ranges allowed by WithLocalOnly
+var loopbackCIDRs = []string{"127.0.0.0/8", "::1/128"}
+
+// WithAllowedCIDRs restricts the endpoints to clients in the CIDR ranges, e.g. "10.0.0.0/8",
+// responding 403 Forbidden to others. Single addresses are allowed as well. The client address is the
+// connection's remote address, X-Forwarded-For is not trusted, so behind a proxy the proxy's address is checked.
+// It panics on invalid ranges, like a mux on invalid patterns.
+func WithAllowedCIDRs(cidrs ...string) Option {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := parseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("flightrecorder: %v", err))
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return func(o *options) {
+		o.allowedCIDRs = append(o.allowedCIDRs, prefixes...)
+	}
+}
+
+// WithLocalOnly restricts the endpoints to clients on loopback addresses,
+// for services exposing them on a shared server or mux
+func WithLocalOnly() Option {
+	return WithAllowedCIDRs(loopbackCIDRs...)
+}
+
+// parseCIDR parses a CIDR range or a single address
+func parseCIDR(cidr string) (netip.Prefix, error) {
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid allowed address %q", cidr)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid allowed CIDR %q", cidr)
+	}
+	return prefix.Masked(), nil
+}
+
+// allowed reports whether the remote address of a request is in the allowlist.
+// Requests without an IP address, e.g. over a Unix socket, are not allowed.
+func (s *Service) allowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.opts.allowedCIDRs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// withAllowlist rejects requests from clients outside the allowlist
+func (s *Service) withAllowlist(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowed(r.RemoteAddr) {
+			writeError(w, http.StatusForbidden, ErrForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// Allowlist wraps a handler serving the recorder outside the routes, e.g. the ConnectRPC service,
+// with the allowlist of WithAllowedCIDRs. It returns h unchanged without an allowlist.
+func (s *Service) Allowlist(h http.Handler) http.Handler {
+	if len(s.opts.allowedCIDRs) == 0 {
+		return h
+	}
+	return s.withAllowlist(h.ServeHTTP)
+}
+
+// allowlistRoutes wraps the handlers of routes with the allowlist
+func (s *Service) allowlistRoutes(routes []Route) []Route {
+	if len(s.opts.allowedCIDRs) == 0 {
+		return routes
+	}
+	for i, route := range routes {
+		routes[i].Handler = s.withAllowlist(route.Handler)
+	}
+	return routes
+}
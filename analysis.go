@@ -0,0 +1,244 @@
+package flightrecorder
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// topFunctions is the number of functions reported by running time
+const topFunctions = 10
+
+// DurationBuckets are the upper bounds of the DurationStats histogram buckets
+var DurationBuckets = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// TraceSummary summarizes the goroutines, GC and scheduling of a snapshot
+type TraceSummary struct {
+	Duration          time.Duration  // time between the first and last event
+	Events            int            // number of events
+	Goroutines        int            // goroutines alive at the end of the trace
+	GoroutinesCreated int            // goroutines created during the trace
+	GoroutineStates   map[string]int // number of goroutines in each state at the end of the trace
+	GCCycles          int            // GC cycles started during the trace
+	GCPauses          DurationStats  // GC stop-the-world pauses
+	SchedLatency      DurationStats  // time goroutines spent runnable before running
+	TopFunctions      []FunctionTime // goroutine functions with the most running time
+	// GoroutinesByFunction counts the goroutines alive at the end of the trace by the function they were started with
+	GoroutinesByFunction map[string]int
+}
+
+// DurationStats summarizes a set of durations
+type DurationStats struct {
+	Count int
+	Total time.Duration
+	P50   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+	// Buckets counts the durations less than or equal to each of DurationBuckets
+	Buckets []int
+}
+
+// FunctionTime is the running time of the goroutines started by a function
+type FunctionTime struct {
+	Function string
+	Running  time.Duration
+}
+
+func newDurationStats(samples []time.Duration) DurationStats {
+	if len(samples) == 0 {
+		return DurationStats{Buckets: make([]int, len(DurationBuckets))}
+	}
+	slices.Sort(samples)
+
+	stats := DurationStats{
+		Count:   len(samples),
+		P50:     percentile(samples, 0.50),
+		P99:     percentile(samples, 0.99),
+		Max:     samples[len(samples)-1],
+		Buckets: make([]int, len(DurationBuckets)),
+	}
+	for _, d := range samples {
+		stats.Total += d
+		for i, bound := range DurationBuckets {
+			if d <= bound {
+				stats.Buckets[i]++
+			}
+		}
+	}
+	return stats
+}
+
+// percentile returns the percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// goroutineState tracks a goroutine while summarizing a trace
+type goroutineState struct {
+	state         trace.GoState
+	function      string
+	runnableSince trace.Time
+	runningSince  trace.Time
+}
+
+// Summarize parses a snapshot and summarizes it
+func Summarize(r io.Reader) (TraceSummary, error) {
+	reader, err := trace.NewReader(r)
+	if err != nil {
+		return TraceSummary{}, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	var (
+		summary      TraceSummary
+		first, last  trace.Time
+		goroutines   = make(map[trace.GoID]*goroutineState)
+		running      = make(map[string]time.Duration)
+		ranges       = make(map[string]trace.Time)
+		gcPauses     []time.Duration
+		schedLatency []time.Duration
+	)
+
+	for {
+		ev, err := reader.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return TraceSummary{}, fmt.Errorf("%w: after %d events: %v", ErrInvalidSnapshot, summary.Events, err)
+		}
+
+		now := ev.Time()
+		if summary.Events == 0 {
+			first = now
+		}
+		last = now
+		summary.Events++
+
+		switch ev.Kind() {
+		case trace.EventStateTransition:
+			st := ev.StateTransition()
+			if st.Resource.Kind != trace.ResourceGoroutine {
+				continue
+			}
+			from, to := st.Goroutine()
+
+			g := goroutines[st.Resource.Goroutine()]
+			if g == nil {
+				g = &goroutineState{}
+				goroutines[st.Resource.Goroutine()] = g
+			}
+			if g.function == "" {
+				g.function = rootFunction(goroutineStack(ev))
+			}
+			if from == trace.GoNotExist && to == trace.GoRunnable {
+				summary.GoroutinesCreated++
+			}
+
+			if from == trace.GoRunning && g.runningSince != 0 {
+				running[g.function] += now.Sub(g.runningSince)
+				g.runningSince = 0
+			}
+			switch to {
+			case trace.GoRunnable:
+				g.runnableSince = now
+			case trace.GoRunning:
+				if from == trace.GoRunnable && g.runnableSince != 0 {
+					schedLatency = append(schedLatency, now.Sub(g.runnableSince))
+				}
+				g.runnableSince = 0
+				g.runningSince = now
+			}
+			g.state = to
+
+		case trace.EventRangeBegin:
+			rng := ev.Range()
+			ranges[rng.Name+"/"+rng.Scope.String()] = now
+			if rng.Name == "GC concurrent mark phase" {
+				summary.GCCycles++
+			}
+
+		case trace.EventRangeEnd:
+			rng := ev.Range()
+			key := rng.Name + "/" + rng.Scope.String()
+			begin, ok := ranges[key]
+			delete(ranges, key)
+			if ok && strings.HasPrefix(rng.Name, "stop-the-world") && strings.Contains(rng.Name, "GC") {
+				gcPauses = append(gcPauses, now.Sub(begin))
+			}
+		}
+	}
+	if summary.Events == 0 {
+		return TraceSummary{}, fmt.Errorf("%w: trace has no events", ErrInvalidSnapshot)
+	}
+
+	summary.Duration = last.Sub(first)
+	summary.GoroutineStates = make(map[string]int)
+	summary.GoroutinesByFunction = make(map[string]int)
+	for _, g := range goroutines {
+		if g.runningSince != 0 {
+			running[g.function] += last.Sub(g.runningSince)
+		}
+		if g.state != trace.GoNotExist {
+			summary.Goroutines++
+			summary.GoroutineStates[g.state.String()]++
+			summary.GoroutinesByFunction[cmp.Or(g.function, unknownFunction)]++
+		}
+	}
+	summary.GCPauses = newDurationStats(gcPauses)
+	summary.SchedLatency = newDurationStats(schedLatency)
+
+	for function, d := range running {
+		summary.TopFunctions = append(summary.TopFunctions, FunctionTime{Function: function, Running: d})
+	}
+	slices.SortFunc(summary.TopFunctions, func(a, b FunctionTime) int {
+		return cmp.Or(cmp.Compare(b.Running, a.Running), strings.Compare(a.Function, b.Function))
+	})
+	if len(summary.TopFunctions) > topFunctions {
+		summary.TopFunctions = summary.TopFunctions[:topFunctions]
+	}
+	return summary, nil
+}
+
+// goroutineStack returns the stack of the goroutine making a state transition, if any. Status events
+// at the start of a generation and transitions the goroutine made itself carry it as the event's stack;
+// others, e.g. another goroutine unblocking it, carry the stack of whoever made the transition.
+func goroutineStack(ev trace.Event) trace.Stack {
+	st := ev.StateTransition()
+	if st.Stack != trace.NoStack {
+		return st.Stack
+	}
+	from, _ := st.Goroutine()
+	if from == trace.GoUndetermined || ev.Goroutine() == st.Resource.Goroutine() {
+		return ev.Stack()
+	}
+	return trace.NoStack
+}
+
+// rootFunction returns the function a goroutine was started with, the outermost frame of its stack
+func rootFunction(stack trace.Stack) string {
+	var root string
+	for frame := range stack.Frames() {
+		if frame.Func != "" && frame.Func != "runtime.goexit" {
+			root = frame.Func
+		}
+	}
+	return root
+}
@@ -0,0 +1,146 @@
+package flightrecorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	rtrace "runtime/trace"
+	"slices"
+	"time"
+)
+
+const (
+	// DefaultLogCategory is the trace log category of messages logged over HTTP without a category
+	DefaultLogCategory = "flightrecorder"
+	// MarkerLogCategory is the trace log category of markers
+	MarkerLogCategory = "marker"
+
+	// maxMarkers bounds the markers kept for snapshot metadata
+	maxMarkers = 100
+)
+
+// Marker is a labelled point in time, e.g. a deploy or the start of an incident,
+// recorded in the trace and in the metadata of snapshots covering it
+type Marker struct {
+	Label string    `json:"label"`
+	Time  time.Time `json:"time"`
+}
+
+// MarkRequest represents the mark request payload
+type MarkRequest struct {
+	Label string `json:"label"`
+}
+
+// LogRequest represents the log request payload
+type LogRequest struct {
+	Category string `json:"category,omitempty"`
+	Message  string `json:"message"`
+}
+
+// NewTask creates a trace task, which groups the regions and logs of an operation
+// across goroutines in snapshots. End the task when the operation completes.
+func (s *Service) NewTask(ctx context.Context, name string) (context.Context, *rtrace.Task) {
+	return rtrace.NewTask(ctx, name)
+}
+
+// StartRegion starts a trace region on the calling goroutine, which appears in snapshots
+// as a named span. End the region on the same goroutine.
+func (s *Service) StartRegion(ctx context.Context, name string) *rtrace.Region {
+	return rtrace.StartRegion(ctx, name)
+}
+
+// Log records a message in the trace, attributed to the task of ctx if any
+func (s *Service) Log(ctx context.Context, category, message string) {
+	rtrace.Log(ctx, category, message)
+}
+
+// Logf records a formatted message in the trace
+func (s *Service) Logf(ctx context.Context, category, format string, args ...any) {
+	rtrace.Logf(ctx, category, format, args...)
+}
+
+// Mark records a marker as a trace log event in the MarkerLogCategory and keeps it,
+// so snapshots taken while it is within the recorded window list it in their metadata
+func (s *Service) Mark(ctx context.Context, label string) (Marker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.recorder.Enabled() {
+		return Marker{}, ErrNotRunning
+	}
+
+	marker := Marker{Label: label, Time: time.Now()}
+	rtrace.Log(ctx, MarkerLogCategory, label)
+
+	s.markers = append(s.markers, marker)
+	if len(s.markers) > maxMarkers {
+		s.markers = slices.Delete(s.markers, 0, len(s.markers)-maxMarkers)
+	}
+	return marker, nil
+}
+
+// markersLocked returns the markers within the recorded window: since the recorder
+// was started or cleared and no older than the period, s.mu must be held
+func (s *Service) markersLocked(now time.Time) []Marker {
+	since := now.Add(-s.period)
+	if s.startedAt.After(since) {
+		since = s.startedAt
+	}
+
+	var markers []Marker
+	for _, m := range s.markers {
+		if !m.Time.Before(since) {
+			markers = append(markers, m)
+		}
+	}
+	return markers
+}
+
+func (s *Service) handleMark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req MarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest))
+		return
+	}
+	if req.Label == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: label is required", ErrInvalidRequest))
+		return
+	}
+
+	marker, err := s.Mark(r.Context(), req.Label)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, marker)
+}
+
+func (s *Service) handleLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest))
+		return
+	}
+	if req.Message == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: message is required", ErrInvalidRequest))
+		return
+	}
+	if req.Category == "" {
+		req.Category = DefaultLogCategory
+	}
+
+	s.Log(r.Context(), req.Category, req.Message)
+	w.WriteHeader(http.StatusNoContent)
+}
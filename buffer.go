@@ -0,0 +1,157 @@
+package flightrecorder
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BufferUtilization estimates how much history the flight recorder buffer retains, so users know whether
+// a snapshot taken now covers the last seconds they care about. The window grows from the start of the
+// recorder up to the period; once the buffer was measured at its size, which the recorder treats as a hint
+// taking precedence over the period, the window is the measured bytes over the rate the buffer filled at.
+// The buffer is measured by every snapshot and with WithBufferSampling.
+type BufferUtilization struct {
+	Window     Duration   `json:"window"`                // estimated time window a snapshot taken now covers
+	Full       bool       `json:"full"`                  // whether the buffer reached its period or size and discards older data
+	Bytes      ByteSize   `json:"bytes,omitempty"`       // size of the buffer when it was last measured
+	Percent    float64    `json:"percent,omitempty"`     // Bytes relative to the size of the recorder
+	MeasuredAt *time.Time `json:"measured_at,omitempty"` // when the buffer was last measured
+}
+
+// bufferWindowPrecision rounds the reported window, so the status only changes every so often while the buffer fills
+const bufferWindowPrecision = 100 * time.Millisecond
+
+// bufferMeasurement is the last measured size of the buffer, and the rate the buffer filled at since
+// the recorder was started at rateSince, in bytes per second
+type bufferMeasurement struct {
+	mu        sync.Mutex
+	bytes     int64
+	at        time.Time
+	rate      float64
+	rateSince time.Time
+}
+
+// recordBufferLocked records a measurement of the buffer, s.mu must be held
+func (s *Service) recordBufferLocked(bytes int64, at time.Time) {
+	m := &s.buffer
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bytes, m.at = bytes, at
+	// The rate is measured while the buffer still holds everything recorded since the start.
+	elapsed := at.Sub(s.startedAt)
+	if elapsed > 0 && (!m.rateSince.Equal(s.startedAt) || bytes < s.recordingSize && elapsed < s.recordingPeriod) {
+		m.rate, m.rateSince = float64(bytes)/elapsed.Seconds(), s.startedAt
+	}
+}
+
+func (m *bufferMeasurement) last() (bytes int64, at time.Time, rate float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.bytes, m.at, m.rate
+}
+
+// WithBufferSampling measures the size of the flight recorder buffer every interval, for the buffer utilization
+// of the status between snapshots. Measuring writes the buffer to a counting writer without copying it, but
+// snapshots taken meanwhile fail with ErrSnapshotInProgress, see WithSnapshotRetry.
+func WithBufferSampling(interval time.Duration) Option {
+	return func(o *options) {
+		o.bufferSampling = interval
+	}
+}
+
+// bufferUtilizationLocked estimates the utilization of the running recorder's buffer, s.mu must be held
+func (s *Service) bufferUtilizationLocked(now time.Time) *BufferUtilization {
+	if !s.recorder.Enabled() {
+		return nil
+	}
+
+	elapsed := now.Sub(s.startedAt)
+	u := &BufferUtilization{
+		Window: Duration(min(elapsed, s.recordingPeriod).Truncate(bufferWindowPrecision)),
+		Full:   elapsed >= s.recordingPeriod,
+	}
+
+	bytes, at, rate := s.buffer.last()
+	if at.Before(s.startedAt) || bytes == 0 {
+		return u
+	}
+	u.Bytes = ByteSize(bytes)
+	u.MeasuredAt = &at
+	if s.recordingSize > 0 {
+		u.Percent = float64(int(1000*float64(bytes)/float64(s.recordingSize))) / 10
+	}
+	if bytes >= s.recordingSize && rate > 0 {
+		window := time.Duration(float64(bytes) / rate * float64(time.Second))
+		u.Window = Duration(min(time.Duration(u.Window), window.Truncate(bufferWindowPrecision)))
+		u.Full = true
+	}
+	return u
+}
+
+// handleSnapshotHead answers HEAD /snapshot with the headers of a snapshot without taking it or writing the buffer,
+// so probes don't make concurrent snapshots fail. The Content-Length is the size of the buffer when it was last
+// measured, an estimate, omitted when snapshot filters or ?last change the size of the snapshot.
+func (s *Service) handleSnapshotHead(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	running := s.recorder.Enabled()
+	bytes, at, _ := s.buffer.last()
+	measured := !at.Before(s.startedAt) && bytes > 0
+	s.mu.RUnlock()
+
+	if !running {
+		writeError(w, http.StatusInternalServerError, ErrNotRunning)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if measured && len(s.opts.filters) == 0 && r.URL.Query().Get("last") == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(bytes, 10))
+	}
+	w.Header().Set(HeaderSnapshotGoVersion, s.build.GoVersion)
+	w.WriteHeader(http.StatusOK)
+}
+
+// runBufferSampler measures the buffer every interval until ctx is done
+func (s *Service) runBufferSampler(ctx context.Context) {
+	defer s.health.bufferSampler.Store(false)
+
+	ticker := time.NewTicker(s.opts.bufferSampling)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.measureBuffer()
+		}
+	}
+}
+
+// measureBuffer measures the size of the running recorder's buffer, skipping it while a snapshot is in progress
+func (s *Service) measureBuffer() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.recorder.Enabled() {
+		return
+	}
+	var w countingWriter
+	if _, err := s.recorder.WriteTo(&w); err == nil {
+		s.recordBufferLocked(w.n, time.Now())
+	}
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,47 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSnapshotHeadUsesLastMeasurement(t *testing.T) {
+	s := NewService()
+	t.Cleanup(func() { s.Close() })
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	head := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodHead, target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("HEAD %s: got %d, want 200", target, w.Code)
+		}
+		return w
+	}
+
+	if w := head("/recorder/snapshot"); w.Header().Get("Content-Length") != "" {
+		t.Fatalf("Content-Length %s before the buffer was measured", w.Header().Get("Content-Length"))
+	}
+	if _, at, _ := s.buffer.last(); !at.IsZero() {
+		t.Fatal("HEAD /snapshot measured the buffer")
+	}
+
+	if _, err := s.Capture("manual"); err != nil {
+		t.Fatal(err)
+	}
+	bytes, at, _ := s.buffer.last()
+	if w := head("/recorder/snapshot"); w.Header().Get("Content-Length") == "" || w.Header().Get("Content-Length") == "0" {
+		t.Fatalf("Content-Length %q, want the %d bytes measured by the snapshot", w.Header().Get("Content-Length"), bytes)
+	}
+	if _, after, _ := s.buffer.last(); !after.Equal(at) {
+		t.Fatal("HEAD /snapshot measured the buffer")
+	}
+	if w := head("/recorder/snapshot?last=1s"); w.Header().Get("Content-Length") != "" {
+		t.Fatalf("Content-Length %s with ?last, which changes the size of the snapshot", w.Header().Get("Content-Length"))
+	}
+}
@@ -0,0 +1,50 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// BuildInfo describes the process producing the status and snapshots.
+// Trace tooling compatibility depends on the Go version which produced a trace.
+type BuildInfo struct {
+	GoVersion   string `json:"go_version"`
+	Module      string `json:"module,omitempty"`       // path of the main module
+	Version     string `json:"version,omitempty"`      // version of the main module, (devel) for local builds
+	VCSRevision string `json:"vcs_revision,omitempty"` // commit the binary was built from
+	VCSModified bool   `json:"vcs_modified,omitempty"` // whether the working tree had uncommitted changes
+	Hostname    string `json:"hostname"`
+	PID         int    `json:"pid"`
+}
+
+// readBuildInfo returns the build info of the running binary
+func readBuildInfo(hostname string, pid int) BuildInfo {
+	b := BuildInfo{GoVersion: runtime.Version(), Hostname: hostname, PID: pid}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Module, b.Version = info.Main.Path, info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			b.VCSRevision = setting.Value
+		case "vcs.modified":
+			b.VCSModified = setting.Value == "true"
+		}
+	}
+	return b
+}
+
+// BuildInfo returns the build info of the process
+func (s *Service) BuildInfo() BuildInfo {
+	return s.build
+}
+
+// setGoVersionHeader sets the Go version header of snapshots which record it
+func setGoVersionHeader(w http.ResponseWriter, meta SnapshotMeta) {
+	if meta.Build != nil {
+		w.Header().Set(HeaderSnapshotGoVersion, meta.Build.GoVersion)
+	}
+}
@@ -0,0 +1,141 @@
+package flightrecorder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"time"
+)
+
+// EnvironmentSummary describes the process and host a bundle was taken from
+type EnvironmentSummary struct {
+	GoVersion    string    `json:"go_version"`
+	GOOS         string    `json:"goos"`
+	GOARCH       string    `json:"goarch"`
+	NumCPU       int       `json:"num_cpu"`
+	GOMAXPROCS   int       `json:"gomaxprocs"`
+	NumGoroutine int       `json:"num_goroutine"`
+	Hostname     string    `json:"hostname"`
+	PID          int       `json:"pid"`
+	Executable   string    `json:"executable"`
+	Time         time.Time `json:"time"`
+}
+
+func environmentSummary() EnvironmentSummary {
+	hostname, _ := os.Hostname()
+	executable, _ := os.Executable()
+	return EnvironmentSummary{
+		GoVersion:    runtime.Version(),
+		GOOS:         runtime.GOOS,
+		GOARCH:       runtime.GOARCH,
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumGoroutine: runtime.NumGoroutine(),
+		Hostname:     hostname,
+		PID:          os.Getpid(),
+		Executable:   executable,
+		Time:         time.Now(),
+	}
+}
+
+// Bundle writes a tar.gz diagnostic bundle to w.
+// The bundle contains the trace snapshot, runtime.MemStats, a goroutine stack
+// dump, build info and an environment summary. When no snapshot can be taken
+// (e.g. the flight recorder is stopped), the reason is written to trace.err
+// and the remaining files are still included.
+func (s *Service) Bundle(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	addFile := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write bundle header for %s: %w", name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write bundle file %s: %w", name, err)
+		}
+		return nil
+	}
+
+	snapshot, err := s.Snapshot()
+	if err != nil {
+		err = addFile("trace.err", []byte(err.Error()+"\n"))
+	} else {
+		err = addFile("trace.out", snapshot)
+	}
+	if err != nil {
+		return err
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	memStatsJSON, err := json.MarshalIndent(memStats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal memstats: %w", err)
+	}
+	if err := addFile("memstats.json", memStatsJSON); err != nil {
+		return err
+	}
+
+	var goroutines bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&goroutines, 2); err != nil {
+		return fmt.Errorf("failed to dump goroutines: %w", err)
+	}
+	if err := addFile("goroutines.txt", goroutines.Bytes()); err != nil {
+		return err
+	}
+
+	buildInfo := "build info not available\n"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		buildInfo = info.String()
+	}
+	if err := addFile("buildinfo.txt", []byte(buildInfo)); err != nil {
+		return err
+	}
+
+	envJSON, err := json.MarshalIndent(environmentSummary(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal environment: %w", err)
+	}
+	if err := addFile("env.json", envJSON); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close bundle: %w", err)
+	}
+	return gz.Close()
+}
+
+func (s *Service) handleBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.Bundle(&buf); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	filename := fmt.Sprintf("flightrecorder-bundle-%d.tar.gz", time.Now().Unix())
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(buf.Bytes())
+}
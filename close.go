@@ -0,0 +1,72 @@
+package flightrecorder
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned when using a service after Close
+var ErrClosed = errors.New("flight recorder service is closed")
+
+// background tracks the background goroutines of the service, so Close can wait for them
+type background struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+// goBackground runs f in a background goroutine, unless the service is closed
+func (s *Service) goBackground(f func()) bool {
+	s.background.mu.Lock()
+	defer s.background.mu.Unlock()
+
+	if s.background.closed {
+		return false
+	}
+	s.background.wg.Go(f)
+	return true
+}
+
+// Close tears the service down: it stops the flight recorder, cancels all background goroutines
+// started by the service and waits for them, closes event subscriptions and removes the spill files
+// of stored snapshots. Closing the recorder is not persisted to the state file, so recording resumes
+// after a restart. Close is safe to call more than once.
+func (s *Service) Close() error {
+	s.background.mu.Lock()
+	if s.background.closed {
+		s.background.mu.Unlock()
+		return nil
+	}
+	s.background.closed = true
+	s.background.mu.Unlock()
+
+	s.cancel()
+
+	var err error
+	s.mu.Lock()
+	if s.recorder.Enabled() {
+		err = s.recorder.Stop()
+		s.publishStatusLocked(EventStopped)
+	}
+	s.endSessionLocked()
+	s.mu.Unlock()
+
+	s.background.wg.Wait()
+	s.events.close()
+	s.store.removeSpilled()
+	return err
+}
+
+// ResetService closes the global service, so the next InitService creates a new one
+// with its options, e.g. for tests or to enable the recorder only during a diagnostic window.
+func ResetService() error {
+	serviceMu.Lock()
+	defer serviceMu.Unlock()
+
+	if service == nil {
+		return nil
+	}
+	err := service.Close()
+	service = nil
+	return err
+}
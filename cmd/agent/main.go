@@ -0,0 +1,306 @@
+// Command agent is a sidecar for applications embedding the flight recorder service.
+//
+// The execution tracer only records the process it runs in, so the recorder itself must be
+// embedded in the application. The agent drives it from the outside:
+//
+//   - exposes the application's control API on its own port (proxied to -target)
+//   - scrapes a Prometheus metric from the application and snapshots when it exceeds -threshold
+//   - writes snapshots to a directory, e.g. a mounted volume, or the sink of -plugins
+//   - runs the triggers and notifiers of -plugins, including out-of-process plugins (see pluginrpc)
+//   - serves /healthz (agent alive) and /readyz (application recorder reachable)
+//   - on SIGTERM flushes a final snapshot before shutting down
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+	"github.com/mcwalrus/http-flight-recorder/flightrecorder/pluginrpc"
+)
+
+type agent struct {
+	target    *url.URL
+	client    *http.Client
+	sink      flightrecorder.Sink
+	notifiers []flightrecorder.Notifier
+	metricURL string
+	metric    string
+	threshold float64
+	cooldown  time.Duration
+}
+
+// snapshot downloads a snapshot from the application and writes it to the sink
+func (a *agent) snapshot(ctx context.Context, trigger string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.target.JoinPath("snapshot").String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server error: %s", strings.TrimSpace(string(data)))
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("snapshot_%s_%d.trace", trigger, now.Unix())
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+
+	meta := flightrecorder.SnapshotMeta{
+		Name:      name,
+		CreatedAt: now,
+		Size:      int64(len(data)),
+		Trigger:   trigger,
+	}
+	if err := a.sink.Write(ctx, meta, data); err != nil {
+		return err
+	}
+	log.Printf("Snapshot saved to %s (%d bytes, trigger %s)", name, len(data), trigger)
+	a.notify(ctx, flightrecorder.Event{Type: flightrecorder.EventTriggerFired, Time: now, Trigger: trigger, Snapshot: &meta})
+	return nil
+}
+
+// notify notifies the notifiers of -plugins of an event
+func (a *agent) notify(ctx context.Context, e flightrecorder.Event) {
+	for _, n := range a.notifiers {
+		if err := n.Notify(ctx, e); err != nil {
+			log.Printf("Error: notifier: %v", err)
+		}
+	}
+}
+
+// loadPlugins configures the agent with the sink, triggers and notifiers of a plugin configuration file.
+// Triggers are run until ctx is done and snapshot with their name as trigger, at most once per cooldown.
+func (a *agent) loadPlugins(ctx context.Context, path string) error {
+	config, err := flightrecorder.LoadPluginConfig(path)
+	if err != nil {
+		return err
+	}
+	plugins, err := config.Instantiate()
+	if err != nil {
+		return err
+	}
+	if plugins.Sink != nil {
+		a.sink = plugins.Sink
+	}
+	a.notifiers = plugins.Notifiers
+	for _, t := range plugins.Triggers {
+		var last time.Time
+		go t.Trigger.Run(ctx, func() {
+			if time.Since(last) < t.Cooldown {
+				return
+			}
+			last = time.Now()
+			if err := a.snapshot(ctx, t.Name); err != nil {
+				log.Printf("Error: %v", err)
+			}
+		})
+	}
+	return nil
+}
+
+// scrape returns the largest value of the metric across its series
+func (a *agent) scrape(ctx context.Context) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.metricURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to scrape metrics: %w", err)
+	}
+	defer resp.Body.Close()
+
+	value, found := math.Inf(-1), false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, a.metric) {
+			continue
+		}
+		rest := line[len(a.metric):]
+		if !strings.HasPrefix(rest, " ") && !strings.HasPrefix(rest, "{") {
+			continue // a different metric sharing the prefix
+		}
+		if i := strings.LastIndex(rest, "}"); i >= 0 {
+			rest = rest[i+1:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		value, found = max(value, v), true
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read metrics: %w", err)
+	}
+	if !found {
+		return 0, fmt.Errorf("metric %s not found", a.metric)
+	}
+	return value, nil
+}
+
+// watch scrapes the metric every interval and snapshots when it exceeds the threshold
+func (a *agent) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		value, err := a.scrape(ctx)
+		if err != nil {
+			log.Printf("Error: %v", err)
+			continue
+		}
+		if value <= a.threshold || time.Since(last) < a.cooldown {
+			continue
+		}
+
+		log.Printf("Metric %s = %g exceeds threshold %g", a.metric, value, a.threshold)
+		if err := a.snapshot(ctx, "metric"); err != nil {
+			log.Printf("Error: %v", err)
+			continue
+		}
+		last = time.Now()
+	}
+}
+
+func (a *agent) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+func (a *agent) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, a.target.JoinPath("status").String(), nil)
+	if err == nil {
+		var resp *http.Response
+		resp, err = a.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("recorder status returned %s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+func main() {
+	listen := flag.String("listen", ":9090", "agent listen address")
+	target := flag.String("target", "http://localhost:8080/recorder", "base URL of the application's flight recorder endpoints")
+	dir := flag.String("dir", "/snapshots", "directory snapshots are written to")
+	metricsURL := flag.String("metrics-url", "", "Prometheus metrics URL of the application (disables metric triggers when empty)")
+	metric := flag.String("metric", "", "metric name compared against -threshold")
+	threshold := flag.Float64("threshold", 0, "snapshot when the metric exceeds this value")
+	interval := flag.Duration("interval", 15*time.Second, "metric scrape interval")
+	cooldown := flag.Duration("cooldown", 5*time.Minute, "minimum time between two metric-triggered snapshots")
+	flushOnExit := flag.Bool("flush-on-exit", true, "write a final snapshot on SIGTERM")
+	plugins := flag.String("plugins", "", "JSON plugin configuration of the sink, replacing -dir, triggers and notifiers")
+	flag.Parse()
+
+	targetURL, err := url.Parse(*target)
+	if err != nil {
+		log.Fatal("Invalid target:", err)
+	}
+
+	a := &agent{
+		target:    targetURL,
+		client:    &http.Client{Timeout: 60 * time.Second},
+		sink:      flightrecorder.NewFileSink(*dir),
+		metricURL: *metricsURL,
+		metric:    *metric,
+		threshold: *threshold,
+		cooldown:  *cooldown,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *plugins != "" {
+		defer pluginrpc.Cleanup()
+		if err := a.loadPlugins(ctx, *plugins); err != nil {
+			pluginrpc.Cleanup()
+			log.Fatal("Invalid plugins: ", err)
+		}
+	}
+
+	if a.metricURL != "" && a.metric != "" {
+		go a.watch(ctx, *interval)
+	}
+
+	// Proxy the control API, so it is reachable on the agent's port.
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL = targetURL.JoinPath(strings.TrimPrefix(r.In.URL.Path, "/recorder"))
+			r.Out.URL.RawQuery = r.In.URL.RawQuery
+			r.Out.Host = targetURL.Host
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/recorder/", proxy)
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+
+	server := &http.Server{Addr: *listen, Handler: mux}
+	go func() {
+		log.Printf("Starting agent on %s for %s\n", *listen, targetURL)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Server failed to start:", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Received signal to stop agent")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if *flushOnExit {
+		if err := a.snapshot(shutdownCtx, "shutdown"); err != nil {
+			log.Printf("Error: final snapshot: %v", err)
+		}
+	}
+	server.Shutdown(shutdownCtx)
+}
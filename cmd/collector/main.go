@@ -0,0 +1,267 @@
+// Command collector is a reference collector for snapshots uploaded by flightrecorder.HTTPSink.
+//
+// It stores uploads under a directory and lists them:
+//
+//	POST /snapshots          upload a snapshot (body is the trace, metadata in X-Snapshot-* headers)
+//	POST /stream             receive snapshots streamed by flightrecorder.HTTPStreamSink, e.g. continuous export
+//	GET  /snapshots          list uploaded snapshots, ?label=key=value filters by labels
+//	GET  /snapshots/{name...} download an uploaded snapshot
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+)
+
+const metaSuffix = ".meta.json"
+
+// Upload describes a snapshot received by the collector
+type Upload struct {
+	Name       string    `json:"name"`
+	ID         string    `json:"id"`
+	Trigger    string    `json:"trigger"`
+	Hostname   string    `json:"hostname"`
+	CreatedAt  string    `json:"created_at"`
+	ReceivedAt time.Time `json:"received_at"`
+	RemoteAddr string    `json:"remote_addr"`
+	Size       int64     `json:"size"`
+	GoVersion  string    `json:"go_version,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type collector struct {
+	dir     string
+	token   string
+	maxSize int64
+}
+
+func (c *collector) authorized(r *http.Request) bool {
+	if c.token == "" {
+		return true
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(c.token)) == 1
+}
+
+// path resolves an upload name to a path under the collector directory
+func (c *collector) path(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) || strings.HasSuffix(name, metaSuffix) {
+		return "", fmt.Errorf("invalid snapshot name: %q", name)
+	}
+	return filepath.Join(c.dir, name), nil
+}
+
+func (c *collector) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if !c.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	upload := Upload{
+		Name:       r.Header.Get(flightrecorder.HeaderSnapshotName),
+		ID:         r.Header.Get(flightrecorder.HeaderSnapshotID),
+		Trigger:    r.Header.Get(flightrecorder.HeaderSnapshotTrigger),
+		Hostname:   r.Header.Get(flightrecorder.HeaderSnapshotHostname),
+		CreatedAt:  r.Header.Get(flightrecorder.HeaderSnapshotCreatedAt),
+		RemoteAddr: r.RemoteAddr,
+		GoVersion:  r.Header.Get(flightrecorder.HeaderSnapshotGoVersion),
+	}
+	if labels, err := url.ParseQuery(r.Header.Get(flightrecorder.HeaderSnapshotLabels)); err == nil && len(labels) > 0 {
+		upload.Labels = make(map[string]string, len(labels))
+		for key := range labels {
+			upload.Labels[key] = labels.Get(key)
+		}
+	}
+
+	upload, status, err := c.save(upload, http.MaxBytesReader(w, r.Body, c.maxSize))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(upload)
+}
+
+// handleStream stores the snapshots streamed by flightrecorder.HTTPStreamSink, until the stream ends
+func (c *collector) handleStream(w http.ResponseWriter, r *http.Request) {
+	if !c.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var received int
+	err := flightrecorder.ReadSnapshotStream(r.Body, func(meta flightrecorder.SnapshotMeta, body io.Reader) error {
+		if meta.Size > c.maxSize {
+			return fmt.Errorf("snapshot %s of %d bytes exceeds the maximum size", meta.ID, meta.Size)
+		}
+		upload := Upload{
+			Name:       meta.Name,
+			ID:         meta.ID,
+			Trigger:    meta.Trigger,
+			CreatedAt:  meta.CreatedAt.UTC().Format(time.RFC3339Nano),
+			RemoteAddr: r.RemoteAddr,
+			Labels:     meta.Labels,
+		}
+		if meta.Build != nil {
+			upload.Hostname, upload.GoVersion = meta.Build.Hostname, meta.Build.GoVersion
+		}
+		if _, _, err := c.save(upload, body); err != nil {
+			return err
+		}
+		received++
+		return nil
+	})
+	if err != nil {
+		log.Printf("Stream from %s failed after %d snapshots: %v", r.RemoteAddr, received, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"received": received})
+}
+
+// save stores a snapshot and its metadata, it returns the status of the failure on errors
+func (c *collector) save(upload Upload, body io.Reader) (Upload, int, error) {
+	if upload.Name == "" {
+		upload.Name = fmt.Sprintf("snapshot_%d.trace", time.Now().UnixNano())
+	}
+	path, err := c.path(upload.Name)
+	if err != nil {
+		return upload, http.StatusBadRequest, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return upload, http.StatusInternalServerError, err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return upload, http.StatusInternalServerError, err
+	}
+	size, err := io.Copy(f, body)
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return upload, http.StatusBadRequest, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return upload, http.StatusInternalServerError, err
+	}
+
+	upload.Name = filepath.ToSlash(upload.Name)
+	upload.ReceivedAt = time.Now().UTC()
+	upload.Size = size
+	meta, _ := json.MarshalIndent(upload, "", "  ")
+	if err := os.WriteFile(path+metaSuffix, meta, 0644); err != nil {
+		return upload, http.StatusInternalServerError, err
+	}
+
+	log.Printf("Received %s (%d bytes) from %s", upload.Name, size, upload.Hostname)
+	return upload, 0, nil
+}
+
+func (c *collector) handleList(w http.ResponseWriter, r *http.Request) {
+	if !c.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Each ?label=key=value must match the labels of the upload.
+	var filters [][2]string
+	for _, filter := range r.URL.Query()["label"] {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid label filter %q, expected key=value", filter), http.StatusBadRequest)
+			return
+		}
+		filters = append(filters, [2]string{key, value})
+	}
+
+	uploads := []Upload{}
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, metaSuffix) {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var upload Upload
+		if err := json.Unmarshal(data, &upload); err != nil {
+			return nil // skip unreadable metadata
+		}
+		for _, filter := range filters {
+			if upload.Labels[filter[0]] != filter[1] {
+				return nil
+			}
+		}
+		uploads = append(uploads, upload)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].ReceivedAt.Before(uploads[j].ReceivedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploads)
+}
+
+func (c *collector) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if !c.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path, err := c.path(r.PathValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, path)
+}
+
+func main() {
+	addr := flag.String("addr", ":8090", "listen address")
+	dir := flag.String("dir", "snapshots", "directory uploads are stored in")
+	token := flag.String("token", os.Getenv("COLLECTOR_TOKEN"), "bearer token required from uploaders (default $COLLECTOR_TOKEN)")
+	maxSize := flag.Int64("max-size", 1<<30, "maximum snapshot size in bytes")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatal("Failed to create snapshot directory:", err)
+	}
+
+	c := &collector{dir: *dir, token: *token, maxSize: *maxSize}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /snapshots", c.handleUpload)
+	mux.HandleFunc("POST /stream", c.handleStream)
+	mux.HandleFunc("GET /snapshots", c.handleList)
+	mux.HandleFunc("GET /snapshots/{name...}", c.handleDownload)
+
+	log.Printf("Starting collector on %s, storing snapshots in %s\n", *addr, *dir)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		log.Fatal("Server failed to start:", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+	"github.com/mcwalrus/http-flight-recorder/flightrecorder/client"
+)
+
+// defaultKeyEnv is the environment variable holding the base64 encoded snapshot encryption key
+const defaultKeyEnv = "FLIGHTREC_KEY"
+
+// runDownload downloads a stored snapshot, decrypting it when it is encrypted
+func runDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	out := fs.String("o", "", "output path (default <id>.trace)")
+	keyEnv := fs.String("key-env", defaultKeyEnv, "environment variable with the base64 encoded encryption key")
+	raw := fs.Bool("raw", false, "keep encrypted snapshots encrypted")
+	timeout := fs.Duration("timeout", 2*time.Minute, "timeout of the download")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flightctl download [flags] <base-url> <id>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("download expects a base URL and a snapshot ID")
+	}
+	baseURL, id := fs.Arg(0), fs.Arg(1)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	c := client.New(baseURL)
+	c.BearerToken = os.Getenv("RECORDER_TOKEN")
+	var buf bytes.Buffer
+	if _, err := c.DownloadSnapshot(ctx, id, &buf); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	path := *out
+	if path == "" {
+		path = id + ".trace"
+	}
+	if flightrecorder.IsEncrypted(data) && !*raw {
+		var err error
+		if data, err = decrypt(data, *keyEnv); err != nil {
+			return err
+		}
+	} else if flightrecorder.IsEncrypted(data) && *out == "" {
+		path += flightrecorder.EncryptedSuffix
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%d bytes)\n", path, len(data))
+	return nil
+}
+
+// runDecrypt decrypts an encrypted snapshot file, e.g. written by a file sink
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	out := fs.String("o", "", "output path (default the input path without .enc)")
+	keyEnv := fs.String("key-env", defaultKeyEnv, "environment variable with the base64 encoded encryption key")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flightctl decrypt [flags] <snapshot.enc>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("decrypt expects one snapshot")
+	}
+
+	in := fs.Arg(0)
+	path := *out
+	if path == "" {
+		trimmed, ok := strings.CutSuffix(in, flightrecorder.EncryptedSuffix)
+		if !ok {
+			return errors.New("decrypt expects a .enc file or -o")
+		}
+		path = trimmed
+	}
+
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	if data, err = decrypt(data, *keyEnv); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%d bytes)\n", path, len(data))
+	return nil
+}
+
+// readSnapshot reads a snapshot file, decrypting it with the key of FLIGHTREC_KEY when it is encrypted
+func readSnapshot(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if flightrecorder.IsEncrypted(data) {
+		return decrypt(data, defaultKeyEnv)
+	}
+	return data, nil
+}
+
+func decrypt(data []byte, keyEnv string) ([]byte, error) {
+	key, err := flightrecorder.DecodeKey(os.Getenv(keyEnv))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot is encrypted, set %s: %w", keyEnv, err)
+	}
+	return flightrecorder.DecryptSnapshot(key, data)
+}
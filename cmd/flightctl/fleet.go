@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mcwalrus/http-flight-recorder/flightrecorder/client"
+)
+
+// runFleet runs a command on every target of a fleet, resolved from the arguments,
+// a targets file, Kubernetes pods or a Consul service
+func runFleet(args []string) error {
+	fs := flag.NewFlagSet("fleet", flag.ContinueOnError)
+	targetsFile := fs.String("targets", "", "file with one base URL per line")
+	selector := fs.String("k8s", "", "Kubernetes label selector of the pods, e.g. app=checkout")
+	namespace := fs.String("namespace", "", "namespace of the pods (default the in-cluster namespace)")
+	apiServer := fs.String("api-server", "", "Kubernetes API server URL, e.g. http://localhost:8001 from kubectl proxy (default in-cluster)")
+	consul := fs.String("consul", "", "Consul service name")
+	consulAddr := fs.String("consul-addr", "", "Consul agent URL (default http://127.0.0.1:8500)")
+	port := fs.Int("port", 8080, "port of the endpoints on discovered targets")
+	prefix := fs.String("prefix", "/recorder", "prefix of the endpoints on discovered targets")
+	parallelism := fs.Int("parallelism", 8, "maximum number of targets called at once")
+	out := fs.String("o", "", "snapshot archive path (default fleet-<time>.tar.gz)")
+	timeout := fs.Duration("timeout", 2*time.Minute, "timeout of the command")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flightctl fleet <start|stop|status|snapshot> [flags] [base-url...]")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return errors.New("fleet expects a command")
+	}
+	command := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	fleet := client.NewFleetClient(fs.Args())
+	fleet.Parallelism = *parallelism
+	if token := os.Getenv("RECORDER_TOKEN"); token != "" {
+		fleet.NewClient = func(baseURL string) *client.Client {
+			c := client.New(baseURL)
+			c.BearerToken = token
+			return c
+		}
+	}
+	switch {
+	case *targetsFile != "":
+		fleet.Resolver = client.StaticFile{Path: *targetsFile}
+	case *selector != "":
+		fleet.Resolver = client.KubernetesPods{Namespace: *namespace, Selector: *selector, Port: *port, Prefix: *prefix,
+			APIServer: *apiServer, Token: os.Getenv("KUBE_TOKEN")}
+	case *consul != "":
+		fleet.Resolver = client.ConsulService{Service: *consul, Address: *consulAddr, Prefix: *prefix, Token: os.Getenv("CONSUL_HTTP_TOKEN")}
+	case len(fleet.Targets) == 0:
+		return errors.New("fleet expects base URLs, -targets, -k8s or -consul")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	var results []client.Result
+	var err error
+	switch command {
+	case "start":
+		results, err = fleet.Start(ctx)
+	case "stop":
+		results, err = fleet.Stop(ctx)
+	case "status":
+		results, err = fleet.Status(ctx)
+	case "snapshot":
+		path := *out
+		if path == "" {
+			path = fmt.Sprintf("fleet-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+		}
+		f, createErr := os.Create(path)
+		if createErr != nil {
+			return createErr
+		}
+		defer f.Close()
+		results, err = fleet.Snapshot(ctx, f)
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		fmt.Printf("Wrote %s\n\n", path)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown fleet command %q", command)
+	}
+	if results == nil && err != nil {
+		return err
+	}
+
+	writeResults(results)
+	warnSharedInstances(results)
+	if err != nil {
+		return fmt.Errorf("%d of %d targets failed", countFailed(results), len(results))
+	}
+	return nil
+}
+
+func writeResults(results []client.Result) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "target\tinstance\tresult")
+	for _, r := range results {
+		instance := r.Instance
+		if instance == "" {
+			instance = "-"
+		}
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(tw, "%s\t%s\terror: %v\n", r.Target, instance, r.Err)
+		case r.Status != nil:
+			fmt.Fprintf(tw, "%s\t%s\tenabled=%t period=%v size=%s\n", r.Target, instance, r.Status.Enabled, r.Status.Period, r.Status.Size)
+		case r.Name != "":
+			fmt.Fprintf(tw, "%s\t%s\t%s (%d bytes)\n", r.Target, instance, r.Name, r.Size)
+		default:
+			fmt.Fprintf(tw, "%s\t%s\tok\n", r.Target, instance)
+		}
+	}
+	tw.Flush()
+}
+
+// warnSharedInstances warns about targets answered by the same instance, e.g. addresses of a load
+// balancer, whose results are of one replica rather than one each
+func warnSharedInstances(results []client.Result) {
+	seen := make(map[string]string)
+	for _, r := range results {
+		if r.Instance == "" {
+			continue
+		}
+		if first, ok := seen[r.Instance]; ok {
+			fmt.Fprintf(os.Stderr, "Warning: %s and %s were answered by the same instance %s\n", first, r.Target, r.Instance)
+			continue
+		}
+		seen[r.Instance] = r.Target
+	}
+}
+
+func countFailed(results []client.Result) int {
+	var failed int
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,217 @@
+// Command flightctl works with flight recorder snapshots offline and controls fleets of services.
+//
+// Usage:
+//
+//	flightctl summary <snapshot>
+//	flightctl diff <snapshot-a> <snapshot-b>
+//	flightctl convert [-o file] <snapshot>
+//	flightctl merge [-o file] [-summary] <snapshot>...
+//	flightctl download [-o file] <base-url> <id>
+//	flightctl decrypt [-o file] <snapshot.enc>
+//	flightctl verify [-sig file | -sha256 sum] <snapshot>
+//	flightctl keygen
+//	flightctl fleet <start|stop|status|snapshot> [-targets file | -k8s selector | -consul service] [base-url...]
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+)
+
+const usage = `Usage:
+  flightctl summary <snapshot>               summarize a snapshot
+  flightctl diff <snapshot-a> <snapshot-b>   compare two snapshots
+  flightctl convert <snapshot>               convert a snapshot to an approximate pprof CPU profile
+  flightctl merge <snapshot>...              merge sequential snapshots into one timeline
+  flightctl download <base-url> <id>         download a stored snapshot, decrypting it with $FLIGHTREC_KEY
+  flightctl decrypt <snapshot.enc>           decrypt a snapshot with $FLIGHTREC_KEY
+  flightctl verify <snapshot>                verify a snapshot's signature with $FLIGHTREC_VERIFY_KEY
+  flightctl keygen                           generate a snapshot signing key pair
+  flightctl fleet <command> [flags]          start, stop, check or snapshot every target of a fleet
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "summary":
+		err = runSummary(args)
+	case "diff":
+		err = runDiff(args)
+	case "convert":
+		err = runConvert(args)
+	case "merge":
+		err = runMerge(args)
+	case "fleet":
+		err = runFleet(args)
+	case "download":
+		err = runDownload(args)
+	case "decrypt":
+		err = runDecrypt(args)
+	case "verify":
+		err = runVerify(args)
+	case "keygen":
+		err = runKeygen(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func runSummary(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("summary expects one snapshot")
+	}
+	data, err := readSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+
+	summary, err := flightrecorder.Summarize(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return writeSummary(os.Stdout, summary)
+}
+
+func runDiff(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("diff expects two snapshots")
+	}
+	a, err := readSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := readSnapshot(args[1])
+	if err != nil {
+		return err
+	}
+
+	report, err := flightrecorder.CompareSnapshots(bytes.NewReader(a), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("A: %s\nB: %s\n\n", args[0], args[1])
+	return report.WriteText(os.Stdout)
+}
+
+// runConvert converts a snapshot to a pprof profile, for go tool pprof and flame graph tooling
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	out := fs.String("o", "", "output path (default the snapshot path with .pb.gz)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flightctl convert [flags] <snapshot>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("convert expects one snapshot")
+	}
+
+	in := fs.Arg(0)
+	data, err := readSnapshot(in)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := flightrecorder.ConvertToPprof(bytes.NewReader(data), &buf); err != nil {
+		return err
+	}
+
+	path := *out
+	if path == "" {
+		path = flightrecorder.ProfileName(in)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%d bytes)\n", path, buf.Len())
+	return nil
+}
+
+// runMerge merges sequential snapshots of a process into one trace, or summarizes the merged timeline
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	out := fs.String("o", "merged.trace", "output path")
+	summary := fs.Bool("summary", false, "print the summary of the merged timeline instead of writing it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flightctl merge [flags] <snapshot>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("merge expects snapshots")
+	}
+
+	snapshots := make([][]byte, fs.NArg())
+	for i, path := range fs.Args() {
+		data, err := readSnapshot(path)
+		if err != nil {
+			return err
+		}
+		snapshots[i] = data
+	}
+	merged, err := flightrecorder.MergeSnapshots(snapshots...)
+	if err != nil {
+		return err
+	}
+
+	if *summary {
+		s, err := flightrecorder.Summarize(bytes.NewReader(merged))
+		if err != nil {
+			return err
+		}
+		return writeSummary(os.Stdout, s)
+	}
+	if err := os.WriteFile(*out, merged, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%d bytes from %d snapshots)\n", *out, len(merged), len(snapshots))
+	return nil
+}
+
+func writeSummary(w io.Writer, s flightrecorder.TraceSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "duration\t%v\n", s.Duration)
+	fmt.Fprintf(tw, "events\t%d\n", s.Events)
+	fmt.Fprintf(tw, "goroutines\t%d (%d created)\n", s.Goroutines, s.GoroutinesCreated)
+	for _, state := range slices.Sorted(maps.Keys(s.GoroutineStates)) {
+		fmt.Fprintf(tw, "  %s\t%d\n", state, s.GoroutineStates[state])
+	}
+	fmt.Fprintf(tw, "gc cycles\t%d\n", s.GCCycles)
+	fmt.Fprintf(tw, "gc pauses\t%d, total %v, max %v\n", s.GCPauses.Count, s.GCPauses.Total, s.GCPauses.Max)
+	fmt.Fprintf(tw, "sched latency\tp50 %v, p99 %v, max %v\n", s.SchedLatency.P50, s.SchedLatency.P99, s.SchedLatency.Max)
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "function\trunning time")
+	for _, f := range s.TopFunctions {
+		fmt.Fprintf(tw, "%s\t%v\n", f.Function, f.Running)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+)
+
+// defaultVerifyKeyEnv is the environment variable holding the base64 encoded public key verifying snapshots
+const defaultVerifyKeyEnv = "FLIGHTREC_VERIFY_KEY"
+
+// runVerify verifies the checksum and signature of a snapshot file, e.g. written by a file sink
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	keyEnv := fs.String("key-env", defaultVerifyKeyEnv, "environment variable with the base64 encoded public key")
+	sigPath := fs.String("sig", "", "signature file (default <snapshot>.sig)")
+	sha256 := fs.String("sha256", "", "expected SHA-256, e.g. from the snapshot metadata, instead of a signature")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flightctl verify [flags] <snapshot>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("verify expects one snapshot")
+	}
+
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	checksum := flightrecorder.SnapshotChecksum(data)
+	fmt.Printf("sha256: %s\n", checksum)
+	if *sha256 != "" {
+		if *sha256 != checksum {
+			return fmt.Errorf("checksum mismatch: expected %s", *sha256)
+		}
+		fmt.Println("Checksum OK")
+		return nil
+	}
+
+	if *sigPath == "" {
+		*sigPath = path + flightrecorder.SignatureSuffix
+	}
+	signature, err := os.ReadFile(*sigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read signature: %w", err)
+	}
+	key, err := flightrecorder.DecodeVerifyKey(os.Getenv(*keyEnv))
+	if err != nil {
+		return fmt.Errorf("set %s: %w", *keyEnv, err)
+	}
+	if err := flightrecorder.VerifySnapshot(key, data, string(signature)); err != nil {
+		return err
+	}
+	fmt.Println("Signature OK")
+	return nil
+}
+
+// runKeygen generates an Ed25519 key pair for WithSnapshotSigning
+func runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flightctl keygen")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("signing key (keep secret): %s\n", base64.StdEncoding.EncodeToString(private.Seed()))
+	fmt.Printf("verify key:                %s\n", base64.StdEncoding.EncodeToString(public))
+	return nil
+}
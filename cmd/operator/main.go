@@ -0,0 +1,627 @@
+// Command operator is a reference Kubernetes operator for fleet-wide flight recordings.
+//
+// It reconciles FlightRecording resources: each one selects pods embedding the flight recorder,
+// and the operator drives their HTTP control API to record for the requested duration, then
+// uploads a snapshot of every pod to the recording's storage and reports the outcome in its status.
+//
+//	apiVersion: flightrecorder.dev/v1alpha1
+//	kind: FlightRecording
+//	metadata:
+//	  name: checkout-latency
+//	spec:
+//	  selector:
+//	    matchLabels:
+//	      app: checkout
+//	  duration: 30s
+//	  period: 10s
+//	  size: 128MB
+//	  storage:
+//	    url: http://collector.observability:8090/upload
+//
+// The operator talks to the Kubernetes API over plain HTTP (in-cluster service account, or
+// -api-server e.g. with kubectl proxy) and resyncs periodically instead of using watches,
+// to stay free of client libraries. Print the CRD and RBAC manifests with -print-manifests.
+// The period and size a recording sets are restored once its snapshots are taken, and
+// -pod-token-file authenticates the operator to pods serving the control API with WithAuth.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+)
+
+const (
+	group    = "flightrecorder.dev"
+	version  = "v1alpha1"
+	resource = "flightrecordings"
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// Phases of a FlightRecording
+const (
+	PhaseRecording = "Recording"
+	PhaseCompleted = "Completed"
+	PhaseFailed    = "Failed"
+)
+
+// FlightRecording is an on-demand recording of the pods matching a selector
+type FlightRecording struct {
+	Metadata ObjectMeta            `json:"metadata"`
+	Spec     FlightRecordingSpec   `json:"spec"`
+	Status   FlightRecordingStatus `json:"status,omitempty"`
+}
+
+// ObjectMeta holds the metadata fields the operator uses
+type ObjectMeta struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// FlightRecordingSpec describes the recording to take
+type FlightRecordingSpec struct {
+	Selector struct {
+		MatchLabels map[string]string `json:"matchLabels"`
+	} `json:"selector"`
+	Port     int    `json:"port,omitempty"`     // port of the control API (default 8080)
+	Prefix   string `json:"prefix,omitempty"`   // path prefix of the control API (default /recorder)
+	Duration string `json:"duration,omitempty"` // how long to record before taking snapshots (default 30s)
+	Period   string `json:"period,omitempty"`   // flight recorder period set before starting
+	Size     string `json:"size,omitempty"`     // flight recorder size set before starting
+	Stop     bool   `json:"stop,omitempty"`     // stop the recorders after taking snapshots
+	Storage  struct {
+		URL string `json:"url"` // HTTP collector snapshots are uploaded to
+	} `json:"storage"`
+}
+
+// FlightRecordingStatus reports the progress of a recording
+type FlightRecordingStatus struct {
+	Phase       string        `json:"phase,omitempty"`
+	Message     string        `json:"message,omitempty"`
+	StartedAt   *time.Time    `json:"startedAt,omitempty"`
+	CompletedAt *time.Time    `json:"completedAt,omitempty"`
+	Pods        []PodSnapshot `json:"pods,omitempty"`
+}
+
+// PodSnapshot is the outcome of recording a pod
+type PodSnapshot struct {
+	Pod      string `json:"pod"`
+	IP       string `json:"ip"`
+	Snapshot string `json:"snapshot,omitempty"` // name of the uploaded snapshot
+	Error    string `json:"error,omitempty"`
+	// Period and size of the pod's recorder before the recording changed them, restored once it completes
+	PreviousPeriod string `json:"previousPeriod,omitempty"`
+	PreviousSize   string `json:"previousSize,omitempty"`
+}
+
+type pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Status   struct {
+		Phase string `json:"phase"`
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+// kube is a minimal client of the Kubernetes API
+type kube struct {
+	server    *url.URL
+	token     string
+	tokenFile string // read for every request when set, as projected service account tokens rotate
+	client    *http.Client
+}
+
+// newInClusterKube creates a client from the pod's service account
+func newInClusterKube() (*kube, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a cluster, set -api-server")
+	}
+	if _, err := readToken(serviceAccountDir + "/token"); err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account CA certificate")
+	}
+
+	return &kube{
+		server:    &url.URL{Scheme: "https", Host: net.JoinHostPort(host, port)},
+		tokenFile: serviceAccountDir + "/token",
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+func (k *kube) do(ctx context.Context, method, path, contentType string, body, out any) error {
+	u := *k.server
+	u.Path, u.RawQuery, _ = strings.Cut(path, "?")
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	token := k.token
+	if k.tokenFile != "" {
+		if token, err = readToken(k.tokenFile); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// readToken reads a bearer token from a file
+func readToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (k *kube) listRecordings(ctx context.Context, namespace string) ([]FlightRecording, error) {
+	path := "/apis/" + group + "/" + version + "/" + resource
+	if namespace != "" {
+		path = "/apis/" + group + "/" + version + "/namespaces/" + namespace + "/" + resource
+	}
+	var list struct {
+		Items []FlightRecording `json:"items"`
+	}
+	err := k.do(ctx, http.MethodGet, path, "", nil, &list)
+	return list.Items, err
+}
+
+func (k *kube) updateStatus(ctx context.Context, fr FlightRecording) error {
+	path := fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s/%s/status", group, version, fr.Metadata.Namespace, resource, fr.Metadata.Name)
+	patch := map[string]any{"status": fr.Status}
+	return k.do(ctx, http.MethodPatch, path, "application/merge-patch+json", patch, nil)
+}
+
+func (k *kube) listPods(ctx context.Context, namespace string, labels map[string]string) ([]pod, error) {
+	var selector []string
+	for key, value := range labels {
+		selector = append(selector, key+"="+value)
+	}
+	slices.Sort(selector)
+
+	query := url.Values{"labelSelector": {strings.Join(selector, ",")}}
+	var list struct {
+		Items []pod `json:"items"`
+	}
+	err := k.do(ctx, http.MethodGet, "/api/v1/namespaces/"+namespace+"/pods?"+query.Encode(), "", nil, &list)
+	return list.Items, err
+}
+
+type operator struct {
+	kube      *kube
+	client    *http.Client
+	namespace string
+	// podTokenFile holds the bearer token sent to the control API of the pods, read for every request
+	podTokenFile string
+}
+
+// reconcile advances a recording: it starts the recorders of the selected pods,
+// then once the duration passed, uploads their snapshots and completes the recording
+func (o *operator) reconcile(ctx context.Context, fr FlightRecording) error {
+	switch fr.Status.Phase {
+	case "":
+		return o.start(ctx, fr)
+	case PhaseRecording:
+		duration, _ := parseDuration(fr.Spec.Duration)
+		if fr.Status.StartedAt != nil && time.Since(*fr.Status.StartedAt) < duration {
+			return nil
+		}
+		return o.collect(ctx, fr)
+	}
+	return nil
+}
+
+func (o *operator) start(ctx context.Context, fr FlightRecording) error {
+	fail := func(format string, args ...any) error {
+		fr.Status.Phase = PhaseFailed
+		fr.Status.Message = fmt.Sprintf(format, args...)
+		return o.kube.updateStatus(ctx, fr)
+	}
+	if _, err := parseDuration(fr.Spec.Duration); err != nil {
+		return fail("invalid duration: %v", err)
+	}
+	if fr.Spec.Storage.URL == "" {
+		return fail("storage.url is required")
+	}
+	if len(fr.Spec.Selector.MatchLabels) == 0 {
+		return fail("selector.matchLabels is required")
+	}
+
+	pods, err := o.kube.listPods(ctx, fr.Metadata.Namespace, fr.Spec.Selector.MatchLabels)
+	if err != nil {
+		return err
+	}
+
+	update := map[string]string{}
+	if fr.Spec.Period != "" {
+		update["period"] = fr.Spec.Period
+	}
+	if fr.Spec.Size != "" {
+		update["size"] = fr.Spec.Size
+	}
+
+	fr.Status.Pods = nil
+	started := 0
+	for _, p := range pods {
+		if p.Status.Phase != "Running" || p.Status.PodIP == "" {
+			continue
+		}
+		result := PodSnapshot{Pod: p.Metadata.Name, IP: p.Status.PodIP}
+		if err := o.startPod(ctx, controlURL(fr.Spec, p.Status.PodIP), update, &result); err != nil {
+			result.Error = err.Error()
+		} else {
+			started++
+		}
+		fr.Status.Pods = append(fr.Status.Pods, result)
+	}
+	if started == 0 {
+		return fail("no running pod matching the selector could be started")
+	}
+
+	now := time.Now().UTC()
+	fr.Status.Phase = PhaseRecording
+	fr.Status.Message = fmt.Sprintf("recording %d pods", started)
+	fr.Status.StartedAt = &now
+	log.Printf("%s/%s: recording %d pods", fr.Metadata.Namespace, fr.Metadata.Name, started)
+	return o.kube.updateStatus(ctx, fr)
+}
+
+func (o *operator) collect(ctx context.Context, fr FlightRecording) error {
+	sink := flightrecorder.NewHTTPSink(fr.Spec.Storage.URL)
+
+	uploaded := 0
+	for i, result := range fr.Status.Pods {
+		if result.Error != "" {
+			continue
+		}
+		base := controlURL(fr.Spec, result.IP)
+		data, err := o.get(ctx, base+"/snapshot")
+		if err == nil {
+			meta := flightrecorder.SnapshotMeta{
+				Name:      fmt.Sprintf("%s/%s/%s-%s.trace", fr.Metadata.Namespace, fr.Metadata.Name, result.Pod, time.Now().UTC().Format("20060102T150405Z")),
+				CreatedAt: time.Now(),
+				Size:      int64(len(data)),
+				Trigger:   "operator",
+			}
+			if err = sink.Write(ctx, meta, data); err == nil {
+				fr.Status.Pods[i].Snapshot = meta.Name
+				uploaded++
+			}
+		}
+		if result.PreviousPeriod != "" || result.PreviousSize != "" {
+			if err := o.restorePod(ctx, base, result); err != nil {
+				log.Printf("%s/%s: failed to restore the configuration of %s: %v", fr.Metadata.Namespace, fr.Metadata.Name, result.Pod, err)
+			}
+		}
+		if err == nil && fr.Spec.Stop {
+			if err := o.post(ctx, base+"/stop", nil); err != nil && !errors.Is(err, flightrecorder.ErrNotRunning) {
+				log.Printf("%s/%s: failed to stop %s: %v", fr.Metadata.Namespace, fr.Metadata.Name, result.Pod, err)
+			}
+		}
+		if err != nil {
+			fr.Status.Pods[i].Error = err.Error()
+		}
+	}
+
+	now := time.Now().UTC()
+	fr.Status.CompletedAt = &now
+	fr.Status.Phase = PhaseCompleted
+	fr.Status.Message = fmt.Sprintf("uploaded %d of %d snapshots", uploaded, len(fr.Status.Pods))
+	if uploaded == 0 {
+		fr.Status.Phase = PhaseFailed
+	}
+	log.Printf("%s/%s: %s", fr.Metadata.Namespace, fr.Metadata.Name, fr.Status.Message)
+	return o.kube.updateStatus(ctx, fr)
+}
+
+// startPod configures and starts the recorder of a pod, which may already be running,
+// or refuse to as a follower of a replicated service. The configuration it replaces is
+// recorded in result, to be restored by restorePod.
+func (o *operator) startPod(ctx context.Context, base string, update map[string]string, result *PodSnapshot) error {
+	if len(update) > 0 {
+		data, err := o.get(ctx, base+"/config")
+		if err != nil {
+			return err
+		}
+		var previous flightrecorder.ResolvedConfig
+		if err := json.Unmarshal(data, &previous); err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+		if err := o.call(ctx, http.MethodPatch, base+"/config", update); err != nil {
+			return err
+		}
+		if _, ok := update["period"]; ok {
+			result.PreviousPeriod = time.Duration(previous.PeriodNs).String()
+		}
+		if _, ok := update["size"]; ok {
+			result.PreviousSize = strconv.FormatInt(previous.SizeBytes, 10)
+		}
+	}
+	err := o.post(ctx, base+"/start", nil)
+	if err != nil && !errors.Is(err, flightrecorder.ErrAlreadyRunning) && !errors.Is(err, flightrecorder.ErrNotLeader) {
+		return err
+	}
+	return nil
+}
+
+// restorePod restores the period and size of a pod replaced by startPod
+func (o *operator) restorePod(ctx context.Context, base string, result PodSnapshot) error {
+	update := map[string]string{}
+	if result.PreviousPeriod != "" {
+		update["period"] = result.PreviousPeriod
+	}
+	if result.PreviousSize != "" {
+		update["size"] = result.PreviousSize
+	}
+	return o.call(ctx, http.MethodPatch, base+"/config", update)
+}
+
+// post calls a control endpoint, returning the service's error response as an error
+func (o *operator) post(ctx context.Context, url string, body any) error {
+	return o.call(ctx, http.MethodPost, url, body)
+}
+
+// call sends body to an endpoint with the method, returning the service's error response as an error
+func (o *operator) call(ctx context.Context, method, url string, body any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = o.send(req)
+	return err
+}
+
+func (o *operator) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return o.send(req)
+}
+
+func (o *operator) send(req *http.Request) ([]byte, error) {
+	if o.podTokenFile != "" {
+		token, err := readToken(o.podTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var errorResp flightrecorder.ErrorResponse
+		if json.Unmarshal(data, &errorResp) == nil && errorResp.Code != "" {
+			return nil, &errorResp
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// resync reconciles all recordings
+func (o *operator) resync(ctx context.Context) {
+	recordings, err := o.kube.listRecordings(ctx, o.namespace)
+	if err != nil {
+		log.Printf("Error: failed to list recordings: %v", err)
+		return
+	}
+	for _, fr := range recordings {
+		if err := o.reconcile(ctx, fr); err != nil {
+			log.Printf("Error: %s/%s: %v", fr.Metadata.Namespace, fr.Metadata.Name, err)
+		}
+	}
+}
+
+func controlURL(spec FlightRecordingSpec, ip string) string {
+	port, prefix := spec.Port, spec.Prefix
+	if port == 0 {
+		port = 8080
+	}
+	if prefix == "" {
+		prefix = "/recorder"
+	}
+	return "http://" + net.JoinHostPort(ip, fmt.Sprint(port)) + prefix
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 30 * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("%s must be positive", s)
+	}
+	return d, err
+}
+
+func main() {
+	apiServer := flag.String("api-server", "", "Kubernetes API server URL, e.g. http://127.0.0.1:8001 with kubectl proxy (default in-cluster)")
+	namespace := flag.String("namespace", "", "namespace to watch (default all namespaces)")
+	interval := flag.Duration("resync", 5*time.Second, "how often recordings are reconciled")
+	podTokenFile := flag.String("pod-token-file", "", "file holding the bearer token sent to the control API of the pods, e.g. a mounted secret (read for every request)")
+	printManifests := flag.Bool("print-manifests", false, "print the CRD and RBAC manifests and exit")
+	flag.Parse()
+
+	if *printManifests {
+		fmt.Print(manifests)
+		return
+	}
+
+	var k *kube
+	if *apiServer != "" {
+		u, err := url.Parse(*apiServer)
+		if err != nil {
+			log.Fatal("Invalid API server:", err)
+		}
+		k = &kube{server: u, client: &http.Client{Timeout: 30 * time.Second}, token: os.Getenv("KUBE_TOKEN")}
+	} else {
+		var err error
+		if k, err = newInClusterKube(); err != nil {
+			log.Fatal("Error: ", err)
+		}
+	}
+
+	o := &operator{
+		kube:         k,
+		client:       &http.Client{Timeout: 60 * time.Second},
+		namespace:    *namespace,
+		podTokenFile: *podTokenFile,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Reconciling %s.%s every %s", resource, group, *interval)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		o.resync(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+const manifests = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: flightrecordings.flightrecorder.dev
+spec:
+  group: flightrecorder.dev
+  names:
+    kind: FlightRecording
+    plural: flightrecordings
+    singular: flightrecording
+    shortNames: [frec]
+  scope: Namespaced
+  versions:
+    - name: v1alpha1
+      served: true
+      storage: true
+      subresources:
+        status: {}
+      additionalPrinterColumns:
+        - {name: Phase, type: string, jsonPath: .status.phase}
+        - {name: Message, type: string, jsonPath: .status.message}
+        - {name: Age, type: date, jsonPath: .metadata.creationTimestamp}
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              required: [selector, storage]
+              properties:
+                selector:
+                  type: object
+                  properties:
+                    matchLabels:
+                      type: object
+                      additionalProperties: {type: string}
+                port: {type: integer}
+                prefix: {type: string}
+                duration: {type: string}
+                period: {type: string}
+                size: {type: string}
+                stop: {type: boolean}
+                storage:
+                  type: object
+                  required: [url]
+                  properties:
+                    url: {type: string}
+            status:
+              type: object
+              x-kubernetes-preserve-unknown-fields: true
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: flightrecorder-operator
+rules:
+  - apiGroups: [""]
+    resources: [pods]
+    verbs: [get, list]
+  - apiGroups: [flightrecorder.dev]
+    resources: [flightrecordings]
+    verbs: [get, list, watch]
+  - apiGroups: [flightrecorder.dev]
+    resources: [flightrecordings/status]
+    verbs: [get, patch, update]
+`
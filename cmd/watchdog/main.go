@@ -0,0 +1,373 @@
+// Command watchdog runs an application embedding the flight recorder service as a child process
+// and collects what it left behind when it dies, for "dump on exit" behaviour.
+//
+// The execution tracer only records the process it runs in, so the recorder must be embedded in
+// the application, typically with WithContinuousRecording and WithCrashDump. The watchdog:
+//
+//   - starts the command given after the flags, passing stdout through and keeping the tail of stderr
+//   - forwards SIGINT and SIGTERM to it
+//   - polls -health and sends SIGQUIT after -health-failures consecutive failures, so a hung
+//     application dumps its buffer and goroutines and dies, and SIGKILL if it is still running
+//     after -kill-grace
+//   - when the child exits with a failure, writes a tar.gz bundle of the newest continuous recording
+//     windows, the crash dumps written since it started, the stderr tail and the exit status to -out
+//   - exits with the child's exit code
+//
+// Usage:
+//
+//	watchdog -windows /var/lib/app/windows -crashes /var/lib/app/crashes -out /var/lib/app/bundles -- ./app -flag
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxStderr is the size of the stderr tail kept for the bundle
+const maxStderr = 1 << 20
+
+// ExitReport describes how the child process exited, written to exit.json in the bundle
+type ExitReport struct {
+	Command   []string  `json:"command"`
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+	ExitedAt  time.Time `json:"exited_at"`
+	ExitCode  int       `json:"exit_code"`
+	State     string    `json:"state"`            // e.g. "exit status 2" or "signal: killed"
+	Reason    string    `json:"reason,omitempty"` // set when the watchdog killed the child
+}
+
+// tail keeps the last max bytes written to it
+type tail struct {
+	mu   sync.Mutex
+	max  int
+	data []byte
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.data = append(t.data, p...)
+	if len(t.data) > t.max {
+		t.data = slices.Clone(t.data[len(t.data)-t.max:])
+	}
+	return len(p), nil
+}
+
+func (t *tail) Bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.data)
+}
+
+type watchdog struct {
+	windows string
+	crashes string
+	out     string
+	keep    int
+
+	health         string
+	healthInterval time.Duration
+	healthFailures int
+	killGrace      time.Duration
+}
+
+// watchHealth polls the health URL and signals the child after consecutive failures, killing it
+// when it is still running after the grace period, and returns the reason, or "" when ctx is done first
+func (wd *watchdog) watchHealth(ctx context.Context, p *os.Process) string {
+	// Give the application time to start listening.
+	timer := time.NewTimer(wd.healthInterval)
+	defer timer.Stop()
+
+	client := &http.Client{Timeout: wd.healthInterval}
+	var failures int
+	for {
+		select {
+		case <-ctx.Done():
+			return ""
+		case <-timer.C:
+		}
+
+		if err := checkHealth(ctx, client, wd.health); err != nil {
+			failures++
+			log.Printf("Health check %d/%d failed: %v", failures, wd.healthFailures, err)
+		} else {
+			failures = 0
+		}
+		if failures >= wd.healthFailures {
+			reason := fmt.Sprintf("unresponsive: %d consecutive health check failures", failures)
+			log.Printf("Sending SIGQUIT to %d: %s", p.Pid, reason)
+			p.Signal(syscall.SIGQUIT)
+			return wd.killAfterGrace(ctx, p, reason)
+		}
+		timer.Reset(wd.healthInterval)
+	}
+}
+
+// killAfterGrace kills the child unless it exits, i.e. ctx is done, within the grace period after SIGQUIT,
+// as it may handle or ignore the signal or hang while dumping
+func (wd *watchdog) killAfterGrace(ctx context.Context, p *os.Process, reason string) string {
+	timer := time.NewTimer(wd.killGrace)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return reason
+	case <-timer.C:
+	}
+	log.Printf("Sending SIGKILL to %d: still running %s after SIGQUIT", p.Pid, wd.killGrace)
+	p.Kill()
+	return fmt.Sprintf("%s, killed after SIGQUIT for %s", reason, wd.killGrace)
+}
+
+func checkHealth(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// recentFiles returns the paths of the regular files in dir matching the pattern modified after since,
+// oldest first, keeping the newest keep when keep is positive
+func recentFiles(dir, pattern string, since time.Time, keep int) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		path    string
+		modTime time.Time
+	}
+	var files []file
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(since) {
+			continue
+		}
+		files = append(files, file{path, info.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	if keep > 0 && len(files) > keep {
+		files = files[len(files)-keep:]
+	}
+
+	var recent []string
+	for _, f := range files {
+		recent = append(recent, f.path)
+	}
+	return recent, nil
+}
+
+// writeBundle writes the crash bundle of the exited child and returns its path
+func (wd *watchdog) writeBundle(report ExitReport, stderr []byte) (string, error) {
+	// Windows written before the child started belong to an earlier run.
+	windows, err := recentFiles(wd.windows, "window-*.trace", report.StartedAt, wd.keep)
+	if err != nil {
+		return "", fmt.Errorf("failed to list windows: %w", err)
+	}
+	crashes, err := recentFiles(wd.crashes, "crash-*.trace", report.StartedAt, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to list crash dumps: %w", err)
+	}
+
+	if err := os.MkdirAll(wd.out, 0755); err != nil {
+		return "", fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	path := filepath.Join(wd.out, fmt.Sprintf("exit-%s-%d.tar.gz", report.ExitedAt.UTC().Format("20060102T150405Z"), report.PID))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	addFile := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: report.ExitedAt,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write bundle header for %s: %w", name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write bundle file %s: %w", name, err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := addFile("exit.json", data); err != nil {
+		return "", err
+	}
+	if err := addFile("stderr.log", stderr); err != nil {
+		return "", err
+	}
+	addFiles := func(dir string, paths []string) error {
+		for _, p := range paths {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				// Rotation may have deleted the window since it was listed.
+				continue
+			}
+			if err := addFile(dir+"/"+filepath.Base(p), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := addFiles("windows", windows); err != nil {
+		return "", err
+	}
+	if err := addFiles("crashes", crashes); err != nil {
+		return "", err
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("failed to close bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("failed to close bundle: %w", err)
+	}
+	return path, f.Close()
+}
+
+func main() {
+	windows := flag.String("windows", "", "continuous recording directory of the application (WithContinuousRecording)")
+	crashes := flag.String("crashes", "", "crash dump directory of the application (WithCrashDump)")
+	out := flag.String("out", "bundles", "directory exit bundles are written to")
+	keep := flag.Int("keep", 3, "number of the newest continuous recording windows included in bundles")
+	health := flag.String("health", "", "health URL of the application, e.g. http://localhost:8080/recorder/healthz (disables liveness checks when empty)")
+	healthInterval := flag.Duration("health-interval", 10*time.Second, "health check interval and timeout")
+	healthFailures := flag.Int("health-failures", 3, "consecutive health check failures before the application is sent SIGQUIT")
+	killGrace := flag.Duration("kill-grace", 30*time.Second, "time the application has to exit after SIGQUIT before it is sent SIGKILL")
+	always := flag.Bool("always", false, "write a bundle when the application exits successfully too")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] -- command [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	wd := &watchdog{
+		windows:        *windows,
+		crashes:        *crashes,
+		out:            *out,
+		keep:           *keep,
+		health:         *health,
+		healthInterval: *healthInterval,
+		healthFailures: *healthFailures,
+		killGrace:      *killGrace,
+	}
+
+	stderr := &tail{max: maxStderr}
+	cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+
+	// Forward termination signals instead of dying before the child.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	// Truncate to the second, the modification times of files may be coarser than the clock.
+	startedAt := time.Now().Truncate(time.Second)
+	if err := cmd.Start(); err != nil {
+		log.Fatal("Failed to start command:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var reason string
+	var wg sync.WaitGroup
+	if wd.health != "" {
+		wg.Go(func() { reason = wd.watchHealth(ctx, cmd.Process) })
+	}
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err := cmd.Wait()
+	cancel()
+	wg.Wait()
+	signal.Stop(signals)
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		log.Fatal("Failed to wait for command:", err)
+	}
+
+	report := ExitReport{
+		Command:   flag.Args(),
+		PID:       cmd.Process.Pid,
+		StartedAt: startedAt,
+		ExitedAt:  time.Now(),
+		ExitCode:  cmd.ProcessState.ExitCode(),
+		State:     cmd.ProcessState.String(),
+		Reason:    reason,
+	}
+	log.Printf("Command %s: %s", strings.Join(report.Command, " "), report.State)
+
+	if !cmd.ProcessState.Success() || *always {
+		path, err := wd.writeBundle(report, stderr.Bytes())
+		if err != nil {
+			log.Printf("Error: bundle: %v", err)
+		} else {
+			log.Printf("Wrote bundle %s", path)
+		}
+	}
+
+	code := report.ExitCode
+	if code < 0 {
+		// Killed by a signal, exit as shells report it.
+		code = 128
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			code += int(status.Signal())
+		}
+	}
+	os.Exit(code)
+}
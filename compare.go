@@ -0,0 +1,130 @@
+package flightrecorder
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DiffReport reports the differences between two snapshots
+type DiffReport struct {
+	A, B      TraceSummary
+	Metrics   []MetricDelta
+	Functions []FunctionDelta
+}
+
+// MetricDelta compares a metric of two snapshots, durations are in nanoseconds
+type MetricDelta struct {
+	Name     string
+	Duration bool
+	A, B     int64
+}
+
+// Delta returns the change of the metric from A to B
+func (d MetricDelta) Delta() int64 {
+	return d.B - d.A
+}
+
+// FunctionDelta compares the running time of a goroutine function in two snapshots
+type FunctionDelta struct {
+	Function string
+	A, B     time.Duration
+}
+
+// CompareSnapshots parses two snapshots and reports the deltas in goroutine counts,
+// GC behavior, scheduler latency and the top functions by running time from a to b
+func CompareSnapshots(a, b io.Reader) (DiffReport, error) {
+	sa, err := Summarize(a)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("first snapshot: %w", err)
+	}
+	sb, err := Summarize(b)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("second snapshot: %w", err)
+	}
+	return newDiffReport(sa, sb), nil
+}
+
+func newDiffReport(a, b TraceSummary) DiffReport {
+	report := DiffReport{A: a, B: b}
+
+	count := func(name string, a, b int) {
+		report.Metrics = append(report.Metrics, MetricDelta{Name: name, A: int64(a), B: int64(b)})
+	}
+	duration := func(name string, a, b time.Duration) {
+		report.Metrics = append(report.Metrics, MetricDelta{Name: name, Duration: true, A: int64(a), B: int64(b)})
+	}
+
+	duration("duration", a.Duration, b.Duration)
+	count("events", a.Events, b.Events)
+	count("goroutines", a.Goroutines, b.Goroutines)
+	count("goroutines created", a.GoroutinesCreated, b.GoroutinesCreated)
+	states := slices.Sorted(maps.Keys(a.GoroutineStates))
+	for state := range b.GoroutineStates {
+		if _, ok := a.GoroutineStates[state]; !ok {
+			states = append(states, state)
+		}
+	}
+	for _, state := range states {
+		count("goroutines "+strings.ToLower(state), a.GoroutineStates[state], b.GoroutineStates[state])
+	}
+	count("gc cycles", a.GCCycles, b.GCCycles)
+	count("gc pauses", a.GCPauses.Count, b.GCPauses.Count)
+	duration("gc pause total", a.GCPauses.Total, b.GCPauses.Total)
+	duration("gc pause max", a.GCPauses.Max, b.GCPauses.Max)
+	duration("sched latency p50", a.SchedLatency.P50, b.SchedLatency.P50)
+	duration("sched latency p99", a.SchedLatency.P99, b.SchedLatency.P99)
+	duration("sched latency max", a.SchedLatency.Max, b.SchedLatency.Max)
+
+	functions := make(map[string]*FunctionDelta)
+	for _, f := range a.TopFunctions {
+		functions[f.Function] = &FunctionDelta{Function: f.Function, A: f.Running}
+	}
+	for _, f := range b.TopFunctions {
+		if d, ok := functions[f.Function]; ok {
+			d.B = f.Running
+		} else {
+			functions[f.Function] = &FunctionDelta{Function: f.Function, B: f.Running}
+		}
+	}
+	for _, d := range functions {
+		report.Functions = append(report.Functions, *d)
+	}
+	slices.SortFunc(report.Functions, func(x, y FunctionDelta) int {
+		return cmp.Or(cmp.Compare(max(y.A, y.B), max(x.A, x.B)), strings.Compare(x.Function, y.Function))
+	})
+	return report
+}
+
+// WriteText writes the report as aligned text tables
+func (r DiffReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "metric\tA\tB\tdelta")
+	for _, m := range r.Metrics {
+		if m.Duration {
+			fmt.Fprintf(tw, "%s\t%v\t%v\t%s\n", m.Name, time.Duration(m.A), time.Duration(m.B), signed(time.Duration(m.Delta()).String(), m.Delta()))
+		} else {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", m.Name, m.A, m.B, signed(fmt.Sprint(m.Delta()), m.Delta()))
+		}
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "function running time\tA\tB\tdelta")
+	for _, f := range r.Functions {
+		fmt.Fprintf(tw, "%s\t%v\t%v\t%s\n", f.Function, f.A, f.B, signed((f.B-f.A).String(), int64(f.B-f.A)))
+	}
+	return tw.Flush()
+}
+
+// signed prefixes positive deltas with a plus sign
+func signed(s string, delta int64) string {
+	if delta > 0 {
+		return "+" + s
+	}
+	return s
+}
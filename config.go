@@ -0,0 +1,253 @@
+package flightrecorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ReconfigureTrigger is the trigger name of the final snapshots captured before a recorder restarts
+// to apply a new configuration
+const ReconfigureTrigger = "reconfigure"
+
+// ApplyMode is when a new period or size takes effect on a running recorder
+type ApplyMode string
+
+const (
+	// ApplyOnRestart applies the change the next time the recorder starts, e.g. on start, clear or a new session
+	ApplyOnRestart ApplyMode = "on_restart"
+	// ApplyImmediate restarts the recorder right away, discarding its buffer
+	ApplyImmediate ApplyMode = "immediate"
+)
+
+// maxConfigChanges bounds the changes kept in the configuration history
+const maxConfigChanges = 100
+
+// ConfigChange is a change of the configuration, with the fields it changed
+type ConfigChange struct {
+	Time    time.Time           `json:"time"`
+	By      string              `json:"by,omitempty"` // principal which applied the change, see WithPrincipal
+	Changes []ConfigFieldChange `json:"changes"`
+}
+
+// ConfigFieldChange is the change of a configuration field
+type ConfigFieldChange struct {
+	Field string `json:"field"`          // e.g. period, size or labels.region
+	From  string `json:"from,omitempty"` // empty for added labels
+	To    string `json:"to,omitempty"`   // empty for removed labels
+}
+
+// PrincipalFunc identifies the client making a request, "" for anonymous clients
+type PrincipalFunc func(r *http.Request) string
+
+// WithPrincipal sets how the clients changing the configuration are identified, e.g. from the user
+// set by the authentication middleware, so the configuration reports who applied the last change.
+// By default clients are identified by the common name of their TLS client certificate, see WithClientCA.
+func WithPrincipal(f PrincipalFunc) Option {
+	return func(o *options) {
+		o.principal = f
+	}
+}
+
+// principal returns the identity of the client making the request, "" when it is anonymous
+func (s *Service) principal(r *http.Request) string {
+	if s.opts.principal != nil {
+		return s.opts.principal(r)
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return ""
+}
+
+// complete checks the update request sets every field of the configuration, as a full replace must
+func (req UpdateRequest) complete() error {
+	if req.Period == nil {
+		return &ConfigError{Field: "period", Message: "is required"}
+	}
+	if req.Size == nil {
+		return &ConfigError{Field: "size", Message: "is required"}
+	}
+	return nil
+}
+
+// replacing returns the update request as a full replace: omitted thresholds disable their trigger
+func (req UpdateRequest) replacing() UpdateRequest {
+	var disabled Duration
+	if req.GCPauseThreshold == nil {
+		req.GCPauseThreshold = &disabled
+	}
+	if req.SchedLatencyThreshold == nil {
+		req.SchedLatencyThreshold = &disabled
+	}
+	return req
+}
+
+// restartPendingLocked reports whether the recorder is running with another period or size, s.mu must be held
+func (s *Service) restartPendingLocked(period time.Duration, size int64) bool {
+	return s.recorder.Enabled() && (period != s.recordingPeriod || size != s.recordingSize)
+}
+
+// restartsRecorder reports whether applying the update request restarts the recorder
+func (s *Service) restartsRecorder(req UpdateRequest) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	config := s.resolveLocked(req, false)
+	return req.Apply == ApplyImmediate && s.restartPendingLocked(time.Duration(config.PeriodNs), config.SizeBytes)
+}
+
+// updateErrorStatus returns the status of a failed update: 400 for invalid requests,
+// otherwise the status of the failed final snapshot or restart
+func updateErrorStatus(err error) int {
+	var configErr *ConfigError
+	switch {
+	case errors.As(err, &configErr), errors.Is(err, ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrSnapshotVetoed):
+		return http.StatusConflict
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Config returns the current configuration of the flight recorder
+func (s *Service) Config() ResolvedConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	config := s.resolveLocked(UpdateRequest{}, false)
+	config.AppliedAt, config.AppliedBy = s.configAppliedAt, s.configAppliedBy
+	return config
+}
+
+// ConfigHistory returns the recent changes of the configuration, oldest first.
+// With WithStateFile the history is persisted along with the configuration.
+func (s *Service) ConfigHistory() []ConfigChange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Clone(s.configHistory)
+}
+
+// recordConfigChangeLocked records the change from the configuration before, if any, and returns it.
+// s.mu must be held.
+func (s *Service) recordConfigChangeLocked(before ResolvedConfig, principal string) []ConfigFieldChange {
+	changes := diffConfig(before, s.resolveLocked(UpdateRequest{}, false))
+	if len(changes) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	s.configHistory = append(s.configHistory, ConfigChange{Time: now, By: principal, Changes: changes})
+	if len(s.configHistory) > maxConfigChanges {
+		s.configHistory = slices.Delete(s.configHistory, 0, len(s.configHistory)-maxConfigChanges)
+	}
+	if principal != "" {
+		s.configAppliedAt, s.configAppliedBy = now, principal
+	}
+	return changes
+}
+
+// restoreConfigHistory restores the persisted history, and who applied the last change from it
+func (s *Service) restoreConfigHistory(history []ConfigChange) {
+	if len(history) > maxConfigChanges {
+		history = history[len(history)-maxConfigChanges:]
+	}
+	s.configHistory = history
+	for _, change := range slices.Backward(history) {
+		if change.By != "" {
+			s.configAppliedAt, s.configAppliedBy = change.Time, change.By
+			return
+		}
+	}
+}
+
+// diffConfig returns the fields which differ between the configurations, labels sorted by key
+func diffConfig(before, after ResolvedConfig) []ConfigFieldChange {
+	var changes []ConfigFieldChange
+	field := func(name, from, to string) {
+		if from != to {
+			changes = append(changes, ConfigFieldChange{Field: name, From: from, To: to})
+		}
+	}
+	field("period", before.Period, after.Period)
+	field("size", before.Size, after.Size)
+	field("gc_pause_threshold", before.GCPauseThreshold, after.GCPauseThreshold)
+	field("sched_latency_threshold", before.SchedLatencyThreshold, after.SchedLatencyThreshold)
+
+	keys := slices.Collect(maps.Keys(before.Labels))
+	for key := range after.Labels {
+		if _, ok := before.Labels[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		field("labels."+key, before.Labels[key], after.Labels[key])
+	}
+	return changes
+}
+
+// ReplaceConfig replaces the flight recorder configuration. Unlike Update, the period and size
+// are required, omitted thresholds are disabled and the labels replace the labels of the service.
+func (s *Service) ReplaceConfig(req UpdateRequest) error {
+	return s.update(context.Background(), req, true, "")
+}
+
+func (s *Service) handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeCached(w, r, s.Config())
+
+	case http.MethodPatch, http.MethodPut:
+		replace := r.Method == http.MethodPut
+
+		var req UpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				err = fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest)
+			}
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if r.URL.Query().Get("dry_run") == "true" {
+			err := s.Validate(req)
+			if err == nil && replace {
+				err = req.complete()
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			writeResponse(w, r, http.StatusOK, s.resolve(req, replace))
+			return
+		}
+
+		if err := s.update(r.Context(), req, replace, s.principal(r)); err != nil {
+			writeError(w, updateErrorStatus(err), err)
+			return
+		}
+		writeResponse(w, r, http.StatusOK, s.Config())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) handleConfigHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeCached(w, r, s.ConfigHistory())
+}
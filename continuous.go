@@ -0,0 +1,125 @@
+package flightrecorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultContinuousKeep = 10
+
+	continuousPrefix = "window-"
+	continuousSuffix = ".trace"
+)
+
+// ContinuousRecording writes a snapshot of the flight recorder into a rolling set of files
+// every window, like log rotation, so a crash at any time leaves recent history on disk.
+// Windows are only written while the recorder is running. The recorder period should be
+// at least the window, so consecutive files cover the time between them.
+type ContinuousRecording struct {
+	Dir    string        // directory of the window files, created as needed
+	Window time.Duration // time between two window files
+	Keep   int           // number of window files kept, older ones are deleted (default 10)
+}
+
+func (c ContinuousRecording) enabled() bool {
+	return c.Dir != "" && c.Window > 0
+}
+
+// WithContinuousRecording enables continuous recording into rolling window files
+func WithContinuousRecording(c ContinuousRecording) Option {
+	return func(o *options) {
+		o.continuous = c
+	}
+}
+
+// runContinuous writes a window file every window until ctx is done
+func (s *Service) runContinuous(ctx context.Context) {
+	defer s.health.continuous.Store(false)
+
+	ticker := time.NewTicker(s.opts.continuous.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := s.writeWindow(now)
+			if errors.Is(err, ErrNotRunning) {
+				continue
+			}
+			s.health.recordContinuousWrite(err)
+		}
+	}
+}
+
+// writeWindow writes the flight recorder buffer to a window file and deletes the oldest files beyond Keep
+func (s *Service) writeWindow(now time.Time) error {
+	data, _, err := s.writeSnapshot(s.ctx)
+	if err != nil {
+		return err
+	}
+
+	c := s.opts.continuous
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create window directory: %w", err)
+	}
+
+	// Window names sort by time, so rotation deletes from the front.
+	path := filepath.Join(c.Dir, continuousPrefix+now.UTC().Format("20060102T150405.000Z")+continuousSuffix)
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write window: %w", err)
+	}
+	return rotateWindows(c)
+}
+
+// rotateWindows deletes the oldest window files beyond Keep
+func rotateWindows(c ContinuousRecording) error {
+	keep := c.Keep
+	if keep <= 0 {
+		keep = defaultContinuousKeep
+	}
+
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list windows: %w", err)
+	}
+	var windows []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, continuousPrefix) && strings.HasSuffix(name, continuousSuffix) {
+			windows = append(windows, name)
+		}
+	}
+	slices.Sort(windows)
+
+	for len(windows) > keep {
+		if err := os.Remove(filepath.Join(c.Dir, windows[0])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete window: %w", err)
+		}
+		windows = windows[1:]
+	}
+	return nil
+}
+
+// recordContinuousWrite records the result of the last window write
+func (h *serviceHealth) recordContinuousWrite(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.continuousErr = err
+}
+
+func (h *serviceHealth) continuousWriteErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.continuousErr
+}
@@ -0,0 +1,124 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig allows web dashboards hosted on other origins to call the endpoints from the browser
+type CORSConfig struct {
+	AllowedOrigins   []string      // origins allowed to call the endpoints, "*" allows any origin
+	AllowedMethods   []string      // methods allowed in preflight requests (default GET, POST, PATCH, PUT, DELETE)
+	AllowedHeaders   []string      // request headers allowed in preflight requests (default Content-Type)
+	AllowCredentials bool          // whether the browser may send cookies and HTTP authentication
+	MaxAge           time.Duration // how long browsers may cache preflight responses
+}
+
+// corsExposedHeaders are the response headers scripts on other origins may read
+var corsExposedHeaders = []string{"Content-Disposition", "ETag", HeaderSnapshotEvents, HeaderSnapshotEncrypted, HeaderSnapshotGoVersion,
+	HeaderSnapshotSHA256, HeaderSnapshotSignature, HeaderInstance, HeaderQuotaLimit, HeaderQuotaRemaining, "Retry-After"}
+
+// WithCORS sets the CORS configuration of the handlers. Preflight OPTIONS requests
+// are answered for every endpoint, requests from other origins are not rejected
+// but browsers hide the responses from scripts of origins that are not allowed.
+func WithCORS(config CORSConfig) Option {
+	return func(o *options) {
+		o.cors = &config
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for the origin, empty if not allowed
+func (c *CORSConfig) allowOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" && !c.AllowCredentials {
+			return "*"
+		}
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// withCORS adds the CORS headers to the responses of h and answers preflight requests
+func (s *Service) withCORS(h http.HandlerFunc) http.HandlerFunc {
+	c := s.opts.cors
+	if c == nil {
+		return h
+	}
+
+	methods := c.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodPut, http.MethodDelete}
+	}
+	headers := c.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Content-Type"}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := c.allowOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		w.Header().Add("Vary", "Origin")
+		if origin != "" && allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			if c.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if preflight {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+				if c.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+				}
+			} else {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			}
+		}
+
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// corsRoutes wraps the handlers of routes with CORS and adds the preflight OPTIONS routes
+func (s *Service) corsRoutes(routes []Route) []Route {
+	if s.opts.cors == nil {
+		return routes
+	}
+
+	type key struct {
+		path  string
+		admin bool
+	}
+	var preflight, options []key
+	for i, route := range routes {
+		routes[i].Handler = s.withCORS(route.Handler)
+		k := key{route.Path, route.Admin}
+		if route.Method == http.MethodOptions {
+			options = append(options, k)
+		} else if !slices.Contains(preflight, k) {
+			preflight = append(preflight, k)
+		}
+	}
+	// The OPTIONS routes of methodRoutes answer the requests which are not preflight requests.
+	for _, k := range preflight {
+		if !slices.Contains(options, k) {
+			routes = append(routes, Route{http.MethodOptions, k.path, k.admin, s.withCORS(handleMethodNotAllowed)})
+		}
+	}
+	return routes
+}
+
+// handleMethodNotAllowed answers OPTIONS requests which are not CORS preflight requests
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
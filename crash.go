@@ -0,0 +1,118 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// crashSignals are the fatal signals the crash handler dumps the buffer on before the process dies.
+// Faults in Go code (SIGSEGV, SIGBUS) surface as panics, recovered by RecoverCrash.
+var crashSignals = []os.Signal{syscall.SIGABRT, syscall.SIGQUIT}
+
+// WithCrashDump makes the service write the flight recorder buffer to dir, on a best-effort basis,
+// when the process receives SIGABRT or SIGQUIT and when RecoverCrash recovers a panic.
+// The signal is raised again after the dump, so the process still dies with the runtime's crash report.
+func WithCrashDump(dir string) Option {
+	return func(o *options) {
+		o.crashDir = dir
+	}
+}
+
+// runCrashHandler dumps the buffer on crash signals until ctx is done
+func (s *Service) runCrashHandler(ctx context.Context) {
+	defer s.health.crashHandler.Store(false)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, crashSignals...)
+	defer signal.Stop(signals)
+
+	select {
+	case <-ctx.Done():
+		return
+	case sig := <-signals:
+		s.dumpOnCrash()
+		// Restore the default behaviour and raise the signal again, so the process dies as it would have.
+		signal.Reset(sig)
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			p.Signal(sig)
+		}
+		time.Sleep(time.Second)
+		os.Exit(2)
+	}
+}
+
+// RecoverCrash writes a crash dump when the deferring goroutine panics and panics again.
+// Use it as `defer service.RecoverCrash()` at the top of goroutines, together with
+// debug.SetPanicOnFault(true) to turn faults on unexpected addresses into panics.
+// Without WithCrashDump, or when no goroutine panics, it does nothing.
+// It also dumps panics raised while the service's lock is held, e.g. in a UseRecorder callback,
+// though the buffer statistics of the status aren't updated by such a dump.
+func (s *Service) RecoverCrash() {
+	if s.opts.crashDir == "" {
+		return
+	}
+	if v := recover(); v != nil {
+		s.dumpOnCrash()
+		panic(v)
+	}
+}
+
+// dumpOnCrash writes a crash dump, reporting failures on stderr as the process is about to die
+func (s *Service) dumpOnCrash() {
+	if err := s.writeCrashDump(); err != nil {
+		fmt.Fprintf(os.Stderr, "flightrecorder: crash dump failed: %v\n", err)
+	}
+}
+
+// writeCrashDump writes the flight recorder buffer to a crash file
+func (s *Service) writeCrashDump() error {
+	data, err := s.writeCrashSnapshot()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.opts.crashDir, 0755); err != nil {
+		return fmt.Errorf("failed to create crash directory: %w", err)
+	}
+
+	path := filepath.Join(s.opts.crashDir, fmt.Sprintf("crash-%s-%d.trace", time.Now().UTC().Format("20060102T150405Z"), s.pid))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write crash dump: %w", err)
+	}
+	return nil
+}
+
+// writeCrashSnapshot writes the flight recorder buffer without waiting for s.mu, which the crashing
+// goroutine may hold, or a hung one when dumping on SIGQUIT. The recorder is never replaced and is
+// safe to write concurrently, so only the bookkeeping done under the lock is skipped without it.
+func (s *Service) writeCrashSnapshot() ([]byte, error) {
+	var data []byte
+	var err error
+	if s.mu.TryRLock() {
+		data, _, err = s.writeBufferLocked(s.ctx)
+		s.mu.RUnlock()
+	} else {
+		data, err = s.writeRecorder(s.ctx)
+	}
+	if err != nil || len(s.opts.filters) == 0 {
+		return data, err
+	}
+	return s.filterSnapshot(data)
+}
+
+// writeRecorder writes the flight recorder buffer without s.mu
+func (s *Service) writeRecorder(ctx context.Context) ([]byte, error) {
+	if !s.recorder.Enabled() {
+		return nil, ErrNotRunning
+	}
+	var buf bytes.Buffer
+	if _, err := s.recorder.WriteTo(contextWriter{ctx, &buf}); err != nil {
+		return nil, writeBufferError(err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,48 @@
+package flightrecorder
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecoverCrashWhileLocked(t *testing.T) {
+	dir := t.TempDir()
+	s := NewService(WithCrashDump(dir))
+	t.Cleanup(func() { s.Close() })
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan any)
+	go func() {
+		defer func() { done <- recover() }()
+		defer s.RecoverCrash()
+		// A panic while holding the lock, as in a UseRecorder callback, leaves it held for the dump.
+		s.mu.Lock()
+		panic("boom")
+	}()
+
+	select {
+	case v := <-done:
+		s.mu.Unlock()
+		if v != "boom" {
+			t.Fatalf("recovered %v, want the panic to be raised again", v)
+		}
+	case <-time.After(10 * time.Second):
+		s.mu.Unlock()
+		<-done
+		t.Fatal("crash dump blocked on the service lock")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d crash dumps, want 1", len(entries))
+	}
+	if info, err := entries[0].Info(); err != nil || info.Size() == 0 {
+		t.Fatalf("crash dump is empty: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package flightrecorder
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// WithLogger sets the logger of the service, which warns about requests to deprecated endpoints
+// (default slog.Default())
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+func (s *Service) logger() *slog.Logger {
+	if s.opts.logger == nil {
+		return slog.Default()
+	}
+	return s.opts.logger
+}
+
+// deprecationLog remembers the deprecated endpoints which were warned about, so each is logged once
+type deprecationLog struct {
+	mu     sync.Mutex
+	logged map[string]bool
+}
+
+// first reports whether the endpoint is requested for the first time
+func (l *deprecationLog) first(endpoint string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.logged[endpoint] {
+		return false
+	}
+	if l.logged == nil {
+		l.logged = make(map[string]bool)
+	}
+	l.logged[endpoint] = true
+	return true
+}
+
+// deprecateRoutes marks the responses of the routes superseded by another endpoint, see routeDoc.successor,
+// with a Deprecation header and a Link to the successor, and warns once per endpoint in the log.
+// Deprecated endpoints are kept for the whole major version of the module.
+func (s *Service) deprecateRoutes(routes []Route) []Route {
+	for i, route := range routes {
+		path := unversionedPath(route)
+		endpoint := route.Method + " " + path
+		successor := routeDocs[endpoint].successor
+		if successor == "" {
+			continue
+		}
+		successorMethod, successorPath, _ := strings.Cut(successor, " ")
+		depth := strings.Count(route.Path, "/")
+		h := route.Handler
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != route.Method {
+				h(w, r)
+				return
+			}
+			// The successor is registered under the same prefix, with the version.
+			prefix := r.URL.Path
+			for range depth {
+				prefix = prefix[:max(strings.LastIndex(prefix, "/"), 0)]
+			}
+			link := prefix + "/" + APIVersion + expandPath(successorPath, r)
+			w.Header().Set("Deprecation", "true")
+			w.Header().Add("Link", "<"+link+`>; rel="successor-version"`)
+			if s.deprecations.first(endpoint) {
+				s.logger().Warn("flight recorder endpoint is deprecated",
+					"endpoint", endpoint, "successor", successorMethod+" "+link,
+					"remote_addr", r.RemoteAddr, "user_agent", r.UserAgent())
+			}
+			h(w, r)
+		}
+	}
+	return routes
+}
+
+// expandPath replaces the {name} wildcards of the path with the path values of the request
+func expandPath(path string, r *http.Request) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			segments[i] = r.PathValue(strings.TrimSuffix(segment[1:len(segment)-1], "..."))
+		}
+	}
+	return strings.Join(segments, "/")
+}
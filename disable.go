@@ -0,0 +1,70 @@
+package flightrecorder
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// WithDisabledEndpoints removes endpoints from the HTTP API, e.g. "POST /update" or "/stop", so production
+// builds never expose them whatever the registration or middleware. Patterns are paths without the version,
+// optionally preceded by a method, as in HandlerSet.Endpoints. Disabled endpoints respond 404 Not Found
+// and are left out of the routes and the OpenAPI document. It panics on patterns matching no endpoint,
+// so a typo cannot leave an endpoint exposed.
+func WithDisabledEndpoints(patterns ...string) Option {
+	routes := new(Service).routes()
+	for _, pattern := range patterns {
+		if !slices.ContainsFunc(routes, func(route Route) bool { return route.matches(pattern) }) {
+			panic(fmt.Sprintf("flightrecorder: disabled endpoint %q matches no endpoint", pattern))
+		}
+	}
+	return func(o *options) {
+		o.disabledEndpoints = append(o.disabledEndpoints, patterns...)
+	}
+}
+
+// EndpointDisabled reports whether an endpoint pattern, see WithDisabledEndpoints, matches a disabled endpoint,
+// for adapters exposing the endpoints in another form
+func (s *Service) EndpointDisabled(pattern string) bool {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	for _, route := range s.routes() {
+		if (method == "" || route.Method == method) && route.matches(path) && s.disabled(route) {
+			return true
+		}
+	}
+	return false
+}
+
+// disabled reports whether the route is disabled by WithDisabledEndpoints
+func (s *Service) disabled(route Route) bool {
+	return slices.ContainsFunc(s.opts.disabledEndpoints, route.matches)
+}
+
+// disableRoutes drops the disabled routes. The handlers of the others respond 404 to the methods
+// which are disabled, since RegisterHandlers registers a path with the handler of its first route.
+func (s *Service) disableRoutes(routes []Route) []Route {
+	if len(s.opts.disabledEndpoints) == 0 {
+		return routes
+	}
+
+	enabled := routes[:0]
+	for _, route := range routes {
+		if s.disabled(route) {
+			continue
+		}
+		path, h := route.Path, route.Handler
+		route.Handler = func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions && s.disabled(Route{Method: r.Method, Path: path}) {
+				http.NotFound(w, r)
+				return
+			}
+			h(w, r)
+		}
+		enabled = append(enabled, route)
+	}
+	return enabled
+}
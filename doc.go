@@ -0,0 +1,8 @@
+// Package flightrecorder serves the runtime/trace flight recorder over HTTP, so snapshots of recent
+// execution traces can be taken from running processes on request or on triggers.
+//
+// The module github.com/mcwalrus/http-flight-recorder follows semantic versioning. Within v1, the exported
+// API of this package and its subpackages and the /v1 paths of the HTTP API (see APIVersion) only change in
+// backwards-compatible ways: fields, options and endpoints are added, and superseded ones are marked
+// deprecated but kept until the next major version.
+package flightrecorder
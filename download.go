@@ -0,0 +1,68 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// maxSnapshotDownloads is the number of downloads kept per stored snapshot, the most recent ones
+const maxSnapshotDownloads = 100
+
+// Formats of snapshot downloads
+const (
+	DownloadTrace      = "trace"      // the trace, GET /snapshots/{id}
+	DownloadProfile    = "pprof"      // its pprof profile, GET /snapshots/{id}/pprof
+	DownloadFlamegraph = "flame"      // its flame graph, GET /snapshots/{id}/flame
+	DownloadSignedURL  = "signed_url" // a signed download URL, GET /snapshots/{id}/url
+)
+
+// SnapshotDownload records a download of a stored snapshot through the HTTP API, since traces may contain
+// sensitive data. Downloads are attributed to the principal of WithPrincipal, or the common name of the client
+// certificate with mutual TLS.
+type SnapshotDownload struct {
+	Time       time.Time `json:"time"`
+	By         string    `json:"by,omitempty"` // principal which downloaded the snapshot
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Format     string    `json:"format"` // trace, pprof, flame or signed_url
+	// Quota is the use of the quota of the principal by the download, see WithPrincipalQuota
+	Quota *QuotaUsage `json:"quota,omitempty"`
+}
+
+// recordDownload records a download of a stored snapshot by the request and publishes a snapshot_downloaded event
+func (s *Service) recordDownload(r *http.Request, id, format string) {
+	download := SnapshotDownload{
+		Time:       time.Now(),
+		By:         s.principal(r),
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+		Format:     format,
+		Quota:      quotaUsageFrom(r.Context()),
+	}
+	meta, ok := s.store.recordDownload(id, download)
+	if !ok {
+		return
+	}
+	s.publish(Event{Type: EventSnapshotDownloaded, Time: download.Time, Snapshot: &meta, Download: &download})
+}
+
+// recordDownload adds a download to the metadata of a stored snapshot and returns the metadata
+func (st *snapshotStore) recordDownload(id string, download SnapshotDownload) (SnapshotMeta, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for i, snap := range st.snapshots {
+		if snap.meta.ID != id {
+			continue
+		}
+		// The downloads are copied rather than appended in place, since listed metadata shares them.
+		downloads := append(slices.Clip(snap.meta.Downloads), download)
+		if len(downloads) > maxSnapshotDownloads {
+			downloads = slices.Clone(downloads[len(downloads)-maxSnapshotDownloads:])
+		}
+		st.snapshots[i].meta.Downloads = downloads
+		return st.snapshots[i].meta, true
+	}
+	return SnapshotMeta{}, false
+}
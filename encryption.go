@@ -0,0 +1,146 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// EncryptedSuffix is appended to the names of encrypted snapshots
+	EncryptedSuffix = ".enc"
+
+	// encryptionAlgorithm is the value of HeaderSnapshotEncrypted
+	encryptionAlgorithm = "aes-gcm"
+)
+
+// encryptionMagic starts encrypted snapshots, followed by the nonce and the sealed trace
+var encryptionMagic = []byte("FRENC\x01")
+
+// ErrDecrypt is returned when an encrypted snapshot can't be decrypted with the key
+var ErrDecrypt = errors.New("failed to decrypt snapshot")
+
+// KeyFunc returns the AES key snapshots are encrypted with, 16, 24 or 32 bytes for AES-128, AES-192 or AES-256.
+// It is called for every snapshot, so keys fetched from a KMS can be cached and rotated by the function.
+type KeyFunc func(ctx context.Context) ([]byte, error)
+
+// WithEncryption encrypts captured snapshots with AES-GCM before they are kept in the snapshot store,
+// written to the sink or queued for retries, since traces can contain sensitive goroutine labels and
+// region names. Encrypted snapshots are named with EncryptedSuffix and decrypted with DecryptSnapshot,
+// e.g. by flightctl. Live snapshots from GET /snapshot are not stored and not encrypted.
+func WithEncryption(key KeyFunc) Option {
+	return func(o *options) {
+		o.encryptionKey = key
+	}
+}
+
+// KeyFromEnv returns a KeyFunc reading a base64 encoded key from the environment variable
+func KeyFromEnv(name string) KeyFunc {
+	return func(ctx context.Context) ([]byte, error) {
+		return DecodeKey(os.Getenv(name))
+	}
+}
+
+// StaticKey returns a KeyFunc returning the key
+func StaticKey(key []byte) KeyFunc {
+	return func(ctx context.Context) ([]byte, error) {
+		return key, nil
+	}
+}
+
+// DecodeKey decodes a base64 encoded AES key
+func DecodeKey(encoded string) ([]byte, error) {
+	return decodeBase64Key(encoded, "encryption key")
+}
+
+// IsEncrypted reports whether data is an encrypted snapshot
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptionMagic)
+}
+
+// EncryptSnapshot encrypts a snapshot with AES-GCM
+func EncryptSnapshot(key, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(encryptionMagic)+aead.NonceSize(), len(encryptionMagic)+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(out, encryptionMagic)
+	nonce := out[len(encryptionMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, data, encryptionMagic), nil
+}
+
+// DecryptSnapshot decrypts a snapshot encrypted by EncryptSnapshot
+func DecryptSnapshot(key, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("%w: not an encrypted snapshot", ErrDecrypt)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[len(encryptionMagic):]
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: truncated", ErrDecrypt)
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], encryptionMagic)
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrong key or corrupted snapshot", ErrDecrypt)
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSnapshot encrypts a captured snapshot when encryption is configured
+func (s *Service) encryptSnapshot(meta SnapshotMeta, data []byte) (SnapshotMeta, []byte, error) {
+	if s.opts.encryptionKey == nil {
+		return meta, data, nil
+	}
+
+	key, err := s.opts.encryptionKey(s.ctx)
+	if err != nil {
+		return SnapshotMeta{}, nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	data, err = EncryptSnapshot(key, data)
+	if err != nil {
+		return SnapshotMeta{}, nil, fmt.Errorf("failed to encrypt snapshot: %w", err)
+	}
+	meta.Name += EncryptedSuffix
+	meta.Size = int64(len(data))
+	meta.Encrypted = true
+	s.sealSnapshot(&meta, data)
+	return meta, data, nil
+}
+
+// decryptSnapshot returns the trace of a stored snapshot, decrypting it when it is encrypted
+func (s *Service) decryptSnapshot(meta SnapshotMeta, data []byte) ([]byte, error) {
+	if !meta.Encrypted {
+		return data, nil
+	}
+	if s.opts.encryptionKey == nil {
+		return nil, fmt.Errorf("%w: no encryption key", ErrDecrypt)
+	}
+
+	key, err := s.opts.encryptionKey(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	return DecryptSnapshot(key, data)
+}
@@ -0,0 +1,123 @@
+package flightrecorder
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Errors returned by the service, the API reports them with their error code
+var (
+	ErrAlreadyRunning     = errors.New("flight recorder is already running")
+	ErrNotRunning         = errors.New("flight recorder is not running")
+	ErrSnapshotInProgress = errors.New("flight recorder snapshot already in progress")
+	ErrSnapshotTimeout    = errors.New("flight recorder snapshot timed out")
+	ErrInvalidRequest     = errors.New("invalid request")
+)
+
+// ErrorCode is a machine-readable error code of an error response
+type ErrorCode string
+
+const (
+	CodeAlreadyRunning       ErrorCode = "already_running"
+	CodeNotRunning           ErrorCode = "not_running"
+	CodeSnapshotInProgress   ErrorCode = "snapshot_in_progress"
+	CodeSnapshotTimeout      ErrorCode = "snapshot_timeout"
+	CodeSnapshotVetoed       ErrorCode = "snapshot_vetoed"
+	CodeSnapshotNotFound     ErrorCode = "snapshot_not_found"
+	CodeInvalidSnapshot      ErrorCode = "invalid_snapshot"
+	CodeInvalidRequest       ErrorCode = "invalid_request"
+	CodeInvalidConfig        ErrorCode = "invalid_config"
+	CodeClosed               ErrorCode = "closed"
+	CodeForbidden            ErrorCode = "forbidden"
+	CodeQuotaExceeded        ErrorCode = "quota_exceeded"
+	CodeSignedURLUnsupported ErrorCode = "signed_url_unsupported"
+	CodeReadOnly             ErrorCode = "read_only"
+	CodeNotLeader            ErrorCode = "not_leader"
+	CodeRateLimited          ErrorCode = "rate_limited"
+	CodeInternal             ErrorCode = "internal"
+)
+
+// errorCodes maps sentinel errors to their error codes
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrAlreadyRunning, CodeAlreadyRunning},
+	{ErrNotRunning, CodeNotRunning},
+	{ErrSnapshotTimeout, CodeSnapshotTimeout}, // before ErrSnapshotInProgress, as timed out retries wrap both
+	{ErrSnapshotInProgress, CodeSnapshotInProgress},
+	{ErrSnapshotVetoed, CodeSnapshotVetoed},
+	{ErrSnapshotNotFound, CodeSnapshotNotFound},
+	{ErrInvalidSnapshot, CodeInvalidSnapshot},
+	{ErrInvalidRequest, CodeInvalidRequest},
+	{ErrClosed, CodeClosed},
+	{ErrForbidden, CodeForbidden},
+	{ErrQuotaExceeded, CodeQuotaExceeded},
+	{ErrSignedURLUnsupported, CodeSignedURLUnsupported},
+	{ErrReadOnly, CodeReadOnly},
+	{ErrNotLeader, CodeNotLeader},
+	{ErrPrincipalQuotaExceeded, CodeRateLimited},
+}
+
+// ConfigError describes an invalid configuration field
+type ConfigError struct {
+	Field   string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid " + e.Field + ": " + e.Message
+}
+
+// ErrorResponse represents an error response.
+// Clients can match it against the sentinel errors with errors.Is.
+type ErrorResponse struct {
+	Code    ErrorCode         `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+	// Instance identifies the service instance which answered, see Service.Instance
+	Instance string `json:"instance,omitempty"`
+}
+
+func (e *ErrorResponse) Error() string {
+	return e.Message
+}
+
+// Is reports whether the response has the error code of target
+func (e *ErrorResponse) Is(target error) bool {
+	for _, c := range errorCodes {
+		if c.err == target {
+			return c.code == e.Code
+		}
+	}
+	return false
+}
+
+// newErrorResponse builds the error response of err
+func newErrorResponse(err error) ErrorResponse {
+	resp := ErrorResponse{Code: CodeInternal, Message: err.Error()}
+
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		resp.Code = CodeInvalidConfig
+		resp.Details = map[string]string{"field": configErr.Field}
+		return resp
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			resp.Code = c.code
+			break
+		}
+	}
+	return resp
+}
+
+// writeError writes err as a JSON error response with the status code
+func writeError(w http.ResponseWriter, status int, err error) {
+	resp := newErrorResponse(err)
+	resp.Instance = w.Header().Get(HeaderInstance)
+	data, _ := responseStyleOf(w).encodeError(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
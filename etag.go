@@ -0,0 +1,69 @@
+package flightrecorder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// writeCached writes v in the negotiated format with an ETag of its content,
+// responding 304 Not Modified when the client already has it, so polling is cheap.
+func writeCached(w http.ResponseWriter, r *http.Request, v any) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	data, err := render(format, responseStyleOf(w), v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	etag := contentETag(data)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Write(data)
+}
+
+// contentETag returns the ETag of a response body
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// cachedETag returns the ETag writeCached sends for v, empty if v cannot be rendered
+func cachedETag(w http.ResponseWriter, r *http.Request, v any) string {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		return ""
+	}
+	data, err := render(format, responseStyleOf(w), v)
+	if err != nil {
+		return ""
+	}
+	return contentETag(data)
+}
+
+// etagMatch reports whether an If-None-Match header matches the etag,
+// using the weak comparison of RFC 9110
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package flightrecorder
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// eventBufferSize is the number of events buffered per subscriber
+// before further events are dropped for that subscriber.
+const eventBufferSize = 16
+
+// eventKeepAlive is how often an idle event stream sends a keep-alive comment
+const eventKeepAlive = 30 * time.Second
+
+// maxRecentEvents is the number of events kept for RecentEvents
+const maxRecentEvents = 500
+
+// EventType identifies a change in the flight recorder service
+type EventType string
+
+const (
+	EventStatus         EventType = "status"          // current status, sent when a stream opens
+	EventStarted        EventType = "started"         // flight recorder started
+	EventStopped        EventType = "stopped"         // flight recorder stopped
+	EventCleared        EventType = "cleared"         // flight recorder buffer discarded
+	EventUpdated        EventType = "updated"         // configuration updated
+	EventSnapshot       EventType = "snapshot"        // snapshot taken
+	EventSnapshotStored EventType = "snapshot_stored" // snapshot kept in the snapshot store
+	EventTriggerFired   EventType = "trigger_fired"   // automatic trigger fired
+	EventLocked         EventType = "locked"          // service locked read-only
+	EventUnlocked       EventType = "unlocked"        // read-only lock lifted
+
+	EventSnapshotDownloaded EventType = "snapshot_downloaded" // stored snapshot downloaded, see SnapshotDownload
+	EventRateLimited        EventType = "rate_limited"        // request rejected by the quota of its principal, see WithPrincipalQuota
+)
+
+// Event describes a change in the flight recorder service
+type Event struct {
+	Type     EventType         `json:"type"`
+	Time     time.Time         `json:"time"`
+	Status   *StatusResponse   `json:"status,omitempty"`
+	Snapshot *SnapshotMeta     `json:"snapshot,omitempty"`
+	Trigger  string            `json:"trigger,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Download *SnapshotDownload `json:"download,omitempty"`
+	Quota    *QuotaUsage       `json:"quota,omitempty"`
+}
+
+// eventBroker fans out events to subscribers
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+	recent      []Event // recent events but status and live snapshots, oldest first
+}
+
+func (b *eventBroker) subscribe() chan Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, eventBufferSize)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Event]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, ch)
+}
+
+// close closes the channels of all subscribers
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		close(ch)
+	}
+	b.subscribers = nil
+	b.closed = true
+}
+
+// publish sends the event to all subscribers without blocking;
+// slow subscribers miss events rather than stalling the service.
+func (b *eventBroker) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e.Type != EventStatus && e.Type != EventSnapshot {
+		if len(b.recent) == maxRecentEvents {
+			b.recent = slices.Delete(b.recent, 0, 1)
+		}
+		b.recent = append(b.recent, e)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving service events and a function to cancel the subscription.
+// Events are dropped for subscribers which do not keep up. The channel is closed when the service is closed.
+func (s *Service) Subscribe() (<-chan Event, func()) {
+	ch := s.events.subscribe()
+	return ch, func() { s.events.unsubscribe(ch) }
+}
+
+// RecentEvents returns the last 500 events, oldest first, except status events and the snapshot events
+// of snapshots which were not stored, e.g. to annotate dashboards with the activity of the recorder
+func (s *Service) RecentEvents() []Event {
+	s.events.mu.Lock()
+	defer s.events.mu.Unlock()
+
+	return slices.Clone(s.events.recent)
+}
+
+func (s *Service) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.events.publish(e)
+}
+
+// publishStatusLocked publishes an event carrying the current status.
+// s.mu must be held.
+func (s *Service) publishStatusLocked(t EventType) {
+	status := s.status()
+	s.publish(Event{Type: t, Status: &status})
+}
+
+func (s *Service) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Events are renamed like responses, but not wrapped in the envelope.
+	style := responseStyle{naming: responseStyleOf(w).naming}
+	writeEvent := func(e Event) error {
+		data, err := style.encode(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	status := s.Status()
+	if err := writeEvent(Event{Type: EventStatus, Time: time.Now(), Status: &status}); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case e, ok := <-events:
+			// The subscription is closed when the service is torn down.
+			if !ok {
+				return
+			}
+			if err := writeEvent(e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package flightrecorder
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsStreamEndsWhenSubscriptionCloses(t *testing.T) {
+	s := NewService()
+	t.Cleanup(func() { s.Close() })
+
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/recorder/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || lines.Text() != "event: status" {
+		t.Fatalf("got %q, want the status event", lines.Text())
+	}
+
+	// The broker closes the subscriptions before the service context is checked again.
+	s.events.close()
+
+	var events []string
+	for lines.Scan() {
+		if event, ok := strings.CutPrefix(lines.Text(), "event: "); ok {
+			events = append(events, event)
+		}
+		if len(events) > 10 {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		t.Fatal("stream not ended after the subscription closed")
+	}
+	if len(events) > 0 {
+		t.Errorf("got events %q after the subscription closed", events)
+	}
+}
@@ -3,9 +3,9 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -15,7 +15,8 @@ import (
 	"syscall"
 	"time"
 
-	flightrecorder "flight-recorder"
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+	"github.com/mcwalrus/http-flight-recorder/flightrecorder/client"
 )
 
 const (
@@ -25,15 +26,13 @@ const (
 
 type FlightRecorderCLI struct {
 	server   *http.Server
-	client   *http.Client
-	baseURL  string
+	client   *client.Client
 	shutdown chan os.Signal
 }
 
 func NewFlightRecorderCLI() *FlightRecorderCLI {
 	return &FlightRecorderCLI{
-		client:   &http.Client{Timeout: 5 * time.Second},
-		baseURL:  baseURL,
+		client:   client.New(baseURL + "/recorder"),
 		shutdown: make(chan os.Signal, 1),
 	}
 }
@@ -64,7 +63,8 @@ func (cli *FlightRecorderCLI) StartServer() {
 		log.Println("  POST /recorder/start")
 		log.Println("  POST /recorder/stop")
 		log.Println("  GET  /recorder/snapshot")
-		log.Println("  POST  /recorder/update")
+		log.Println("  GET  /recorder/config")
+		log.Println("  PATCH /recorder/config")
 		log.Println("")
 
 		if err := cli.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -82,51 +82,26 @@ func (cli *FlightRecorderCLI) StopServer() {
 }
 
 func (cli *FlightRecorderCLI) GetStatus() error {
-	resp, err := cli.client.Get(cli.baseURL + "/recorder/status")
+	status, err := cli.client.Status(context.Background())
 	if err != nil {
 		return fmt.Errorf("failed to get status: %w", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server error: %s", string(body))
-	}
-
-	var status map[string]interface{}
-	if err := json.Unmarshal(body, &status); err != nil {
-		return fmt.Errorf("failed to parse status: %w", err)
-	}
 
 	fmt.Printf("Flight Recorder Status:\n")
-	fmt.Printf("  Enabled: %t\n", status["enabled"])
-	fmt.Printf("  Period: %v\n", status["period"])
-	fmt.Printf("  Size: %d bytes\n", status["size"])
+	fmt.Printf("  Enabled: %t\n", status.Enabled)
+	fmt.Printf("  Period: %v\n", status.Period)
+	fmt.Printf("  Size: %s\n", status.Size)
 	return nil
 }
 
 func (cli *FlightRecorderCLI) StartFlightRecorder() error {
-	resp, err := cli.client.Post(cli.baseURL+"/recorder/start", "application/json", nil)
-	if err != nil {
-		return fmt.Errorf("failed to start flight recorder: %w", err)
+	err := cli.client.Start(context.Background())
+	if errors.Is(err, flightrecorder.ErrAlreadyRunning) {
+		fmt.Println("Flight recorder is already running.")
+		return nil
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		var errorResp flightrecorder.ErrorResponse
-		if err := json.Unmarshal(body, &errorResp); err == nil {
-			return fmt.Errorf("server error: %s", errorResp.Error)
-		}
-		return fmt.Errorf("server error: %s", string(body))
+		return fmt.Errorf("failed to start flight recorder: %w", err)
 	}
 
 	fmt.Println("Flight recorder started successfully!")
@@ -134,23 +109,13 @@ func (cli *FlightRecorderCLI) StartFlightRecorder() error {
 }
 
 func (cli *FlightRecorderCLI) StopFlightRecorder() error {
-	resp, err := cli.client.Post(cli.baseURL+"/recorder/stop", "application/json", nil)
-	if err != nil {
-		return fmt.Errorf("failed to stop flight recorder: %w", err)
+	err := cli.client.Stop(context.Background())
+	if errors.Is(err, flightrecorder.ErrNotRunning) {
+		fmt.Println("Flight recorder is not running.")
+		return nil
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		var errorResp flightrecorder.ErrorResponse
-		if err := json.Unmarshal(body, &errorResp); err == nil {
-			return fmt.Errorf("server error: %s", errorResp.Error)
-		}
-		return fmt.Errorf("server error: %s", string(body))
+		return fmt.Errorf("failed to stop flight recorder: %w", err)
 	}
 
 	fmt.Println("Flight recorder stopped successfully!")
@@ -158,32 +123,18 @@ func (cli *FlightRecorderCLI) StopFlightRecorder() error {
 }
 
 func (cli *FlightRecorderCLI) GetSnapshot() error {
-	resp, err := cli.client.Get(cli.baseURL + "/recorder/snapshot")
-	if err != nil {
+	var buf bytes.Buffer
+	if _, err := cli.client.Snapshot(context.Background(), &buf); err != nil {
 		return fmt.Errorf("failed to get snapshot: %w", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		var errorResp flightrecorder.ErrorResponse
-		if err := json.Unmarshal(body, &errorResp); err == nil {
-			return fmt.Errorf("server error: %s", errorResp.Error)
-		}
-		return fmt.Errorf("server error: %s", string(body))
-	}
 
 	// Save snapshot to file
 	filename := fmt.Sprintf("snapshot_%d.trace", time.Now().Unix())
-	if err := os.WriteFile(filename, body, 0644); err != nil {
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
 		return fmt.Errorf("failed to save snapshot: %w", err)
 	}
 
-	fmt.Printf("Snapshot saved to %s (%d bytes)\n", filename, len(body))
+	fmt.Printf("Snapshot saved to %s (%d bytes)\n", filename, buf.Len())
 	return nil
 }
 
@@ -202,7 +153,7 @@ func (cli *FlightRecorderCLI) UpdateFlightRecorder() error {
 
 	if periodStr != "" {
 		if period, err := strconv.Atoi(periodStr); err == nil {
-			periodDuration := time.Duration(period) * time.Second
+			periodDuration := flightrecorder.Duration(time.Duration(period) * time.Second)
 			updateReq.Period = &periodDuration
 		} else {
 			return fmt.Errorf("invalid period: %s", periodStr)
@@ -210,37 +161,17 @@ func (cli *FlightRecorderCLI) UpdateFlightRecorder() error {
 	}
 
 	if sizeStr != "" {
-		if size, err := strconv.Atoi(sizeStr); err == nil {
-			sizeBytes := size * 1024 * 1024 // Convert MB to bytes
+		if size, err := strconv.ParseInt(sizeStr, 10, 64); err == nil {
+			sizeBytes := flightrecorder.ByteSize(size * 1024 * 1024) // Convert MB to bytes
 			updateReq.Size = &sizeBytes
 		} else {
 			return fmt.Errorf("invalid size: %s", sizeStr)
 		}
 	}
 
-	jsonData, err := json.Marshal(updateReq)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	resp, err := cli.client.Post(cli.baseURL+"/recorder/update", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
+	if err := cli.client.Update(context.Background(), updateReq); err != nil {
 		return fmt.Errorf("failed to update flight recorder: %w", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		var errorResp flightrecorder.ErrorResponse
-		if err := json.Unmarshal(body, &errorResp); err == nil {
-			return fmt.Errorf("server error: %s", errorResp.Error)
-		}
-		return fmt.Errorf("server error: %s", string(body))
-	}
 
 	fmt.Println("Flight recorder configuration updated successfully!")
 	return nil
@@ -7,7 +7,7 @@ import (
 	"os/signal"
 	"syscall"
 
-	flightrecorder "flight-recorder"
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
 )
 
 func main() {
@@ -0,0 +1,91 @@
+package flightrecorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ExportTrigger is the trigger of the chunks written by continuous export
+const ExportTrigger = "export"
+
+// ContinuousExport ships the flight recorder buffer to a remote collector every interval, like continuous
+// profiling, so traces of any moment are available without an on-demand snapshot. Chunks are written to
+// the sink only, they are neither kept in the snapshot store nor queued for retries: a failed chunk is
+// superseded by the next one. Chunks are only written while the recorder is running. The recorder
+// period should be at least the interval, so consecutive chunks cover the time between them.
+type ContinuousExport struct {
+	Sink     Sink          // destination of the chunks, e.g. HTTPSink or HTTPStreamSink
+	Interval time.Duration // time between two chunks, e.g. a minute
+}
+
+func (c ContinuousExport) enabled() bool {
+	return c.Sink != nil && c.Interval > 0
+}
+
+// WithContinuousExport enables continuous export of the buffer to a sink.
+// Sinks implementing io.Closer, e.g. HTTPStreamSink, are closed by Close.
+func WithContinuousExport(c ContinuousExport) Option {
+	return func(o *options) {
+		o.export = c
+	}
+}
+
+// runExport writes a chunk to the export sink every interval until ctx is done
+func (s *Service) runExport(ctx context.Context) {
+	defer s.health.export.Store(false)
+	if closer, ok := s.opts.export.Sink.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	ticker := time.NewTicker(s.opts.export.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.exportChunk(ctx)
+			if errors.Is(err, ErrNotRunning) {
+				continue
+			}
+			s.health.recordExport(err)
+		}
+	}
+}
+
+// exportChunk writes the flight recorder buffer to the export sink, within an interval
+func (s *Service) exportChunk(ctx context.Context) error {
+	meta, data, err := s.snapshot(ExportTrigger)
+	if err != nil {
+		return err
+	}
+	if meta, data, err = s.encryptSnapshot(meta, data); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.opts.export.Interval)
+	defer cancel()
+	if err := s.opts.export.Sink.Write(ctx, meta, data); err != nil {
+		return fmt.Errorf("failed to export chunk %s: %w", meta.ID, err)
+	}
+	return nil
+}
+
+// recordExport records the result of the last chunk export
+func (h *serviceHealth) recordExport(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.exportErr = err
+}
+
+func (h *serviceHealth) exportWriteErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.exportErr
+}
@@ -0,0 +1,179 @@
+//go:build flightrecorder_faults
+
+package flightrecorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// ErrFaultInjected is the error of the failures injected with POST /faults
+var ErrFaultInjected = errors.New("injected fault")
+
+// FaultRequest configures the failures injected by POST /faults, replacing the active ones. It is only
+// available with the flightrecorder_faults build tag, for integration tests of dashboards, clients and runbooks.
+type FaultRequest struct {
+	SnapshotError  string   `json:"snapshot_error,omitempty"`  // snapshots fail with the message
+	SnapshotActive bool     `json:"snapshot_active,omitempty"` // snapshots fail as if another one was in progress
+	SlowWrite      Duration `json:"slow_write,omitempty"`      // writes of the buffer are delayed
+	SinkError      string   `json:"sink_error,omitempty"`      // sink writes fail with the message
+	// Count is the number of operations each fault applies to, 0 until the faults are cleared
+	Count int `json:"count,omitempty"`
+}
+
+// Faults are the active faults, with the remaining number of operations each applies to, 0 until cleared
+type Faults struct {
+	FaultRequest
+	Remaining map[string]int `json:"remaining,omitempty"`
+}
+
+// faultInjector injects the failures set with POST /faults
+type faultInjector struct {
+	mu        sync.Mutex
+	req       FaultRequest
+	remaining map[string]int
+}
+
+// takeLocked reports whether the fault applies to an operation, counting it down
+func (f *faultInjector) takeLocked(fault string, active bool) bool {
+	if !active {
+		return false
+	}
+	if f.req.Count == 0 {
+		return true
+	}
+	if f.remaining[fault] == 0 {
+		return false
+	}
+	f.remaining[fault]--
+	return true
+}
+
+// snapshotFault delays the write of the buffer and returns the injected snapshot failure, if any
+func (f *faultInjector) snapshotFault(ctx context.Context) error {
+	f.mu.Lock()
+	slow := f.takeLocked("slow_write", f.req.SlowWrite > 0)
+	delay := time.Duration(f.req.SlowWrite)
+	var err error
+	switch {
+	case f.takeLocked("snapshot_active", f.req.SnapshotActive):
+		err = trace.ErrSnapshotActive
+	case f.takeLocked("snapshot_error", f.req.SnapshotError != ""):
+		err = fmt.Errorf("%w: %s", ErrFaultInjected, f.req.SnapshotError)
+	}
+	f.mu.Unlock()
+
+	if slow {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
+
+// sinkFault returns the injected sink failure, if any
+func (f *faultInjector) sinkFault() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.takeLocked("sink_error", f.req.SinkError != "") {
+		return fmt.Errorf("%w: %s", ErrFaultInjected, f.req.SinkError)
+	}
+	return nil
+}
+
+// set replaces the active faults
+func (f *faultInjector) set(req FaultRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.req, f.remaining = req, nil
+	if req.Count > 0 {
+		f.remaining = map[string]int{}
+		for fault, active := range map[string]bool{
+			"snapshot_error":  req.SnapshotError != "",
+			"snapshot_active": req.SnapshotActive,
+			"slow_write":      req.SlowWrite > 0,
+			"sink_error":      req.SinkError != "",
+		} {
+			if active {
+				f.remaining[fault] = req.Count
+			}
+		}
+	}
+}
+
+func (f *faultInjector) active() Faults {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return Faults{FaultRequest: f.req, Remaining: maps.Clone(f.remaining)}
+}
+
+// InjectFaults replaces the simulated failures of the service, FaultRequest{} clears them
+func (s *Service) InjectFaults(req FaultRequest) error {
+	if req.SlowWrite < 0 {
+		return &ConfigError{Field: "slow_write", Message: fmt.Sprintf("%s must not be negative", req.SlowWrite)}
+	}
+	if req.Count < 0 {
+		return &ConfigError{Field: "count", Message: fmt.Sprintf("%d must not be negative", req.Count)}
+	}
+	s.faults.set(req)
+	if req == (FaultRequest{}) {
+		s.logger().Info("flight recorder faults cleared")
+	} else {
+		s.logger().Warn("flight recorder faults injected", "faults", req)
+	}
+	return nil
+}
+
+func init() {
+	routeDocs["GET /faults"] = routeDoc{summary: "Get the injected faults", response: Faults{}}
+	routeDocs["POST /faults"] = routeDoc{summary: "Inject simulated failures, replacing the active ones", request: FaultRequest{}, response: Faults{}}
+	routeDocs["DELETE /faults"] = routeDoc{summary: "Clear the injected faults", status: http.StatusNoContent}
+}
+
+// faultRoutes returns the fault injection endpoints
+func (s *Service) faultRoutes() []Route {
+	return []Route{
+		{http.MethodGet, "/faults", true, s.handleFaults},
+		{http.MethodPost, "/faults", true, s.handleFaults},
+		{http.MethodDelete, "/faults", true, s.handleFaults},
+	}
+}
+
+func (s *Service) handleFaults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeResponse(w, r, http.StatusOK, s.faults.active())
+
+	case http.MethodPost:
+		var req FaultRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest))
+			return
+		}
+		if err := s.InjectFaults(req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeResponse(w, r, http.StatusOK, s.faults.active())
+
+	case http.MethodDelete:
+		s.InjectFaults(FaultRequest{})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
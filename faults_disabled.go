@@ -0,0 +1,16 @@
+//go:build !flightrecorder_faults
+
+package flightrecorder
+
+import "context"
+
+// faultInjector injects simulated failures, only built with the flightrecorder_faults build tag
+type faultInjector struct{}
+
+func (*faultInjector) snapshotFault(ctx context.Context) error { return nil }
+func (*faultInjector) sinkFault() error                        { return nil }
+
+// faultRoutes returns the fault injection endpoints, none without the flightrecorder_faults build tag
+func (s *Service) faultRoutes() []Route {
+	return nil
+}
@@ -0,0 +1,192 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"golang.org/x/exp/trace"
+)
+
+// Event types and limits of the Go execution trace format (Go 1.22 and later)
+// needed to rewrite the string dictionary of a trace
+const (
+	traceEvEventBatch        = 1
+	traceEvStrings           = 4
+	traceEvString            = 5
+	traceEvExperimentalBatch = 49
+	traceEvEndOfGeneration   = 52
+
+	traceMaxBatchSize = 64 << 10
+	traceHeaderSuffix = " trace\x00\x00\x00"
+)
+
+// DefaultRedaction replaces redacted strings
+const DefaultRedaction = "[redacted]"
+
+// SnapshotFilter rewrites snapshots before they are stored, served or written to disk,
+// e.g. to redact sensitive annotations before traces leave an environment.
+// A failing filter fails the snapshot, so unfiltered traces are never served.
+type SnapshotFilter interface {
+	Filter(data []byte) ([]byte, error)
+}
+
+// SnapshotFilterFunc adapts a function to a SnapshotFilter
+type SnapshotFilterFunc func(data []byte) ([]byte, error)
+
+// Filter calls f(data)
+func (f SnapshotFilterFunc) Filter(data []byte) ([]byte, error) {
+	return f(data)
+}
+
+// WithSnapshotFilter adds filters rewriting every snapshot in order: served and stored snapshots,
+// bundles, continuous recording windows and crash dumps
+func WithSnapshotFilter(filters ...SnapshotFilter) Option {
+	return func(o *options) {
+		o.filters = append(o.filters, filters...)
+	}
+}
+
+// filterSnapshot runs the snapshot filters on data
+func (s *Service) filterSnapshot(data []byte) ([]byte, error) {
+	for _, f := range s.opts.filters {
+		var err error
+		if data, err = f.Filter(data); err != nil {
+			return nil, fmt.Errorf("failed to filter snapshot: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// Redactor is a SnapshotFilter replacing user annotations matching patterns: log messages
+// and categories, task names and region names. The rest of the trace is kept as is.
+type Redactor struct {
+	LogMessages   []*regexp.Regexp // trace.Log messages to redact
+	LogCategories []*regexp.Regexp // trace.Log categories to redact
+	TaskNames     []*regexp.Regexp // trace.NewTask names to redact
+	RegionNames   []*regexp.Regexp // trace.StartRegion and WithRegion names to redact
+	Replacement   string           // replaces redacted strings (default DefaultRedaction)
+}
+
+// Filter redacts the annotations of a trace. The trace's string dictionary is rewritten,
+// so a redacted string is also replaced where the trace uses it for anything else.
+func (r *Redactor) Filter(data []byte) ([]byte, error) {
+	redact := make(map[string]bool)
+	reader, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	for {
+		ev, err := reader.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+
+		switch ev.Kind() {
+		case trace.EventLog:
+			l := ev.Log()
+			r.match(redact, l.Message, r.LogMessages)
+			r.match(redact, l.Category, r.LogCategories)
+		case trace.EventTaskBegin, trace.EventTaskEnd:
+			r.match(redact, ev.Task().Type, r.TaskNames)
+		case trace.EventRegionBegin, trace.EventRegionEnd:
+			r.match(redact, ev.Region().Type, r.RegionNames)
+		}
+	}
+	if len(redact) == 0 {
+		return data, nil
+	}
+
+	replacement := r.Replacement
+	if replacement == "" {
+		replacement = DefaultRedaction
+	}
+	return RewriteStrings(data, func(s string) string {
+		if redact[s] {
+			return replacement
+		}
+		return s
+	})
+}
+
+func (r *Redactor) match(redact map[string]bool, s string, patterns []*regexp.Regexp) {
+	if s == "" || redact[s] {
+		return
+	}
+	for _, p := range patterns {
+		if p.MatchString(s) {
+			redact[s] = true
+			return
+		}
+	}
+}
+
+// RewriteStrings rewrites the string dictionary of a trace, which holds the function and file names
+// of stacks and the names and messages of user annotations, re-encoding the batches holding it.
+// Every other batch is copied unchanged.
+func RewriteStrings(data []byte, rewrite func(string) string) ([]byte, error) {
+	header, batches, err := readTraceBatches(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(header)
+	for _, b := range batches {
+		if b.typ != traceEvEventBatch || len(b.data) == 0 || b.data[0] != traceEvStrings {
+			out.Write(b.raw)
+			continue
+		}
+		rewritten, err := rewriteStringsBatch(b.data, rewrite)
+		if err != nil {
+			return nil, err
+		}
+		// The batch header is re-encoded with the size of the rewritten batch.
+		hr := bytes.NewReader(b.raw[1:])
+		out.WriteByte(traceEvEventBatch)
+		for range 3 {
+			v, _ := binary.ReadUvarint(hr)
+			out.Write(binary.AppendUvarint(nil, v))
+		}
+		out.Write(binary.AppendUvarint(nil, uint64(len(rewritten))))
+		out.Write(rewritten)
+	}
+	return out.Bytes(), nil
+}
+
+// rewriteStringsBatch rewrites the entries of a string dictionary batch
+func rewriteStringsBatch(batch []byte, rewrite func(string) string) ([]byte, error) {
+	out := []byte{traceEvStrings}
+	r := bytes.NewReader(batch[1:])
+	for r.Len() > 0 {
+		if typ, _ := r.ReadByte(); typ != traceEvString {
+			return nil, fmt.Errorf("%w: unexpected event %d in string dictionary", ErrInvalidSnapshot, typ)
+		}
+		id, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: truncated string dictionary", ErrInvalidSnapshot)
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, fmt.Errorf("%w: truncated string dictionary", ErrInvalidSnapshot)
+		}
+		s := make([]byte, n)
+		r.Read(s)
+
+		rewritten := rewrite(string(s))
+		out = append(out, traceEvString)
+		out = binary.AppendUvarint(out, id)
+		out = binary.AppendUvarint(out, uint64(len(rewritten)))
+		out = append(out, rewritten...)
+	}
+	if len(out) > traceMaxBatchSize {
+		return nil, errors.New("rewritten string dictionary exceeds the maximum batch size")
+	}
+	return out, nil
+}
@@ -0,0 +1,100 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	rtrace "runtime/trace"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/trace"
+)
+
+// recordAnnotatedTrace records a trace with a real flight recorder holding user annotations
+func recordAnnotatedTrace(t *testing.T) []byte {
+	t.Helper()
+	recorder := trace.NewFlightRecorder()
+	if err := recorder.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Stop()
+
+	ctx, task := rtrace.NewTask(context.Background(), "checkout customer-4711")
+	rtrace.WithRegion(ctx, "charge card-4111111111111111", func() {
+		rtrace.Log(ctx, "auth", "token=secret-2f7c")
+	})
+	task.End()
+
+	var buf bytes.Buffer
+	if _, err := recorder.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRedactorRewritesStrings(t *testing.T) {
+	data := recordAnnotatedTrace(t)
+	secrets := []string{"customer-4711", "card-4111111111111111", "token=secret-2f7c"}
+	for _, secret := range secrets {
+		if !bytes.Contains(data, []byte(secret)) {
+			t.Fatalf("trace doesn't hold %q", secret)
+		}
+	}
+
+	redactor := &Redactor{
+		LogMessages: []*regexp.Regexp{regexp.MustCompile(`secret`)},
+		TaskNames:   []*regexp.Regexp{regexp.MustCompile(`customer-\d+`)},
+		RegionNames: []*regexp.Regexp{regexp.MustCompile(`card-\d+`)},
+	}
+	filtered, err := redactor.Filter(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range secrets {
+		if bytes.Contains(filtered, []byte(secret)) {
+			t.Fatalf("redacted trace still holds %q", secret)
+		}
+	}
+
+	var redacted int
+	for _, ev := range parseTrace(t, filtered) {
+		switch ev.Kind() {
+		case trace.EventLog:
+			if ev.Log().Category == "auth" && ev.Log().Message == DefaultRedaction {
+				redacted++
+			}
+		case trace.EventTaskBegin:
+			if ev.Task().Type == DefaultRedaction {
+				redacted++
+			}
+		case trace.EventRegionBegin:
+			if ev.Region().Type == DefaultRedaction {
+				redacted++
+			}
+		}
+	}
+	if redacted != len(secrets) {
+		t.Fatalf("%d redacted annotations in the trace, want %d", redacted, len(secrets))
+	}
+}
+
+func TestRewriteStringsLongerStrings(t *testing.T) {
+	data := recordAnnotatedTrace(t)
+
+	rewritten, err := RewriteStrings(data, func(s string) string {
+		return strings.ToUpper(s) + "!"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, ev := range parseTrace(t, rewritten) {
+		if ev.Kind() == trace.EventLog && ev.Log().Message == "TOKEN=SECRET-2F7C!" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("rewritten log message not in the trace")
+	}
+}
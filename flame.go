@@ -0,0 +1,221 @@
+package flightrecorder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// FlameMetric is the time a flame graph shows
+type FlameMetric string
+
+const (
+	FlameRunning FlameMetric = "running" // time goroutines were running, at the stack where they stopped
+	FlameBlocked FlameMetric = "blocked" // time goroutines were blocked, at the stack where they blocked
+)
+
+// flameStates maps the flame graph metrics to the goroutine states they measure
+var flameStates = map[FlameMetric]trace.GoState{
+	FlameRunning: trace.GoRunning,
+	FlameBlocked: trace.GoWaiting,
+}
+
+// Flame graph SVG layout
+const (
+	flameWidth       = 1200
+	flameFrameHeight = 16
+	flameCharWidth   = 7 // approximate width of a character of the 12px font
+	flameMinWidth    = 0.5
+)
+
+// FlameNode is a frame of a flame graph, in the d3-flamegraph JSON format.
+// Value is the time in nanoseconds spent in the frame and its children.
+type FlameNode struct {
+	Name     string       `json:"name"`
+	Value    int64        `json:"value"`
+	Children []*FlameNode `json:"children,omitempty"`
+}
+
+// Flamegraph parses a snapshot into a flame graph of the time goroutines spent in the metric's state,
+// attributed to the goroutine stacks. The root is the whole trace, children are sorted by name.
+func Flamegraph(r io.Reader, metric FlameMetric) (*FlameNode, error) {
+	state, ok := flameStates[metric]
+	if !ok {
+		return nil, fmt.Errorf("%w: metric %q should be running or blocked", ErrInvalidRequest, metric)
+	}
+
+	root := &FlameNode{Name: "all"}
+	_, err := goroutineStackTimes(r, state, func(stack trace.Stack, d time.Duration) {
+		var frames []string
+		for frame := range stack.Frames() {
+			if frame.Func != "runtime.goexit" {
+				frames = append(frames, frame.Func)
+			}
+		}
+		if len(frames) == 0 {
+			frames = append(frames, unknownFunction)
+		}
+
+		node := root
+		node.Value += d.Nanoseconds()
+		// Frames are leaf first, flame graphs grow from the root.
+		for _, name := range slices.Backward(frames) {
+			node = node.child(name)
+			node.Value += d.Nanoseconds()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	root.sort()
+	return root, nil
+}
+
+func (n *FlameNode) child(name string) *FlameNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &FlameNode{Name: name}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *FlameNode) sort() {
+	slices.SortFunc(n.Children, func(a, b *FlameNode) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+func (n *FlameNode) depth() int {
+	depth := 0
+	for _, c := range n.Children {
+		depth = max(depth, c.depth())
+	}
+	return depth + 1
+}
+
+// WriteSVG renders the flame graph as a standalone SVG, with the root at the bottom
+// and the time of each frame in its tooltip
+func (n *FlameNode) WriteSVG(w io.Writer, title string) error {
+	bw := bufio.NewWriter(w)
+	height := (n.depth() + 2) * flameFrameHeight
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="monospace" font-size="12">`+"\n",
+		flameWidth, height, flameWidth, height)
+	fmt.Fprintf(bw, `<text x="%d" y="%d" text-anchor="middle" font-size="14">%s</text>`+"\n",
+		flameWidth/2, flameFrameHeight, html.EscapeString(title))
+	if n.Value > 0 {
+		n.writeSVG(bw, n.Value, 0, float64(height-flameFrameHeight), float64(flameWidth)/float64(n.Value))
+	}
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
+
+func (n *FlameNode) writeSVG(w io.Writer, total int64, x, y, scale float64) {
+	width := float64(n.Value) * scale
+	if width < flameMinWidth {
+		return
+	}
+
+	label := fmt.Sprintf("%s (%v, %.2f%%)", n.Name, time.Duration(n.Value), 100*float64(n.Value)/float64(total))
+	fmt.Fprintf(w, `<g><title>%s</title><rect x="%.1f" y="%.1f" width="%.1f" height="%d" fill="%s" rx="2"/>`,
+		html.EscapeString(label), x, y, width, flameFrameHeight-1, flameColor(n.Name))
+	if chars := int(width/flameCharWidth) - 1; chars >= 3 {
+		text := n.Name
+		if len(text) > chars {
+			text = text[:chars-2] + ".."
+		}
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f">%s</text>`, x+3, y+flameFrameHeight-4, html.EscapeString(text))
+	}
+	fmt.Fprintln(w, "</g>")
+
+	for _, c := range n.Children {
+		c.writeSVG(w, total, x, y-flameFrameHeight, scale)
+		x += float64(c.Value) * scale
+	}
+}
+
+// flameColor returns a warm color derived from the function name, so functions keep their colors across graphs
+func flameColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	v := h.Sum32()
+	return fmt.Sprintf("rgb(%d,%d,%d)", 205+v%50, 80+(v>>8)%150, (v>>16)%55)
+}
+
+// SnapshotFlamegraph parses a stored snapshot by ID into a flame graph, see Flamegraph
+func (s *Service) SnapshotFlamegraph(id string, metric FlameMetric) (SnapshotMeta, *FlameNode, error) {
+	meta, data, err := s.StoredSnapshot(id)
+	if err != nil {
+		return SnapshotMeta{}, nil, err
+	}
+	if data, err = s.decryptSnapshot(meta, data); err != nil {
+		return SnapshotMeta{}, nil, err
+	}
+	root, err := Flamegraph(bytes.NewReader(data), metric)
+	return meta, root, err
+}
+
+// handleSnapshotFlamegraph serves the flame graph of a stored snapshot as SVG,
+// or as d3-flamegraph JSON with ?format=json or Accept: application/json
+func (s *Service) handleSnapshotFlamegraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	metric := FlameMetric(query.Get("metric"))
+	if metric == "" {
+		metric = FlameRunning
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "svg"
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			format = "json"
+		}
+	}
+	if format != "svg" && format != "json" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: format %q should be svg or json", ErrInvalidRequest, format))
+		return
+	}
+
+	meta, root, err := s.SnapshotFlamegraph(r.PathValue("id"), metric)
+	if err != nil {
+		code := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrSnapshotNotFound):
+			code = http.StatusNotFound
+		case errors.Is(err, ErrInvalidRequest):
+			code = http.StatusBadRequest
+		}
+		writeError(w, code, err)
+		return
+	}
+
+	s.recordDownload(r, meta.ID, DownloadFlamegraph)
+	w.Header().Add("Vary", "Accept")
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(root)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	root.WriteSVG(w, fmt.Sprintf("%s: %s time of %s", meta.ID, metric, time.Duration(root.Value)))
+}
@@ -2,19 +2,42 @@ package flightrecorder
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math"
 	"net/http"
+	"os"
+	"reflect"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/exp/trace"
 )
 
 var (
-	once    sync.Once
-	service *Service
+	serviceMu sync.Mutex
+	service   *Service
+)
+
+// maxStatusWait bounds how long a status request may wait for a change
+const maxStatusWait = 5 * time.Minute
+
+// Snapshot timeout of the handlers, see WithSnapshotTimeout, and the Retry-After of timed out snapshots
+const (
+	defaultSnapshotTimeout = 30 * time.Second
+	snapshotRetryAfter     = 5 * time.Second
+)
+
+// Backoff between the attempts of WithSnapshotRetry, doubled after every attempt
+const (
+	snapshotRetryBackoff    = 10 * time.Millisecond
+	maxSnapshotRetryBackoff = 250 * time.Millisecond
 )
 
 // Service manages the flight recorder and HTTP endpoints
@@ -22,120 +45,846 @@ type Service struct {
 	recorder *trace.FlightRecorder
 	mu       sync.RWMutex
 	period   time.Duration
-	size     int
+	size     int64
+	opts     options
+	store    *snapshotStore
+	events   eventBroker
+	hooks    snapshotHooks
+	triggers triggerSet
+	limiter  triggerLimiter
+	quotas   quotaCounter
+	health   serviceHealth
+
+	background background
+
+	// startedAt is when the recorder buffer was last started empty,
+	// with the period and size the recorder was last started with
+	startedAt       time.Time
+	recordingPeriod time.Duration
+	recordingSize   int64
+	// buffer is the last measured size of the recorder buffer
+	buffer bufferMeasurement
+	// markers are the most recent markers, oldest first
+	markers []Marker
+
+	// labels describe the origin of snapshots, e.g. service and region
+	labels map[string]string
+
+	// readOnly is the read-only lock, nil when the service is not locked
+	readOnly *ReadOnlyLock
+	// remoteConfigDeferred is the remote configuration to apply once the service is unlocked
+	remoteConfigDeferred *UpdateRequest
+
+	// deprecations are the deprecated endpoints already warned about
+	deprecations deprecationLog
+
+	// configApplied records when and by whom the configuration was last changed, see WithPrincipal,
+	// configHistory the recent changes of the configuration, oldest first
+	configAppliedAt time.Time
+	configAppliedBy string
+	configHistory   []ConfigChange
+
+	// handlerMetrics counts the requests served by the handlers
+	handlerMetrics handlerMetrics
+
+	// sinkQueue holds the snapshots whose sink write is retried
+	sinkQueue sinkQueue
+
+	// leaks holds the recent goroutine samples of the leak detector
+	leaks leakSamples
+
+	// session is the recording session in progress, nil when the recorder runs outside sessions,
+	// sessions are the ended sessions, oldest first
+	session    *recordingSession
+	sessions   []Session
+	sessionSeq atomic.Uint64
+
+	// runtimeTrigger holds the runtime metrics trigger thresholds
+	runtimeTrigger     RuntimeTrigger
+	runtimeTriggerOnce sync.Once
+
+	// snapshotSeq numbers snapshots for their IDs and names
+	snapshotSeq atomic.Uint64
+	// requestSeq numbers the IDs of recorded requests without one, requests the recorded requests in flight
+	requestSeq atomic.Uint64
+	requests   requestRecordings
+	// slowRequests counts the slow requests of CaptureSlowRequests
+	slowRequests slowRequestCounters
+	hostname     string
+	pid          int
+	build        BuildInfo
+	instanceID   string // see Instance
+	faults       faultInjector
+
+	// ctx is cancelled when the service is torn down and
+	// bounds the lifetime of background goroutines.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-// StatusResponse represents the status of the flight recorder
+// StatusResponse represents the status of the flight recorder.
+// Durations are reported as Go durations and sizes in memory units.
 type StatusResponse struct {
-	Enabled bool          `json:"enabled"`
-	Period  time.Duration `json:"period"`
-	Size    int           `json:"size"`
+	// Instance identifies the service instance which answered, see Service.Instance
+	Instance              string   `json:"instance"`
+	Enabled               bool     `json:"enabled"`
+	Period                Duration `json:"period"`
+	Size                  ByteSize `json:"size"`
+	GCPauseThreshold      Duration `json:"gc_pause_threshold,omitempty"`
+	SchedLatencyThreshold Duration `json:"sched_latency_threshold,omitempty"`
+	// TriggerBudgetRemaining is the number of snapshots triggers may still take
+	// this hour, nil when no budget is configured.
+	TriggerBudgetRemaining *int `json:"trigger_budget_remaining,omitempty"`
+	// Buffer estimates the history retained by the buffer, nil when the recorder is stopped
+	Buffer *BufferUtilization `json:"buffer,omitempty"`
+	// SlowRequests counts the slow requests of CaptureSlowRequests, nil without the middleware
+	SlowRequests *SlowRequestStats `json:"slow_requests,omitempty"`
+	// Labels describe the origin of snapshots
+	Labels map[string]string `json:"labels,omitempty"`
+	// StoredSnapshots and StoredBytes are the number and total size of the snapshots in the store
+	StoredSnapshots int   `json:"stored_snapshots"`
+	StoredBytes     int64 `json:"stored_bytes"`
+	// StoreQuota is the maximum total size of the snapshot store, 0 without quota
+	StoreQuota ByteSize `json:"store_quota,omitempty"`
+	// SinkQueued is the number of snapshots waiting to be written to the sink, with SinkRetry
+	SinkQueued int `json:"sink_queued,omitempty"`
+	// SinkLastError is the error of the last failed sink write, cleared by a successful retry
+	SinkLastError string `json:"sink_last_error,omitempty"`
+	// Session is the recording session in progress, nil when the recorder runs outside sessions
+	Session *Session `json:"session,omitempty"`
+	// Build describes the process, e.g. the Go version producing the snapshots
+	Build BuildInfo `json:"build"`
+	// ReadOnly is the read-only lock, nil when the service is not locked
+	ReadOnly *ReadOnlyLock `json:"read_only,omitempty"`
+	// RemoteConfigDeferred reports a remote configuration waiting for the service to be unlocked, see WithRemoteConfig
+	RemoteConfigDeferred bool `json:"remote_config_deferred,omitempty"`
+	// Leader reports whether the replica is the leader, nil without a recorder gate, see WithRecorderGate
+	Leader *bool `json:"leader,omitempty"`
 }
 
 // UpdateRequest represents the update request payload
 type UpdateRequest struct {
-	Period *time.Duration `json:"period,omitempty"`
-	Size   *int           `json:"size,omitempty"`
+	Period                *Duration `json:"period,omitempty"`
+	Size                  *ByteSize `json:"size,omitempty"`
+	GCPauseThreshold      *Duration `json:"gc_pause_threshold,omitempty"`
+	SchedLatencyThreshold *Duration `json:"sched_latency_threshold,omitempty"`
+	// Labels are merged into the labels of the service, labels set to "" are removed.
+	// A full replace (PUT /config) replaces the labels instead.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Apply is when a new period or size takes effect on a running recorder (default on_restart),
+	// with FinalSnapshot capturing the buffer before an immediate restart discards it
+	Apply         ApplyMode `json:"apply,omitempty"`
+	FinalSnapshot bool      `json:"final_snapshot,omitempty"`
 }
 
-// ErrorResponse represents an error response
-type ErrorResponse struct {
-	Error string `json:"error"`
+// ResolvedConfig represents the configuration of the flight recorder, or the one resolved from an update request
+type ResolvedConfig struct {
+	Period                string `json:"period"`
+	PeriodNs              int64  `json:"period_ns"`
+	Size                  string `json:"size"`
+	SizeBytes             int64  `json:"size_bytes"`
+	GCPauseThreshold      string `json:"gc_pause_threshold"`
+	SchedLatencyThreshold string `json:"sched_latency_threshold"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// RestartPending reports the running recorder still uses the previous period or size, until it is restarted
+	RestartPending bool `json:"restart_pending,omitempty"`
+
+	// AppliedAt and AppliedBy record the last change made by an identified client, see WithPrincipal
+	AppliedAt time.Time `json:"applied_at,omitzero"`
+	AppliedBy string    `json:"applied_by,omitempty"`
+}
+
+// ControlResponse represents the response of an idempotent start or stop
+// which found the recorder already in the requested state
+type ControlResponse struct {
+	Instance       string `json:"instance"`
+	AlreadyRunning bool   `json:"already_running,omitempty"`
+	AlreadyStopped bool   `json:"already_stopped,omitempty"`
 }
 
 // InitService creates a new global flight recorder service.
-func InitService() *Service {
-	once.Do(func() {
-		service = &Service{
-			recorder: trace.NewFlightRecorder(),
-			period:   1 * time.Second,  // Default period
-			size:     64 * 1024 * 1024, // Default 64MB
-		}
-	})
+// Options are only applied by the first call, or the first call after ResetService.
+func InitService(opts ...Option) *Service {
+	serviceMu.Lock()
+	defer serviceMu.Unlock()
+
+	if service == nil {
+		service = NewService(opts...)
+	}
 	return service
 }
 
+// NewService creates a new flight recorder service.
+// Only one flight recorder may be running in a process at any given time,
+// so most applications should use the global service from InitService.
+func NewService(opts ...Option) *Service {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	hostname, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		recorder: trace.NewFlightRecorder(),
+		period:   1 * time.Second,  // Default period
+		size:     64 * 1024 * 1024, // Default 64MB
+		opts:     o,
+		store:    &snapshotStore{},
+		hostname: hostname,
+		pid:      os.Getpid(),
+		build:    readBuildInfo(hostname, os.Getpid()),
+		ctx:      ctx,
+		cancel:   cancel,
+
+		runtimeTrigger: o.runtimeTrigger,
+		instanceID:     newInstanceID(hostname, os.Getpid()),
+		labels:         o.labels,
+
+		sinkQueue: sinkQueue{wake: make(chan struct{}, 1)},
+	}
+
+	if o.readOnly != nil {
+		s.readOnly = &ReadOnlyLock{Reason: o.readOnly.Reason, LockedAt: time.Now()}
+	}
+
+	var resume bool
+	var session *Session
+	if o.stateFile != "" {
+		var err error
+		resume, session, err = s.restoreState()
+		s.health.recordStateFile(err)
+	}
+
+	if o.retention.enabled() {
+		s.health.janitor.Store(true)
+		s.goBackground(func() { s.runJanitor(ctx) })
+	}
+	if s.runtimeTrigger.enabled() {
+		s.startRuntimeTrigger()
+	}
+	if o.continuous.enabled() {
+		s.health.continuous.Store(true)
+		s.goBackground(func() { s.runContinuous(ctx) })
+	}
+	if o.export.enabled() {
+		s.health.export.Store(true)
+		s.goBackground(func() { s.runExport(ctx) })
+	}
+	if o.remoteConfig.enabled() {
+		s.health.remoteConfig.Store(true)
+		s.goBackground(func() { s.runRemoteConfig(ctx) })
+	}
+	if o.bufferSampling > 0 {
+		s.health.bufferSampler.Store(true)
+		s.goBackground(func() { s.runBufferSampler(ctx) })
+	}
+	if o.crashDir != "" {
+		s.health.crashHandler.Store(true)
+		s.goBackground(func() { s.runCrashHandler(ctx) })
+	}
+	if s.retryingSink() {
+		if err := s.sinkQueue.load(o.sinkRetry.Dir); err != nil {
+			s.sinkQueue.failed(err)
+		}
+		s.health.sinkRetry.Store(true)
+		s.goBackground(func() { s.runSinkRetry(ctx) })
+	}
+	for _, w := range o.webhooks {
+		events := s.events.subscribe()
+		s.goBackground(func() { s.runWebhook(ctx, w, events) })
+	}
+	for _, n := range o.notifiers {
+		events := s.events.subscribe()
+		s.goBackground(func() { s.runNotifier(ctx, n, events) })
+	}
+	for _, t := range o.triggers {
+		s.goBackground(func() { s.runTrigger(ctx, t) })
+	}
+	if o.leakDetector.enabled() {
+		s.health.leakDetector.Store(true)
+		s.goBackground(func() { s.runLeakDetector(ctx) })
+	}
+	if resume && o.resumeRecording {
+		if err := s.resume(session); err != nil {
+			s.health.recordStateFile(fmt.Errorf("failed to resume recording: %w", err))
+		}
+	}
+	return s
+}
+
 // Status returns the current status of the flight recorder
 func (s *Service) Status() StatusResponse {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return StatusResponse{
-		Enabled: s.recorder.Enabled(),
-		Period:  s.period,
-		Size:    s.size,
+	return s.status()
+}
+
+// status returns the current status, s.mu must be held
+func (s *Service) status() StatusResponse {
+	status := StatusResponse{
+		Instance:              s.instanceID,
+		Enabled:               s.recorder.Enabled(),
+		Period:                Duration(s.period),
+		Size:                  ByteSize(s.size),
+		GCPauseThreshold:      Duration(s.runtimeTrigger.GCPause),
+		SchedLatencyThreshold: Duration(s.runtimeTrigger.SchedLatency),
+		Labels:                maps.Clone(s.labels),
+		StoreQuota:            ByteSize(s.opts.quota.MaxBytes),
+		Session:               s.sessionStatus(),
+		Build:                 s.build,
+		ReadOnly:              s.readOnlyStatus(),
+		RemoteConfigDeferred:  s.remoteConfigDeferred != nil,
+		Leader:                s.leaderStatus(),
+	}
+	status.StoredSnapshots, status.StoredBytes = s.store.usage()
+	if queued, err := s.sinkQueue.state(); err != nil {
+		status.SinkQueued, status.SinkLastError = queued, err.Error()
+	} else {
+		status.SinkQueued = queued
+	}
+	if s.opts.triggerBudget > 0 {
+		remaining := s.limiter.remaining(s.opts.triggerBudget, time.Now())
+		status.TriggerBudgetRemaining = &remaining
 	}
+	status.Buffer = s.bufferUtilizationLocked(time.Now())
+	status.SlowRequests = s.slowRequests.stats()
+	return status
 }
 
 // Start starts the flight recorder
 func (s *Service) Start() error {
+	return s.StartContext(context.Background())
+}
+
+// StartContext starts the flight recorder, unless ctx is done
+func (s *Service) StartContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.recorder.Enabled() {
-		return fmt.Errorf("flight recorder is already running")
+	if err := s.startLocked(); err != nil {
+		return err
 	}
+	s.saveStateLocked()
+	s.publishStatusLocked(EventStarted)
+	return nil
+}
 
-	s.recorder.SetPeriod(s.period)
-	s.recorder.SetSize(s.size)
+// startLocked starts the flight recorder, s.mu must be held
+func (s *Service) startLocked() error {
+	if s.recorder.Enabled() {
+		return ErrAlreadyRunning
+	}
+	if s.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if s.gateRefuses() {
+		return ErrNotLeader
+	}
 
-	return s.recorder.Start()
+	if err := s.startRecorderLocked(); err != nil {
+		return err
+	}
+	traceEvent("started period=%s size=%s", Duration(s.period), ByteSize(s.size))
+	return nil
 }
 
-// Stop stops the flight recorder
+// Stop stops the flight recorder, ending the recording session if any
 func (s *Service) Stop() error {
+	return s.StopContext(context.Background())
+}
+
+// StopContext stops the flight recorder, ending the recording session if any, unless ctx is done
+func (s *Service) StopContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if !s.recorder.Enabled() {
-		return fmt.Errorf("flight recorder is not running")
+		return ErrNotRunning
+	}
+
+	err := s.recorder.Stop()
+	s.endSessionLocked()
+	s.saveStateLocked()
+	s.publishStatusLocked(EventStopped)
+	return err
+}
+
+// Clear discards the flight recorder buffer by stopping and immediately restarting
+// the recorder, atomically with respect to other calls on the service.
+func (s *Service) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.recorder.Enabled() {
+		return ErrNotRunning
+	}
+
+	if err := s.restartLocked(); err != nil {
+		if !s.recorder.Enabled() {
+			s.publishStatusLocked(EventStopped)
+		}
+		return err
 	}
+	traceEvent("cleared")
+	s.publishStatusLocked(EventCleared)
+	return nil
+}
 
-	return s.recorder.Stop()
+// restartLocked stops and starts the running recorder with the configured period and size,
+// discarding its buffer, s.mu must be held
+func (s *Service) restartLocked() error {
+	if err := s.recorder.Stop(); err != nil {
+		return fmt.Errorf("failed to stop flight recorder: %w", err)
+	}
+	if err := s.startRecorderLocked(); err != nil {
+		return fmt.Errorf("failed to restart flight recorder: %w", err)
+	}
+	return nil
+}
+
+// startRecorderLocked starts the stopped recorder with the configured period and size, recording them as
+// the period and size of the recording, s.mu must be held
+func (s *Service) startRecorderLocked() error {
+	s.recorder.SetPeriod(s.period)
+	s.recorder.SetSize(int(s.size))
+
+	if err := s.recorder.Start(); err != nil {
+		return err
+	}
+	s.startedAt, s.recordingPeriod, s.recordingSize = time.Now(), s.period, s.size
+	return nil
+}
+
+// Recorder returns the underlying flight recorder, for code which already works with the trace APIs,
+// e.g. to write the buffer to its own writer. It is the same recorder for the lifetime of the service.
+//
+// The service keeps its state (running, period, size, recording session) next to the recorder and
+// serializes its own calls on it. Starting, stopping or reconfiguring the recorder directly bypasses
+// that state, so use Start, Stop and Update instead, and UseRecorder to call it while the service is idle.
+// WriteTo may be called at any time: concurrent snapshots fail with trace.ErrSnapshotActive.
+func (s *Service) Recorder() *trace.FlightRecorder {
+	return s.recorder
+}
+
+// UseRecorder calls f with the underlying flight recorder while holding the service lock, so no
+// start, stop, update or snapshot of the service runs concurrently. f must not call the service.
+func (s *Service) UseRecorder(f func(r *trace.FlightRecorder) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return f(s.recorder)
+}
+
+// WaitForWindow waits until the flight recorder has been recording for the window since it was
+// last started or cleared, so a snapshot taken right after enabling is not almost empty.
+// The window is capped at the period, as the buffer holds no more than that.
+func (s *Service) WaitForWindow(ctx context.Context, window time.Duration) error {
+	for {
+		s.mu.RLock()
+		enabled, startedAt, period := s.recorder.Enabled(), s.startedAt, s.period
+		s.mu.RUnlock()
+
+		if !enabled {
+			return ErrNotRunning
+		}
+		remaining := min(window, period) - time.Since(startedAt)
+		if remaining <= 0 {
+			return nil
+		}
+
+		// Check again after waiting, the recorder may have been restarted meanwhile.
+		timer := time.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("timed out waiting for a %s window: %w", window, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// WaitForStatusChange waits until the state or configuration of the flight recorder differs from
+// since and returns the new status. When ctx is done first it returns the unchanged status and ctx's error.
+func (s *Service) WaitForStatusChange(ctx context.Context, since StatusResponse) (StatusResponse, error) {
+	// Subscribe before comparing, so no change is missed in between.
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	// The buffer utilization changes as the buffer fills, which alone is no change of the state.
+	since.Buffer = nil
+	for {
+		status := s.Status()
+		unchanged := status
+		unchanged.Buffer = nil
+		if !reflect.DeepEqual(unchanged, since) {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case _, ok := <-events:
+			if !ok {
+				return status, ErrClosed
+			}
+		}
+	}
 }
 
 // Snapshot returns the current snapshot of the flight recorder
 func (s *Service) Snapshot() ([]byte, error) {
+	return s.SnapshotContext(context.Background())
+}
+
+// SnapshotContext returns the current snapshot of the flight recorder. The retries of WithSnapshotRetry
+// end when ctx is done.
+func (s *Service) SnapshotContext(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.serviceContext(ctx)
+	defer cancel()
+
+	_, data, err := s.snapshotFor(ctx, "manual", nil)
+	return data, err
+}
+
+// snapshotContext returns the context of a snapshot taken by a handler, done when the request
+// is done, the service is torn down or the snapshot timeout elapses with ErrSnapshotTimeout
+func (s *Service) snapshotContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := s.serviceContext(r.Context())
+	timeout := s.opts.snapshotTimeout
+	if timeout <= 0 {
+		return ctx, cancel
+	}
+	ctx, cancelTimeout := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %s", ErrSnapshotTimeout, timeout))
+	return ctx, func() {
+		cancelTimeout()
+		cancel()
+	}
+}
+
+// serviceContext returns a context done when ctx is done or the service is torn down
+func (s *Service) serviceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// snapshot takes a snapshot of the flight recorder, the trigger
+// describes what caused it (e.g. "manual", "http").
+func (s *Service) snapshot(trigger string) (SnapshotMeta, []byte, error) {
+	return s.snapshotFor(s.ctx, trigger, nil)
+}
+
+// snapshotFor takes a snapshot of the flight recorder, for a request recorded by RecordRequests if not nil.
+// ctx ends the retries of WithSnapshotRetry, it should be done when the service is torn down.
+func (s *Service) snapshotFor(ctx context.Context, trigger string, request *RecordedRequest) (SnapshotMeta, []byte, error) {
+	return s.snapshotWindow(ctx, trigger, request, 0)
+}
+
+// snapshotWindow is snapshotFor keeping the trailing window of the snapshot if last is positive,
+// trimmed before it is validated, sealed and passed to the OnSnapshot hooks and subscribers
+func (s *Service) snapshotWindow(ctx context.Context, trigger string, request *RecordedRequest, last time.Duration) (SnapshotMeta, []byte, error) {
+	if err := s.runBeforeSnapshot(); err != nil {
+		return SnapshotMeta{}, nil, err
+	}
+	// Logged before the buffer is written, so the snapshot shows when it was taken.
+	traceEvent("snapshot trigger=%s", trigger)
+
+	data, markers, err := s.writeSnapshot(ctx)
+	if err != nil {
+		return SnapshotMeta{}, nil, err
+	}
+	if last > 0 {
+		if data, err = TrimTrace(data, last); err != nil {
+			return SnapshotMeta{}, nil, err
+		}
+	}
+
+	var events int
+	if s.opts.validateSnapshots {
+		if events, err = validateTrace(data); err != nil {
+			return SnapshotMeta{}, nil, err
+		}
+	}
+
+	now := time.Now()
+	seq := s.snapshotSeq.Add(1)
+	meta := SnapshotMeta{
+		ID:        fmt.Sprintf("%s-%04d", now.UTC().Format("20060102T150405Z"), seq),
+		CreatedAt: now,
+		Size:      int64(len(data)),
+		Trigger:   trigger,
+		Events:    events,
+		Markers:   markers,
+		Request:   request,
+	}
+	s.mu.RLock()
+	meta.Labels = maps.Clone(s.labels)
+	meta.Session = s.sessionIDLocked()
+	s.mu.RUnlock()
+	meta.Build = &s.build
+	meta.Instance = s.instanceID
+	meta.Follower = !s.leader()
+	meta.Name = s.renderName(meta, seq)
+	s.sealSnapshot(&meta, data)
+	s.runOnSnapshot(meta, data)
+	s.publish(Event{Type: EventSnapshot, Snapshot: &meta})
+	return meta, data, nil
+}
+
+// writeSnapshot writes the flight recorder buffer through the snapshot filters and returns the markers it covers
+func (s *Service) writeSnapshot(ctx context.Context) ([]byte, []Marker, error) {
+	data, markers, err := s.writeBuffer(ctx)
+	if err != nil || len(s.opts.filters) == 0 {
+		return data, markers, err
+	}
+	data, err = s.filterSnapshot(data)
+	return data, markers, err
+}
+
+// writeBuffer writes the flight recorder buffer and returns the markers it covers.
+// With WithSnapshotRetry, a snapshot in progress is retried with backoff until the retry time elapses or ctx is done.
+func (s *Service) writeBuffer(ctx context.Context) ([]byte, []Marker, error) {
+	retry := s.opts.snapshotRetry
+	deadline := time.Now().Add(retry)
+	backoff := snapshotRetryBackoff
+	for {
+		data, markers, err := s.writeBufferOnce(ctx)
+		if retry <= 0 || !errors.Is(err, ErrSnapshotInProgress) {
+			return data, markers, err
+		}
+
+		wait := min(backoff, time.Until(deadline))
+		if wait <= 0 {
+			return nil, nil, fmt.Errorf("%w, retried for %s", err, retry)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("%w: %w", err, context.Cause(ctx))
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, maxSnapshotRetryBackoff)
+	}
+}
+
+// writeBufferOnce makes a single attempt of writeBuffer, aborted when ctx is done
+func (s *Service) writeBufferOnce(ctx context.Context) ([]byte, []Marker, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.writeBufferLocked(ctx)
+}
 
+// writeBufferLocked writes the flight recorder buffer while holding s.mu
+func (s *Service) writeBufferLocked(ctx context.Context) ([]byte, []Marker, error) {
 	if !s.recorder.Enabled() {
-		return nil, fmt.Errorf("flight recorder is not running")
+		return nil, nil, ErrNotRunning
+	}
+	if err := s.faults.snapshotFault(ctx); err != nil {
+		return nil, nil, writeBufferError(err)
+	}
+
+	if s.opts.spill != nil {
+		buf := &spillBuffer{spill: s.opts.spill}
+		defer buf.Close()
+		_, err := s.recorder.WriteTo(contextWriter{ctx, buf})
+		if err == nil {
+			s.recordBufferLocked(buf.size, time.Now())
+			data, err := buf.Bytes()
+			return data, s.markersLocked(time.Now()), err
+		}
+		return nil, nil, writeBufferError(err)
 	}
 
 	var buf bytes.Buffer
-	_, err := s.recorder.WriteTo(&buf)
+	_, err := s.recorder.WriteTo(contextWriter{ctx, &buf})
 	if err == nil {
-		return buf.Bytes(), nil
+		s.recordBufferLocked(int64(buf.Len()), time.Now())
+		return buf.Bytes(), s.markersLocked(time.Now()), nil
 	}
+	return nil, nil, writeBufferError(err)
+}
+
+// contextWriter fails writes once ctx is done, aborting the write of the recorder buffer
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
 
+func (w contextWriter) Write(p []byte) (int, error) {
+	if w.ctx.Err() != nil {
+		return 0, context.Cause(w.ctx)
+	}
+	return w.w.Write(p)
+}
+
+// writeBufferError returns the error of a failed write of the recorder buffer
+func writeBufferError(err error) error {
 	if errors.Is(err, trace.ErrSnapshotActive) {
-		return nil, fmt.Errorf("flight recorder snapshot already in progress")
-	} else {
-		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+		return ErrSnapshotInProgress
+	}
+	return fmt.Errorf("failed to write snapshot: %w", err)
+}
+
+// Validate checks the update request without applying it
+func (s *Service) Validate(req UpdateRequest) error {
+	if req.Period != nil && *req.Period <= 0 {
+		return &ConfigError{Field: "period", Message: fmt.Sprintf("%s must be positive", *req.Period)}
+	}
+	if req.Size != nil && *req.Size <= 0 {
+		return &ConfigError{Field: "size", Message: fmt.Sprintf("%s must be positive", *req.Size)}
+	}
+	if req.Size != nil && *req.Size > math.MaxInt {
+		return &ConfigError{Field: "size", Message: fmt.Sprintf("%s exceeds the maximum of %s on this platform", *req.Size, ByteSize(math.MaxInt))}
+	}
+	if req.GCPauseThreshold != nil && *req.GCPauseThreshold < 0 {
+		return &ConfigError{Field: "gc_pause_threshold", Message: fmt.Sprintf("%s must not be negative", *req.GCPauseThreshold)}
+	}
+	if req.SchedLatencyThreshold != nil && *req.SchedLatencyThreshold < 0 {
+		return &ConfigError{Field: "sched_latency_threshold", Message: fmt.Sprintf("%s must not be negative", *req.SchedLatencyThreshold)}
+	}
+	switch req.Apply {
+	case "", ApplyOnRestart, ApplyImmediate:
+	default:
+		return &ConfigError{Field: "apply", Message: fmt.Sprintf("%s should be %s or %s", req.Apply, ApplyImmediate, ApplyOnRestart)}
+	}
+	if req.FinalSnapshot && req.Apply != ApplyImmediate {
+		return &ConfigError{Field: "final_snapshot", Message: fmt.Sprintf("requires apply %s", ApplyImmediate)}
+	}
+	return validateLabels(req.Labels)
+}
+
+// resolve returns the configuration which would result from applying the update request,
+// replacing the configuration with replace
+func (s *Service) resolve(req UpdateRequest, replace bool) ResolvedConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.resolveLocked(req, replace)
+}
+
+// resolveLocked is resolve with s.mu held
+func (s *Service) resolveLocked(req UpdateRequest, replace bool) ResolvedConfig {
+	period, size, thresholds := s.period, s.size, s.runtimeTrigger
+	labels := mergeLabels(s.labels, req.Labels)
+
+	if replace {
+		req = req.replacing()
+		labels = mergeLabels(nil, req.Labels)
+	}
+	if req.Period != nil {
+		period = time.Duration(*req.Period)
+	}
+	if req.Size != nil {
+		size = int64(*req.Size)
+	}
+	if req.GCPauseThreshold != nil {
+		thresholds.GCPause = time.Duration(*req.GCPauseThreshold)
+	}
+	if req.SchedLatencyThreshold != nil {
+		thresholds.SchedLatency = time.Duration(*req.SchedLatencyThreshold)
+	}
+	return ResolvedConfig{
+		Period:                period.String(),
+		PeriodNs:              period.Nanoseconds(),
+		Size:                  formatMemoryUnits(size),
+		SizeBytes:             size,
+		GCPauseThreshold:      thresholds.GCPause.String(),
+		SchedLatencyThreshold: thresholds.SchedLatency.String(),
+		Labels:                labels,
+		RestartPending:        req.Apply != ApplyImmediate && s.restartPendingLocked(period, size),
 	}
 }
 
 // Update updates the flight recorder configuration
 func (s *Service) Update(req UpdateRequest) error {
+	return s.UpdateContext(context.Background(), req)
+}
+
+// UpdateContext updates the flight recorder configuration, unless ctx is done.
+// ctx also bounds the final snapshot of the update, which is written to the sink.
+func (s *Service) UpdateContext(ctx context.Context, req UpdateRequest) error {
+	return s.update(ctx, req, false, "")
+}
+
+// update applies the update request, replacing the configuration with replace.
+// The change is recorded as applied by the principal, unless it is anonymous.
+func (s *Service) update(ctx context.Context, req UpdateRequest, replace bool, principal string) error {
+	if err := s.Validate(req); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if replace {
+		if err := req.complete(); err != nil {
+			return err
+		}
+		req = req.replacing()
+	}
+	// Captures hold the lock themselves, so the final snapshot is taken first.
+	if req.FinalSnapshot && s.restartsRecorder(req) {
+		if _, err := s.CaptureContext(ctx, ReconfigureTrigger); err != nil {
+			return fmt.Errorf("failed to capture the final snapshot: %w", err)
+		}
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// A running recorder only picks up a new period or size when it is started again.
+	before := s.resolveLocked(UpdateRequest{}, false)
 	if req.Period != nil {
-		s.period = *req.Period
-		if s.recorder.Enabled() {
-			s.recorder.SetPeriod(s.period)
-		}
+		s.period = time.Duration(*req.Period)
 	}
-
 	if req.Size != nil {
-		s.size = *req.Size
-		if s.recorder.Enabled() {
-			s.recorder.SetSize(s.size)
-		}
+		s.size = int64(*req.Size)
 	}
 
-	return nil
+	if req.GCPauseThreshold != nil {
+		s.runtimeTrigger.GCPause = time.Duration(*req.GCPauseThreshold)
+	}
+	if req.SchedLatencyThreshold != nil {
+		s.runtimeTrigger.SchedLatency = time.Duration(*req.SchedLatencyThreshold)
+	}
+	if s.runtimeTrigger.enabled() {
+		s.startRuntimeTrigger()
+	}
+	if replace {
+		s.labels = mergeLabels(nil, req.Labels)
+	} else {
+		s.labels = mergeLabels(s.labels, req.Labels)
+	}
+	changes := s.recordConfigChangeLocked(before, principal)
+
+	var err error
+	if req.Apply == ApplyImmediate && s.restartPendingLocked(s.period, s.size) {
+		err = s.restartLocked()
+	}
+	// Logged after the restart, which discards the buffer.
+	if len(changes) > 0 {
+		traceConfigChange(changes, principal)
+	}
+
+	s.saveStateLocked()
+	s.publishStatusLocked(EventUpdated)
+	return err
 }
 
 // HTTP handlers
@@ -146,8 +895,20 @@ func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	status := s.Status()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	if wait := r.URL.Query().Get("wait_for_change"); wait != "" {
+		timeout, err := time.ParseDuration(wait)
+		if err != nil || timeout < 0 || timeout > maxStatusWait {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: wait_for_change %q should be a duration up to %s", ErrInvalidRequest, wait, maxStatusWait))
+			return
+		}
+		// A client whose ETag is already stale gets the current status right away.
+		if inm := r.Header.Get("If-None-Match"); inm == "" || etagMatch(inm, cachedETag(w, r, status)) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			status, _ = s.WaitForStatusChange(ctx, status)
+			cancel()
+		}
+	}
+	writeCached(w, r, status)
 }
 
 func (s *Service) handleStart(w http.ResponseWriter, r *http.Request) {
@@ -156,11 +917,34 @@ func (s *Service) handleStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := s.Start()
+	var req StartRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				err = fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest)
+			}
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	var err error
+	if req.Duration != 0 {
+		err = s.StartSession(req.Duration, req.Snapshot)
+	} else {
+		err = s.StartContext(r.Context())
+	}
+	if err != nil && s.opts.idempotentControl && errors.Is(err, ErrAlreadyRunning) {
+		writeResponse(w, r, http.StatusOK, ControlResponse{Instance: s.instanceID, AlreadyRunning: true})
+		return
+	}
+	if errors.Is(err, ErrNotLeader) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
@@ -173,11 +957,28 @@ func (s *Service) handleStop(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := s.Stop()
+	err := s.StopContext(r.Context())
+	if err != nil && s.opts.idempotentControl && errors.Is(err, ErrNotRunning) {
+		writeResponse(w, r, http.StatusOK, ControlResponse{Instance: s.instanceID, AlreadyStopped: true})
+		return
+	}
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Service) handleClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := s.Clear()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
@@ -185,23 +986,67 @@ func (s *Service) handleStop(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Service) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodHead {
+		s.handleSnapshotHead(w, r)
+		return
+	}
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	snapshot, err := s.Snapshot()
+	var last time.Duration
+	if v := r.URL.Query().Get("last"); v != "" {
+		var err error
+		if last, err = time.ParseDuration(v); err != nil || last <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: last %q should be a positive duration (e.g. 30s)", ErrInvalidRequest, v))
+			return
+		}
+	}
+
+	if wait := r.URL.Query().Get("wait"); wait != "" {
+		window, err := time.ParseDuration(wait)
+		if err != nil || window < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: wait %q should be a duration (e.g. 30s)", ErrInvalidRequest, wait))
+			return
+		}
+		if err := s.WaitForWindow(r.Context(), window); err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				code = http.StatusServiceUnavailable
+			}
+			writeError(w, code, err)
+			return
+		}
+	}
+
+	ctx, cancel := s.snapshotContext(r)
+	defer cancel()
+	meta, snapshot, err := s.snapshotWindow(ctx, "http", nil, last)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrSnapshotVetoed) {
+			code = http.StatusConflict
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, ErrSnapshotTimeout) {
+			code = http.StatusServiceUnavailable
+		}
+		if errors.Is(err, ErrSnapshotTimeout) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(snapshotRetryAfter/time.Second)))
+		}
+		writeError(w, code, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", contentDisposition(meta.Name))
+	setEventsHeader(w, meta)
+	setGoVersionHeader(w, meta)
+	setChecksumHeaders(w.Header(), meta)
 	w.Write(snapshot)
 }
 
+// handleUpdate serves the deprecated POST /update, superseded by PATCH /config
 func (s *Service) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -210,37 +1055,28 @@ func (s *Service) handleUpdate(w http.ResponseWriter, r *http.Request) {
 
 	var req UpdateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid JSON payload"})
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			err = fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest)
+		}
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	err := s.Update(req)
+	if r.URL.Query().Get("dry_run") == "true" {
+		if err := s.Validate(req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeResponse(w, r, http.StatusOK, s.resolve(req, false))
+		return
+	}
+
+	err := s.update(r.Context(), req, false, s.principal(r))
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		writeError(w, updateErrorStatus(err), err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
-
-// RegisterHandlers registers the flight recorder HTTP handlers to the given mux
-func (s *Service) RegisterHandlers(mux *http.ServeMux) {
-	mux.HandleFunc("/recorder/status", s.handleStatus)
-	mux.HandleFunc("/recorder/start", s.handleStart)
-	mux.HandleFunc("/recorder/stop", s.handleStop)
-	mux.HandleFunc("/recorder/snapshot", s.handleSnapshot)
-	mux.HandleFunc("/recorder/update", s.handleUpdate)
-}
-
-// RegisterHandlersWithPrefix registers the flight recorder HTTP handlers with a custom prefix
-func (s *Service) RegisterHandlersWithPrefix(mux *http.ServeMux, prefix string) {
-	mux.HandleFunc(prefix+"/status", s.handleStatus)
-	mux.HandleFunc(prefix+"/start", s.handleStart)
-	mux.HandleFunc(prefix+"/stop", s.handleStop)
-	mux.HandleFunc(prefix+"/snapshot", s.handleSnapshot)
-	mux.HandleFunc(prefix+"/update", s.handleUpdate)
-}
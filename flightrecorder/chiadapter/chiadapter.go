@@ -0,0 +1,51 @@
+// Package chiadapter registers the flight recorder HTTP handlers on a chi router.
+//
+// Mount the routes in a route group to add middleware, e.g. authentication:
+//
+//	r.Route("/recorder", func(r chi.Router) {
+//		r.Use(auth)
+//		chiadapter.Register(r, service)
+//	})
+package chiadapter
+
+import (
+	"net/http"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Register registers all flight recorder routes on r
+func Register(r chi.Router, s *flightrecorder.Service) {
+	register(r, s, func(flightrecorder.Route) bool { return true })
+}
+
+// RegisterRead registers the read-only flight recorder routes on r
+func RegisterRead(r chi.Router, s *flightrecorder.Service) {
+	register(r, s, func(route flightrecorder.Route) bool { return !route.Admin })
+}
+
+// RegisterAdmin registers the mutating flight recorder routes on r
+func RegisterAdmin(r chi.Router, s *flightrecorder.Service) {
+	register(r, s, func(route flightrecorder.Route) bool { return route.Admin })
+}
+
+func register(r chi.Router, s *flightrecorder.Service, include func(flightrecorder.Route) bool) {
+	for _, route := range s.Routes() {
+		if include(route) {
+			r.Method(route.Method, route.Path, handler(route))
+		}
+	}
+}
+
+// handler copies the URL parameters of chi to the request's path values read by the handlers
+func handler(route flightrecorder.Route) http.HandlerFunc {
+	params := route.Params()
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range params {
+			r.SetPathValue(name, chi.URLParam(r, name))
+		}
+		route.Handler(w, r)
+	}
+}
@@ -0,0 +1,311 @@
+// Package client is a typed client for the flight recorder HTTP API.
+//
+//	c := client.New("http://localhost:8080/recorder")
+//	c.BearerToken = os.Getenv("RECORDER_TOKEN")
+//	if err := c.Start(ctx); err != nil && !errors.Is(err, flightrecorder.ErrAlreadyRunning) {
+//		return err
+//	}
+//
+// Errors returned by the service are *flightrecorder.ErrorResponse values,
+// which match the sentinel errors of the flightrecorder package with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+)
+
+const (
+	defaultRetries = 3
+	defaultBackoff = 500 * time.Millisecond
+)
+
+// Client calls the flight recorder endpoints of a service.
+// Requests without side effects are retried on network errors, 429 and 5xx responses;
+// other requests only on 429 and 503 responses, which the service rejected without acting on.
+type Client struct {
+	BaseURL     string        // URL the endpoints are registered under, e.g. http://localhost:8080/recorder
+	BearerToken string        // sent as an Authorization bearer token when set
+	Header      http.Header   // additional headers sent with every request, e.g. for other authentication schemes
+	HTTPClient  *http.Client  // defaults to a client with a 60s timeout
+	MaxRetries  int           // retries after the first attempt (default 3)
+	Backoff     time.Duration // initial backoff, doubled on every retry (default 500ms)
+}
+
+// New creates a client for the endpoints registered under baseURL
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+		MaxRetries: defaultRetries,
+		Backoff:    defaultBackoff,
+	}
+}
+
+// Status returns the status of the flight recorder
+func (c *Client) Status(ctx context.Context) (flightrecorder.StatusResponse, error) {
+	var status flightrecorder.StatusResponse
+	err := c.doJSON(ctx, http.MethodGet, "/status", nil, nil, &status)
+	return status, err
+}
+
+// Start starts the flight recorder
+func (c *Client) Start(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodPost, "/start", nil, nil, nil)
+}
+
+// StartSession starts the flight recorder for the duration, capturing a snapshot into the store before it stops with snapshot
+func (c *Client) StartSession(ctx context.Context, duration time.Duration, snapshot bool) error {
+	return c.doJSON(ctx, http.MethodPost, "/start", nil, flightrecorder.StartRequest{Duration: duration, Snapshot: snapshot}, nil)
+}
+
+// CreateSession starts the flight recorder in a named session
+func (c *Client) CreateSession(ctx context.Context, req flightrecorder.SessionRequest) (flightrecorder.Session, error) {
+	var session flightrecorder.Session
+	err := c.doJSON(ctx, http.MethodPost, "/sessions", nil, req, &session)
+	return session, err
+}
+
+// ListSessions returns the recent recording sessions, oldest first
+func (c *Client) ListSessions(ctx context.Context) ([]flightrecorder.Session, error) {
+	var sessions []flightrecorder.Session
+	err := c.doJSON(ctx, http.MethodGet, "/sessions", nil, nil, &sessions)
+	return sessions, err
+}
+
+// Stop stops the flight recorder
+func (c *Client) Stop(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodPost, "/stop", nil, nil, nil)
+}
+
+// Clear discards the buffer of the flight recorder
+func (c *Client) Clear(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodPost, "/clear", nil, nil, nil)
+}
+
+// Lock locks the service read-only, rejecting the other admin requests with ErrReadOnly until Unlock
+func (c *Client) Lock(ctx context.Context, reason string) (flightrecorder.ReadOnlyLock, error) {
+	var lock flightrecorder.ReadOnlyLock
+	err := c.doJSON(ctx, http.MethodPost, "/lock", nil, flightrecorder.LockRequest{Reason: reason}, &lock)
+	return lock, err
+}
+
+// Unlock lifts the read-only lock of the service
+func (c *Client) Unlock(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodPost, "/unlock", nil, nil, nil)
+}
+
+// Update updates the configuration of the flight recorder
+func (c *Client) Update(ctx context.Context, req flightrecorder.UpdateRequest) error {
+	return c.doJSON(ctx, http.MethodPatch, "/config", nil, req, nil)
+}
+
+// Config returns the current configuration of the flight recorder
+func (c *Client) Config(ctx context.Context) (flightrecorder.ResolvedConfig, error) {
+	var config flightrecorder.ResolvedConfig
+	err := c.doJSON(ctx, http.MethodGet, "/config", nil, nil, &config)
+	return config, err
+}
+
+// ConfigHistory returns the recent changes of the configuration, oldest first
+func (c *Client) ConfigHistory(ctx context.Context) ([]flightrecorder.ConfigChange, error) {
+	var history []flightrecorder.ConfigChange
+	err := c.doJSON(ctx, http.MethodGet, "/config/history", nil, nil, &history)
+	return history, err
+}
+
+// ReplaceConfig replaces the configuration of the flight recorder and returns the new configuration,
+// see Service.ReplaceConfig
+func (c *Client) ReplaceConfig(ctx context.Context, req flightrecorder.UpdateRequest) (flightrecorder.ResolvedConfig, error) {
+	var config flightrecorder.ResolvedConfig
+	err := c.doJSON(ctx, http.MethodPut, "/config", nil, req, &config)
+	return config, err
+}
+
+// Validate validates the update request and returns the configuration it would result in, without applying it
+func (c *Client) Validate(ctx context.Context, req flightrecorder.UpdateRequest) (flightrecorder.ResolvedConfig, error) {
+	var config flightrecorder.ResolvedConfig
+	err := c.doJSON(ctx, http.MethodPatch, "/config", url.Values{"dry_run": {"true"}}, req, &config)
+	return config, err
+}
+
+// Snapshot writes a snapshot of the flight recorder buffer to w and returns its size
+func (c *Client) Snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	n, _, err := c.downloadFrom(ctx, "/snapshot", w)
+	return n, err
+}
+
+// SnapshotFrom is Snapshot also returning the instance which took the snapshot, telling apart
+// the replicas of a service reached through a load balancer
+func (c *Client) SnapshotFrom(ctx context.Context, w io.Writer) (n int64, instance string, err error) {
+	return c.downloadFrom(ctx, "/snapshot", w)
+}
+
+// Capture captures a snapshot into the snapshot store of the service
+func (c *Client) Capture(ctx context.Context) (flightrecorder.SnapshotMeta, error) {
+	var meta flightrecorder.SnapshotMeta
+	err := c.doJSON(ctx, http.MethodPost, "/snapshots", nil, nil, &meta)
+	return meta, err
+}
+
+// ListSnapshots returns the metadata of the stored snapshots
+func (c *Client) ListSnapshots(ctx context.Context) ([]flightrecorder.SnapshotMeta, error) {
+	var snapshots []flightrecorder.SnapshotMeta
+	err := c.doJSON(ctx, http.MethodGet, "/snapshots", nil, nil, &snapshots)
+	return snapshots, err
+}
+
+// DownloadSnapshot writes the stored snapshot with the id to w and returns its size
+func (c *Client) DownloadSnapshot(ctx context.Context, id string, w io.Writer) (int64, error) {
+	return c.download(ctx, "/snapshots/"+url.PathEscape(id), w)
+}
+
+// DownloadProfile writes the pprof profile of the stored snapshot with the id to w and returns its size
+func (c *Client) DownloadProfile(ctx context.Context, id string, w io.Writer) (int64, error) {
+	return c.download(ctx, "/snapshots/"+url.PathEscape(id)+"/pprof", w)
+}
+
+// SignedURL returns a download URL of the stored snapshot with the id signed by the sink of the service, valid for expiry
+func (c *Client) SignedURL(ctx context.Context, id string, expiry time.Duration) (flightrecorder.SignedURL, error) {
+	var signed flightrecorder.SignedURL
+	err := c.doJSON(ctx, http.MethodGet, "/snapshots/"+url.PathEscape(id)+"/url", url.Values{"expires": {expiry.String()}}, nil, &signed)
+	return signed, err
+}
+
+// DeleteSnapshot deletes the stored snapshot with the id
+func (c *Client) DeleteSnapshot(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/snapshots/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// doJSON sends in as the JSON request body, when set, and decodes the JSON response into out, when set
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	resp, err := c.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// download streams the response body of a GET request to w
+func (c *Client) download(ctx context.Context, path string, w io.Writer) (int64, error) {
+	n, _, err := c.downloadFrom(ctx, path, w)
+	return n, err
+}
+
+// downloadFrom is download also returning the instance which answered
+func (c *Client) downloadFrom(ctx context.Context, path string, w io.Writer) (int64, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	instance := resp.Header.Get(flightrecorder.HeaderInstance)
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, instance, fmt.Errorf("failed to download %s: %w", path, err)
+	}
+	return n, instance, nil
+}
+
+// do sends the request to the versioned path, retrying transient failures,
+// and returns the response when it succeeded
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u := c.BaseURL + "/" + flightrecorder.APIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = defaultBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, retry, err := c.attempt(ctx, method, u, body)
+		if err == nil {
+			return resp, nil
+		}
+		if !retry || attempt >= c.MaxRetries {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt makes a single request and reports whether a failure is worth retrying
+func (c *Client) attempt(ctx context.Context, method, u string, body []byte) (*http.Response, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range c.Header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	safe := method == http.MethodGet
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, safe && ctx.Err() == nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, false, nil
+	}
+	defer resp.Body.Close()
+
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable ||
+		safe && resp.StatusCode >= 500
+	return nil, retry, responseError(resp)
+}
+
+// responseError decodes the error response of the service, or describes an unexpected response
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var errResp flightrecorder.ErrorResponse
+	if err := json.Unmarshal(data, &errResp); err == nil && errResp.Code != "" {
+		return &errResp
+	}
+	return fmt.Errorf("unexpected response %s: %s", resp.Status, bytes.TrimSpace(data))
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPort             = 8080
+	defaultPrefix           = "/recorder"
+	defaultConsul           = "http://127.0.0.1:8500"
+	serviceAccountCA        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	serviceAccountToken     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// Resolver resolves the base URLs of the services of a fleet.
+// FleetClient resolves its targets again before every command, so the fleet follows scaling and restarts.
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// StaticFile resolves targets from a file with one base URL per line.
+// Blank lines and lines starting with # are ignored. The file is read again on every resolve.
+type StaticFile struct {
+	Path string
+}
+
+// Resolve reads the base URLs of the file
+func (f StaticFile) Resolve(ctx context.Context) ([]string, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read targets: %w", err)
+	}
+
+	var targets []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			targets = append(targets, line)
+		}
+	}
+	return targets, scanner.Err()
+}
+
+// KubernetesPods resolves targets from the running, ready pods matching a label selector.
+// Without APIServer it uses the in-cluster service account, which needs permission to list pods.
+type KubernetesPods struct {
+	Namespace string       // namespace of the pods (default the service account's namespace, else "default")
+	Selector  string       // label selector, e.g. app=checkout
+	Port      int          // port the endpoints are served on (default 8080)
+	Prefix    string       // prefix the endpoints are registered under (default /recorder)
+	APIServer string       // URL of the API server, e.g. http://localhost:8001 from kubectl proxy
+	Token     string       // bearer token for the API server (default the service account token in-cluster)
+	Client    *http.Client // client for the API server (default the in-cluster client, else a 30s timeout)
+}
+
+// Resolve lists the pods matching the selector
+func (k KubernetesPods) Resolve(ctx context.Context) ([]string, error) {
+	server, client, token, err := k.apiServer()
+	if err != nil {
+		return nil, err
+	}
+	namespace := k.Namespace
+	if namespace == "" {
+		namespace = "default"
+		if data, err := os.ReadFile(serviceAccountNamespace); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+
+	u := strings.TrimSuffix(server, "/") + "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods?" +
+		url.Values{"labelSelector": {k.Selector}}.Encode()
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				DeletionTimestamp *time.Time `json:"deletionTimestamp"`
+			} `json:"metadata"`
+			Status struct {
+				Phase      string `json:"phase"`
+				PodIP      string `json:"podIP"`
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := getJSON(ctx, client, u, token, &list); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	var targets []string
+	for _, pod := range list.Items {
+		if pod.Metadata.DeletionTimestamp != nil || pod.Status.Phase != "Running" || pod.Status.PodIP == "" {
+			continue
+		}
+		var ready bool
+		for _, c := range pod.Status.Conditions {
+			ready = ready || c.Type == "Ready" && c.Status == "True"
+		}
+		if ready {
+			targets = append(targets, baseURL(pod.Status.PodIP, k.Port, k.Prefix))
+		}
+	}
+	return targets, nil
+}
+
+// apiServer returns the API server URL, client and token, from the fields or the in-cluster service account
+func (k KubernetesPods) apiServer() (string, *http.Client, string, error) {
+	if k.APIServer != "" {
+		client := k.Client
+		if client == nil {
+			client = &http.Client{Timeout: 30 * time.Second}
+		}
+		return k.APIServer, client, k.Token, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return "", nil, "", errors.New("not running in a cluster, set the API server")
+	}
+	token := k.Token
+	if token == "" {
+		data, err := os.ReadFile(serviceAccountToken)
+		if err != nil {
+			return "", nil, "", err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	client := k.Client
+	if client == nil {
+		ca, err := os.ReadFile(serviceAccountCA)
+		if err != nil {
+			return "", nil, "", err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return "", nil, "", errors.New("invalid service account CA certificate")
+		}
+		client = &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		}
+	}
+	return "https://" + net.JoinHostPort(host, port), client, token, nil
+}
+
+// ConsulService resolves targets from the healthy instances of a Consul service
+type ConsulService struct {
+	Service string       // name of the service
+	Tag     string       // only instances with the tag when set
+	Address string       // URL of the Consul agent (default http://127.0.0.1:8500)
+	Token   string       // Consul ACL token
+	Prefix  string       // prefix the endpoints are registered under (default /recorder)
+	Client  *http.Client // defaults to a client with a 30s timeout
+}
+
+// Resolve lists the instances passing their health checks
+func (c ConsulService) Resolve(ctx context.Context) ([]string, error) {
+	address := c.Address
+	if address == "" {
+		address = defaultConsul
+	}
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	query := url.Values{"passing": {"true"}}
+	if c.Tag != "" {
+		query.Set("tag", c.Tag)
+	}
+
+	u := strings.TrimSuffix(address, "/") + "/v1/health/service/" + url.PathEscape(c.Service) + "?" + query.Encode()
+	var entries []struct {
+		Node struct {
+			Address string `json:"Address"`
+		} `json:"Node"`
+		Service struct {
+			Address string `json:"Address"`
+			Port    int    `json:"Port"`
+		} `json:"Service"`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	if err := doJSONRequest(client, req, &entries); err != nil {
+		return nil, fmt.Errorf("failed to list instances of %s: %w", c.Service, err)
+	}
+
+	var targets []string
+	for _, e := range entries {
+		// Instances without their own address run on the node's address.
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		targets = append(targets, baseURL(host, e.Service.Port, c.Prefix))
+	}
+	return targets, nil
+}
+
+// baseURL returns the base URL of the endpoints on the host and port
+func baseURL(host string, port int, prefix string) string {
+	if port <= 0 {
+		port = defaultPort
+	}
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(port)) + "/" + strings.Trim(prefix, "/")
+}
+
+func getJSON(ctx context.Context, client *http.Client, u, token string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return doJSONRequest(client, req, out)
+}
+
+func doJSONRequest(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
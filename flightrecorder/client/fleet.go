@@ -0,0 +1,203 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+)
+
+const defaultParallelism = 8
+
+// FleetClient fans commands out to the flight recorder endpoints of many services concurrently,
+// e.g. every replica of a deployment, with bounded parallelism
+type FleetClient struct {
+	Targets     []string                     // base URLs of the services, e.g. http://10.0.0.1:8080/recorder
+	Resolver    Resolver                     // resolves more targets before every command when set
+	Parallelism int                          // maximum number of targets called at once (default 8)
+	NewClient   func(baseURL string) *Client // creates the client of each target, e.g. to set auth (default New)
+}
+
+// NewFleetClient creates a fleet client for the services at the base URLs
+func NewFleetClient(targets []string) *FleetClient {
+	return &FleetClient{Targets: targets, Parallelism: defaultParallelism}
+}
+
+// NewDiscoveryFleetClient creates a fleet client for the services found by the resolver
+func NewDiscoveryFleetClient(resolver Resolver) *FleetClient {
+	return &FleetClient{Resolver: resolver, Parallelism: defaultParallelism}
+}
+
+// Result is the outcome of a command on one target
+type Result struct {
+	Target string
+	Err    error
+	Status *flightrecorder.StatusResponse // set by Status
+	Size   int64                          // snapshot size, set by Snapshot
+	Name   string                         // tarball entry of the snapshot, set by Snapshot
+	// Instance is the service instance which answered, set by Status and Snapshot. Targets behind
+	// a load balancer may be answered by any replica, or several targets by the same one.
+	Instance string
+}
+
+// TargetError is the error of a command on one target
+type TargetError struct {
+	Target string
+	Err    error
+}
+
+func (e *TargetError) Error() string {
+	return e.Target + ": " + e.Err.Error()
+}
+
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+// Start starts the flight recorder of every target
+func (f *FleetClient) Start(ctx context.Context) ([]Result, error) {
+	return f.each(ctx, func(ctx context.Context, c *Client, r *Result) error {
+		return c.Start(ctx)
+	})
+}
+
+// Stop stops the flight recorder of every target
+func (f *FleetClient) Stop(ctx context.Context) ([]Result, error) {
+	return f.each(ctx, func(ctx context.Context, c *Client, r *Result) error {
+		return c.Stop(ctx)
+	})
+}
+
+// Status returns the status of every target
+func (f *FleetClient) Status(ctx context.Context) ([]Result, error) {
+	return f.each(ctx, func(ctx context.Context, c *Client, r *Result) error {
+		status, err := c.Status(ctx)
+		if err == nil {
+			r.Status, r.Instance = &status, status.Instance
+		}
+		return err
+	})
+}
+
+// Snapshot snapshots every target and writes the snapshots to w as a tar.gz archive,
+// one <target>.trace entry per target. Snapshots are buffered in memory until written,
+// so at most Parallelism snapshots are held at once.
+func (f *FleetClient) Snapshot(ctx context.Context, w io.Writer) ([]Result, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	var mu sync.Mutex
+	names := make(map[string]int)
+	results, err := f.each(ctx, func(ctx context.Context, c *Client, r *Result) error {
+		var buf bytes.Buffer
+		_, instance, err := c.SnapshotFrom(ctx, &buf)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		r.Name = entryName(r.Target)
+		// Targets on the same host and path get numbered entries.
+		if names[r.Name]++; names[r.Name] > 1 {
+			r.Name = fmt.Sprintf("%s-%d.trace", strings.TrimSuffix(r.Name, ".trace"), names[r.Name])
+		}
+		r.Size, r.Instance = int64(buf.Len()), instance
+		hdr := &tar.Header{
+			Name:    r.Name,
+			Mode:    0644,
+			Size:    r.Size,
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write archive header for %s: %w", r.Name, err)
+		}
+		if _, err := tw.Write(buf.Bytes()); err != nil {
+			return fmt.Errorf("failed to write archive file %s: %w", r.Name, err)
+		}
+		return nil
+	})
+
+	if closeErr := tw.Close(); closeErr != nil {
+		return results, fmt.Errorf("failed to close archive: %w", closeErr)
+	}
+	if closeErr := gz.Close(); closeErr != nil {
+		return results, fmt.Errorf("failed to close archive: %w", closeErr)
+	}
+	return results, err
+}
+
+// each runs the command on every target with bounded parallelism and returns the results in target order,
+// with the errors of the failed targets joined as TargetErrors
+func (f *FleetClient) each(ctx context.Context, command func(ctx context.Context, c *Client, r *Result) error) ([]Result, error) {
+	parallelism := f.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+	newClient := f.NewClient
+	if newClient == nil {
+		newClient = New
+	}
+
+	targets := f.Targets
+	if f.Resolver != nil {
+		resolved, err := f.Resolver.Resolve(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve targets: %w", err)
+		}
+		targets = append(slices.Clip(targets), resolved...)
+	}
+
+	results := make([]Result, len(targets))
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		results[i].Target = target
+		wg.Go(func() {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i].Err = ctx.Err()
+				return
+			}
+			results[i].Err = command(ctx, newClient(target), &results[i])
+		})
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, &TargetError{Target: r.Target, Err: r.Err})
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// entryNameReplacer replaces the characters of URLs which are awkward in file names
+var entryNameReplacer = strings.NewReplacer(":", "_", "/", "_", "\\", "_")
+
+// entryName returns the archive entry name of a target, e.g. 10.0.0.1_8080.trace,
+// keeping the path when it is not the default prefix
+func entryName(target string) string {
+	name := target
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		name = u.Host
+		if path := strings.Trim(u.Path, "/"); path != "" && path != "recorder" {
+			name += "_" + path
+		}
+	}
+	return entryNameReplacer.Replace(name) + ".trace"
+}
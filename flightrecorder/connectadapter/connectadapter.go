@@ -0,0 +1,250 @@
+// Package connectadapter serves the flight recorder control API as a ConnectRPC service,
+// so services built on connect-go can mount it next to their own services and share
+// interceptors (auth, logging):
+//
+//	path, handler := connectadapter.NewHandler(service, connect.WithInterceptors(auth))
+//	mux.Handle(path, handler)
+//
+// Messages are the flight recorder's JSON types, exchanged with the Connect protocol's
+// JSON encoding (Content-Type application/json). There is no protobuf schema, so clients
+// using the binary protobuf encoding or gRPC are not supported.
+package connectadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+
+	"connectrpc.com/connect"
+)
+
+// ServiceName is the fully-qualified name of the recorder service
+const ServiceName = "flightrecorder.v1.RecorderService"
+
+// Procedures of the recorder service
+const (
+	StatusProcedure   = "/" + ServiceName + "/Status"
+	StartProcedure    = "/" + ServiceName + "/Start"
+	StopProcedure     = "/" + ServiceName + "/Stop"
+	ClearProcedure    = "/" + ServiceName + "/Clear"
+	UpdateProcedure   = "/" + ServiceName + "/Update"
+	SnapshotProcedure = "/" + ServiceName + "/Snapshot"
+	CaptureProcedure  = "/" + ServiceName + "/Capture"
+	MarkProcedure     = "/" + ServiceName + "/Mark"
+	LogProcedure      = "/" + ServiceName + "/Log"
+)
+
+// procedureEndpoints are the HTTP endpoints the procedures mirror, a procedure is left out
+// when any of them is disabled, see flightrecorder.WithDisabledEndpoints
+var procedureEndpoints = map[string][]string{
+	StatusProcedure:   {"GET /status"},
+	StartProcedure:    {"POST /start"},
+	StopProcedure:     {"POST /stop"},
+	ClearProcedure:    {"POST /clear"},
+	UpdateProcedure:   {"POST /update", "PATCH /config"},
+	SnapshotProcedure: {"GET /snapshot"},
+	CaptureProcedure:  {"POST /snapshots"},
+	MarkProcedure:     {"POST /mark"},
+	LogProcedure:      {"POST /log"},
+}
+
+// procedureQuotas are the kinds of requests of flightrecorder.WithPrincipalQuota the procedures are counted as,
+// like the endpoints they mirror
+var procedureQuotas = map[string]string{
+	StartProcedure:    flightrecorder.QuotaMutations,
+	StopProcedure:     flightrecorder.QuotaMutations,
+	ClearProcedure:    flightrecorder.QuotaMutations,
+	UpdateProcedure:   flightrecorder.QuotaMutations,
+	SnapshotProcedure: flightrecorder.QuotaDownloads,
+	CaptureProcedure:  flightrecorder.QuotaMutations,
+	MarkProcedure:     flightrecorder.QuotaMutations,
+	LogProcedure:      flightrecorder.QuotaMutations,
+}
+
+// Empty is the request or response of procedures without parameters or results
+type Empty struct{}
+
+// SnapshotResponse carries a snapshot, base64 encoded in JSON
+type SnapshotResponse struct {
+	Data []byte `json:"data"`
+}
+
+// CaptureRequest represents the capture request payload
+type CaptureRequest struct {
+	Trigger string `json:"trigger,omitempty"`
+}
+
+// NewHandler returns the path to mount the recorder service on and its handler.
+// Options such as connect.WithInterceptors apply to all procedures.
+// The allowlist of flightrecorder.WithAllowedCIDRs and the quotas of flightrecorder.WithPrincipalQuota apply as well.
+func NewHandler(s *flightrecorder.Service, opts ...connect.HandlerOption) (string, http.Handler) {
+	opts = append([]connect.HandlerOption{connect.WithCodec(jsonCodec{})}, opts...)
+
+	mux := http.NewServeMux()
+	errWriter := connect.NewErrorWriter(opts...)
+	handle := func(procedure string, h http.Handler) {
+		if !slices.ContainsFunc(procedureEndpoints[procedure], s.EndpointDisabled) {
+			mux.Handle(procedure, quota(s, procedureQuotas[procedure], h, errWriter))
+		}
+	}
+	handle(StatusProcedure, connect.NewUnaryHandlerSimple(StatusProcedure,
+		func(ctx context.Context, _ *Empty) (*flightrecorder.StatusResponse, error) {
+			status := s.Status()
+			return &status, nil
+		}, opts...))
+	handle(StartProcedure, connect.NewUnaryHandlerSimple(StartProcedure,
+		func(ctx context.Context, _ *Empty) (*Empty, error) {
+			if err := writable(s); err != nil {
+				return nil, err
+			}
+			return &Empty{}, connectError(s.StartContext(ctx))
+		}, opts...))
+	handle(StopProcedure, connect.NewUnaryHandlerSimple(StopProcedure,
+		func(ctx context.Context, _ *Empty) (*Empty, error) {
+			if err := writable(s); err != nil {
+				return nil, err
+			}
+			return &Empty{}, connectError(s.StopContext(ctx))
+		}, opts...))
+	handle(ClearProcedure, connect.NewUnaryHandlerSimple(ClearProcedure,
+		func(ctx context.Context, _ *Empty) (*Empty, error) {
+			if err := writable(s); err != nil {
+				return nil, err
+			}
+			return &Empty{}, connectError(s.Clear())
+		}, opts...))
+	handle(UpdateProcedure, connect.NewUnaryHandlerSimple(UpdateProcedure,
+		func(ctx context.Context, req *flightrecorder.UpdateRequest) (*Empty, error) {
+			if err := writable(s); err != nil {
+				return nil, err
+			}
+			return &Empty{}, connectError(s.UpdateContext(ctx, *req))
+		}, opts...))
+	handle(SnapshotProcedure, connect.NewUnaryHandlerSimple(SnapshotProcedure,
+		func(ctx context.Context, _ *Empty) (*SnapshotResponse, error) {
+			data, err := s.SnapshotContext(ctx)
+			if err != nil {
+				return nil, connectError(err)
+			}
+			return &SnapshotResponse{Data: data}, nil
+		}, opts...))
+	handle(CaptureProcedure, connect.NewUnaryHandlerSimple(CaptureProcedure,
+		func(ctx context.Context, req *CaptureRequest) (*flightrecorder.SnapshotMeta, error) {
+			if err := writable(s); err != nil {
+				return nil, err
+			}
+			trigger := req.Trigger
+			if trigger == "" {
+				trigger = "connect"
+			}
+			meta, err := s.CaptureContext(ctx, trigger)
+			if err != nil {
+				return nil, connectError(err)
+			}
+			return &meta, nil
+		}, opts...))
+	handle(MarkProcedure, connect.NewUnaryHandlerSimple(MarkProcedure,
+		func(ctx context.Context, req *flightrecorder.MarkRequest) (*flightrecorder.Marker, error) {
+			if err := writable(s); err != nil {
+				return nil, err
+			}
+			if req.Label == "" {
+				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("label is required"))
+			}
+			marker, err := s.Mark(ctx, req.Label)
+			if err != nil {
+				return nil, connectError(err)
+			}
+			return &marker, nil
+		}, opts...))
+	handle(LogProcedure, connect.NewUnaryHandlerSimple(LogProcedure,
+		func(ctx context.Context, req *flightrecorder.LogRequest) (*Empty, error) {
+			if err := writable(s); err != nil {
+				return nil, err
+			}
+			if req.Message == "" {
+				return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("message is required"))
+			}
+			if req.Category == "" {
+				req.Category = flightrecorder.DefaultLogCategory
+			}
+			s.Log(ctx, req.Category, req.Message)
+			return &Empty{}, nil
+		}, opts...))
+
+	return "/" + ServiceName + "/", s.Allowlist(mux)
+}
+
+// quota counts the calls of a procedure against the quota of their principal for the kind of requests,
+// rejecting the calls beyond it with CodeResourceExhausted, like the endpoints respond 429
+func quota(s *flightrecorder.Service, kind string, h http.Handler, errWriter *connect.ErrorWriter) http.Handler {
+	if kind == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usage, retryAfter, err := s.CountQuota(r, kind)
+		if usage != nil {
+			flightrecorder.SetQuotaHeaders(w.Header(), usage, retryAfter)
+		}
+		if err != nil {
+			errWriter.Write(w, r, connect.NewError(connect.CodeResourceExhausted, err))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// writable fails with ErrReadOnly while the service is locked read-only, like the admin endpoints
+func writable(s *flightrecorder.Service) error {
+	if s.ReadOnly() != nil {
+		return connectError(flightrecorder.ErrReadOnly)
+	}
+	return nil
+}
+
+// connectError maps the service errors to Connect error codes
+func connectError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var configErr *flightrecorder.ConfigError
+	switch {
+	case errors.As(err, &configErr), errors.Is(err, flightrecorder.ErrInvalidRequest):
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	case errors.Is(err, flightrecorder.ErrAlreadyRunning), errors.Is(err, flightrecorder.ErrNotRunning),
+		errors.Is(err, flightrecorder.ErrReadOnly), errors.Is(err, flightrecorder.ErrNotLeader):
+		return connect.NewError(connect.CodeFailedPrecondition, err)
+	case errors.Is(err, flightrecorder.ErrSnapshotInProgress), errors.Is(err, flightrecorder.ErrSnapshotVetoed):
+		return connect.NewError(connect.CodeAborted, err)
+	case errors.Is(err, flightrecorder.ErrQuotaExceeded):
+		return connect.NewError(connect.CodeResourceExhausted, err)
+	case errors.Is(err, flightrecorder.ErrClosed):
+		return connect.NewError(connect.CodeUnavailable, err)
+	case errors.Is(err, context.Canceled):
+		return connect.NewError(connect.CodeCanceled, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return connect.NewError(connect.CodeDeadlineExceeded, err)
+	default:
+		return connect.NewError(connect.CodeInternal, err)
+	}
+}
+
+// jsonCodec encodes the flight recorder's types with encoding/json,
+// replacing Connect's default JSON codec which only supports protobuf messages
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
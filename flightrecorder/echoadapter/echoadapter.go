@@ -0,0 +1,64 @@
+// Package echoadapter registers the flight recorder HTTP handlers on an echo router.
+//
+// Register the routes on a group to add middleware, or pass route-level middleware:
+//
+//	echoadapter.Register(e.Group("/recorder", auth), service)
+package echoadapter
+
+import (
+	"strings"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Router is implemented by *echo.Echo and *echo.Group
+type Router interface {
+	Add(method, path string, handler echo.HandlerFunc, middleware ...echo.MiddlewareFunc) *echo.Route
+}
+
+// Register registers all flight recorder routes on r with the route-level middleware
+func Register(r Router, s *flightrecorder.Service, middleware ...echo.MiddlewareFunc) {
+	register(r, s, func(flightrecorder.Route) bool { return true }, middleware)
+}
+
+// RegisterRead registers the read-only flight recorder routes on r with the route-level middleware
+func RegisterRead(r Router, s *flightrecorder.Service, middleware ...echo.MiddlewareFunc) {
+	register(r, s, func(route flightrecorder.Route) bool { return !route.Admin }, middleware)
+}
+
+// RegisterAdmin registers the mutating flight recorder routes on r with the route-level middleware
+func RegisterAdmin(r Router, s *flightrecorder.Service, middleware ...echo.MiddlewareFunc) {
+	register(r, s, func(route flightrecorder.Route) bool { return route.Admin }, middleware)
+}
+
+func register(r Router, s *flightrecorder.Service, include func(flightrecorder.Route) bool, middleware []echo.MiddlewareFunc) {
+	for _, route := range s.Routes() {
+		if include(route) {
+			r.Add(route.Method, path(route), handler(route), middleware...)
+		}
+	}
+}
+
+// path converts the {name} wildcards of a route to echo's :name parameters
+func path(route flightrecorder.Route) string {
+	p := route.Path
+	for _, name := range route.Params() {
+		p = strings.Replace(p, "{"+name+"}", ":"+name, 1)
+	}
+	return p
+}
+
+// handler copies the echo parameters to the request's path values read by the handlers
+func handler(route flightrecorder.Route) echo.HandlerFunc {
+	params := route.Params()
+	return func(c echo.Context) error {
+		r := c.Request()
+		for _, name := range params {
+			r.SetPathValue(name, c.Param(name))
+		}
+		route.Handler(c.Response(), r)
+		return nil
+	}
+}
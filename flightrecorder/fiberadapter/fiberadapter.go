@@ -0,0 +1,69 @@
+// Package fiberadapter registers the flight recorder HTTP handlers on a fiber router.
+//
+// Register the routes on a group to add middleware, e.g. authentication:
+//
+//	fiberadapter.Register(app.Group("/recorder", auth), service)
+//
+// Fiber is built on fasthttp, so the net/http handlers run through fiber's adaptor,
+// which buffers responses. The events stream is therefore not registered.
+package fiberadapter
+
+import (
+	"net/http"
+	"strings"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+)
+
+// Register registers all flight recorder routes on r
+func Register(r fiber.Router, s *flightrecorder.Service) {
+	register(r, s, func(flightrecorder.Route) bool { return true })
+}
+
+// RegisterRead registers the read-only flight recorder routes on r
+func RegisterRead(r fiber.Router, s *flightrecorder.Service) {
+	register(r, s, func(route flightrecorder.Route) bool { return !route.Admin })
+}
+
+// RegisterAdmin registers the mutating flight recorder routes on r
+func RegisterAdmin(r fiber.Router, s *flightrecorder.Service) {
+	register(r, s, func(route flightrecorder.Route) bool { return route.Admin })
+}
+
+func register(r fiber.Router, s *flightrecorder.Service, include func(flightrecorder.Route) bool) {
+	for _, route := range s.Routes() {
+		if strings.TrimPrefix(route.Path, "/"+flightrecorder.APIVersion) == "/events" || !include(route) {
+			continue
+		}
+		r.Add(route.Method, path(route), handler(route))
+	}
+}
+
+// path converts the {name} wildcards of a route to fiber's :name parameters
+func path(route flightrecorder.Route) string {
+	p := route.Path
+	for _, name := range route.Params() {
+		p = strings.Replace(p, "{"+name+"}", ":"+name, 1)
+	}
+	return p
+}
+
+// handler copies the fiber parameters to the request's path values read by the handlers
+func handler(route flightrecorder.Route) fiber.Handler {
+	params := route.Params()
+	return func(c *fiber.Ctx) error {
+		values := make(map[string]string, len(params))
+		for _, name := range params {
+			values[name] = c.Params(name)
+		}
+		return adaptor.HTTPHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, value := range values {
+				r.SetPathValue(name, value)
+			}
+			route.Handler(w, r)
+		})(c)
+	}
+}
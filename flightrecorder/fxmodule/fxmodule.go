@@ -0,0 +1,96 @@
+// Package fxmodule ties the lifecycle of the flight recorder service to dependency injection containers.
+//
+// Module provides the service to Fx applications, started on OnStart and shut down on OnStop, taking a
+// last snapshot like WithSnapshotOnShutdown:
+//
+//	fx.New(
+//		fxmodule.Module,
+//		fxmodule.WithOptions(flightrecorder.WithSink(flightrecorder.NewFileSink("/var/lib/flightrecorder"))),
+//		fx.Invoke(func(mux *http.ServeMux, s *flightrecorder.Service) { s.RegisterHandlers(mux) }),
+//	)
+//
+// ProvideService and NewService are constructors for wire and dig, which have no lifecycle of their own.
+package fxmodule
+
+import (
+	"context"
+	"errors"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+
+	"go.uber.org/fx"
+)
+
+// optionsGroup is the value group the options of the service are collected from
+const optionsGroup = "flightrecorder.options"
+
+// Options are the options of the service created by ProvideService and NewService
+type Options []flightrecorder.Option
+
+// Module provides the *flightrecorder.Service, created with the options added by WithOptions
+var Module = fx.Module("flightrecorder",
+	fx.Provide(newFxService),
+)
+
+// WithOptions adds options to the service provided by Module, it may be used more than once
+func WithOptions(opts ...flightrecorder.Option) fx.Option {
+	return fx.Provide(fx.Annotate(
+		func() []flightrecorder.Option { return opts },
+		fx.ResultTags(`group:"`+optionsGroup+`,flatten"`),
+	))
+}
+
+// params are the dependencies of the service provided by Module
+type params struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Options   []flightrecorder.Option `group:"flightrecorder.options"`
+}
+
+func newFxService(p params) *flightrecorder.Service {
+	s := flightrecorder.NewService(options(p.Options)...)
+	p.Lifecycle.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			return start(ctx, s)
+		},
+		OnStop: s.Shutdown,
+	})
+	return s
+}
+
+// ProvideService creates and starts a service for wire, the cleanup function shuts it down
+// with Service.Shutdown, taking a last snapshot like WithSnapshotOnShutdown
+func ProvideService(opts Options) (*flightrecorder.Service, func(), error) {
+	s, err := NewService(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, func() { s.Shutdown(context.Background()) }, nil
+}
+
+// NewService creates and starts a service, e.g. for dig. Call Service.Shutdown on shutdown, which
+// takes a last snapshot like WithSnapshotOnShutdown.
+func NewService(opts Options) (*flightrecorder.Service, error) {
+	s := flightrecorder.NewService(options(opts)...)
+	if err := start(context.Background(), s); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// options takes a shutdown snapshot by default, written to the sink of the service.
+// WithSnapshotOnShutdown in opts overrides it, the options being applied in order.
+func options(opts []flightrecorder.Option) []flightrecorder.Option {
+	return append([]flightrecorder.Option{flightrecorder.WithSnapshotOnShutdown(nil)}, opts...)
+}
+
+// start starts the recorder, unless it was resumed from the state file or the recorder gate refuses it
+func start(ctx context.Context, s *flightrecorder.Service) error {
+	err := s.StartContext(ctx)
+	if errors.Is(err, flightrecorder.ErrAlreadyRunning) || errors.Is(err, flightrecorder.ErrNotLeader) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+// Package ginadapter registers the flight recorder HTTP handlers on a gin router.
+//
+// Register the routes on a route group to add middleware, e.g. authentication:
+//
+//	ginadapter.Register(router.Group("/recorder", auth), service)
+package ginadapter
+
+import (
+	"strings"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Register registers all flight recorder routes on r
+func Register(r gin.IRoutes, s *flightrecorder.Service) {
+	register(r, s, func(flightrecorder.Route) bool { return true })
+}
+
+// RegisterRead registers the read-only flight recorder routes on r
+func RegisterRead(r gin.IRoutes, s *flightrecorder.Service) {
+	register(r, s, func(route flightrecorder.Route) bool { return !route.Admin })
+}
+
+// RegisterAdmin registers the mutating flight recorder routes on r
+func RegisterAdmin(r gin.IRoutes, s *flightrecorder.Service) {
+	register(r, s, func(route flightrecorder.Route) bool { return route.Admin })
+}
+
+func register(r gin.IRoutes, s *flightrecorder.Service, include func(flightrecorder.Route) bool) {
+	for _, route := range s.Routes() {
+		if include(route) {
+			r.Handle(route.Method, path(route), handler(route))
+		}
+	}
+}
+
+// path converts the {name} wildcards of a route to gin's :name parameters
+func path(route flightrecorder.Route) string {
+	p := route.Path
+	for _, name := range route.Params() {
+		p = strings.Replace(p, "{"+name+"}", ":"+name, 1)
+	}
+	return p
+}
+
+// handler copies the gin parameters to the request's path values read by the handlers
+func handler(route flightrecorder.Route) gin.HandlerFunc {
+	params := route.Params()
+	return func(c *gin.Context) {
+		for _, name := range params {
+			c.Request.SetPathValue(name, c.Param(name))
+		}
+		route.Handler(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,118 @@
+// Package nettraceadapter reports the flight recorder events to golang.org/x/net/trace, so the recorder
+// activity appears on the /debug/events and /debug/requests pages many services already expose:
+//
+//	stop := nettraceadapter.Register(service)
+//	defer stop()
+//
+// Every event is logged to an event log of the "flightrecorder" family on /debug/events, titled by the
+// "service" label of the recorder or the hostname. Stored snapshots, fired triggers and snapshot downloads
+// are also traces of the "flightrecorder" family on /debug/requests, titled by the event type, and triggers
+// which failed to capture a snapshot are traces marked as errors.
+//
+// Importing golang.org/x/net/trace registers its pages on http.DefaultServeMux, as the package does for
+// any importer; mount trace.Traces and trace.Events on another mux to serve them elsewhere.
+package nettraceadapter
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+
+	"golang.org/x/net/trace"
+)
+
+// Family is the family of the event log and traces of the recorder
+const Family = "flightrecorder"
+
+// Register reports the events of s to x/net/trace until stop is called or the service is closed
+func Register(s *flightrecorder.Service) (stop func()) {
+	title := s.Status().Labels["service"]
+	if title == "" {
+		title, _ = os.Hostname()
+	}
+	events, cancel := s.Subscribe()
+	log := trace.NewEventLog(Family, title)
+
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		defer log.Finish()
+		for {
+			select {
+			case <-quit:
+				return
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				report(log, e)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+			cancel()
+			<-done
+		})
+	}
+}
+
+// report logs an event and traces the snapshot events
+func report(log trace.EventLog, e flightrecorder.Event) {
+	switch e.Type {
+	case flightrecorder.EventStatus, flightrecorder.EventSnapshot:
+		// The current status and the snapshots taken but not stored are not activity worth a page.
+		return
+	}
+
+	msg := describe(e)
+	if e.Error != "" {
+		log.Errorf("%s", msg)
+	} else {
+		log.Printf("%s", msg)
+	}
+
+	switch e.Type {
+	case flightrecorder.EventSnapshotStored, flightrecorder.EventTriggerFired, flightrecorder.EventSnapshotDownloaded:
+	default:
+		return
+	}
+	tr := trace.New(Family, string(e.Type))
+	defer tr.Finish()
+	tr.LazyPrintf("%s", msg)
+	if e.Error != "" {
+		tr.SetError()
+	}
+}
+
+// describe formats an event as a line of text
+func describe(e flightrecorder.Event) string {
+	msg := string(e.Type)
+	if e.Trigger != "" {
+		msg += " trigger=" + e.Trigger
+	}
+	if e.Snapshot != nil {
+		msg += " snapshot=" + e.Snapshot.ID
+		if e.Snapshot.Request != nil {
+			msg += " request=" + e.Snapshot.Request.ID
+		}
+	}
+	if e.Download != nil {
+		msg += " format=" + e.Download.Format
+		if e.Download.By != "" {
+			msg += " by=" + e.Download.By
+		}
+	}
+	if e.Quota != nil {
+		msg += fmt.Sprintf(" principal=%s kind=%s used=%d limit=%d", e.Quota.Principal, e.Quota.Kind, e.Quota.Used, e.Quota.Limit)
+	}
+	if e.Error != "" {
+		msg += " error=" + e.Error
+	}
+	return msg
+}
@@ -0,0 +1,354 @@
+// Package pluginrpc runs sinks and notifiers as out-of-process plugins with hashicorp/go-plugin, so closed-source
+// or differently-licensed integrations can be attached at runtime without being compiled into the application
+// or the agent. A plugin is an executable serving a sink, a notifier or both:
+//
+//	func main() {
+//		pluginrpc.Serve(pluginrpc.ServeConfig{
+//			Sink: func(config json.RawMessage) (flightrecorder.Sink, error) { return newS3Sink(config) },
+//		})
+//	}
+//
+// Importing the package registers the "plugin" sink and notifier types with flightrecorder.RegisterSinkFactory
+// and flightrecorder.RegisterNotifierFactory, launching the executable of their configuration:
+//
+//	{"sink": {"type": "plugin", "config": {"path": "/usr/lib/flightrecorder/s3", "config": {"bucket": "traces"}}}}
+//
+// Plugins speak the gRPC protocol of go-plugin. Their services are declared in this package and encoded with
+// the flight recorder's JSON types, since there is no protobuf schema. Plugin processes are started when the
+// configuration is instantiated and run until Cleanup is called, typically deferred in main. A plugin which
+// exits fails its writes and notifications.
+package pluginrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// Handshake is the handshake of the plugins, preventing them from being run as regular executables
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  2, // 1 was net/rpc
+	MagicCookieKey:   "FLIGHTRECORDER_PLUGIN",
+	MagicCookieValue: "7f3c9b1e-trace-sink",
+}
+
+// Names of the plugins an executable may serve
+const (
+	SinkPlugin     = "sink"
+	NotifierPlugin = "notifier"
+)
+
+// ServeConfig configures the plugins served by a plugin executable, created from the JSON configuration of the host
+type ServeConfig struct {
+	Sink     flightrecorder.SinkFactory
+	Notifier flightrecorder.NotifierFactory
+}
+
+// Serve serves the plugins of a plugin executable to the host which launched it, it doesn't return
+func Serve(config ServeConfig) {
+	plugins := plugin.PluginSet{}
+	if config.Sink != nil {
+		plugins[SinkPlugin] = &sinkPlugin{factory: config.Sink}
+	}
+	if config.Notifier != nil {
+		plugins[NotifierPlugin] = &notifierPlugin{factory: config.Notifier}
+	}
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         plugins,
+		GRPCServer: func(opts []grpc.ServerOption) *grpc.Server {
+			// Snapshots are larger than the 4MB gRPC receives by default.
+			return plugin.DefaultGRPCServer(append(opts, grpc.MaxRecvMsgSize(math.MaxInt32)))
+		},
+	})
+}
+
+// Config is the configuration of the "plugin" sink and notifier types
+type Config struct {
+	Path   string          `json:"path"`             // plugin executable
+	Args   []string        `json:"args,omitempty"`   // arguments of the executable
+	Config json.RawMessage `json:"config,omitempty"` // configuration passed to the plugin's factory
+}
+
+// Cleanup kills the plugin processes launched by the host
+func Cleanup() {
+	plugin.CleanupClients()
+}
+
+func init() {
+	flightrecorder.RegisterSinkFactory("plugin", func(raw json.RawMessage) (flightrecorder.Sink, error) {
+		var c Config
+		if err := flightrecorder.DecodePluginConfig(raw, &c); err != nil {
+			return nil, err
+		}
+		return LaunchSink(c)
+	})
+	flightrecorder.RegisterNotifierFactory("plugin", func(raw json.RawMessage) (flightrecorder.Notifier, error) {
+		var c Config
+		if err := flightrecorder.DecodePluginConfig(raw, &c); err != nil {
+			return nil, err
+		}
+		return LaunchNotifier(c)
+	})
+}
+
+// LaunchSink starts a plugin executable and returns the sink it serves
+func LaunchSink(c Config) (flightrecorder.Sink, error) {
+	raw, err := launch(c, SinkPlugin)
+	if err != nil {
+		return nil, err
+	}
+	return raw.(*sinkClient), nil
+}
+
+// LaunchNotifier starts a plugin executable and returns the notifier it serves
+func LaunchNotifier(c Config) (flightrecorder.Notifier, error) {
+	raw, err := launch(c, NotifierPlugin)
+	if err != nil {
+		return nil, err
+	}
+	return raw.(*notifierClient), nil
+}
+
+// launch starts the plugin executable, dispenses the plugin and configures it
+func launch(c Config, name string) (any, error) {
+	if c.Path == "" {
+		return nil, errors.New("path is required")
+	}
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  Handshake,
+		Plugins:          plugin.PluginSet{SinkPlugin: &sinkPlugin{}, NotifierPlugin: &notifierPlugin{}},
+		Cmd:              exec.Command(c.Path, c.Args...),
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Managed:          true,
+		Logger:           hclog.New(&hclog.LoggerOptions{Name: "flightrecorder-plugin", Output: os.Stderr, Level: hclog.Warn}),
+	})
+	rpcClient, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("failed to start plugin %s: %w", c.Path, err)
+	}
+	raw, err := rpcClient.Dispense(name)
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("plugin %s doesn't serve a %s: %w", c.Path, name, err)
+	}
+	if err := raw.(configurable).configure(context.Background(), c.Config); err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("failed to configure plugin %s: %w", c.Path, err)
+	}
+	return raw, nil
+}
+
+// configurable is the client of a plugin, configured once dispensed
+type configurable interface {
+	configure(ctx context.Context, config json.RawMessage) error
+}
+
+// codecName is the content subtype of the calls of the plugin services, encoded as JSON. The services of
+// go-plugin itself, e.g. its health check, keep the protobuf codec on the same connection.
+const codecName = "flightrecorder-json"
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return codecName }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// Services and methods of the plugins
+const (
+	sinkService     = "flightrecorder.plugin.Sink"
+	notifierService = "flightrecorder.plugin.Notifier"
+
+	configureMethod = "Configure"
+	writeMethod     = "Write"
+	notifyMethod    = "Notify"
+)
+
+// Messages of the plugin services
+type (
+	configureRequest struct {
+		Config json.RawMessage `json:"config,omitempty"`
+	}
+	writeRequest struct {
+		Meta flightrecorder.SnapshotMeta `json:"meta"`
+		Data []byte                      `json:"data"`
+	}
+	notifyRequest struct {
+		Event flightrecorder.Event `json:"event"`
+	}
+	emptyResponse struct{}
+)
+
+// invoke calls a method of a plugin service
+func invoke(ctx context.Context, conn *grpc.ClientConn, service, method string, req any) error {
+	err := conn.Invoke(ctx, "/"+service+"/"+method, req, &emptyResponse{}, grpc.CallContentSubtype(codecName))
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// Errors of the plugin are reported as they were returned, without the status code.
+	return errors.New(status.Convert(err).Message())
+}
+
+// unaryMethod declares a method of a plugin service calling handle with the decoded request
+func unaryMethod[S, Req any](service, method string, handle func(srv S, ctx context.Context, req *Req) error) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				return &emptyResponse{}, handle(srv.(S), ctx, req.(*Req))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + service + "/" + method}, handler)
+		},
+	}
+}
+
+// sinkPlugin is the go-plugin of a sink, the factory is nil on the host
+type sinkPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	factory flightrecorder.SinkFactory
+}
+
+var sinkServiceDesc = grpc.ServiceDesc{
+	ServiceName: sinkService,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(sinkService, configureMethod, (*sinkServer).configure),
+		unaryMethod(sinkService, writeMethod, (*sinkServer).write),
+	},
+}
+
+func (p *sinkPlugin) GRPCServer(_ *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&sinkServiceDesc, &sinkServer{factory: p.factory})
+	return nil
+}
+
+func (p *sinkPlugin) GRPCClient(_ context.Context, _ *plugin.GRPCBroker, conn *grpc.ClientConn) (any, error) {
+	return &sinkClient{conn: conn}, nil
+}
+
+// sinkServer serves a sink in the plugin process
+type sinkServer struct {
+	factory flightrecorder.SinkFactory
+	sink    flightrecorder.Sink
+}
+
+// configure creates the sink from its configuration
+func (s *sinkServer) configure(_ context.Context, req *configureRequest) error {
+	sink, err := s.factory(req.Config)
+	if err != nil {
+		return err
+	}
+	s.sink = sink
+	return nil
+}
+
+// write writes a snapshot to the sink, ending when the host's write does
+func (s *sinkServer) write(ctx context.Context, req *writeRequest) error {
+	if s.sink == nil {
+		return errors.New("sink is not configured")
+	}
+	return s.sink.Write(ctx, req.Meta, req.Data)
+}
+
+// sinkClient is the sink of a plugin on the host
+type sinkClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *sinkClient) configure(ctx context.Context, config json.RawMessage) error {
+	return invoke(ctx, c.conn, sinkService, configureMethod, &configureRequest{Config: config})
+}
+
+// Write writes the snapshot through the plugin
+func (c *sinkClient) Write(ctx context.Context, meta flightrecorder.SnapshotMeta, data []byte) error {
+	return invoke(ctx, c.conn, sinkService, writeMethod, &writeRequest{Meta: meta, Data: data})
+}
+
+// notifierPlugin is the go-plugin of a notifier, the factory is nil on the host
+type notifierPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	factory flightrecorder.NotifierFactory
+}
+
+var notifierServiceDesc = grpc.ServiceDesc{
+	ServiceName: notifierService,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod(notifierService, configureMethod, (*notifierServer).configure),
+		unaryMethod(notifierService, notifyMethod, (*notifierServer).notify),
+	},
+}
+
+func (p *notifierPlugin) GRPCServer(_ *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&notifierServiceDesc, &notifierServer{factory: p.factory})
+	return nil
+}
+
+func (p *notifierPlugin) GRPCClient(_ context.Context, _ *plugin.GRPCBroker, conn *grpc.ClientConn) (any, error) {
+	return &notifierClient{conn: conn}, nil
+}
+
+// notifierServer serves a notifier in the plugin process
+type notifierServer struct {
+	factory  flightrecorder.NotifierFactory
+	notifier flightrecorder.Notifier
+}
+
+// configure creates the notifier from its configuration
+func (s *notifierServer) configure(_ context.Context, req *configureRequest) error {
+	notifier, err := s.factory(req.Config)
+	if err != nil {
+		return err
+	}
+	s.notifier = notifier
+	return nil
+}
+
+// notify notifies the notifier of an event
+func (s *notifierServer) notify(ctx context.Context, req *notifyRequest) error {
+	if s.notifier == nil {
+		return errors.New("notifier is not configured")
+	}
+	return s.notifier.Notify(ctx, req.Event)
+}
+
+// notifierClient is the notifier of a plugin on the host
+type notifierClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *notifierClient) configure(ctx context.Context, config json.RawMessage) error {
+	return invoke(ctx, c.conn, notifierService, configureMethod, &configureRequest{Config: config})
+}
+
+// Notify notifies the plugin of the event
+func (c *notifierClient) Notify(ctx context.Context, e flightrecorder.Event) error {
+	return invoke(ctx, c.conn, notifierService, notifyMethod, &notifyRequest{Event: e})
+}
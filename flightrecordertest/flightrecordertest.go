@@ -0,0 +1,151 @@
+// Package flightrecordertest provides helpers to test and benchmark applications
+// and trigger configurations using the flight recorder service.
+//
+// Only one flight recorder may be running in a process at any given time,
+// so tests using these helpers must not run in parallel with each other.
+package flightrecordertest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	flightrecorder "github.com/mcwalrus/http-flight-recorder"
+	"golang.org/x/exp/trace"
+)
+
+// TraceStats summarizes a parsed trace
+type TraceStats struct {
+	Events int                     // total number of events
+	Kinds  map[trace.EventKind]int // number of events by kind
+	Logs   map[string][]string     // trace log messages by category
+}
+
+// NewServer creates a new flight recorder service with the handlers registered
+// under /recorder on an httptest server. The recorder is started, and both the
+// service and the server are closed when the test finishes.
+func NewServer(tb testing.TB, opts ...flightrecorder.Option) (*flightrecorder.Service, *httptest.Server) {
+	tb.Helper()
+
+	service := flightrecorder.NewService(opts...)
+	if err := service.Start(); err != nil {
+		tb.Fatalf("failed to start flight recorder: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	service.RegisterHandlers(mux)
+	server := httptest.NewServer(mux)
+
+	tb.Cleanup(func() {
+		server.Close()
+		service.Close()
+	})
+	return service, server
+}
+
+// Capture runs fn with the flight recorder running and returns the snapshot taken afterwards.
+// The recorder is started if needed, and left as it was found.
+func Capture(tb testing.TB, service *flightrecorder.Service, fn func()) []byte {
+	tb.Helper()
+
+	if !service.Status().Enabled {
+		if err := service.Start(); err != nil {
+			tb.Fatalf("failed to start flight recorder: %v", err)
+		}
+		defer service.Stop()
+	}
+
+	fn()
+
+	data, err := service.Snapshot()
+	if err != nil {
+		tb.Fatalf("failed to take snapshot: %v", err)
+	}
+	return data
+}
+
+// Benchmark runs the benchmark function with the flight recorder running and returns
+// the snapshot taken afterwards. The timer is reset before fn runs, so starting the
+// recorder is not measured, and stopped before the snapshot is taken.
+func Benchmark(b *testing.B, service *flightrecorder.Service, fn func(b *testing.B)) []byte {
+	b.Helper()
+
+	return Capture(b, service, func() {
+		b.ResetTimer()
+		fn(b)
+		b.StopTimer()
+	})
+}
+
+// ParseTrace parses the trace and summarizes its events.
+// Empty or truncated traces return an error.
+func ParseTrace(data []byte) (TraceStats, error) {
+	stats := TraceStats{
+		Kinds: make(map[trace.EventKind]int),
+		Logs:  make(map[string][]string),
+	}
+	if len(data) == 0 {
+		return stats, errors.New("empty trace")
+	}
+
+	reader, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return stats, fmt.Errorf("invalid trace: %w", err)
+	}
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("invalid trace after %d events: %w", stats.Events, err)
+		}
+		stats.Events++
+		stats.Kinds[event.Kind()]++
+		if event.Kind() == trace.EventLog {
+			log := event.Log()
+			stats.Logs[log.Category] = append(stats.Logs[log.Category], log.Message)
+		}
+	}
+	if stats.Events == 0 {
+		return stats, errors.New("trace has no events")
+	}
+	return stats, nil
+}
+
+// AssertValidTrace fails the test unless the data is a valid, non-empty trace
+func AssertValidTrace(tb testing.TB, data []byte) TraceStats {
+	tb.Helper()
+
+	stats, err := ParseTrace(data)
+	if err != nil {
+		tb.Fatalf("snapshot of %d bytes is not a valid trace: %v", len(data), err)
+	}
+	return stats
+}
+
+// GetSnapshot downloads a snapshot from the server's snapshot endpoint
+// and fails the test unless it is a valid trace.
+func GetSnapshot(tb testing.TB, server *httptest.Server) []byte {
+	tb.Helper()
+
+	resp, err := server.Client().Get(server.URL + "/recorder/snapshot")
+	if err != nil {
+		tb.Fatalf("failed to get snapshot: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		tb.Fatalf("failed to read snapshot: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		tb.Fatalf("snapshot returned %s: %s", resp.Status, data)
+	}
+	AssertValidTrace(tb, data)
+	return data
+}
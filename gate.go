@@ -0,0 +1,62 @@
+package flightrecorder
+
+import "errors"
+
+// ErrNotLeader is returned when the recorder gate refuses to start the recorder of a follower
+var ErrNotLeader = errors.New("flight recorder is not the leader")
+
+// RecorderGate decides whether the replica of a replicated service records, e.g. wired to a leader election
+// primitive, so a consensus group produces one recording rather than N identical ones
+type RecorderGate interface {
+	Leader() bool
+}
+
+// RecorderGateFunc adapts a function to a RecorderGate
+type RecorderGateFunc func() bool
+
+// Leader calls f()
+func (f RecorderGateFunc) Leader() bool {
+	return f()
+}
+
+// GateMode is what followers do, see WithRecorderGate
+type GateMode string
+
+const (
+	// GateRefuse refuses to start the recorder of followers with ErrNotLeader and skips their triggers
+	GateRefuse GateMode = "refuse"
+	// GateMark lets followers record, marking their snapshots as from a follower
+	GateMark GateMode = "mark"
+)
+
+// WithRecorderGate consults the gate before the recorder starts and triggers fire, refusing them on followers
+// or marking the snapshots of followers depending on the mode (default GateRefuse). Snapshots taken on a
+// follower are marked in both modes. The gate is consulted on every start and trigger, so it should be cheap,
+// e.g. reading the state of a lease held by a background election.
+func WithRecorderGate(g RecorderGate, mode GateMode) Option {
+	return func(o *options) {
+		if mode == "" {
+			mode = GateRefuse
+		}
+		o.gate, o.gateMode = g, mode
+	}
+}
+
+// leader reports whether the replica is the leader, true without a gate
+func (s *Service) leader() bool {
+	return s.opts.gate == nil || s.opts.gate.Leader()
+}
+
+// gateRefuses reports whether the gate refuses to start the recorder or fire triggers
+func (s *Service) gateRefuses() bool {
+	return s.opts.gateMode == GateRefuse && !s.leader()
+}
+
+// leaderStatus returns the leadership of the replica for the status, nil without a gate
+func (s *Service) leaderStatus() *bool {
+	if s.opts.gate == nil {
+		return nil
+	}
+	leader := s.opts.gate.Leader()
+	return &leader
+}
@@ -0,0 +1,266 @@
+package flightrecorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Metrics of the Grafana JSON data source API
+const (
+	GrafanaSnapshots     = "snapshots"      // table of the stored snapshots
+	GrafanaSnapshotSize  = "snapshot_size"  // time series of the sizes of the stored snapshots, in bytes
+	GrafanaSnapshotCount = "snapshot_count" // time series of the number of stored snapshots per interval
+	GrafanaTriggers      = "triggers"       // time series of the fired triggers per interval, one per trigger
+)
+
+var grafanaMetrics = []string{GrafanaSnapshots, GrafanaSnapshotSize, GrafanaSnapshotCount, GrafanaTriggers}
+
+// GrafanaRange is the time range of a Grafana query
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// GrafanaTarget is a query of a Grafana panel
+type GrafanaTarget struct {
+	Target string `json:"target"` // metric, e.g. snapshot_size
+	RefID  string `json:"refId,omitempty"`
+}
+
+// GrafanaQueryRequest is the payload of the query request of the Grafana JSON data source
+type GrafanaQueryRequest struct {
+	Range      GrafanaRange    `json:"range"`
+	IntervalMs int64           `json:"intervalMs,omitempty"` // bucket of the count series (default 1m)
+	Targets    []GrafanaTarget `json:"targets"`
+}
+
+// GrafanaColumn is a column of a table result
+type GrafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"` // time, string or number
+}
+
+// GrafanaQueryResult is a time series, with Target and Datapoints of [value, unix milliseconds],
+// or a table, with Type "table", Columns and Rows
+type GrafanaQueryResult struct {
+	Target     string          `json:"target,omitempty"`
+	Datapoints [][2]float64    `json:"datapoints,omitempty"`
+	Type       string          `json:"type,omitempty"`
+	Columns    []GrafanaColumn `json:"columns,omitempty"`
+	Rows       [][]any         `json:"rows,omitempty"`
+}
+
+// GrafanaMetric is a metric offered to the query editor
+type GrafanaMetric struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// GrafanaAnnotationRequest is the payload of the annotations request of the Grafana JSON data source
+type GrafanaAnnotationRequest struct {
+	Range      GrafanaRange           `json:"range"`
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+}
+
+// GrafanaAnnotationQuery is the annotation query of a Grafana dashboard
+type GrafanaAnnotationQuery struct {
+	Name  string `json:"name"`
+	Query string `json:"query,omitempty"` // event types separated by commas (default trigger_fired)
+}
+
+// GrafanaAnnotation marks an event of the recorder on Grafana panels
+type GrafanaAnnotation struct {
+	Time  int64    `json:"time"` // unix milliseconds
+	Title string   `json:"title"`
+	Text  string   `json:"text,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// instance names the service in Grafana series, by its "service" label or its hostname
+func (s *Service) instance() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if service := s.labels["service"]; service != "" {
+		return service + "@" + s.hostname
+	}
+	return s.hostname
+}
+
+// GrafanaQuery answers a query of the Grafana JSON data source from the stored snapshots and the recent events
+func (s *Service) GrafanaQuery(req GrafanaQueryRequest) ([]GrafanaQueryResult, error) {
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	inRange := func(t time.Time) bool {
+		return !t.Before(req.Range.From) && (req.Range.To.IsZero() || !t.After(req.Range.To))
+	}
+
+	var snapshots []SnapshotMeta
+	for _, meta := range s.Snapshots() {
+		if inRange(meta.CreatedAt) {
+			snapshots = append(snapshots, meta)
+		}
+	}
+	instance := s.instance()
+
+	results := []GrafanaQueryResult{}
+	for _, target := range req.Targets {
+		switch target.Target {
+		case GrafanaSnapshots:
+			table := GrafanaQueryResult{
+				Type: "table",
+				Columns: []GrafanaColumn{
+					{"Time", "time"}, {"Instance", "string"}, {"ID", "string"}, {"Name", "string"},
+					{"Trigger", "string"}, {"Size", "number"}, {"Session", "string"},
+				},
+				Rows: [][]any{},
+			}
+			for _, meta := range snapshots {
+				table.Rows = append(table.Rows, []any{meta.CreatedAt.UnixMilli(), instance, meta.ID, meta.Name, meta.Trigger, meta.Size, meta.Session})
+			}
+			results = append(results, table)
+		case GrafanaSnapshotSize:
+			series := GrafanaQueryResult{Target: instance + " " + GrafanaSnapshotSize, Datapoints: [][2]float64{}}
+			for _, meta := range snapshots {
+				series.Datapoints = append(series.Datapoints, [2]float64{float64(meta.Size), float64(meta.CreatedAt.UnixMilli())})
+			}
+			results = append(results, series)
+		case GrafanaSnapshotCount:
+			var times []time.Time
+			for _, meta := range snapshots {
+				times = append(times, meta.CreatedAt)
+			}
+			results = append(results, GrafanaQueryResult{Target: instance + " " + GrafanaSnapshotCount, Datapoints: countBuckets(times, interval)})
+		case GrafanaTriggers:
+			fired := make(map[string][]time.Time)
+			for _, e := range s.RecentEvents() {
+				if e.Type == EventTriggerFired && inRange(e.Time) {
+					fired[e.Trigger] = append(fired[e.Trigger], e.Time)
+				}
+			}
+			for _, trigger := range slices.Sorted(maps.Keys(fired)) {
+				results = append(results, GrafanaQueryResult{Target: instance + " " + trigger, Datapoints: countBuckets(fired[trigger], interval)})
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown metric %q, should be one of %v", ErrInvalidRequest, target.Target, grafanaMetrics)
+		}
+	}
+	return results, nil
+}
+
+// countBuckets counts the times per interval, as datapoints at the start of each non-empty interval
+func countBuckets(times []time.Time, interval time.Duration) [][2]float64 {
+	counts := make(map[int64]int)
+	for _, t := range times {
+		counts[t.Truncate(interval).UnixMilli()]++
+	}
+	datapoints := [][2]float64{}
+	for _, bucket := range slices.Sorted(maps.Keys(counts)) {
+		datapoints = append(datapoints, [2]float64{float64(counts[bucket]), float64(bucket)})
+	}
+	return datapoints
+}
+
+// GrafanaAnnotations returns the recent events of the requested types as annotations
+func (s *Service) GrafanaAnnotations(req GrafanaAnnotationRequest) []GrafanaAnnotation {
+	types := []EventType{EventTriggerFired}
+	if req.Annotation.Query != "" {
+		types = nil
+		for t := range strings.SplitSeq(req.Annotation.Query, ",") {
+			types = append(types, EventType(strings.TrimSpace(t)))
+		}
+	}
+
+	instance := s.instance()
+	annotations := []GrafanaAnnotation{}
+	for _, e := range s.RecentEvents() {
+		if !slices.Contains(types, e.Type) || e.Time.Before(req.Range.From) || (!req.Range.To.IsZero() && e.Time.After(req.Range.To)) {
+			continue
+		}
+		a := GrafanaAnnotation{Time: e.Time.UnixMilli(), Title: string(e.Type), Tags: []string{instance, string(e.Type)}}
+		if e.Trigger != "" {
+			a.Title += ": " + e.Trigger
+			a.Tags = append(a.Tags, e.Trigger)
+		}
+		if e.Snapshot != nil {
+			a.Text = fmt.Sprintf("snapshot %s (%d bytes)", e.Snapshot.ID, e.Snapshot.Size)
+		}
+		if e.Error != "" {
+			a.Text = e.Error
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations
+}
+
+// handleGrafana answers the connection test of the Grafana JSON data source, a GET of its URL with a trailing slash
+func (s *Service) handleGrafana(w http.ResponseWriter, r *http.Request) {
+	// The path is a subtree pattern of ServeMux, which also matches unknown paths below it.
+	if !strings.HasSuffix(r.URL.Path, "/grafana/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Service) handleGrafanaMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metrics := make([]GrafanaMetric, 0, len(grafanaMetrics))
+	for _, metric := range grafanaMetrics {
+		metrics = append(metrics, GrafanaMetric{Label: metric, Value: metric})
+	}
+	writeResponse(w, r, http.StatusOK, metrics)
+}
+
+func (s *Service) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req GrafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest))
+		return
+	}
+	results, err := s.GrafanaQuery(req)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidRequest) {
+			code = http.StatusBadRequest
+		}
+		writeError(w, code, err)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, results)
+}
+
+func (s *Service) handleGrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req GrafanaAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest))
+		return
+	}
+	writeResponse(w, r, http.StatusOK, s.GrafanaAnnotations(req))
+}
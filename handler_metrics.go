@@ -0,0 +1,205 @@
+package flightrecorder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestDurationBuckets are the upper bounds of the handler latency histogram buckets
+var RequestDurationBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// handlerMetrics counts the requests served by the handlers, by route and method
+type handlerMetrics struct {
+	mu     sync.Mutex
+	routes map[routeKey]*routeStats
+}
+
+type routeKey struct {
+	route  string
+	method string
+}
+
+type routeStats struct {
+	buckets []uint64 // requests less than or equal to each of RequestDurationBuckets
+	count   uint64
+	sum     time.Duration
+	codes   map[int]uint64
+}
+
+// observe records a request served by the route
+func (m *handlerMetrics) observe(route, method string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.routes == nil {
+		m.routes = make(map[routeKey]*routeStats)
+	}
+	key := routeKey{route, method}
+	stats := m.routes[key]
+	if stats == nil {
+		stats = &routeStats{buckets: make([]uint64, len(RequestDurationBuckets)), codes: make(map[int]uint64)}
+		m.routes[key] = stats
+	}
+	for i, bound := range RequestDurationBuckets {
+		if d <= bound {
+			stats.buckets[i]++
+		}
+	}
+	stats.count++
+	stats.sum += d
+	stats.codes[code]++
+}
+
+// snapshot returns a copy of the stats sorted by route and method
+func (m *handlerMetrics) snapshot() ([]routeKey, map[routeKey]routeStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]routeKey, 0, len(m.routes))
+	stats := make(map[routeKey]routeStats, len(m.routes))
+	for key, s := range m.routes {
+		keys = append(keys, key)
+		stats[key] = routeStats{buckets: slices.Clone(s.buckets), count: s.count, sum: s.sum, codes: maps.Clone(s.codes)}
+	}
+	slices.SortFunc(keys, func(a, b routeKey) int {
+		return strings.Compare(a.route+" "+a.method, b.route+" "+b.method)
+	})
+	return keys, stats
+}
+
+// metricsMethods are the methods reported in the metrics, others are reported as "other"
+var metricsMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}
+
+// statusRecorder records the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController flush streamed responses such as the event stream
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument records the latency and status code of the requests served by the route's handler.
+// Versioned paths and their aliases are reported as the same route.
+func (s *Service) instrument(route Route) http.HandlerFunc {
+	h := route.Handler
+	path := unversionedPath(route)
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			method := r.Method
+			if !slices.Contains(metricsMethods, method) {
+				method = "other"
+			}
+			code := rec.code
+			if code == 0 {
+				code = http.StatusOK
+			}
+			s.handlerMetrics.observe(path, method, code, time.Since(start))
+		}()
+		h(rec, r)
+	}
+}
+
+// instrumentRoutes wraps the handlers of routes with the handler metrics
+func (s *Service) instrumentRoutes(routes []Route) []Route {
+	for i, route := range routes {
+		routes[i].Handler = s.instrument(route)
+	}
+	return routes
+}
+
+// WriteMetrics writes the recorder gauges and the latency histograms and status code counters
+// of its handlers as OpenMetrics text, for services exposing them on their own metrics endpoint
+func (s *Service) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	status := s.Status()
+
+	gauge := func(name, unit, help string, value float64) {
+		writeMetricHeader(bw, name, "gauge", unit, help)
+		fmt.Fprintf(bw, "%s %s\n", name, formatFloat(value))
+	}
+	var enabled float64
+	if status.Enabled {
+		enabled = 1
+	}
+	gauge("flightrecorder_enabled", "", "Whether the flight recorder is running.", enabled)
+	gauge("flightrecorder_period_seconds", "seconds", "Minimum time window kept in the buffer.", time.Duration(status.Period).Seconds())
+	gauge("flightrecorder_buffer_bytes", "bytes", "Maximum size of the buffer.", float64(status.Size))
+	gauge("flightrecorder_stored_snapshots", "", "Snapshots held in the snapshot store.", float64(status.StoredSnapshots))
+	gauge("flightrecorder_sink_queued", "", "Snapshots waiting to be written to the sink.", float64(status.SinkQueued))
+	gauge("flightrecorder_stored_bytes", "bytes", "Total size of the snapshots held in the snapshot store.", float64(status.StoredBytes))
+
+	keys, stats := s.handlerMetrics.snapshot()
+
+	writeMetricHeader(bw, "flightrecorder_http_requests", "counter", "", "Requests served by the recorder endpoints.")
+	for _, key := range keys {
+		codes := stats[key].codes
+		for _, code := range slices.Sorted(maps.Keys(codes)) {
+			fmt.Fprintf(bw, "flightrecorder_http_requests_total{route=\"%s\",method=\"%s\",code=\"%d\"} %d\n",
+				escapeLabel(key.route), key.method, code, codes[code])
+		}
+	}
+
+	writeMetricHeader(bw, "flightrecorder_http_request_duration_seconds", "histogram", "seconds", "Time taken to serve requests to the recorder endpoints.")
+	for _, key := range keys {
+		labels := fmt.Sprintf(`route="%s",method="%s"`, escapeLabel(key.route), key.method)
+		st := stats[key]
+		for i, bound := range RequestDurationBuckets {
+			fmt.Fprintf(bw, "flightrecorder_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound.Seconds()), st.buckets[i])
+		}
+		fmt.Fprintf(bw, "flightrecorder_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, st.count)
+		fmt.Fprintf(bw, "flightrecorder_http_request_duration_seconds_count{%s} %d\n", labels, st.count)
+		fmt.Fprintf(bw, "flightrecorder_http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(st.sum.Seconds()))
+	}
+
+	fmt.Fprintln(bw, "# EOF")
+	return bw.Flush()
+}
+
+func (s *Service) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+	s.WriteMetrics(w)
+}
@@ -0,0 +1,31 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsCountPatch(t *testing.T) {
+	s := NewService()
+	t.Cleanup(func() { s.Close() })
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/recorder/config", strings.NewReader(`{"period":"5s"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH /config: got %d: %s", w.Code, w.Body)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `flightrecorder_http_requests_total{route="/config",method="PATCH",code="200"} 1`
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("metrics don't contain %s:\n%s", want, buf.String())
+	}
+}
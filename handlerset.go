@@ -0,0 +1,109 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// HandlerSet selects endpoints of the service and the middleware wrapping them, so the same service
+// can be registered under several prefixes with different access, e.g. full access under
+// /internal/recorder and the status only under /public/recorder:
+//
+//	service.Handlers().Use(internalAuth).Register(mux, "/internal/recorder")
+//	service.Handlers().Endpoints("GET /status").Register(mux, "/public/recorder")
+//
+// Its methods return a new set, so sets can be derived from a common base.
+type HandlerSet struct {
+	s          *Service
+	include    []func(Route) bool
+	middleware []func(http.Handler) http.Handler
+}
+
+// Handlers returns the set of all endpoints of the service, without middleware
+func (s *Service) Handlers() HandlerSet {
+	return HandlerSet{s: s}
+}
+
+// with returns a copy of the set including only the routes matching include as well
+func (h HandlerSet) with(include func(Route) bool) HandlerSet {
+	h.include = append(slices.Clip(h.include), include)
+	return h
+}
+
+// Read keeps the read-only endpoints, like RegisterReadHandlers
+func (h HandlerSet) Read() HandlerSet {
+	return h.with(func(route Route) bool { return !route.Admin })
+}
+
+// Admin keeps the mutating endpoints, like RegisterAdminHandlers
+func (h HandlerSet) Admin() HandlerSet {
+	return h.with(func(route Route) bool { return route.Admin })
+}
+
+// Endpoints keeps the endpoints matching one of the patterns: a path without the version, as in
+// the route table of the README, optionally preceded by a method, e.g. "/snapshots/{id}" or "GET /status".
+// Both the versioned path and its unversioned alias are kept.
+func (h HandlerSet) Endpoints(patterns ...string) HandlerSet {
+	return h.with(func(route Route) bool { return slices.ContainsFunc(patterns, route.matches) })
+}
+
+// Except drops the endpoints matching one of the patterns, see Endpoints
+func (h HandlerSet) Except(patterns ...string) HandlerSet {
+	return h.with(func(route Route) bool { return !slices.ContainsFunc(patterns, route.matches) })
+}
+
+// Use wraps the handlers of the set with middleware, e.g. authentication.
+// The first middleware is the outermost, and runs after the middleware of the service (CORS, allowlist).
+func (h HandlerSet) Use(middleware ...func(http.Handler) http.Handler) HandlerSet {
+	h.middleware = append(slices.Clip(h.middleware), middleware...)
+	return h
+}
+
+// Routes returns the routes of the set with their handlers wrapped by the middleware,
+// so adapters can register the set on other routers
+func (h HandlerSet) Routes() []Route {
+	var routes []Route
+	for _, route := range h.s.Routes() {
+		if !h.includes(route) {
+			continue
+		}
+		handler := http.Handler(route.Handler)
+		for _, mw := range slices.Backward(h.middleware) {
+			handler = mw(handler)
+		}
+		route.Handler = handler.ServeHTTP
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// Register registers the handlers of the set to the given mux under the prefix,
+// requests with other methods on the paths of the set are rejected by the mux
+func (h HandlerSet) Register(mux *http.ServeMux, prefix string) {
+	for _, route := range h.Routes() {
+		mux.HandleFunc(route.Method+" "+prefix+route.Path, route.Handler)
+	}
+}
+
+func (h HandlerSet) includes(route Route) bool {
+	for _, include := range h.include {
+		if !include(route) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the route matches an endpoint pattern of HandlerSet.Endpoints
+func (r Route) matches(pattern string) bool {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	// CORS preflight routes are kept along with the methods of their path.
+	if method != "" && method != r.Method && r.Method != http.MethodOptions {
+		return false
+	}
+	return unversionedPath(r) == strings.TrimSpace(path)
+}
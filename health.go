@@ -0,0 +1,267 @@
+package flightrecorder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// sinkFailureWindow is how long a failed sink write keeps the service unready,
+	// unless a later write succeeds
+	sinkFailureWindow = 5 * time.Minute
+	// healthCheckTimeout bounds sink reachability checks
+	healthCheckTimeout = 5 * time.Second
+
+	healthOK          = "ok"
+	healthUnavailable = "unavailable"
+)
+
+// SinkChecker is implemented by sinks which can check they are reachable
+type SinkChecker interface {
+	Check(ctx context.Context) error
+}
+
+// HealthResponse represents the response of the health and readiness endpoints
+type HealthResponse struct {
+	Instance string            `json:"instance"` // service instance which answered, see Service.Instance
+	Status   string            `json:"status"`   // "ok" or "unavailable"
+	Checks   map[string]string `json:"checks"`   // result of each check, "ok" or the failure
+}
+
+// serviceHealth tracks the background goroutines, sink writes and state file of the service
+type serviceHealth struct {
+	janitor        atomic.Bool
+	runtimeTrigger atomic.Bool
+	continuous     atomic.Bool
+	export         atomic.Bool
+	crashHandler   atomic.Bool
+	sinkRetry      atomic.Bool
+	leakDetector   atomic.Bool
+	bufferSampler  atomic.Bool
+	remoteConfig   atomic.Bool
+
+	mu           sync.Mutex
+	sinkErr      error
+	sinkFailedAt time.Time
+	stateErr     error
+
+	continuousErr   error
+	exportErr       error
+	webhookErr      error
+	remoteConfigErr error
+
+	tlsConfigured bool
+	tlsErr        error
+}
+
+// recordSinkWrite records the result of a sink write
+func (h *serviceHealth) recordSinkWrite(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.sinkErr = err
+	if err != nil {
+		h.sinkFailedAt = time.Now()
+	}
+}
+
+// recentSinkFailure returns the last sink write error if it failed within the window
+func (h *serviceHealth) recentSinkFailure(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.sinkErr != nil && now.Sub(h.sinkFailedAt) < sinkFailureWindow {
+		return h.sinkErr
+	}
+	return nil
+}
+
+func (s *Service) newHealthResponse(checks map[string]string) HealthResponse {
+	status := healthOK
+	for _, result := range checks {
+		if result != healthOK {
+			status = healthUnavailable
+		}
+	}
+	return HealthResponse{Instance: s.instanceID, Status: status, Checks: checks}
+}
+
+// Health reports whether the background goroutines of the service are alive
+func (s *Service) Health() HealthResponse {
+	checks := map[string]string{"service": healthOK}
+	if s.ctx.Err() != nil {
+		checks["service"] = "closed"
+	}
+	if s.opts.retention.enabled() {
+		checks["janitor"] = aliveCheck(s.health.janitor.Load())
+	}
+
+	s.mu.RLock()
+	runtimeTrigger := s.runtimeTrigger.enabled()
+	s.mu.RUnlock()
+	if runtimeTrigger {
+		checks["runtime_trigger"] = aliveCheck(s.health.runtimeTrigger.Load())
+	}
+	if s.opts.continuous.enabled() {
+		checks["continuous"] = aliveCheck(s.health.continuous.Load())
+	}
+	if s.opts.export.enabled() {
+		checks["export"] = aliveCheck(s.health.export.Load())
+	}
+	if s.opts.crashDir != "" {
+		checks["crash_handler"] = aliveCheck(s.health.crashHandler.Load())
+	}
+	if s.retryingSink() {
+		checks["sink_retry"] = aliveCheck(s.health.sinkRetry.Load())
+	}
+	if s.opts.leakDetector.enabled() {
+		checks["leak_detector"] = aliveCheck(s.health.leakDetector.Load())
+	}
+	if s.opts.bufferSampling > 0 {
+		checks["buffer_sampler"] = aliveCheck(s.health.bufferSampler.Load())
+	}
+	if s.opts.remoteConfig.enabled() {
+		checks["remote_config"] = aliveCheck(s.health.remoteConfig.Load())
+	}
+	return s.newHealthResponse(checks)
+}
+
+// Ready reports whether the service is ready to take snapshots: it is healthy,
+// the recorder is running, the sink is reachable, recent sink writes succeeded
+// and the state file was last loaded or saved successfully
+func (s *Service) Ready(ctx context.Context) HealthResponse {
+	checks := s.Health().Checks
+
+	checks["recorder"] = healthOK
+	if !s.Status().Enabled {
+		checks["recorder"] = ErrNotRunning.Error()
+	}
+
+	if s.opts.sink != nil {
+		checks["sink"] = healthOK
+		if checker, ok := s.opts.sink.(SinkChecker); ok {
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			if err := checker.Check(ctx); err != nil {
+				checks["sink"] = err.Error()
+			}
+		}
+		checks["sink_writes"] = healthOK
+		if err := s.health.recentSinkFailure(time.Now()); err != nil {
+			checks["sink_writes"] = err.Error()
+		}
+	}
+	if s.opts.stateFile != "" {
+		checks["state_file"] = healthOK
+		if err := s.health.stateFileErr(); err != nil {
+			checks["state_file"] = err.Error()
+		}
+	}
+	if s.opts.continuous.enabled() {
+		checks["continuous_writes"] = healthOK
+		if err := s.health.continuousWriteErr(); err != nil {
+			checks["continuous_writes"] = err.Error()
+		}
+	}
+	if s.opts.export.enabled() {
+		checks["export_writes"] = healthOK
+		if err := s.health.exportWriteErr(); err != nil {
+			checks["export_writes"] = err.Error()
+		}
+	}
+	if s.opts.remoteConfig.enabled() {
+		checks["remote_config_sync"] = healthOK
+		if err := s.health.remoteConfigSyncErr(); err != nil {
+			checks["remote_config_sync"] = err.Error()
+		}
+	}
+	if len(s.opts.webhooks) > 0 {
+		checks["webhooks"] = healthOK
+		if err := s.health.webhookDeliveryErr(); err != nil {
+			checks["webhooks"] = err.Error()
+		}
+	}
+	if configured, err := s.health.tlsReloadErr(); configured {
+		checks["tls_reload"] = healthOK
+		if err != nil {
+			checks["tls_reload"] = err.Error()
+		}
+	}
+	return s.newHealthResponse(checks)
+}
+
+func aliveCheck(alive bool) string {
+	if alive {
+		return healthOK
+	}
+	return "not running"
+}
+
+// Check reports whether the sink directory exists and is writable
+func (f *FileSink) Check(ctx context.Context) error {
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return fmt.Errorf("snapshot directory is not available: %w", err)
+	}
+	tmp, err := os.CreateTemp(f.Dir, ".check-*")
+	if err != nil {
+		return fmt.Errorf("snapshot directory is not writable: %w", err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// Check reports whether the collector is reachable.
+// Any response other than a 5xx counts, as collectors need not support HEAD.
+func (h *HTTPSink) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, h.URL, nil)
+	if err != nil {
+		return err
+	}
+	if h.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.BearerToken)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("collector is not reachable: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *Service) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealth(w, r, s.Health())
+}
+
+func (s *Service) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeHealth(w, r, s.Ready(r.Context()))
+}
+
+// writeHealth writes the health response, with 503 when a check failed
+func writeHealth(w http.ResponseWriter, r *http.Request, health HealthResponse) {
+	code := http.StatusOK
+	if health.Status != healthOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeResponse(w, r, code, health)
+}
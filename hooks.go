@@ -0,0 +1,62 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrSnapshotVetoed is returned when a BeforeSnapshot hook refuses a snapshot
+var ErrSnapshotVetoed = errors.New("snapshot vetoed")
+
+// snapshotHooks holds the callbacks registered around snapshots
+type snapshotHooks struct {
+	mu     sync.RWMutex
+	before []func() error
+	after  []func(meta SnapshotMeta, data io.Reader)
+}
+
+// BeforeSnapshot registers a hook run before every snapshot, both HTTP-triggered and programmatic.
+// Returning an error vetoes the snapshot (e.g. refusing snapshots during a deploy window);
+// the snapshot then fails with an error wrapping ErrSnapshotVetoed.
+func (s *Service) BeforeSnapshot(hook func() error) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+
+	s.hooks.before = append(s.hooks.before, hook)
+}
+
+// OnSnapshot registers a hook run after every snapshot, both HTTP-triggered and programmatic.
+// Each hook receives its own reader over the snapshot data, e.g. to annotate or copy it.
+// Hooks run synchronously before the snapshot is returned to the caller.
+func (s *Service) OnSnapshot(hook func(meta SnapshotMeta, data io.Reader)) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+
+	s.hooks.after = append(s.hooks.after, hook)
+}
+
+func (s *Service) runBeforeSnapshot() error {
+	s.hooks.mu.RLock()
+	hooks := s.hooks.before
+	s.hooks.mu.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(); err != nil {
+			return fmt.Errorf("%w: %w", ErrSnapshotVetoed, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) runOnSnapshot(meta SnapshotMeta, data []byte) {
+	s.hooks.mu.RLock()
+	hooks := s.hooks.after
+	s.hooks.mu.RUnlock()
+
+	for _, hook := range hooks {
+		hook(meta, bytes.NewReader(data))
+	}
+}
@@ -0,0 +1,41 @@
+package flightrecorder
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+)
+
+// HeaderInstance is set on every response of the handlers to the instance of the service, so snapshots
+// taken through a load balancer can be traced back to the replica which took them
+const HeaderInstance = "X-FlightRecorder-Instance"
+
+// newInstanceID returns the ID of a service instance: the hostname and process ID, which identify the
+// replica, and a random suffix telling apart the services of a process and processes reusing a PID
+func newInstanceID(hostname string, pid int) string {
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	if hostname == "" {
+		hostname = "unknown"
+	}
+	return hostname + "-" + strconv.Itoa(pid) + "-" + hex.EncodeToString(suffix[:])
+}
+
+// Instance returns the ID of the service instance, advertised in the HeaderInstance header and the
+// instance field of the JSON responses
+func (s *Service) Instance() string {
+	return s.instanceID
+}
+
+// instanceRoutes sets the instance header on the responses of the routes
+func (s *Service) instanceRoutes(routes []Route) []Route {
+	for i, route := range routes {
+		h := route.Handler
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(HeaderInstance, s.instanceID)
+			h(w, r)
+		}
+	}
+	return routes
+}
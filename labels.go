@@ -0,0 +1,65 @@
+package flightrecorder
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+)
+
+// maxLabelValue bounds the length of label values
+const maxLabelValue = 256
+
+// labelKey matches valid label keys, which are usable as {label.<key>} name template variables
+var labelKey = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// WithLabels sets the initial labels of the service, e.g. {"service": "checkout", "region": "eu"}.
+// Labels are reported in the status, attached to snapshot metadata and can be changed with Update.
+func WithLabels(labels map[string]string) Option {
+	return func(o *options) {
+		o.labels = maps.Clone(labels)
+	}
+}
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !labelKey.MatchString(key) {
+			return &ConfigError{Field: "labels", Message: fmt.Sprintf("key %q should be lowercase letters, digits and underscores, starting with a letter", key)}
+		}
+		if len(value) > maxLabelValue {
+			return &ConfigError{Field: "labels", Message: fmt.Sprintf("value of %q exceeds %d bytes", key, maxLabelValue)}
+		}
+	}
+	return nil
+}
+
+// mergeLabels returns the labels with the update applied, labels updated to "" are removed
+func mergeLabels(labels, update map[string]string) map[string]string {
+	if update == nil {
+		return labels
+	}
+	merged := maps.Clone(labels)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	for key, value := range update {
+		if value == "" {
+			delete(merged, key)
+		} else {
+			merged[key] = value
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// encodeLabels encodes labels for the HeaderSnapshotLabels header, as a URL query sorted by key
+func encodeLabels(labels map[string]string) string {
+	values := make(url.Values, len(labels))
+	for key, value := range labels {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
@@ -0,0 +1,242 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLeakSamples   = 5
+	defaultLeakMinGrowth = 10
+)
+
+// GoroutineGrowthTrigger is the trigger name of snapshots captured by the leak detector
+const GoroutineGrowthTrigger = "goroutine-growth"
+
+// LeakDetector samples the goroutines alive in the flight recorder buffer every interval, grouped by the
+// function they were started with, and flags groups which grew in every one of the last samples,
+// the signature of a goroutine leak. Samples are only taken while the recorder is running.
+type LeakDetector struct {
+	Interval  time.Duration // time between two samples, 0 disables the detector
+	Samples   int           // successive samples a group must grow over to be flagged (default 5)
+	MinGrowth int           // goroutines a group must grow by over the samples to be flagged (default 10)
+	Trigger   bool          // capture a snapshot with GoroutineGrowthTrigger when a group is flagged
+	Cooldown  time.Duration // minimum time between two snapshots of the trigger
+}
+
+func (d LeakDetector) enabled() bool {
+	return d.Interval > 0
+}
+
+func (d LeakDetector) samples() int {
+	if d.Samples < 2 {
+		return defaultLeakSamples
+	}
+	return d.Samples
+}
+
+func (d LeakDetector) minGrowth() int {
+	if d.MinGrowth <= 0 {
+		return defaultLeakMinGrowth
+	}
+	return d.MinGrowth
+}
+
+// WithLeakDetector enables the goroutine leak detector
+func WithLeakDetector(d LeakDetector) Option {
+	return func(o *options) {
+		o.leakDetector = d
+	}
+}
+
+// GoroutineSample counts the goroutines alive at a point in time by the function they were started with
+type GoroutineSample struct {
+	Time       time.Time      `json:"time"`
+	Snapshot   string         `json:"snapshot,omitempty"` // ID of the stored snapshot the sample was taken from
+	Goroutines map[string]int `json:"goroutines"`
+}
+
+// GoroutineGrowth is a group of goroutines started with the same function which grew in every sample
+type GoroutineGrowth struct {
+	Function string `json:"function"`
+	Counts   []int  `json:"counts"` // goroutines of the group in each sample, oldest first
+	Growth   int    `json:"growth"` // goroutines added from the first to the last sample
+}
+
+// GoroutineGrowthReport is the result of the goroutine leak analysis
+type GoroutineGrowthReport struct {
+	// Source is "detector" for the samples of the leak detector, else "snapshots" for the stored snapshots
+	Source  string            `json:"source"`
+	Samples []time.Time       `json:"samples"`         // time of each sample compared, oldest first
+	Growing []GoroutineGrowth `json:"growing"`         // flagged groups, fastest growing first
+	Error   string            `json:"error,omitempty"` // error of the last sample of the detector
+}
+
+// DetectGoroutineGrowth compares successive samples, oldest first, and returns the groups whose
+// goroutines increased from every sample to the next by minGrowth in total, fastest growing first
+func DetectGoroutineGrowth(samples []GoroutineSample, minGrowth int) []GoroutineGrowth {
+	if len(samples) < 2 {
+		return nil
+	}
+
+	functions := make(map[string]bool)
+	for _, sample := range samples {
+		for function := range sample.Goroutines {
+			functions[function] = true
+		}
+	}
+
+	var growing []GoroutineGrowth
+	for _, function := range slices.Sorted(maps.Keys(functions)) {
+		counts := make([]int, len(samples))
+		monotonic := true
+		for i, sample := range samples {
+			counts[i] = sample.Goroutines[function]
+			if i > 0 && counts[i] <= counts[i-1] {
+				monotonic = false
+			}
+		}
+		if growth := counts[len(counts)-1] - counts[0]; monotonic && growth >= minGrowth {
+			growing = append(growing, GoroutineGrowth{Function: function, Counts: counts, Growth: growth})
+		}
+	}
+	slices.SortStableFunc(growing, func(a, b GoroutineGrowth) int {
+		return cmp.Compare(b.Growth, a.Growth)
+	})
+	return growing
+}
+
+// leakSamples holds the most recent samples of the leak detector, oldest first
+type leakSamples struct {
+	mu      sync.Mutex
+	samples []GoroutineSample
+	lastErr error
+}
+
+// add records a sample, keeping the last n, and returns the groups growing over them
+func (l *leakSamples) add(sample GoroutineSample, n, minGrowth int) []GoroutineGrowth {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.samples = append(l.samples, sample)
+	if len(l.samples) > n {
+		l.samples = slices.Delete(l.samples, 0, len(l.samples)-n)
+	}
+	l.lastErr = nil
+	if len(l.samples) < n {
+		return nil
+	}
+	return DetectGoroutineGrowth(l.samples, minGrowth)
+}
+
+func (l *leakSamples) failed(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastErr = err
+}
+
+func (l *leakSamples) report(minGrowth int) GoroutineGrowthReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	report := newGoroutineGrowthReport("detector", l.samples, minGrowth)
+	if l.lastErr != nil {
+		report.Error = l.lastErr.Error()
+	}
+	return report
+}
+
+func newGoroutineGrowthReport(source string, samples []GoroutineSample, minGrowth int) GoroutineGrowthReport {
+	report := GoroutineGrowthReport{
+		Source:  source,
+		Samples: make([]time.Time, len(samples)),
+		Growing: DetectGoroutineGrowth(samples, minGrowth),
+	}
+	for i, sample := range samples {
+		report.Samples[i] = sample.Time
+	}
+	return report
+}
+
+// runLeakDetector samples the goroutines every interval until ctx is done
+func (s *Service) runLeakDetector(ctx context.Context) {
+	defer s.health.leakDetector.Store(false)
+
+	d := s.opts.leakDetector
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			data, _, err := s.writeBuffer(ctx)
+			if errors.Is(err, ErrNotRunning) || errors.Is(err, ErrSnapshotInProgress) {
+				continue
+			}
+			var summary TraceSummary
+			if err == nil {
+				summary, err = Summarize(bytes.NewReader(data))
+			}
+			if err != nil {
+				s.leaks.failed(err)
+				continue
+			}
+
+			sample := GoroutineSample{Time: now, Goroutines: summary.GoroutinesByFunction}
+			growing := s.leaks.add(sample, d.samples(), d.minGrowth())
+			if len(growing) > 0 && d.Trigger {
+				s.fireTrigger(GoroutineGrowthTrigger, d.Cooldown)
+			}
+		}
+	}
+}
+
+// GoroutineGrowth reports the goroutine groups growing over the samples of the leak detector,
+// or over the stored snapshots, oldest first, when the detector is disabled
+func (s *Service) GoroutineGrowth() (GoroutineGrowthReport, error) {
+	d := s.opts.leakDetector
+	if d.enabled() {
+		return s.leaks.report(d.minGrowth()), nil
+	}
+
+	var samples []GoroutineSample
+	for _, meta := range s.store.list() {
+		_, summary, err := s.SnapshotSummary(meta.ID)
+		if errors.Is(err, ErrSnapshotNotFound) {
+			continue // deleted since it was listed
+		}
+		if err != nil {
+			return GoroutineGrowthReport{}, err
+		}
+		samples = append(samples, GoroutineSample{Time: meta.CreatedAt, Snapshot: meta.ID, Goroutines: summary.GoroutinesByFunction})
+	}
+	slices.SortStableFunc(samples, func(a, b GoroutineSample) int {
+		return cmp.Or(a.Time.Compare(b.Time), strings.Compare(a.Snapshot, b.Snapshot))
+	})
+	return newGoroutineGrowthReport("snapshots", samples, d.minGrowth()), nil
+}
+
+func (s *Service) handleGoroutineGrowth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := s.GoroutineGrowth()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, report)
+}
@@ -0,0 +1,153 @@
+package flightrecorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ErrReadOnly is returned for mutating requests while the service is locked read-only
+var ErrReadOnly = errors.New("flight recorder is locked read-only")
+
+// ReadOnlyLock describes the read-only lock of the service, e.g. during a change freeze
+type ReadOnlyLock struct {
+	Reason   string    `json:"reason,omitempty"`
+	LockedBy string    `json:"locked_by,omitempty"` // principal which locked the service, see WithPrincipal
+	LockedAt time.Time `json:"locked_at"`
+}
+
+// LockRequest is the optional payload of the lock request
+type LockRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// WithReadOnly creates the service locked read-only, until it is unlocked with POST /unlock or Unlock
+func WithReadOnly(reason string) Option {
+	return func(o *options) {
+		o.readOnly = &ReadOnlyLock{Reason: reason}
+	}
+}
+
+// Lock locks the service read-only: the admin endpoints other than unlock respond 423 Locked with
+// ErrReadOnly, while the read-only endpoints, e.g. snapshot downloads, stay available. The lock applies to
+// remote clients: the service methods and automatic triggers are not affected. The remote configuration of
+// WithRemoteConfig is applied once the service is unlocked. Locking a locked service updates the reason.
+func (s *Service) Lock(reason string) ReadOnlyLock {
+	return s.lock(reason, "")
+}
+
+func (s *Service) lock(reason, principal string) ReadOnlyLock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readOnly = &ReadOnlyLock{Reason: reason, LockedBy: principal, LockedAt: time.Now()}
+	if principal != "" {
+		traceEvent("locked by=%s reason=%q", principal, reason)
+	} else {
+		traceEvent("locked reason=%q", reason)
+	}
+	s.saveStateLocked()
+	s.publishStatusLocked(EventLocked)
+	return *s.readOnly
+}
+
+// Unlock lifts the read-only lock, it reports whether the service was locked
+func (s *Service) Unlock() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readOnly == nil {
+		return false
+	}
+	s.readOnly = nil
+	traceEvent("unlocked")
+	if desired := s.remoteConfigDeferred; desired != nil {
+		s.remoteConfigDeferred = nil
+		s.goBackground(func() { s.health.recordRemoteConfig(s.applyRemoteConfig(s.ctx, *desired)) })
+	}
+	s.saveStateLocked()
+	s.publishStatusLocked(EventUnlocked)
+	return true
+}
+
+// ReadOnly returns the read-only lock, nil when the service is not locked
+func (s *Service) ReadOnly() *ReadOnlyLock {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.readOnlyStatus()
+}
+
+// readOnlyStatus returns a copy of the read-only lock, s.mu must be held
+func (s *Service) readOnlyStatus() *ReadOnlyLock {
+	if s.readOnly == nil {
+		return nil
+	}
+	lock := *s.readOnly
+	return &lock
+}
+
+// lockRoutes rejects the admin routes while the service is locked, except the lock routes themselves
+func (s *Service) lockRoutes(routes []Route) []Route {
+	// Paths are registered once for all methods, so every handler rejects the admin methods of its path.
+	adminMethods := make(map[string][]string)
+	for _, route := range routes {
+		path := unversionedPath(route)
+		if route.Admin && path != "/lock" && path != "/unlock" {
+			adminMethods[route.Path] = append(adminMethods[route.Path], route.Method)
+		}
+	}
+
+	for i, route := range routes {
+		methods, ok := adminMethods[route.Path]
+		if !ok {
+			continue
+		}
+		h := route.Handler
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(methods, r.Method) {
+				h(w, r)
+				return
+			}
+			if lock := s.ReadOnly(); lock != nil {
+				err := ErrReadOnly
+				if lock.Reason != "" {
+					err = fmt.Errorf("%w: %s", ErrReadOnly, lock.Reason)
+				}
+				writeError(w, http.StatusLocked, err)
+				return
+			}
+			h(w, r)
+		}
+	}
+	return routes
+}
+
+func (s *Service) handleLock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LockRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest))
+			return
+		}
+	}
+	writeResponse(w, r, http.StatusOK, s.lock(req.Reason, s.principal(r)))
+}
+
+func (s *Service) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLockRegisterHandlers(t *testing.T) {
+	s := NewService()
+	t.Cleanup(func() { s.Close() })
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Capture("manual"); err != nil {
+		t.Fatal(err)
+	}
+	id := s.Snapshots()[0].ID
+
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	do := func(method, path, body string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, "/recorder"+path, strings.NewReader(body)))
+		return w.Code
+	}
+
+	before := s.Config()
+	if code := do(http.MethodPost, "/lock", `{"reason": "freeze"}`); code != http.StatusOK {
+		t.Fatalf("POST /lock: got %d, want 200", code)
+	}
+
+	// Admin methods sharing their path with read-only routes are registered with the read-only handler.
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/stop", ""},
+		{http.MethodPost, "/snapshots", ""},
+		{http.MethodDelete, "/snapshots", ""},
+		{http.MethodDelete, "/snapshots/" + id, ""},
+		{http.MethodPatch, "/config", `{"size": "32MB"}`},
+		{http.MethodPut, "/config", `{"period": "2s", "size": "32MB"}`},
+		{http.MethodPost, "/sessions", `{"duration": "10s"}`},
+		{http.MethodPost, "/v1/snapshots", ""},
+	} {
+		if code := do(req.method, req.path, req.body); code != http.StatusLocked {
+			t.Errorf("%s %s: got %d, want 423", req.method, req.path, code)
+		}
+	}
+	for _, path := range []string{"/status", "/config", "/snapshots", "/snapshots/" + id} {
+		if code := do(http.MethodGet, path, ""); code != http.StatusOK {
+			t.Errorf("GET %s: got %d, want 200", path, code)
+		}
+	}
+	if got := len(s.Snapshots()); got != 1 {
+		t.Errorf("got %d stored snapshots, want 1", got)
+	}
+	if after := s.Config(); after.Size != before.Size || after.Period != before.Period {
+		t.Errorf("configuration changed while locked: %+v, was %+v", after, before)
+	}
+
+	if code := do(http.MethodPost, "/unlock", ""); code != http.StatusNoContent {
+		t.Fatalf("POST /unlock: got %d, want 204", code)
+	}
+	if code := do(http.MethodPost, "/snapshots", ""); code != http.StatusCreated {
+		t.Errorf("POST /snapshots after unlock: got %d, want 201", code)
+	}
+}
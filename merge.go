@@ -0,0 +1,64 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// MergeSnapshots merges sequential snapshots of the same process, e.g. scheduled captures, into a single
+// ordered trace covering a longer timeline than the buffer allows. Traces are made of generations numbered
+// by the runtime, so overlapping snapshots are de-duplicated by generation: each generation is kept once,
+// from the snapshot holding the most of it, and the generations are written in order. The parser needs
+// consecutive generations, so the snapshots must overlap or follow each other: captures at most a recording
+// period apart. Summarize the merged trace for a combined report.
+func MergeSnapshots(snapshots ...[]byte) ([]byte, error) {
+	if len(snapshots) == 0 {
+		return nil, errors.New("no snapshots to merge")
+	}
+
+	var header []byte
+	generations := make(map[uint64][]byte)
+	for i, data := range snapshots {
+		h, batches, err := readTraceBatches(data)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %d: %w", i+1, err)
+		}
+		if header == nil {
+			header = h
+		} else if !bytes.Equal(header, h) {
+			return nil, fmt.Errorf("snapshot %d: %w: trace version %q differs from %q", i+1, ErrInvalidSnapshot,
+				bytes.TrimRight(h, "\x00"), bytes.TrimRight(header, "\x00"))
+		}
+
+		gens := make(map[uint64][]byte)
+		for _, b := range batches {
+			gens[b.gen] = append(gens[b.gen], b.raw...)
+		}
+		for gen, data := range gens {
+			if len(data) > len(generations[gen]) {
+				generations[gen] = data
+			}
+		}
+	}
+
+	gens := slices.Sorted(maps.Keys(generations))
+	for i := 1; i < len(gens); i++ {
+		if gens[i] != gens[i-1]+1 {
+			return nil, fmt.Errorf("%w: snapshots don't overlap, generations %d to %d are missing", ErrInvalidSnapshot, gens[i-1]+1, gens[i]-1)
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(header)
+	for _, gen := range gens {
+		out.Write(generations[gen])
+	}
+	// Snapshots of different processes have unrelated generations, which the parser rejects.
+	if _, err := validateTrace(out.Bytes()); err != nil {
+		return nil, fmt.Errorf("merged snapshots, which should be of the same process: %w", err)
+	}
+	return out.Bytes(), nil
+}
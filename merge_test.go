@@ -0,0 +1,49 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+func TestMergeOverlappingSnapshots(t *testing.T) {
+	recorder := trace.NewFlightRecorder()
+	if err := recorder.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Stop()
+	snapshot := func() []byte {
+		t.Helper()
+		busy(1500 * time.Millisecond)
+		var buf bytes.Buffer
+		if _, err := recorder.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	first, second := snapshot(), snapshot()
+
+	merged, err := MergeSnapshots(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := parseTrace(t, merged)
+
+	seen := make(map[string]bool, len(events))
+	for _, ev := range events {
+		key := ev.String()
+		if seen[key] {
+			t.Fatalf("event duplicated by the merge: %s", key)
+		}
+		seen[key] = true
+	}
+	firstEvents, secondEvents := parseTrace(t, first), parseTrace(t, second)
+	if len(events) >= len(firstEvents)+len(secondEvents) {
+		t.Fatalf("merged trace has %d events, want fewer than the %d+%d events of the overlapping snapshots", len(events), len(firstEvents), len(secondEvents))
+	}
+	if events[0].Time() > firstEvents[0].Time() || events[len(events)-1].Time() < secondEvents[len(secondEvents)-1].Time() {
+		t.Fatal("merged trace doesn't cover both snapshots")
+	}
+}
@@ -0,0 +1,166 @@
+package flightrecorder
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// WithOptionsHandler sets the handler of OPTIONS requests which are not CORS preflight requests, called with
+// the Allow header set to the methods of the endpoint (default responds 204 No Content)
+func WithOptionsHandler(h http.HandlerFunc) Option {
+	return func(o *options) {
+		o.optionsHandler = h
+	}
+}
+
+// handleOptions answers OPTIONS requests with the Allow header set by methodRoutes
+func handleOptions(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// nativeHeadPaths are the paths whose GET handlers answer HEAD requests themselves
+var nativeHeadPaths = []string{"/snapshot", "/snapshots/{id}"}
+
+// methodRoutes answers HEAD requests on GET endpoints and OPTIONS requests with the Allow header, which is
+// also set on the responses rejecting the methods an endpoint doesn't support. HEAD routes and OPTIONS routes
+// are added, so adapters registering routes by method serve them.
+func (s *Service) methodRoutes(routes []Route) []Route {
+	options := s.opts.optionsHandler
+	if options == nil {
+		options = handleOptions
+	}
+
+	type key struct {
+		path  string
+		admin bool
+	}
+	// Paths are registered once for all methods, so every handler answers the methods of its path.
+	pathMethods := make(map[string][]string)
+	keyMethods := make(map[key][]string)
+	var keys []key
+	gets := make(map[string]Route)
+	for _, route := range routes {
+		k := key{route.Path, route.Admin}
+		if _, ok := keyMethods[k]; !ok {
+			keys = append(keys, k)
+		}
+		pathMethods[route.Path] = append(pathMethods[route.Path], route.Method)
+		keyMethods[k] = append(keyMethods[k], route.Method)
+		if route.Method == http.MethodGet {
+			gets[route.Path] = route
+		}
+	}
+	allow := func(methods []string) string {
+		if slices.Contains(methods, http.MethodGet) {
+			methods = append(slices.Clip(methods), http.MethodHead)
+		}
+		return strings.Join(append(slices.Clip(methods), http.MethodOptions), ", ")
+	}
+
+	wrap := func(path string, methods []string, h http.HandlerFunc) http.HandlerFunc {
+		allowed := allow(methods)
+		get, hasGet := gets[path]
+		var head http.HandlerFunc
+		if hasGet {
+			head = s.headHandler(get)
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodOptions:
+				w.Header().Set("Allow", allowed)
+				options(w, r)
+			case r.Method == http.MethodHead && hasGet:
+				head(w, r)
+			default:
+				// The handlers reject the methods they don't support, or respond 404 to disabled ones.
+				if !slices.Contains(methods, r.Method) {
+					w.Header().Set("Allow", allowed)
+				}
+				h(w, r)
+			}
+		}
+	}
+
+	for i, route := range routes {
+		routes[i].Handler = wrap(route.Path, pathMethods[route.Path], route.Handler)
+	}
+	for _, k := range keys {
+		methods := keyMethods[k]
+		h := wrap(k.path, methods, handleMethodNotAllowed)
+		if slices.Contains(methods, http.MethodGet) {
+			routes = append(routes, Route{http.MethodHead, k.path, k.admin, h})
+		}
+		routes = append(routes, Route{http.MethodOptions, k.path, k.admin, h})
+	}
+	return routes
+}
+
+// headHandler answers HEAD requests on a GET route. JSON and text responses are rendered and discarded,
+// so the headers and Content-Length are those of GET; downloads and streams answer their headers only,
+// without taking a snapshot or recording a download.
+func (s *Service) headHandler(get Route) http.HandlerFunc {
+	path := unversionedPath(get)
+	if slices.Contains(nativeHeadPaths, path) {
+		return get.Handler
+	}
+	doc := routeDocs[http.MethodGet+" "+path]
+	if doc.contentType == "" || doc.contentType == OpenMetricsContentType {
+		return func(w http.ResponseWriter, r *http.Request) {
+			hw := &headWriter{ResponseWriter: w}
+			r = r.Clone(r.Context())
+			r.Method = http.MethodGet
+			get.Handler(hw, r)
+			hw.flush()
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if id := r.PathValue("id"); id != "" {
+			if _, ok := s.store.get(id); !ok {
+				writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id))
+				return
+			}
+		}
+		w.Header().Set("Content-Type", doc.contentType)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// headWriter discards the body of a GET response answering a HEAD request, counting it for the Content-Length
+type headWriter struct {
+	http.ResponseWriter
+	code int
+	n    int
+}
+
+func (w *headWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.n += len(p)
+	return len(p), nil
+}
+
+// Unwrap lets the handlers find the response style of the route
+func (w *headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush writes the status and headers of the GET response with the length of its body
+func (w *headWriter) flush() {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if w.code != http.StatusNoContent && w.code != http.StatusNotModified {
+		w.Header().Set("Content-Length", strconv.Itoa(w.n))
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
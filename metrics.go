@@ -0,0 +1,123 @@
+package flightrecorder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenMetricsContentType is the content type of the snapshot metrics endpoint
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// snapshotAnalysis caches the summary of a stored snapshot, which never changes
+type snapshotAnalysis struct {
+	once    sync.Once
+	summary TraceSummary
+	err     error
+}
+
+// SnapshotSummary parses a stored snapshot by ID and summarizes it.
+// The summary is computed once per snapshot.
+func (s *Service) SnapshotSummary(id string) (SnapshotMeta, TraceSummary, error) {
+	snap, ok := s.store.get(id)
+	if !ok {
+		return SnapshotMeta{}, TraceSummary{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+	snap.analysis.once.Do(func() {
+		data, err := snap.bytes()
+		if err == nil {
+			data, err = s.decryptSnapshot(snap.meta, data)
+		}
+		if err != nil {
+			snap.analysis.err = err
+			return
+		}
+		snap.analysis.summary, snap.analysis.err = Summarize(bytes.NewReader(data))
+	})
+	return snap.meta, snap.analysis.summary, snap.analysis.err
+}
+
+// WriteOpenMetrics writes the summary of a snapshot as OpenMetrics text: the GC pause and scheduler
+// latency histograms, goroutines by state and the trace duration and counts, labelled with the snapshot ID and trigger
+func WriteOpenMetrics(w io.Writer, meta SnapshotMeta, summary TraceSummary) error {
+	bw := bufio.NewWriter(w)
+	labels := fmt.Sprintf(`snapshot="%s",trigger="%s"`, escapeLabel(meta.ID), escapeLabel(meta.Trigger))
+
+	gauge := func(name, unit, help string, value float64) {
+		writeMetricHeader(bw, name, "gauge", unit, help)
+		fmt.Fprintf(bw, "%s{%s} %s\n", name, labels, formatFloat(value))
+	}
+	histogram := func(name, help string, stats DurationStats) {
+		writeMetricHeader(bw, name, "histogram", "seconds", help)
+		for i, bound := range DurationBuckets {
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound.Seconds()), stats.Buckets[i])
+		}
+		fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, stats.Count)
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", name, labels, stats.Count)
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", name, labels, formatFloat(stats.Total.Seconds()))
+	}
+
+	gauge("flightrecorder_snapshot_timestamp_seconds", "seconds", "Time the snapshot was taken.", float64(meta.CreatedAt.UnixNano())/float64(time.Second))
+	gauge("flightrecorder_snapshot_duration_seconds", "seconds", "Time covered by the snapshot.", summary.Duration.Seconds())
+	gauge("flightrecorder_snapshot_events", "", "Number of trace events in the snapshot.", float64(summary.Events))
+	gauge("flightrecorder_snapshot_goroutines_created", "", "Goroutines created during the snapshot.", float64(summary.GoroutinesCreated))
+	gauge("flightrecorder_snapshot_gc_cycles", "", "GC cycles started during the snapshot.", float64(summary.GCCycles))
+
+	writeMetricHeader(bw, "flightrecorder_snapshot_goroutines", "gauge", "", "Goroutines by state at the end of the snapshot.")
+	for _, state := range slices.Sorted(maps.Keys(summary.GoroutineStates)) {
+		fmt.Fprintf(bw, "flightrecorder_snapshot_goroutines{%s,state=\"%s\"} %d\n", labels, escapeLabel(strings.ToLower(state)), summary.GoroutineStates[state])
+	}
+
+	histogram("flightrecorder_snapshot_gc_pause_seconds", "GC stop-the-world pauses during the snapshot.", summary.GCPauses)
+	histogram("flightrecorder_snapshot_sched_latency_seconds", "Time goroutines spent runnable before running.", summary.SchedLatency)
+
+	fmt.Fprintln(bw, "# EOF")
+	return bw.Flush()
+}
+
+func writeMetricHeader(w io.Writer, name, typ, unit, help string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	if unit != "" {
+		fmt.Fprintf(w, "# UNIT %s %s\n", name, unit)
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func (s *Service) handleSnapshotMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	meta, summary, err := s.SnapshotSummary(r.PathValue("id"))
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrSnapshotNotFound) {
+			code = http.StatusNotFound
+		}
+		writeError(w, code, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+	WriteOpenMetrics(w, meta, summary)
+}
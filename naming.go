@@ -0,0 +1,61 @@
+package flightrecorder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultNameTemplate is the default template used to name snapshots
+const DefaultNameTemplate = "flightrecorder-{hostname}-{timestamp}-{seq}.trace"
+
+var templateVar = regexp.MustCompile(`\{([a-z0-9_.]+)\}`)
+
+// nameSanitizer keeps variable values from introducing path separators
+var nameSanitizer = strings.NewReplacer("/", "_", "\\", "_", " ", "_")
+
+// renderName renders the snapshot name template. Supported variables are
+// {hostname}, {pid}, {timestamp} (UTC, 20060102T150405Z), {unix}, {date}
+// (UTC, 2006-01-02), {trigger}, {seq}, {id} and {label.<key>} ("unknown" when the label is not set).
+// Unknown variables are kept as-is.
+func (s *Service) renderName(meta SnapshotMeta, seq uint64) string {
+	created := meta.CreatedAt.UTC()
+	return templateVar.ReplaceAllStringFunc(s.opts.nameTemplate, func(match string) string {
+		var value string
+		switch match[1 : len(match)-1] {
+		case "hostname":
+			value = s.hostname
+		case "pid":
+			value = strconv.Itoa(s.pid)
+		case "timestamp":
+			value = created.Format("20060102T150405Z")
+		case "unix":
+			value = strconv.FormatInt(created.Unix(), 10)
+		case "date":
+			value = created.Format("2006-01-02")
+		case "trigger":
+			value = meta.Trigger
+		case "seq":
+			value = fmt.Sprintf("%04d", seq)
+		case "id":
+			value = meta.ID
+		default:
+			key, ok := strings.CutPrefix(match[1:len(match)-1], "label.")
+			if !ok {
+				return match
+			}
+			value = meta.Labels[key]
+			if value == "" {
+				value = "unknown"
+			}
+		}
+		return nameSanitizer.Replace(value)
+	})
+}
+
+// contentDisposition returns an attachment Content-Disposition header for the snapshot name
+func contentDisposition(name string) string {
+	filename := strings.ReplaceAll(name, "/", "_")
+	return fmt.Sprintf("attachment; filename=%q", filename)
+}
@@ -0,0 +1,189 @@
+package flightrecorder
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PagerDutyEventsURL is the endpoint of the PagerDuty Events API v2
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// notifierEvents are the events notifiers post without Events: triggers which captured a snapshot
+var notifierEvents = []EventType{EventTriggerFired}
+
+// Slack posts Block Kit messages to a Slack incoming webhook, with the summary of the
+// captured snapshot and a link to download it
+type Slack struct {
+	WebhookURL string      // URL of the incoming webhook
+	BaseURL    string      // where the recorder endpoints are served, for download links
+	Events     []EventType // events posted (default trigger_fired)
+}
+
+// WithSlack posts service events to Slack
+func WithSlack(s Slack) Option {
+	return WithWebhook(s.Webhook())
+}
+
+// Webhook returns the webhook posting the messages
+func (s Slack) Webhook() Webhook {
+	return Webhook{
+		URL:       s.WebhookURL,
+		Events:    orNotifierEvents(s.Events),
+		BaseURL:   s.BaseURL,
+		Encode:    encodeSlack,
+		Summarize: true,
+	}
+}
+
+// PagerDuty sends events to the PagerDuty Events API v2. Events of a trigger share a dedup key,
+// so a trigger firing repeatedly updates one alert rather than opening an incident every time.
+type PagerDuty struct {
+	RoutingKey string      // integration key of the PagerDuty service
+	Severity   string      // critical, error, warning (default) or info
+	BaseURL    string      // where the recorder endpoints are served, for download links
+	Events     []EventType // events sent (default trigger_fired)
+	URL        string      // Events API endpoint (default PagerDutyEventsURL)
+}
+
+// WithPagerDuty sends service events to PagerDuty
+func WithPagerDuty(pd PagerDuty) Option {
+	return WithWebhook(pd.Webhook())
+}
+
+// Webhook returns the webhook sending the events
+func (pd PagerDuty) Webhook() Webhook {
+	url := pd.URL
+	if url == "" {
+		url = PagerDutyEventsURL
+	}
+	return Webhook{
+		URL:     url,
+		Events:  orNotifierEvents(pd.Events),
+		BaseURL: pd.BaseURL,
+		Encode:  pd.encode,
+	}
+}
+
+// orNotifierEvents returns events, else the default events of notifiers
+func orNotifierEvents(events []EventType) []EventType {
+	if len(events) == 0 {
+		return notifierEvents
+	}
+	return events
+}
+
+// notificationTitle describes the event in one line
+func notificationTitle(p WebhookPayload) string {
+	switch p.Type {
+	case EventTriggerFired:
+		if p.Error != "" {
+			return fmt.Sprintf("Flight recorder trigger %s fired on %s, capture failed", p.Trigger, p.Hostname)
+		}
+		return fmt.Sprintf("Flight recorder trigger %s captured a snapshot on %s", p.Trigger, p.Hostname)
+	case EventSnapshot, EventSnapshotStored:
+		return fmt.Sprintf("Flight recorder snapshot taken on %s", p.Hostname)
+	default:
+		return fmt.Sprintf("Flight recorder %s on %s", p.Type, p.Hostname)
+	}
+}
+
+// notificationDetails returns the details of the event as key value pairs
+func notificationDetails(p WebhookPayload) [][2]string {
+	var details [][2]string
+	if p.Trigger != "" {
+		details = append(details, [2]string{"Trigger", p.Trigger})
+	}
+	details = append(details, [2]string{"Host", p.Hostname})
+	if p.Snapshot != nil {
+		details = append(details,
+			[2]string{"Snapshot", p.Snapshot.ID},
+			[2]string{"Size", fmt.Sprintf("%d bytes", p.Snapshot.Size)})
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.Labels)) {
+		details = append(details, [2]string{name, p.Labels[name]})
+	}
+	if p.Error != "" {
+		details = append(details, [2]string{"Error", p.Error})
+	}
+	return details
+}
+
+// summaryText describes a snapshot summary in a few lines
+func summaryText(s TraceSummary) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v of trace, %d goroutines (%d created), %d GC cycles\n",
+		s.Duration.Round(time.Millisecond), s.Goroutines, s.GoroutinesCreated, s.GCCycles)
+	fmt.Fprintf(&b, "GC pauses max %v, scheduler latency p99 %v", s.GCPauses.Max, s.SchedLatency.P99)
+	if len(s.TopFunctions) > 0 {
+		f := s.TopFunctions[0]
+		fmt.Fprintf(&b, "\nTop function %s running %v", f.Function, f.Running.Round(time.Microsecond))
+	}
+	return b.String()
+}
+
+// encodeSlack encodes the payload as a Slack Block Kit message
+func encodeSlack(p WebhookPayload) ([]byte, error) {
+	title := notificationTitle(p)
+	text := func(typ, s string) map[string]any { return map[string]any{"type": typ, "text": s} }
+
+	var fields []any
+	for _, d := range notificationDetails(p) {
+		fields = append(fields, text("mrkdwn", "*"+d[0]+"*\n"+d[1]))
+	}
+	// Slack limits sections to 10 fields.
+	if len(fields) > 10 {
+		fields = fields[:10]
+	}
+	blocks := []any{
+		map[string]any{"type": "header", "text": text("plain_text", title)},
+		map[string]any{"type": "section", "fields": fields},
+	}
+	if p.Summary != nil {
+		blocks = append(blocks, map[string]any{"type": "section", "text": text("mrkdwn", "```"+summaryText(*p.Summary)+"```")})
+	}
+	if p.DownloadURL != "" {
+		blocks = append(blocks, map[string]any{"type": "actions", "elements": []any{map[string]any{
+			"type": "button",
+			"text": text("plain_text", "Download snapshot"),
+			"url":  p.DownloadURL,
+		}}})
+	}
+	return json.Marshal(map[string]any{"text": title, "blocks": blocks})
+}
+
+// encode encodes the payload as a PagerDuty trigger event
+func (pd PagerDuty) encode(p WebhookPayload) ([]byte, error) {
+	severity := pd.Severity
+	if severity == "" {
+		severity = "warning"
+	}
+	details := make(map[string]string)
+	for _, d := range notificationDetails(p) {
+		details[strings.ToLower(d[0])] = d[1]
+	}
+
+	trigger := cmp.Or(p.Trigger, string(p.Type))
+	event := map[string]any{
+		"routing_key":  pd.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    "flightrecorder/" + trigger,
+		"payload": map[string]any{
+			"summary":        notificationTitle(p),
+			"source":         p.Hostname,
+			"severity":       severity,
+			"timestamp":      p.Time.UTC().Format(time.RFC3339),
+			"component":      "flight-recorder",
+			"class":          trigger,
+			"custom_details": details,
+		},
+	}
+	if p.DownloadURL != "" {
+		event["links"] = []any{map[string]any{"href": p.DownloadURL, "text": "Download snapshot"}}
+	}
+	return json.Marshal(event)
+}
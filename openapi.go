@@ -0,0 +1,359 @@
+package flightrecorder
+
+import (
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIVersion is the version segment of the HTTP API paths, e.g. /recorder/v1/status.
+// The unversioned paths are kept as aliases of the current version.
+const APIVersion = "v1"
+
+// versionRoutes returns the routes under the API version, followed by their unversioned aliases
+func versionRoutes(routes []Route) []Route {
+	versioned := make([]Route, 0, 2*len(routes))
+	for _, route := range routes {
+		route.Path = "/" + APIVersion + route.Path
+		versioned = append(versioned, route)
+	}
+	return append(versioned, routes...)
+}
+
+// unversionedPath returns the path of a route without the API version prefix added by versionRoutes
+func unversionedPath(route Route) string {
+	return strings.TrimPrefix(route.Path, "/"+APIVersion)
+}
+
+// routeDoc documents a route in the OpenAPI document
+type routeDoc struct {
+	summary         string
+	query           map[string]string // query parameters and their descriptions
+	request         any               // JSON request body, nil when there is none
+	optionalRequest bool              // whether the request body may be omitted
+	response        any               // JSON response body, nil when there is none or it has contentType
+	contentType     string            // content type of non-JSON responses
+	status          int               // status of successful responses (default 200)
+	successor       string            // endpoint superseding the deprecated route, e.g. "PATCH /config"
+}
+
+// routeDocs documents the routes of the HTTP API, keyed by method and path
+var routeDocs = map[string]routeDoc{
+	"GET /status": {
+		summary:  "Get the status of the flight recorder",
+		query:    map[string]string{"wait_for_change": "long-poll until the status changes, a duration up to 5m"},
+		response: StatusResponse{},
+	},
+	"POST /start": {
+		summary:         "Start the flight recorder, for a session of the duration if set",
+		request:         StartRequest{},
+		optionalRequest: true,
+		response:        ControlResponse{},
+	},
+	"POST /stop":  {summary: "Stop the flight recorder", response: ControlResponse{}},
+	"POST /clear": {summary: "Discard the buffer of the flight recorder"},
+	"POST /lock": {
+		summary:         "Lock the service read-only, rejecting the other admin endpoints until unlocked",
+		request:         LockRequest{},
+		optionalRequest: true,
+		response:        ReadOnlyLock{},
+	},
+	"POST /unlock": {summary: "Lift the read-only lock", status: http.StatusNoContent},
+	"GET /snapshot": {
+		summary: "Download a snapshot of the buffer",
+		query: map[string]string{
+			"wait": "wait for the recorded window to cover this duration first",
+			"last": "keep only the trailing window of the trace, e.g. 30s, in whole generations",
+		},
+		contentType: "application/octet-stream",
+	},
+	"POST /update": {
+		summary:   "Update the configuration of the flight recorder, superseded by PATCH /config",
+		query:     map[string]string{"dry_run": "validate and return the resolved configuration without applying it"},
+		request:   UpdateRequest{},
+		response:  ResolvedConfig{},
+		successor: "PATCH /config",
+	},
+	"GET /config":         {summary: "Get the configuration of the flight recorder", response: ResolvedConfig{}},
+	"GET /config/history": {summary: "List the recent configuration changes, oldest first", response: []ConfigChange{}},
+	"PATCH /config": {
+		summary:  "Update the fields of the configuration set in the request",
+		query:    map[string]string{"dry_run": "validate and return the resolved configuration without applying it"},
+		request:  UpdateRequest{},
+		response: ResolvedConfig{},
+	},
+	"PUT /config": {
+		summary:  "Replace the configuration, omitted thresholds are disabled and labels are replaced",
+		query:    map[string]string{"dry_run": "validate and return the resolved configuration without applying it"},
+		request:  UpdateRequest{},
+		response: ResolvedConfig{},
+	},
+	"POST /log":         {summary: "Record a log message in the trace", request: LogRequest{}, status: http.StatusNoContent},
+	"POST /mark":        {summary: "Record a marker in the trace", request: MarkRequest{}, response: Marker{}},
+	"GET /bundle":       {summary: "Download a diagnostic bundle", contentType: "application/gzip"},
+	"GET /snapshots":    {summary: "List stored snapshots", response: []SnapshotMeta{}},
+	"POST /snapshots":   {summary: "Capture a snapshot into the store", response: SnapshotMeta{}, status: http.StatusCreated},
+	"DELETE /snapshots": {summary: "Delete all stored snapshots", response: PurgeResponse{}},
+	"GET /snapshots/{id}": {
+		summary:     "Download a stored snapshot",
+		contentType: "application/octet-stream",
+	},
+	"DELETE /snapshots/{id}": {summary: "Delete a stored snapshot", status: http.StatusNoContent},
+	"GET /snapshots/{id}/url": {
+		summary:  "Get a time-limited download URL of a stored snapshot, signed by the sink",
+		query:    map[string]string{"expires": "how long the URL is valid, a duration up to 168h (default 15m)"},
+		response: SignedURL{},
+	},
+	"GET /snapshots/{id}/metrics": {
+		summary:     "Get metrics summarizing a stored snapshot",
+		contentType: OpenMetricsContentType,
+	},
+	"GET /snapshots/{id}/pprof": {
+		summary:     "Get an approximate CPU profile of a stored snapshot in pprof format",
+		contentType: "application/octet-stream",
+	},
+	"GET /snapshots/{id}/flame": {
+		summary: "Get a flame graph of a stored snapshot as SVG or d3-flamegraph JSON",
+		query: map[string]string{
+			"metric": "running (default) or blocked time of the goroutines",
+			"format": "svg (default) or json",
+		},
+		contentType: "image/svg+xml",
+	},
+	"GET /analysis/goroutine-growth": {
+		summary:  "Get the goroutine groups growing across successive samples or stored snapshots",
+		response: GoroutineGrowthReport{},
+	},
+	"GET /sessions": {summary: "List the recent recording sessions, oldest first", response: []Session{}},
+	"POST /sessions": {
+		summary:  "Start the flight recorder in a named session",
+		request:  SessionRequest{},
+		response: Session{},
+		status:   http.StatusCreated,
+	},
+	"GET /events": {summary: "Stream state changes as Server-Sent Events", contentType: "text/event-stream"},
+	"GET /overhead": {
+		summary:  "Measure the overhead of the stopped flight recorder",
+		response: OverheadReport{},
+	},
+	"POST /overhead": {
+		summary:  "Measure the overhead of the flight recorder, restarting a running recorder and discarding its buffer",
+		response: OverheadReport{},
+	},
+	"GET /metrics":      {summary: "Get the recorder gauges and handler metrics", contentType: OpenMetricsContentType},
+	"GET /healthz":      {summary: "Check the background goroutines are alive", response: HealthResponse{}},
+	"GET /readyz":       {summary: "Check the service is ready to take snapshots", response: HealthResponse{}},
+	"GET /openapi.json": {summary: "Get the OpenAPI document of the HTTP API", response: map[string]any{}},
+	"GET /grafana/":     {summary: "Test the connection of the Grafana JSON data source"},
+	"POST /grafana/metrics": {
+		summary:         "List the metrics of the Grafana JSON data source",
+		request:         map[string]any{},
+		optionalRequest: true,
+		response:        []GrafanaMetric{},
+	},
+	"POST /grafana/query": {
+		summary:  "Query time series and tables of the stored snapshots and fired triggers for Grafana",
+		request:  GrafanaQueryRequest{},
+		response: []GrafanaQueryResult{},
+	},
+	"POST /grafana/annotations": {
+		summary:  "Get recent events of the recorder as Grafana annotations",
+		request:  GrafanaAnnotationRequest{},
+		response: []GrafanaAnnotation{},
+	},
+}
+
+// OpenAPI returns the OpenAPI 3 document of the HTTP API registered under the prefix
+func (s *Service) OpenAPI(prefix string) map[string]any {
+	schemas := make(map[string]any)
+	paths := make(map[string]any)
+	for _, route := range s.routes() {
+		if s.disabled(route) {
+			continue
+		}
+		doc := routeDocs[route.Method+" "+route.Path]
+		path := "/" + APIVersion + route.Path
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = make(map[string]any)
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation(route, doc, schemas)
+	}
+
+	schemaOf(reflect.TypeFor[ErrorResponse](), schemas)
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Flight Recorder API",
+			"version": APIVersion,
+		},
+		"servers":    []any{map[string]any{"url": prefix}},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+}
+
+func operation(route Route, doc routeDoc, schemas map[string]any) map[string]any {
+	var params []any
+	for _, name := range route.Params() {
+		params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+	}
+	for _, name := range slices.Sorted(maps.Keys(doc.query)) {
+		params = append(params, map[string]any{"name": name, "in": "query", "description": doc.query[name], "schema": map[string]any{"type": "string"}})
+	}
+	if doc.response != nil {
+		params = append(params, map[string]any{"name": "format", "in": "query", "description": "response format, also negotiated with the Accept header",
+			"schema": map[string]any{"type": "string", "enum": []string{string(FormatJSON), string(FormatYAML), string(FormatText)}}})
+	}
+
+	status := doc.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	switch {
+	case doc.response != nil:
+		success["content"] = jsonContent(schemaOf(reflect.TypeOf(doc.response), schemas))
+	case doc.contentType != "":
+		schema := map[string]any{"type": "string"}
+		if !strings.Contains(doc.contentType, "text") {
+			schema["format"] = "binary"
+		}
+		success["content"] = map[string]any{doc.contentType: map[string]any{"schema": schema}}
+	}
+
+	op := map[string]any{
+		"operationId": operationID(route),
+		"summary":     doc.summary,
+		"responses": map[string]any{
+			strconv.Itoa(status): success,
+			"default": map[string]any{
+				"description": "Error",
+				"content":     jsonContent(map[string]any{"$ref": "#/components/schemas/ErrorResponse"}),
+			},
+		},
+	}
+	if route.Admin {
+		op["tags"] = []string{"admin"}
+	} else {
+		op["tags"] = []string{"read"}
+	}
+	if doc.successor != "" {
+		op["deprecated"] = true
+	}
+	if params != nil {
+		op["parameters"] = params
+	}
+	if doc.request != nil {
+		op["requestBody"] = map[string]any{"required": !doc.optionalRequest, "content": jsonContent(schemaOf(reflect.TypeOf(doc.request), schemas))}
+	}
+	return op
+}
+
+func jsonContent(schema any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// operationID names an operation from its method and path, e.g. getSnapshotsIdMetrics
+func operationID(route Route) string {
+	id := strings.ToLower(route.Method)
+	for segment := range strings.SplitSeq(route.Path, "/") {
+		segment = strings.Trim(segment, "{}")
+		for word := range strings.FieldsFuncSeq(segment, func(r rune) bool { return r == '.' || r == '_' }) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+// schemaOf returns the JSON schema of a type, adding named structs to schemas and referencing them
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case reflect.TypeFor[time.Duration](), reflect.TypeFor[Duration]():
+		return map[string]any{"type": "string", "description": "Go duration", "example": "1s"}
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[ByteSize]():
+		return map[string]any{"type": "string", "description": "bytes or a memory unit", "example": "64MB"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]any{"type": "object"}
+		}
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok {
+			return ref
+		}
+		// Reserve the name first, so recursive types reference themselves.
+		schemas[t.Name()] = nil
+		schemas[t.Name()] = structSchema(t, schemas)
+		return ref
+	}
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = schemaOf(field.Type, schemas)
+		optional := strings.Contains(options, "omitempty") || strings.Contains(options, "omitzero")
+		if !optional && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (s *Service) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Servers are relative to the prefix the handlers are registered under.
+	prefix := strings.TrimSuffix(r.URL.Path, "/openapi.json")
+	prefix = strings.TrimSuffix(prefix, "/"+APIVersion)
+	writeCached(w, r, s.OpenAPI(prefix))
+}
@@ -0,0 +1,148 @@
+package flightrecorder
+
+import (
+	"crypto/ed25519"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// Option configures a Service
+type Option func(*options)
+
+type options struct {
+	retention      RetentionPolicy
+	quota          StoreQuota
+	sink           Sink
+	sinkRetry      SinkRetry
+	webhooks       []Webhook
+	triggers       []namedTrigger
+	notifiers      []Notifier
+	encryptionKey  KeyFunc
+	filters        []SnapshotFilter
+	nameTemplate   string
+	runtimeTrigger RuntimeTrigger
+
+	triggerCooldown time.Duration
+	triggerBudget   int
+
+	validateSnapshots bool
+	idempotentControl bool
+	snapshotRetry     time.Duration
+	snapshotTimeout   time.Duration
+	bufferSampling    time.Duration
+
+	stateFile       string
+	resumeRecording bool
+
+	listen listenOptions
+
+	labels map[string]string
+
+	continuous   ContinuousRecording
+	export       ContinuousExport
+	crashDir     string
+	leakDetector LeakDetector
+	remoteConfig RemoteConfig
+
+	shutdownSnapshot bool
+	shutdownSink     Sink
+
+	cors *CORSConfig
+
+	allowedCIDRs []netip.Prefix
+
+	principal PrincipalFunc
+
+	spill *SnapshotSpill
+
+	disabledEndpoints []string
+
+	readOnly *ReadOnlyLock
+
+	logger *slog.Logger
+
+	optionsHandler http.HandlerFunc
+
+	gate     RecorderGate
+	gateMode GateMode
+
+	signingKey ed25519.PrivateKey
+
+	principalQuota PrincipalQuota
+
+	style responseStyle
+}
+
+func defaultOptions() options {
+	return options{
+		nameTemplate:    DefaultNameTemplate,
+		snapshotTimeout: defaultSnapshotTimeout,
+	}
+}
+
+// WithRetention sets the retention policy enforced on the snapshot store.
+func WithRetention(policy RetentionPolicy) Option {
+	return func(o *options) {
+		o.retention = policy
+	}
+}
+
+// WithSink sets the sink captured snapshots are written to.
+func WithSink(sink Sink) Option {
+	return func(o *options) {
+		o.sink = sink
+	}
+}
+
+// WithNameTemplate sets the template used to name snapshots, e.g.
+// "{hostname}/{date}/{trigger}-{seq}.trace". Names are used as sink file
+// names or object keys and as the download filename of the HTTP endpoints.
+func WithNameTemplate(template string) Option {
+	return func(o *options) {
+		o.nameTemplate = template
+	}
+}
+
+// WithTriggerCooldown sets the minimum time between two snapshots taken by any trigger.
+func WithTriggerCooldown(d time.Duration) Option {
+	return func(o *options) {
+		o.triggerCooldown = d
+	}
+}
+
+// WithTriggerBudget sets the maximum number of snapshots triggers may take per hour.
+// The remaining budget is reported in the status.
+func WithTriggerBudget(perHour int) Option {
+	return func(o *options) {
+		o.triggerBudget = perHour
+	}
+}
+
+// WithIdempotentControl makes POST /start on a running recorder and POST /stop on a stopped
+// recorder succeed with {"already_running": true} and {"already_stopped": true} instead of failing,
+// so automation can reconcile the desired state without special-casing errors.
+func WithIdempotentControl(enabled bool) Option {
+	return func(o *options) {
+		o.idempotentControl = enabled
+	}
+}
+
+// WithSnapshotRetry retries snapshots which fail because another snapshot is in progress
+// (trace.ErrSnapshotActive) with backoff for up to max, instead of failing with ErrSnapshotInProgress
+// right away, so triggers and schedulers don't fail on transient contention.
+func WithSnapshotRetry(max time.Duration) Option {
+	return func(o *options) {
+		o.snapshotRetry = max
+	}
+}
+
+// WithSnapshotTimeout bounds the snapshots taken by GET /snapshot and POST /snapshots (default 30s), so stuck
+// handlers don't pile up: the write of the buffer and its retries are aborted with ErrSnapshotTimeout and the
+// handlers respond 503 with a Retry-After header. 0 disables the timeout.
+func WithSnapshotTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.snapshotTimeout = timeout
+	}
+}
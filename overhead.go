@@ -0,0 +1,148 @@
+package flightrecorder
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	overheadRounds      = 5
+	overheadRoundTarget = 20 * time.Millisecond
+)
+
+// OverheadReport is the result of an overhead measurement
+type OverheadReport struct {
+	Iterations      int     `json:"iterations"`       // workload iterations per round
+	Rounds          int     `json:"rounds"`           // rounds measured with the recorder off and on
+	Off             string  `json:"off"`              // median round duration with the recorder off
+	On              string  `json:"on"`               // median round duration with the recorder on
+	OverheadPercent float64 `json:"overhead_percent"` // estimated CPU overhead of the recorder
+}
+
+// MeasureOverhead runs a short calibrated workload of goroutine handoffs and allocations,
+// alternating with the flight recorder off and on, and estimates the recorder's overhead.
+// The workload is dominated by scheduling events, which the tracer records in detail,
+// so the estimate is an upper bound for most services.
+// Measuring a running recorder stops it, which discards its buffer, so discard must be true;
+// the recorder is restarted afterwards with the configured period and size, like a restart applying
+// a configuration update. Other calls on the service wait for the measurement.
+func (s *Service) MeasureOverhead(discard bool) (OverheadReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return OverheadReport{}, ErrClosed
+	}
+	running := s.recorder.Enabled()
+	if running && !discard {
+		return OverheadReport{}, fmt.Errorf("%w: measuring the overhead discards its buffer", ErrAlreadyRunning)
+	}
+	if running {
+		if err := s.recorder.Stop(); err != nil {
+			return OverheadReport{}, fmt.Errorf("failed to stop flight recorder: %w", err)
+		}
+	}
+
+	report, err := s.measureOverhead()
+
+	if running {
+		if err := s.startRecorderLocked(); err != nil {
+			s.publishStatusLocked(EventStopped)
+			return report, fmt.Errorf("failed to restart flight recorder: %w", err)
+		}
+		s.publishStatusLocked(EventCleared)
+	}
+	return report, err
+}
+
+// measureOverhead measures the workload with the recorder stopped and started, s.mu must be held
+func (s *Service) measureOverhead() (OverheadReport, error) {
+	// Calibrate the iterations so a round with the recorder off takes about overheadRoundTarget.
+	n := 1000
+	for {
+		if elapsed := timeWorkload(n); elapsed >= overheadRoundTarget || n >= 1<<24 {
+			break
+		}
+		n *= 2
+	}
+
+	off := make([]time.Duration, 0, overheadRounds)
+	on := make([]time.Duration, 0, overheadRounds)
+	for range overheadRounds {
+		off = append(off, timeWorkload(n))
+
+		s.recorder.SetPeriod(s.period)
+		s.recorder.SetSize(int(s.size))
+		if err := s.recorder.Start(); err != nil {
+			return OverheadReport{}, fmt.Errorf("failed to start flight recorder: %w", err)
+		}
+		on = append(on, timeWorkload(n))
+		if err := s.recorder.Stop(); err != nil {
+			return OverheadReport{}, fmt.Errorf("failed to stop flight recorder: %w", err)
+		}
+	}
+
+	slices.Sort(off)
+	slices.Sort(on)
+	medianOff, medianOn := off[len(off)/2], on[len(on)/2]
+	return OverheadReport{
+		Iterations:      n,
+		Rounds:          overheadRounds,
+		Off:             medianOff.String(),
+		On:              medianOn.String(),
+		OverheadPercent: float64(medianOn-medianOff) / float64(medianOff) * 100,
+	}, nil
+}
+
+// timeWorkload times n handoffs of small allocations between two goroutines,
+// exercising the scheduler and allocator events the tracer records
+func timeWorkload(n int) time.Duration {
+	start := time.Now()
+
+	ch := make(chan []byte)
+	done := make(chan int)
+	go func() {
+		total := 0
+		for b := range ch {
+			total += len(b)
+		}
+		done <- total
+	}()
+	for range n {
+		ch <- make([]byte, 64)
+	}
+	close(ch)
+	<-done
+
+	return time.Since(start)
+}
+
+// handleOverhead measures the overhead, GET only while the recorder is stopped and POST also
+// while it runs, discarding its buffer
+func (s *Service) handleOverhead(w http.ResponseWriter, r *http.Request) {
+	var discard bool
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		discard = true
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := s.MeasureOverhead(discard)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrAlreadyRunning) {
+			code = http.StatusConflict
+			err = fmt.Errorf("%w, use POST to measure it anyway", err)
+		}
+		writeError(w, code, err)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, report)
+}
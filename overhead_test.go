@@ -0,0 +1,67 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOverheadDiscardRequiresPost(t *testing.T) {
+	s := NewService()
+	t.Cleanup(func() { s.Close() })
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Applied on the next restart of the running recorder.
+	period := Duration(3 * time.Second)
+	size := ByteSize(16 << 20)
+	if err := s.Update(UpdateRequest{Period: &period, Size: &size}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Config().RestartPending {
+		t.Fatal("update applied to the running recorder")
+	}
+
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	startedAt := func() time.Time {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.startedAt
+	}
+	before := startedAt()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/recorder/overhead?discard=true", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("GET /overhead?discard=true: got %d, want 409", w.Code)
+	}
+	if !startedAt().Equal(before) {
+		t.Fatal("GET /overhead restarted the recorder")
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/recorder/overhead", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /overhead: got %d, want 200: %s", w.Code, w.Body)
+	}
+	if !s.Status().Enabled {
+		t.Fatal("recorder not restarted")
+	}
+	s.mu.RLock()
+	recordingPeriod, recordingSize := s.recordingPeriod, s.recordingSize
+	s.mu.RUnlock()
+	if recordingPeriod != time.Duration(period) || recordingSize != int64(size) {
+		t.Errorf("restarted with period %s and size %d, want %s and %d", recordingPeriod, recordingSize, period, size)
+	}
+	if s.Config().RestartPending {
+		t.Error("restart still pending after the measurement restarted the recorder")
+	}
+
+	for _, route := range s.Routes() {
+		if route.Path == "/overhead" && !route.Admin {
+			t.Errorf("%s /overhead is not an admin route", route.Method)
+		}
+	}
+}
@@ -1,89 +1,223 @@
 package flightrecorder
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// UnmarshalJSON unmarshals the status response payload.
-// It supports both Go duration and memory unit formats.
-func (s *StatusResponse) MarshalJSON() ([]byte, error) {
-	type Alias struct {
-		Enabled bool   `json:"enabled"`
-		Period  string `json:"period"`
-		Size    string `json:"size"`
+// memoryUnit is a suffix and multiplier of a memory unit.
+// Both decimal-looking (KB, MB, GB) and binary (KiB, MiB, GiB) suffixes
+// are powers of 1024, matching the units the size has always been reported in.
+type memoryUnit struct {
+	suffix string
+	mult   int64
+}
+
+// memoryUnits are ordered so that longer suffixes are matched first
+var memoryUnits = []memoryUnit{
+	{"gib", 1 << 30},
+	{"mib", 1 << 20},
+	{"kib", 1 << 10},
+	{"gb", 1 << 30},
+	{"mb", 1 << 20},
+	{"kb", 1 << 10},
+	{"b", 1},
+}
+
+// formatUnits are the units used to format sizes, largest first
+var formatUnits = []memoryUnit{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+}
+
+// ByteSize is a size in bytes which marshals to JSON in memory units, e.g. "64MB", and
+// unmarshals from memory units or integers of bytes, so sizes read the same everywhere
+type ByteSize int64
+
+// ParseByteSize parses an integer of bytes or a memory unit, e.g. 1.5GB, 64MiB, 1MB, 1KB, 1B
+func ParseByteSize(s string) (ByteSize, error) {
+	size, err := parseUnitsBytes(s)
+	return ByteSize(size), err
+}
+
+// String formats the size in the largest unit it reaches, see formatMemoryUnits
+func (b ByteSize) String() string {
+	return formatMemoryUnits(int64(b))
+}
+
+// MarshalJSON marshals the size as a memory unit string
+func (b ByteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+// UnmarshalJSON unmarshals a memory unit string or an integer of bytes
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	size, err := unmarshalSize(data)
+	if err != nil {
+		return err
 	}
-	var t Alias
-	t.Enabled = s.Enabled
-	t.Period = s.Period.String()
-	if s.Size != 0 {
-		t.Size = formatMemoryUnits(s.Size)
-	} else {
-		t.Size = "0B"
+	*b = ByteSize(size)
+	return nil
+}
+
+// Duration is a time.Duration which marshals to JSON as a Go duration, e.g. "1s",
+// and unmarshals from Go duration strings or integers of nanoseconds
+type Duration time.Duration
+
+// String formats the duration as a Go duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON marshals the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON unmarshals a Go duration string or an integer of nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	duration, err := unmarshalDuration(data)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// unmarshalDuration decodes a Go duration string or an integer of nanoseconds, absent values are 0
+func unmarshalDuration(data json.RawMessage) (time.Duration, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return 0, nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(data, &ns); err != nil {
+			return 0, err
+		}
+		return time.Duration(ns), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// unmarshalSize decodes a memory unit string or an integer of bytes, absent values are 0
+func unmarshalSize(data json.RawMessage) (int64, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return 0, nil
 	}
-	return json.Marshal(t)
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var size int64
+		if err := json.Unmarshal(data, &size); err != nil {
+			return 0, err
+		}
+		return size, nil
+	}
+	return parseUnitsBytes(s)
 }
 
-// UnmarshalJSON unmarshals the update request payload.
-// It supports both Go duration and memory unit formats.
+// UnmarshalJSON unmarshals the update request payload, reporting invalid fields as ConfigErrors.
+// Durations are Go durations or integers of nanoseconds, the size memory units or integers of bytes.
 func (u *UpdateRequest) UnmarshalJSON(data []byte) error {
 	type Alias struct {
-		Period *string `json:"period,omitempty"`
-		Size   *string `json:"size,omitempty"`
+		Period                json.RawMessage `json:"period,omitempty"`
+		Size                  json.RawMessage `json:"size,omitempty"`
+		GCPauseThreshold      json.RawMessage `json:"gc_pause_threshold,omitempty"`
+		SchedLatencyThreshold json.RawMessage `json:"sched_latency_threshold,omitempty"`
+
+		Labels        map[string]string `json:"labels,omitempty"`
+		Apply         ApplyMode         `json:"apply,omitempty"`
+		FinalSnapshot bool              `json:"final_snapshot,omitempty"`
 	}
 	var t Alias
 	if err := json.Unmarshal(data, &t); err != nil {
 		return err
 	}
-	u.Period = nil
-	if t.Period != nil {
-		duration, err := time.ParseDuration(*t.Period)
-		if err != nil {
-			return fmt.Errorf("invalid period: %s should be a duration (e.g. 1s, 100ms, 1h)", *t.Period)
-		}
-		u.Period = &duration
+
+	*u = UpdateRequest{Labels: t.Labels, Apply: t.Apply, FinalSnapshot: t.FinalSnapshot}
+	var err error
+	if u.Period, err = unmarshalField[Duration](t.Period, "period", "should be a duration (e.g. 1s, 100ms, 1h)"); err != nil {
+		return err
 	}
-	if t.Size != nil {
-		size, err := parseUnitsBytes(*t.Size)
-		if err != nil {
-			return fmt.Errorf("invalid size: %s should be an integer of bytes, or a memory unit (e.g. X, or 1MB, 1KB, 1B)", *t.Size)
-		}
-		u.Size = &size
+	if u.Size, err = unmarshalField[ByteSize](t.Size, "size", "should be an integer of bytes, or a memory unit (e.g. X, or 1.5GB, 64MiB, 1MB, 1KB, 1B)"); err != nil {
+		return err
+	}
+	if u.GCPauseThreshold, err = unmarshalField[Duration](t.GCPauseThreshold, "gc_pause_threshold", "should be a duration (e.g. 10ms, 0 to disable)"); err != nil {
+		return err
+	}
+	if u.SchedLatencyThreshold, err = unmarshalField[Duration](t.SchedLatencyThreshold, "sched_latency_threshold", "should be a duration (e.g. 10ms, 0 to disable)"); err != nil {
+		return err
 	}
 	return nil
 }
 
-func formatMemoryUnits(s int) string {
-	if s > 1024*1024 {
-		return fmt.Sprintf("%dMB", s/(1024*1024))
-	} else if s > 1024 {
-		return fmt.Sprintf("%dKB", s/1024)
-	} else {
-		return fmt.Sprintf("%dB", s)
+// unmarshalField decodes an optional field of a request, nil when absent,
+// invalid values are reported as a ConfigError with the hint
+func unmarshalField[T any, PT interface {
+	*T
+	json.Unmarshaler
+}](data json.RawMessage, field, hint string) (*T, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	v := PT(new(T))
+	if err := v.UnmarshalJSON(data); err != nil {
+		return nil, &ConfigError{Field: field, Message: fmt.Sprintf("%s %s", bytes.Trim(data, `"`), hint)}
+	}
+	return v, nil
+}
+
+// formatMemoryUnits formats a size in the largest unit it reaches.
+// Fractions are kept, so that parseUnitsBytes returns exactly the same size.
+func formatMemoryUnits(s int64) string {
+	for _, u := range formatUnits {
+		if s < u.mult {
+			continue
+		}
+		if s%u.mult == 0 {
+			return fmt.Sprintf("%d%s", s/u.mult, u.suffix)
+		}
+		// Sizes within float64 precision divide exactly by powers of two.
+		if s < 1<<53 {
+			return strconv.FormatFloat(float64(s)/float64(u.mult), 'f', -1, 64) + u.suffix
+		}
+		break
 	}
+	return fmt.Sprintf("%dB", s)
 }
 
-func parseUnitsBytes(s string) (int, error) {
+func parseUnitsBytes(s string) (int64, error) {
 	s = strings.TrimSpace(s)
-	if strings.HasSuffix(s, "MB") {
-		s = strings.TrimSuffix(s, "MB")
-		return convertMemoryUnits(s, 1024*1024)
-	} else if strings.HasSuffix(s, "KB") {
-		s = strings.TrimSuffix(s, "KB")
-		return convertMemoryUnits(s, 1024)
-	} else if strings.HasSuffix(s, "B") {
-		s = strings.TrimSuffix(s, "B")
+	lower := strings.ToLower(s)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			return convertMemoryUnits(strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.mult)
+		}
 	}
-	return strconv.Atoi(s)
+	return convertMemoryUnits(s, 1)
 }
 
-func convertMemoryUnits(s string, mult int) (int, error) {
-	if v, err := strconv.Atoi(s); err != nil {
-		return 0, err
-	} else {
+func convertMemoryUnits(s string, mult int64) (int64, error) {
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if v > math.MaxInt64/mult || v < math.MinInt64/mult {
+			return 0, fmt.Errorf("size %s overflows", s)
+		}
 		return v * mult, nil
 	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	bytes := math.Round(f * float64(mult))
+	if math.IsNaN(bytes) || bytes >= math.MaxInt64 || bytes <= math.MinInt64 {
+		return 0, fmt.Errorf("size %s overflows", s)
+	}
+	return int64(bytes), nil
 }
@@ -0,0 +1,351 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Trigger captures snapshots on conditions of its own, e.g. signals of an external system. Run watches
+// the condition until ctx is done and calls fire to capture a snapshot, which returns once the snapshot
+// is captured or skipped by the trigger cooldowns and budget.
+type Trigger interface {
+	Run(ctx context.Context, fire func())
+}
+
+// TriggerFunc adapts a function to a Trigger
+type TriggerFunc func(ctx context.Context, fire func())
+
+// Run calls f(ctx, fire)
+func (f TriggerFunc) Run(ctx context.Context, fire func()) {
+	f(ctx, fire)
+}
+
+// Notifier is notified of the events of the service, e.g. to forward them to a chat or an incident tool.
+// Events are delivered in order, a failing notification is logged and the next event is delivered.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// NotifierFunc adapts a function to a Notifier
+type NotifierFunc func(ctx context.Context, e Event) error
+
+// Notify calls f(ctx, e)
+func (f NotifierFunc) Notify(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// Factories of the plugins named in a PluginConfig, creating a plugin from its JSON configuration,
+// which is empty when the configuration has none
+type (
+	SinkFactory     func(config json.RawMessage) (Sink, error)
+	TriggerFactory  func(config json.RawMessage) (Trigger, error)
+	NotifierFactory func(config json.RawMessage) (Notifier, error)
+)
+
+// pluginRegistry holds the registered plugin factories by type name
+var pluginRegistry = struct {
+	mu        sync.RWMutex
+	sinks     map[string]SinkFactory
+	triggers  map[string]TriggerFactory
+	notifiers map[string]NotifierFactory
+}{
+	sinks:     make(map[string]SinkFactory),
+	triggers:  make(map[string]TriggerFactory),
+	notifiers: make(map[string]NotifierFactory),
+}
+
+// RegisterSinkFactory makes a sink type available to plugin configurations by name, typically from the
+// init function of the package implementing it. It panics if the name is already registered or f is nil.
+func RegisterSinkFactory(name string, f SinkFactory) {
+	register(pluginRegistry.sinks, "sink", name, f, f == nil)
+}
+
+// RegisterTriggerFactory makes a trigger type available to plugin configurations by name, see RegisterSinkFactory
+func RegisterTriggerFactory(name string, f TriggerFactory) {
+	register(pluginRegistry.triggers, "trigger", name, f, f == nil)
+}
+
+// RegisterNotifierFactory makes a notifier type available to plugin configurations by name, see RegisterSinkFactory
+func RegisterNotifierFactory(name string, f NotifierFactory) {
+	register(pluginRegistry.notifiers, "notifier", name, f, f == nil)
+}
+
+func register[F any](factories map[string]F, kind, name string, f F, isNil bool) {
+	pluginRegistry.mu.Lock()
+	defer pluginRegistry.mu.Unlock()
+
+	if isNil {
+		panic("flightrecorder: nil " + kind + " factory " + name)
+	}
+	if _, ok := factories[name]; ok {
+		panic("flightrecorder: " + kind + " factory " + name + " registered twice")
+	}
+	factories[name] = f
+}
+
+func lookupFactory[F any](factories map[string]F, kind, name string) (F, error) {
+	pluginRegistry.mu.RLock()
+	defer pluginRegistry.mu.RUnlock()
+
+	f, ok := factories[name]
+	if !ok {
+		return f, fmt.Errorf("unknown %s type %q, registered: %v", kind, name, slices.Sorted(maps.Keys(factories)))
+	}
+	return f, nil
+}
+
+// PluginConfig instantiates plugins by the names their factories are registered with, e.g. from a config file:
+//
+//	{
+//	  "sink": {"type": "s3", "config": {"bucket": "traces"}},
+//	  "triggers": [{"type": "alertmanager", "name": "alert", "cooldown": "10m"}],
+//	  "notifiers": [{"type": "teams", "config": {"url": "https://example.com/hook"}}]
+//	}
+//
+// Sinks and SinkPolicy configure a MultiSink instead of a single sink, the sinks named by their name or type.
+type PluginConfig struct {
+	Sink       *PluginSpec  `json:"sink,omitempty"`
+	Sinks      []PluginSpec `json:"sinks,omitempty"`
+	SinkPolicy SinkPolicy   `json:"sink_policy,omitempty"` // default all_required
+	Triggers   []PluginSpec `json:"triggers,omitempty"`
+	Notifiers  []PluginSpec `json:"notifiers,omitempty"`
+}
+
+// PluginSpec names a plugin and configures it
+type PluginSpec struct {
+	Type     string          `json:"type"`               // name the factory is registered with
+	Name     string          `json:"name,omitempty"`     // trigger recorded in the snapshots of a trigger, or sink of a MultiSink (default the type)
+	Cooldown Duration        `json:"cooldown,omitempty"` // minimum time between two snapshots of a trigger
+	Config   json.RawMessage `json:"config,omitempty"`   // configuration passed to the factory
+}
+
+// LoadPluginConfig reads a JSON plugin configuration file, rejecting unknown fields
+func LoadPluginConfig(path string) (PluginConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PluginConfig{}, fmt.Errorf("failed to read plugin config: %w", err)
+	}
+	var config PluginConfig
+	if err := DecodePluginConfig(data, &config); err != nil {
+		return PluginConfig{}, fmt.Errorf("invalid plugin config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// DecodePluginConfig decodes the JSON configuration of a plugin into v, rejecting unknown fields so typos
+// in config files fail loudly. An empty configuration leaves v as is, for factories to apply defaults.
+func DecodePluginConfig(config json.RawMessage, v any) error {
+	if len(bytes.TrimSpace(config)) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// Plugins are the plugins instantiated from a PluginConfig, for hosts other than a service such as the agent
+type Plugins struct {
+	Sink      Sink // nil when not configured
+	Triggers  []TriggerPlugin
+	Notifiers []Notifier
+}
+
+// TriggerPlugin is a trigger instantiated from a PluginConfig with its name and cooldown
+type TriggerPlugin struct {
+	Name     string
+	Cooldown time.Duration
+	Trigger  Trigger
+}
+
+// Instantiate instantiates the configured plugins with their registered factories
+func (c PluginConfig) Instantiate() (Plugins, error) {
+	var plugins Plugins
+	if c.Sink != nil && len(c.Sinks) > 0 {
+		return Plugins{}, fmt.Errorf("sink and sinks are mutually exclusive")
+	}
+	if c.Sink != nil {
+		sink, err := instantiateSink(*c.Sink)
+		if err != nil {
+			return Plugins{}, err
+		}
+		plugins.Sink = sink
+	}
+	if len(c.Sinks) > 0 {
+		switch c.SinkPolicy {
+		case "", SinkAllRequired, SinkBestEffort:
+		default:
+			return Plugins{}, fmt.Errorf("invalid sink policy %q, should be %s or %s", c.SinkPolicy, SinkAllRequired, SinkBestEffort)
+		}
+		multi := NewMultiSink(c.SinkPolicy)
+		for _, spec := range c.Sinks {
+			sink, err := instantiateSink(spec)
+			if err != nil {
+				return Plugins{}, err
+			}
+			name := spec.Name
+			if name == "" {
+				name = spec.Type
+			}
+			if slices.ContainsFunc(multi.Sinks, func(s NamedSink) bool { return s.Name == name }) {
+				return Plugins{}, fmt.Errorf("sink %s configured twice, name them apart", name)
+			}
+			multi.Sinks = append(multi.Sinks, NamedSink{Name: name, Sink: sink})
+		}
+		plugins.Sink = multi
+	}
+	for _, spec := range c.Triggers {
+		f, err := lookupFactory(pluginRegistry.triggers, "trigger", spec.Type)
+		if err != nil {
+			return Plugins{}, err
+		}
+		t, err := f(spec.Config)
+		if err != nil {
+			return Plugins{}, fmt.Errorf("invalid trigger %s: %w", spec.Type, err)
+		}
+		name := spec.Name
+		if name == "" {
+			name = spec.Type
+		}
+		plugins.Triggers = append(plugins.Triggers, TriggerPlugin{Name: name, Cooldown: time.Duration(spec.Cooldown), Trigger: t})
+	}
+	for _, spec := range c.Notifiers {
+		f, err := lookupFactory(pluginRegistry.notifiers, "notifier", spec.Type)
+		if err != nil {
+			return Plugins{}, err
+		}
+		n, err := f(spec.Config)
+		if err != nil {
+			return Plugins{}, fmt.Errorf("invalid notifier %s: %w", spec.Type, err)
+		}
+		plugins.Notifiers = append(plugins.Notifiers, n)
+	}
+	return plugins, nil
+}
+
+func instantiateSink(spec PluginSpec) (Sink, error) {
+	f, err := lookupFactory(pluginRegistry.sinks, "sink", spec.Type)
+	if err != nil {
+		return nil, err
+	}
+	sink, err := f(spec.Config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink %s: %w", spec.Type, err)
+	}
+	return sink, nil
+}
+
+// Options instantiates the configured plugins with their registered factories and returns the options
+// adding them to a service
+func (c PluginConfig) Options() ([]Option, error) {
+	plugins, err := c.Instantiate()
+	if err != nil {
+		return nil, err
+	}
+	var opts []Option
+	if plugins.Sink != nil {
+		opts = append(opts, WithSink(plugins.Sink))
+	}
+	for _, t := range plugins.Triggers {
+		opts = append(opts, WithTrigger(t.Name, t.Cooldown, t.Trigger))
+	}
+	for _, n := range plugins.Notifiers {
+		opts = append(opts, WithNotifier(n))
+	}
+	return opts, nil
+}
+
+// namedTrigger is a Trigger added with WithTrigger
+type namedTrigger struct {
+	name     string
+	cooldown time.Duration
+	trigger  Trigger
+}
+
+// WithTrigger runs a trigger while the service is up. Its snapshots are captured with the name as their
+// trigger, at most once per cooldown, on top of WithTriggerCooldown and WithTriggerBudget.
+func WithTrigger(name string, cooldown time.Duration, t Trigger) Option {
+	return func(o *options) {
+		o.triggers = append(o.triggers, namedTrigger{name: name, cooldown: cooldown, trigger: t})
+	}
+}
+
+// WithNotifier notifies n of the events of the service until it is torn down
+func WithNotifier(n Notifier) Option {
+	return func(o *options) {
+		o.notifiers = append(o.notifiers, n)
+	}
+}
+
+// runTrigger runs a trigger until ctx is done
+func (s *Service) runTrigger(ctx context.Context, t namedTrigger) {
+	t.trigger.Run(ctx, func() {
+		if ctx.Err() == nil {
+			s.fireTrigger(t.name, t.cooldown)
+		}
+	})
+}
+
+// runNotifier delivers the events of a subscription to the notifier until ctx is done
+func (s *Service) runNotifier(ctx context.Context, n Notifier, events chan Event) {
+	defer s.events.unsubscribe(events)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := n.Notify(ctx, e); err != nil && ctx.Err() == nil {
+				s.logger().Warn("flight recorder notifier failed", "event", e.Type, "error", err)
+			}
+		}
+	}
+}
+
+// The built-in sinks are available to plugin configurations as "file" and "http".
+func init() {
+	RegisterSinkFactory("file", func(config json.RawMessage) (Sink, error) {
+		var c struct {
+			Dir string `json:"dir"`
+		}
+		if err := DecodePluginConfig(config, &c); err != nil {
+			return nil, err
+		}
+		if c.Dir == "" {
+			return nil, fmt.Errorf("dir is required")
+		}
+		return NewFileSink(c.Dir), nil
+	})
+	RegisterSinkFactory("http", func(config json.RawMessage) (Sink, error) {
+		var c struct {
+			URL         string   `json:"url"`
+			BearerToken string   `json:"bearer_token"`
+			MaxRetries  *int     `json:"max_retries"`
+			Backoff     Duration `json:"backoff"`
+		}
+		if err := DecodePluginConfig(config, &c); err != nil {
+			return nil, err
+		}
+		if c.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		sink := NewHTTPSink(c.URL)
+		sink.BearerToken = c.BearerToken
+		if c.MaxRetries != nil {
+			sink.MaxRetries = *c.MaxRetries
+		}
+		if c.Backoff > 0 {
+			sink.Backoff = time.Duration(c.Backoff)
+		}
+		return sink, nil
+	})
+}
@@ -0,0 +1,359 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// ProfileSuffix replaces the suffix of a snapshot's name for its pprof profile
+const ProfileSuffix = ".pb.gz"
+
+// unknownFunction is the frame of running intervals without a stack
+const unknownFunction = "[unknown]"
+
+// profileKind describes a profile derived from the time goroutines spent in a state
+type profileKind struct {
+	state trace.GoState
+	count [2]string // type and unit of the number of intervals
+	value [2]string // type and unit of their time
+}
+
+var (
+	cpuProfile   = profileKind{trace.GoRunning, [2]string{"samples", "count"}, [2]string{"cpu", "nanoseconds"}}
+	blockProfile = profileKind{trace.GoWaiting, [2]string{"contentions", "count"}, [2]string{"delay", "nanoseconds"}}
+)
+
+// ConvertToPprof derives an approximate CPU profile from a snapshot and writes it to w in the gzipped
+// pprof protobuf format, for go tool pprof and flame graph tooling. Each interval a goroutine was running
+// is a sample of its duration, attributed to the goroutine's stack when it stopped running.
+func ConvertToPprof(r io.Reader, w io.Writer) error {
+	_, err := convertProfile(r, w, cpuProfile)
+	return err
+}
+
+// ConvertToBlockProfile derives an approximate block profile from a snapshot, like ConvertToPprof:
+// each interval a goroutine was blocked (on channels, locks, network I/O, ...) is a contention of its
+// duration, attributed to the goroutine's stack when it blocked.
+func ConvertToBlockProfile(r io.Reader, w io.Writer) error {
+	_, err := convertProfile(r, w, blockProfile)
+	return err
+}
+
+// convertProfile writes the profile of the kind derived from a snapshot to w and returns the duration of the snapshot
+func convertProfile(r io.Reader, w io.Writer, kind profileKind) (time.Duration, error) {
+	p := newPprofBuilder()
+	duration, err := goroutineStackTimes(r, kind.state, p.add)
+	if err != nil {
+		return 0, err
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(p.encode(kind, duration.Nanoseconds())); err != nil {
+		return 0, err
+	}
+	return duration, gz.Close()
+}
+
+// goroutineStackTimes calls add with every interval goroutines spent in the state and the last stack of the
+// goroutine seen by then, e.g. where it stopped running or blocked, and returns the duration of the trace
+func goroutineStackTimes(r io.Reader, state trace.GoState, add func(stack trace.Stack, d time.Duration)) (time.Duration, error) {
+	reader, err := trace.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	var (
+		events      int
+		first, last trace.Time
+		since       = make(map[trace.GoID]trace.Time)
+		stacks      = make(map[trace.GoID]trace.Stack)
+	)
+	for {
+		ev, err := reader.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%w: after %d events: %v", ErrInvalidSnapshot, events, err)
+		}
+
+		now := ev.Time()
+		if events == 0 {
+			first = now
+		}
+		last = now
+		events++
+
+		if ev.Kind() != trace.EventStateTransition {
+			continue
+		}
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			continue
+		}
+		id := st.Resource.Goroutine()
+		from, to := st.Goroutine()
+
+		if stack := goroutineStack(ev); stack != trace.NoStack {
+			stacks[id] = stack
+		}
+		if begin, ok := since[id]; ok && from == state {
+			add(stacks[id], now.Sub(begin))
+			delete(since, id)
+		}
+		if to == state {
+			since[id] = now
+		}
+	}
+	if events == 0 {
+		return 0, fmt.Errorf("%w: trace has no events", ErrInvalidSnapshot)
+	}
+	// Goroutines still in the state at the end of the trace stayed until its last event,
+	// added in order of their IDs so the output is deterministic.
+	for _, id := range slices.Sorted(maps.Keys(since)) {
+		add(stacks[id], last.Sub(since[id]))
+	}
+	return last.Sub(first), nil
+}
+
+// pprofLocation is a frame of a profile, identified by its PC and source position
+type pprofLocation struct {
+	pc       uint64
+	function uint64
+	line     int64
+}
+
+// pprofFunction is a function of a profile
+type pprofFunction struct {
+	name int64
+	file int64
+}
+
+// pprofSample is the time in the state of the profile attributed to a stack
+type pprofSample struct {
+	locations []uint64
+	count     int64
+	nanos     int64
+}
+
+// pprofBuilder collects the samples of a profile, deduplicating its strings, functions and locations.
+// IDs are indexes plus one, since pprof reserves zero.
+type pprofBuilder struct {
+	strings     []string
+	stringIDs   map[string]int64
+	functions   []pprofFunction
+	functionIDs map[pprofFunction]uint64
+	locations   []pprofLocation
+	locationIDs map[pprofLocation]uint64
+	samples     []*pprofSample
+	sampleIDs   map[string]*pprofSample
+}
+
+func newPprofBuilder() *pprofBuilder {
+	return &pprofBuilder{
+		strings:     []string{""},
+		stringIDs:   map[string]int64{"": 0},
+		functionIDs: make(map[pprofFunction]uint64),
+		locationIDs: make(map[pprofLocation]uint64),
+		sampleIDs:   make(map[string]*pprofSample),
+	}
+}
+
+func (p *pprofBuilder) string(s string) int64 {
+	id, ok := p.stringIDs[s]
+	if !ok {
+		id = int64(len(p.strings))
+		p.strings = append(p.strings, s)
+		p.stringIDs[s] = id
+	}
+	return id
+}
+
+func (p *pprofBuilder) location(frame trace.StackFrame) uint64 {
+	fn := pprofFunction{name: p.string(frame.Func), file: p.string(frame.File)}
+	fnID, ok := p.functionIDs[fn]
+	if !ok {
+		p.functions = append(p.functions, fn)
+		fnID = uint64(len(p.functions))
+		p.functionIDs[fn] = fnID
+	}
+
+	loc := pprofLocation{pc: frame.PC, function: fnID, line: int64(frame.Line)}
+	id, ok := p.locationIDs[loc]
+	if !ok {
+		p.locations = append(p.locations, loc)
+		id = uint64(len(p.locations))
+		p.locationIDs[loc] = id
+	}
+	return id
+}
+
+// add adds an interval to the sample of the stack, leaf first
+func (p *pprofBuilder) add(stack trace.Stack, d time.Duration) {
+	var locations []uint64
+	for frame := range stack.Frames() {
+		locations = append(locations, p.location(frame))
+	}
+	if len(locations) == 0 {
+		locations = append(locations, p.location(trace.StackFrame{Func: unknownFunction}))
+	}
+
+	var key strings.Builder
+	for _, id := range locations {
+		key.Write(binary.AppendUvarint(nil, id))
+	}
+	sample, ok := p.sampleIDs[key.String()]
+	if !ok {
+		sample = &pprofSample{locations: locations}
+		p.samples = append(p.samples, sample)
+		p.sampleIDs[key.String()] = sample
+	}
+	sample.count++
+	sample.nanos += d.Nanoseconds()
+}
+
+// Field numbers of the pprof profile.proto messages
+const (
+	pprofProfileSampleType  = 1
+	pprofProfileSample      = 2
+	pprofProfileLocation    = 4
+	pprofProfileFunction    = 5
+	pprofProfileStringTable = 6
+	pprofProfileDuration    = 10
+	pprofProfilePeriodType  = 11
+	pprofProfilePeriod      = 12
+
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	pprofSampleLocationID = 1
+	pprofSampleValue      = 2
+
+	pprofLocationID      = 1
+	pprofLocationAddress = 3
+	pprofLocationLine    = 4
+
+	pprofLineFunctionID = 1
+	pprofLineLine       = 2
+
+	pprofFunctionID         = 1
+	pprofFunctionName       = 2
+	pprofFunctionSystemName = 3
+	pprofFunctionFilename   = 4
+)
+
+// encode encodes the profile of the kind as a profile.proto message
+func (p *pprofBuilder) encode(kind profileKind, duration int64) []byte {
+	valueType := func(typ, unit string) []byte {
+		var b []byte
+		b = appendVarintField(b, pprofValueTypeType, uint64(p.string(typ)))
+		return appendVarintField(b, pprofValueTypeUnit, uint64(p.string(unit)))
+	}
+
+	var b []byte
+	b = appendBytesField(b, pprofProfileSampleType, valueType(kind.count[0], kind.count[1]))
+	b = appendBytesField(b, pprofProfileSampleType, valueType(kind.value[0], kind.value[1]))
+	for _, s := range p.samples {
+		var sample, values []byte
+		for _, id := range s.locations {
+			sample = binary.AppendUvarint(sample, id)
+		}
+		sample = appendBytesField(nil, pprofSampleLocationID, sample)
+		values = binary.AppendUvarint(values, uint64(s.count))
+		values = binary.AppendUvarint(values, uint64(s.nanos))
+		b = appendBytesField(b, pprofProfileSample, appendBytesField(sample, pprofSampleValue, values))
+	}
+	for i, loc := range p.locations {
+		var line, location []byte
+		line = appendVarintField(line, pprofLineFunctionID, loc.function)
+		line = appendVarintField(line, pprofLineLine, uint64(loc.line))
+		location = appendVarintField(location, pprofLocationID, uint64(i+1))
+		location = appendVarintField(location, pprofLocationAddress, loc.pc)
+		b = appendBytesField(b, pprofProfileLocation, appendBytesField(location, pprofLocationLine, line))
+	}
+	for i, fn := range p.functions {
+		var function []byte
+		function = appendVarintField(function, pprofFunctionID, uint64(i+1))
+		function = appendVarintField(function, pprofFunctionName, uint64(fn.name))
+		function = appendVarintField(function, pprofFunctionSystemName, uint64(fn.name))
+		function = appendVarintField(function, pprofFunctionFilename, uint64(fn.file))
+		b = appendBytesField(b, pprofProfileFunction, function)
+	}
+	b = appendVarintField(b, pprofProfileDuration, uint64(duration))
+	b = appendBytesField(b, pprofProfilePeriodType, valueType(kind.value[0], kind.value[1]))
+	b = appendVarintField(b, pprofProfilePeriod, 1)
+	// The string table is encoded last, since encoding the value types adds to it.
+	for _, s := range p.strings {
+		b = appendBytesField(b, pprofProfileStringTable, []byte(s))
+	}
+	return b
+}
+
+// appendVarintField appends a varint field, omitting zero values like proto3
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+// appendBytesField appends a length-delimited field
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// SnapshotProfile converts a stored snapshot by ID to a pprof profile, see ConvertToPprof
+func (s *Service) SnapshotProfile(id string, w io.Writer) (SnapshotMeta, error) {
+	meta, data, err := s.StoredSnapshot(id)
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+	if data, err = s.decryptSnapshot(meta, data); err != nil {
+		return SnapshotMeta{}, err
+	}
+	return meta, ConvertToPprof(bytes.NewReader(data), w)
+}
+
+// handleSnapshotProfile serves a stored snapshot as a pprof profile
+func (s *Service) handleSnapshotProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var buf bytes.Buffer
+	meta, err := s.SnapshotProfile(r.PathValue("id"), &buf)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrSnapshotNotFound) {
+			code = http.StatusNotFound
+		}
+		writeError(w, code, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", contentDisposition(ProfileName(meta.Name)))
+	w.Write(buf.Bytes())
+	s.recordDownload(r, meta.ID, DownloadProfile)
+}
+
+// ProfileName returns the name of the pprof profile of a snapshot file
+func ProfileName(name string) string {
+	name = strings.TrimSuffix(name, EncryptedSuffix)
+	return strings.TrimSuffix(name, ".trace") + ProfileSuffix
+}
@@ -0,0 +1,238 @@
+package flightrecorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrPrincipalQuotaExceeded is returned when a principal exceeded its quota of WithPrincipalQuota
+var ErrPrincipalQuotaExceeded = errors.New("principal quota exceeded")
+
+// Headers of the quota of the principal, set on the responses of the endpoints counted by WithPrincipalQuota
+const (
+	HeaderQuotaLimit     = "X-Quota-Limit"     // requests allowed per window
+	HeaderQuotaRemaining = "X-Quota-Remaining" // requests left in the window
+)
+
+// Kinds of requests counted by PrincipalQuota
+const (
+	QuotaDownloads = "downloads" // downloads of snapshots, their profiles, flame graphs and signed URLs, and bundles
+	QuotaMutations = "mutations" // requests of the admin endpoints with a method other than GET
+)
+
+// defaultQuotaWindow is the window of principal quotas by default
+const defaultQuotaWindow = time.Hour
+
+// quotaDownloadPaths are the paths of the endpoints counted as downloads
+var quotaDownloadPaths = []string{"/snapshot", "/bundle", "/snapshots/{id}", "/snapshots/{id}/url", "/snapshots/{id}/pprof", "/snapshots/{id}/flame"}
+
+// PrincipalQuota limits how often each principal downloads snapshots and calls the mutating endpoints, so an
+// automated client can't hammer the service. Principals are identified like WithPrincipal, anonymous clients
+// by their IP address.
+type PrincipalQuota struct {
+	Downloads int           // downloads per window and principal, 0 for no limit
+	Mutations int           // mutating requests per window and principal, 0 for no limit
+	Window    time.Duration // sliding window the requests are counted in (default 1h)
+}
+
+func (q PrincipalQuota) limit(kind string) int {
+	if kind == QuotaDownloads {
+		return q.Downloads
+	}
+	return q.Mutations
+}
+
+func (q PrincipalQuota) window() time.Duration {
+	if q.Window <= 0 {
+		return defaultQuotaWindow
+	}
+	return q.Window
+}
+
+// WithPrincipalQuota enforces the quota on the requests of every principal. Requests beyond it respond
+// 429 Too Many Requests with a Retry-After header and publish a rate_limited event, and the usage of the
+// quota is recorded with the downloads of snapshots.
+func WithPrincipalQuota(q PrincipalQuota) Option {
+	return func(o *options) {
+		o.principalQuota = q
+	}
+}
+
+// QuotaUsage is the use of the quota of a principal by a request, recorded with the downloads of snapshots
+// and in rate_limited events
+type QuotaUsage struct {
+	Principal string `json:"principal"` // principal, or the IP address of an anonymous client
+	Kind      string `json:"kind"`      // downloads or mutations
+	Used      int    `json:"used"`      // requests counted in the window, including this one when it was allowed
+	Limit     int    `json:"limit"`
+}
+
+// quotaCounter counts the requests of the principals in the sliding window of the quota
+type quotaCounter struct {
+	mu        sync.Mutex
+	requests  map[quotaKey][]time.Time
+	exceeded  map[quotaKey]bool // principals rejected since their last allowed request
+	lastSweep time.Time
+}
+
+type quotaKey struct {
+	principal string
+	kind      string
+}
+
+// quotaResult is the result of counting a request against a quota
+type quotaResult struct {
+	used       int
+	allowed    bool
+	retryAfter time.Duration // until the oldest counted request leaves the window, when not allowed
+	first      bool          // first rejection since the last allowed request
+}
+
+// allow counts a request of the principal, unless the principal used its quota
+func (c *quotaCounter) allow(key quotaKey, limit int, window time.Duration, now time.Time) quotaResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.requests == nil {
+		c.requests = make(map[quotaKey][]time.Time)
+		c.exceeded = make(map[quotaKey]bool)
+	}
+	// Principals seen once would be kept forever otherwise.
+	if now.Sub(c.lastSweep) >= window {
+		for k, times := range c.requests {
+			if !times[len(times)-1].After(now.Add(-window)) {
+				delete(c.requests, k)
+				delete(c.exceeded, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	times := c.requests[key]
+	expired := 0
+	for expired < len(times) && !times[expired].After(now.Add(-window)) {
+		expired++
+	}
+	times = slices.Delete(times, 0, expired)
+	if len(times) >= limit {
+		c.requests[key] = times
+		first := !c.exceeded[key]
+		c.exceeded[key] = true
+		return quotaResult{used: len(times), retryAfter: times[0].Add(window).Sub(now), first: first}
+	}
+	c.requests[key] = append(times, now)
+	delete(c.exceeded, key)
+	return quotaResult{used: len(times) + 1, allowed: true}
+}
+
+// quotaPrincipal identifies the client of the request for its quota
+func (s *Service) quotaPrincipal(r *http.Request) string {
+	if principal := s.principal(r); principal != "" {
+		return principal
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// quotaKind returns the kind of requests of the route counted by the quota, "" when it isn't counted
+func quotaKind(route Route) string {
+	path := unversionedPath(route)
+	switch {
+	case route.Method == http.MethodGet && slices.Contains(quotaDownloadPaths, path):
+		return QuotaDownloads
+	case route.Admin && route.Method != http.MethodGet:
+		return QuotaMutations
+	}
+	return ""
+}
+
+// CountQuota counts a request of the kind against the quota of its principal with WithPrincipalQuota, for
+// adapters serving the endpoints over other protocols. It fails with ErrPrincipalQuotaExceeded, publishing a
+// rate_limited event, when the principal used its quota, and retryAfter is when to retry. The usage is nil
+// when requests of the kind aren't limited.
+func (s *Service) CountQuota(r *http.Request, kind string) (usage *QuotaUsage, retryAfter time.Duration, err error) {
+	q := s.opts.principalQuota
+	limit := q.limit(kind)
+	if limit <= 0 {
+		return nil, 0, nil
+	}
+	usage = &QuotaUsage{Principal: s.quotaPrincipal(r), Kind: kind, Limit: limit}
+	result := s.quotas.allow(quotaKey{usage.Principal, kind}, limit, q.window(), time.Now())
+	usage.Used = result.used
+	if result.allowed {
+		return usage, 0, nil
+	}
+	// Only the first rejection is published, so a client hammering the service doesn't flood webhooks.
+	if result.first {
+		s.publish(Event{Type: EventRateLimited, Quota: usage})
+	}
+	return usage, result.retryAfter, fmt.Errorf("%w: %d %s per %s for %s", ErrPrincipalQuotaExceeded, limit, kind, Duration(q.window()), usage.Principal)
+}
+
+// SetQuotaHeaders sets the X-Quota-Limit and X-Quota-Remaining headers of the usage, and Retry-After
+// when the request was rejected
+func SetQuotaHeaders(header http.Header, usage *QuotaUsage, retryAfter time.Duration) {
+	header.Set(HeaderQuotaLimit, strconv.Itoa(usage.Limit))
+	header.Set(HeaderQuotaRemaining, strconv.Itoa(max(usage.Limit-usage.Used, 0)))
+	if retryAfter > 0 {
+		header.Set("Retry-After", strconv.Itoa(int(retryAfter.Truncate(time.Second)/time.Second)+1))
+	}
+}
+
+type quotaUsageKey struct{}
+
+// quotaUsageFrom returns the usage of the quota by the request, nil when it isn't counted
+func quotaUsageFrom(ctx context.Context) *QuotaUsage {
+	usage, _ := ctx.Value(quotaUsageKey{}).(*QuotaUsage)
+	return usage
+}
+
+// quotaRoutes counts the downloads and mutating requests of the principals with WithPrincipalQuota,
+// rejecting the requests beyond their quota
+func (s *Service) quotaRoutes(routes []Route) []Route {
+	q := s.opts.principalQuota
+	// Paths are registered once for all methods, so every handler counts the methods of its path.
+	kinds := make(map[string]map[string]string)
+	for _, route := range routes {
+		if kind := quotaKind(route); kind != "" && q.limit(kind) > 0 {
+			if kinds[route.Path] == nil {
+				kinds[route.Path] = make(map[string]string)
+			}
+			kinds[route.Path][route.Method] = kind
+		}
+	}
+
+	for i, route := range routes {
+		methods, ok := kinds[route.Path]
+		if !ok {
+			continue
+		}
+		h := route.Handler
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			// HEAD requests answered by the GET handler are not counted.
+			kind, ok := methods[r.Method]
+			if !ok {
+				h(w, r)
+				return
+			}
+			usage, retryAfter, err := s.CountQuota(r, kind)
+			SetQuotaHeaders(w.Header(), usage, retryAfter)
+			if err != nil {
+				writeError(w, http.StatusTooManyRequests, err)
+				return
+			}
+			h(w, r.WithContext(context.WithValue(r.Context(), quotaUsageKey{}, usage)))
+		}
+	}
+	return routes
+}
@@ -0,0 +1,43 @@
+package flightrecorder
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrincipalQuotaSharedAcrossAdapters(t *testing.T) {
+	s := NewService(WithPrincipalQuota(PrincipalQuota{Mutations: 2}))
+	t.Cleanup(func() { s.Close() })
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+
+	// An adapter counts its call like the endpoints it mirrors.
+	r := httptest.NewRequest(http.MethodPost, "/rpc/Mark", nil)
+	if usage, _, err := s.CountQuota(r, QuotaMutations); err != nil || usage.Used != 1 {
+		t.Fatalf("CountQuota: got %+v, %v, want the first mutation allowed", usage, err)
+	}
+	if usage, _, err := s.CountQuota(r, QuotaDownloads); usage != nil || err != nil {
+		t.Fatalf("CountQuota of unlimited downloads: got %+v, %v, want nil", usage, err)
+	}
+
+	mark := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/recorder/mark", strings.NewReader(`{"label": "deploy"}`)))
+		return w
+	}
+	if w := mark(); w.Code != http.StatusOK || w.Header().Get(HeaderQuotaRemaining) != "0" {
+		t.Fatalf("POST /mark: got %d with %s remaining, want 200 with 0 remaining", w.Code, w.Header().Get(HeaderQuotaRemaining))
+	}
+	if w := mark(); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("POST /mark beyond the quota: got %d, want 429 with Retry-After", w.Code)
+	}
+	if _, retryAfter, err := s.CountQuota(r, QuotaMutations); !errors.Is(err, ErrPrincipalQuotaExceeded) || retryAfter <= 0 {
+		t.Fatalf("CountQuota beyond the quota: got %v, retry after %s", err, retryAfter)
+	}
+}
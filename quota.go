@@ -0,0 +1,77 @@
+package flightrecorder
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrQuotaExceeded is returned when a snapshot does not fit in the store quota
+var ErrQuotaExceeded = errors.New("snapshot store quota exceeded")
+
+// QuotaPolicy decides what happens to a snapshot which does not fit in the store quota
+type QuotaPolicy int
+
+const (
+	// QuotaDeleteOldest deletes the oldest stored snapshots until the new snapshot fits
+	QuotaDeleteOldest QuotaPolicy = iota
+	// QuotaReject rejects the new snapshot with ErrQuotaExceeded, keeping the stored snapshots
+	QuotaReject
+)
+
+// StoreQuota bounds the total bytes held by the snapshot store, enforced when snapshots are captured.
+// Unlike RetentionPolicy.MaxBytes, which the janitor enforces after the fact, the quota is never exceeded,
+// so an incident storm firing triggers in a loop cannot grow the store beyond it.
+type StoreQuota struct {
+	MaxBytes int64       // maximum total bytes of stored snapshots
+	Policy   QuotaPolicy // what to do with snapshots which don't fit (default QuotaDeleteOldest)
+}
+
+// WithStoreQuota sets the quota of the snapshot store
+func WithStoreQuota(quota StoreQuota) Option {
+	return func(o *options) {
+		o.quota = quota
+	}
+}
+
+func (q StoreQuota) enabled() bool {
+	return q.MaxBytes > 0
+}
+
+// addWithQuota adds a snapshot to the store within the quota and returns the number of
+// snapshots deleted to make room for it
+func (st *snapshotStore) addWithQuota(snap storedSnapshot, quota StoreQuota) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	size := snap.size
+	if quota.enabled() {
+		if size > quota.MaxBytes {
+			return 0, fmt.Errorf("%w: snapshot of %d bytes is larger than the quota of %d bytes",
+				ErrQuotaExceeded, size, quota.MaxBytes)
+		}
+		if st.bytes+size > quota.MaxBytes && quota.Policy == QuotaReject {
+			return 0, fmt.Errorf("%w: %d of %d bytes used, delete snapshots to make room",
+				ErrQuotaExceeded, st.bytes, quota.MaxBytes)
+		}
+	}
+
+	removed := 0
+	for quota.enabled() && st.bytes+size > quota.MaxBytes {
+		st.snapshots[removed].discard()
+		st.bytes -= st.snapshots[removed].size
+		removed++
+	}
+	st.snapshots = slices.Delete(st.snapshots, 0, removed)
+	st.snapshots = append(st.snapshots, snap)
+	st.bytes += size
+	return removed, nil
+}
+
+// usage returns the number of stored snapshots and their total bytes
+func (st *snapshotStore) usage() (int, int64) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return len(st.snapshots), st.bytes
+}
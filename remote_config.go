@@ -0,0 +1,188 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// RemoteConfigPrincipal is the principal of the configuration changes applied by WithRemoteConfig,
+// as reported in the configuration history
+const RemoteConfigPrincipal = "remote-config"
+
+// defaultRemoteConfigInterval is how often the remote configuration is fetched by default
+const defaultRemoteConfigInterval = 30 * time.Second
+
+// RemoteConfig fetches the desired configuration of the recorder from a central control plane, so period,
+// size, trigger thresholds and labels can be changed fleet-wide without a redeploy or calling every instance.
+// The document is an update request, as sent to PATCH /config: the fields it sets are reconciled, the others
+// are left as they are. It is fetched with If-None-Match, so an unchanged document costs a 304, and reapplied
+// whenever the configuration drifts from it, e.g. after a local update.
+type RemoteConfig struct {
+	URL         string        // document of the desired configuration
+	Interval    time.Duration // time between two fetches, and between retries with LongPoll (default 30s)
+	Client      *http.Client  // default http.DefaultClient
+	BearerToken string        // sent as an Authorization bearer token when set
+	// LongPoll fetches the document again as soon as a fetch returns, for control planes holding the
+	// request until the document changes, so changes are pushed to the instances as they are made
+	LongPoll bool
+}
+
+func (c RemoteConfig) enabled() bool {
+	return c.URL != ""
+}
+
+// WithRemoteConfig reconciles the configuration with a document fetched from a central control plane.
+// Requests carry the X-FlightRecorder-Instance header, for control planes serving instances differently.
+func WithRemoteConfig(c RemoteConfig) Option {
+	return func(o *options) {
+		o.remoteConfig = c
+	}
+}
+
+// remoteConfigState is the last document fetched by runRemoteConfig
+type remoteConfigState struct {
+	etag    string
+	desired *UpdateRequest
+}
+
+// runRemoteConfig fetches the remote configuration and reconciles the service with it until ctx is done
+func (s *Service) runRemoteConfig(ctx context.Context) {
+	defer s.health.remoteConfig.Store(false)
+
+	c := s.opts.remoteConfig
+	interval := c.Interval
+	if interval <= 0 {
+		interval = defaultRemoteConfigInterval
+	}
+
+	var state remoteConfigState
+	for {
+		err := s.syncRemoteConfig(ctx, &state)
+		if ctx.Err() != nil {
+			return
+		}
+		s.health.recordRemoteConfig(err)
+		if err != nil {
+			s.logger().Warn("flight recorder remote config failed", "url", c.URL, "error", err)
+		}
+
+		wait := interval
+		if c.LongPoll && err == nil {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// syncRemoteConfig fetches the remote configuration and applies it when the configuration differs
+func (s *Service) syncRemoteConfig(ctx context.Context, state *remoteConfigState) error {
+	if err := s.fetchRemoteConfig(ctx, state); err != nil {
+		return err
+	}
+	if state.desired == nil {
+		return nil
+	}
+
+	current := s.Config()
+	drifted := len(diffConfig(current, s.resolve(*state.desired, false))) > 0
+	desired := state.desired
+	if !drifted {
+		desired = nil
+	}
+	if s.deferRemoteConfig(desired) || !drifted {
+		return nil
+	}
+	return s.applyRemoteConfig(ctx, *state.desired)
+}
+
+// deferRemoteConfig keeps the desired configuration, nil when there is nothing to apply, until the
+// service is unlocked, so the control plane doesn't change it during a change freeze.
+// It reports whether the service is locked.
+func (s *Service) deferRemoteConfig(desired *UpdateRequest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readOnly == nil {
+		return false
+	}
+	s.remoteConfigDeferred = desired
+	return true
+}
+
+// applyRemoteConfig applies the desired remote configuration
+func (s *Service) applyRemoteConfig(ctx context.Context, desired UpdateRequest) error {
+	if err := s.update(ctx, desired, false, RemoteConfigPrincipal); err != nil {
+		return fmt.Errorf("failed to apply remote config: %w", err)
+	}
+	return nil
+}
+
+// fetchRemoteConfig fetches the remote configuration unless it is unchanged since the last fetch
+func (s *Service) fetchRemoteConfig(ctx context.Context, state *remoteConfigState) error {
+	c := s.opts.remoteConfig
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create remote config request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(HeaderInstance, s.instanceID)
+	if state.etag != "" {
+		req.Header.Set("If-None-Match", state.etag)
+	}
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch remote config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to fetch remote config: %s returned %s: %s", c.URL, resp.Status, bytes.TrimSpace(body))
+	}
+
+	var desired UpdateRequest
+	if err := json.NewDecoder(resp.Body).Decode(&desired); err != nil {
+		return fmt.Errorf("invalid remote config: %w", err)
+	}
+	if err := s.Validate(desired); err != nil {
+		return fmt.Errorf("invalid remote config: %w", err)
+	}
+	state.etag, state.desired = resp.Header.Get("ETag"), &desired
+	return nil
+}
+
+// recordRemoteConfig records the result of the last remote configuration sync
+func (h *serviceHealth) recordRemoteConfig(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remoteConfigErr = err
+}
+
+func (h *serviceHealth) remoteConfigSyncErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.remoteConfigErr
+}
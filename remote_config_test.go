@@ -0,0 +1,38 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRemoteConfigDeferredWhileLocked(t *testing.T) {
+	control := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"period": "7s"}`))
+	}))
+	t.Cleanup(control.Close)
+
+	s := NewService(WithReadOnly("change freeze"), WithRemoteConfig(RemoteConfig{URL: control.URL, Interval: time.Hour}))
+	t.Cleanup(func() { s.Close() })
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	waitFor("the remote config to be deferred", func() bool { return s.Status().RemoteConfigDeferred })
+	if period := s.Config().PeriodNs; period == int64(7*time.Second) {
+		t.Fatal("remote config applied while the service is locked")
+	}
+
+	// Applied on unlock, not on the next fetch an hour later.
+	s.Unlock()
+	waitFor("the remote config to be applied", func() bool { return s.Config().PeriodNs == int64(7*time.Second) })
+	if s.Status().RemoteConfigDeferred {
+		t.Fatal("remote config still deferred after unlock")
+	}
+}
@@ -0,0 +1,272 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Format is a response format of the JSON endpoints, negotiated with the Accept header or ?format=
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatText Format = "text" // key=value pairs on one line, one line per item of lists
+)
+
+// formatContentTypes maps the formats to their response content types
+var formatContentTypes = map[Format]string{
+	FormatJSON: "application/json",
+	FormatYAML: "application/yaml",
+	FormatText: "text/plain; charset=utf-8",
+}
+
+// acceptedFormats maps the media types of the Accept header to formats
+var acceptedFormats = map[string]Format{
+	"application/json":   FormatJSON,
+	"application/yaml":   FormatYAML,
+	"application/x-yaml": FormatYAML,
+	"text/yaml":          FormatYAML,
+	"text/plain":         FormatText,
+}
+
+// negotiateFormat returns the response format of a request: the ?format= parameter,
+// else the first supported media type of the Accept header, else JSON
+func negotiateFormat(r *http.Request) (Format, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		format := Format(strings.ToLower(f))
+		if _, ok := formatContentTypes[format]; !ok {
+			return "", fmt.Errorf("%w: format %q should be json, yaml or text", ErrInvalidRequest, f)
+		}
+		return format, nil
+	}
+
+	for accept := range strings.SplitSeq(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if format, ok := acceptedFormats[mediaType]; ok {
+			return format, nil
+		}
+	}
+	return FormatJSON, nil
+}
+
+// render encodes v in the format and style, from its JSON encoding so all formats share the JSON field names and values
+func render(format Format, style responseStyle, v any) ([]byte, error) {
+	data, err := style.encode(v)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatJSON {
+		return append(data, '\n'), nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := decodeNode(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if format == FormatYAML {
+		writeYAML(&buf, node, 0)
+	} else {
+		writeText(&buf, node)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeResponse writes v with the status code in the format negotiated for the request
+func writeResponse(w http.ResponseWriter, r *http.Request, status int, v any) {
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	data, err := render(format, responseStyleOf(w), v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// jsonNode is a decoded JSON value which keeps the order of object keys
+type jsonNode struct {
+	object bool
+	array  bool
+	keys   []string
+	items  []*jsonNode
+	scalar any // nil, bool, json.Number or string
+}
+
+func decodeNode(dec *json.Decoder) (*jsonNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		node := &jsonNode{object: true}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			item, err := decodeNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.keys = append(node.keys, key.(string))
+			node.items = append(node.items, item)
+		}
+		_, err := dec.Token()
+		return node, err
+
+	case json.Delim('['):
+		node := &jsonNode{array: true}
+		for dec.More() {
+			item, err := decodeNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.items = append(node.items, item)
+		}
+		_, err := dec.Token()
+		return node, err
+
+	default:
+		return &jsonNode{scalar: tok}, nil
+	}
+}
+
+func (n *jsonNode) empty() bool {
+	return (n.object || n.array) && len(n.items) == 0
+}
+
+// plainYAML matches strings which need no quotes in YAML
+var plainYAML = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./:+-]*$`)
+
+func yamlScalar(n *jsonNode) string {
+	switch {
+	case n.object:
+		return "{}"
+	case n.array:
+		return "[]"
+	}
+
+	switch v := n.scalar.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		switch strings.ToLower(v) {
+		case "true", "false", "null", "yes", "no", "on", "off", "y", "n", "~":
+			return strconv.Quote(v)
+		}
+		if plainYAML.MatchString(v) {
+			return v
+		}
+		return strconv.Quote(v)
+	}
+	return fmt.Sprint(n.scalar)
+}
+
+// writeYAML writes the node as a YAML block at the indentation
+func writeYAML(w *bytes.Buffer, n *jsonNode, indent int) {
+	pad := strings.Repeat(" ", indent)
+	if !n.object && !n.array || n.empty() {
+		fmt.Fprintf(w, "%s%s\n", pad, yamlScalar(n))
+		return
+	}
+
+	for i, item := range n.items {
+		prefix := pad + "- "
+		if n.object {
+			prefix = pad + n.keys[i] + ":"
+		}
+
+		if !item.object && !item.array || item.empty() {
+			if n.object {
+				prefix += " "
+			}
+			fmt.Fprintf(w, "%s%s\n", prefix, yamlScalar(item))
+			continue
+		}
+		if n.object {
+			fmt.Fprintln(w, prefix)
+			writeYAML(w, item, indent+2)
+			continue
+		}
+
+		// List items start on the line of the dash
+		var block bytes.Buffer
+		writeYAML(&block, item, indent+2)
+		w.WriteString(prefix)
+		w.Write(block.Bytes()[indent+2:])
+	}
+}
+
+// writeText writes objects as key=value pairs on one line, nested keys joined with dots,
+// and lists with one line per item
+func writeText(w io.Writer, n *jsonNode) {
+	if n.array {
+		for _, item := range n.items {
+			writeText(w, item)
+		}
+		return
+	}
+
+	var pairs []string
+	flattenText(&pairs, "", n)
+	fmt.Fprintln(w, strings.Join(pairs, " "))
+}
+
+func flattenText(pairs *[]string, prefix string, n *jsonNode) {
+	switch {
+	case n.object || n.array:
+		for i, item := range n.items {
+			key := strconv.Itoa(i)
+			if n.object {
+				key = n.keys[i]
+			}
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			flattenText(pairs, key, item)
+		}
+	case prefix == "":
+		*pairs = append(*pairs, textValue(n.scalar))
+	default:
+		*pairs = append(*pairs, prefix+"="+textValue(n.scalar))
+	}
+}
+
+func textValue(v any) string {
+	s := fmt.Sprint(v)
+	if v == nil {
+		return "null"
+	}
+	if str, ok := v.(string); ok && (str == "" || strings.ContainsAny(str, " \t\n\"=")) {
+		return strconv.Quote(str)
+	}
+	return s
+}
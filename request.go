@@ -0,0 +1,180 @@
+package flightrecorder
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	rtrace "runtime/trace"
+	"sync"
+	"time"
+)
+
+// RequestTrigger is the trigger of the snapshots of requests recorded by RecordRequests
+const RequestTrigger = "request"
+
+const (
+	// defaultRequestCooldown and defaultRequestsPerHour bound the snapshots clients can ask for with the header
+	defaultRequestCooldown = 10 * time.Second
+	defaultRequestsPerHour = 60
+)
+
+// Headers of request-scoped recording
+const (
+	HeaderFlightRecord   = "X-Flight-Record"    // "1" asks RecordRequests to record the request
+	HeaderFlightRecordID = "X-Flight-Record-Id" // ID of a recorded request, set on its response
+	HeaderRequestID      = "X-Request-Id"       // ID of a request, used by RecordRequests when set
+)
+
+// RequestRecording configures RecordRequests
+type RequestRecording struct {
+	// Match selects the requests to record (default requests with the header X-Flight-Record: 1).
+	// The header is sent by untrusted clients, so public services should also check who sent it.
+	Match func(*http.Request) bool
+	// RequestID returns the ID of a request (default its X-Request-Id header, else a generated ID)
+	RequestID func(*http.Request) string
+	// Cooldown is the minimum time between two recorded requests, on top of WithTriggerCooldown and WithTriggerBudget
+	// (default 10s, negative disables it)
+	Cooldown time.Duration
+	// MaxPerHour is the maximum number of recorded requests per hour (default 60, negative for no limit
+	// beyond WithTriggerBudget)
+	MaxPerHour int
+}
+
+// RecordedRequest is the request a snapshot of RecordRequests was taken for
+type RecordedRequest struct {
+	ID       string   `json:"id"`
+	Method   string   `json:"method"`
+	Path     string   `json:"path"`
+	Status   int      `json:"status"`
+	Duration Duration `json:"duration"`
+}
+
+// requestRecordings counts the recorded requests in flight, and whether RecordRequests started the recorder for them
+type requestRecordings struct {
+	mu       sync.Mutex
+	inFlight int
+	started  bool
+}
+
+// RecordRequests returns middleware recording the requests selected by rr, for reproducing slow endpoints:
+// the recorder is started when a matching request begins, if it isn't running, and a snapshot is taken
+// when the request completes, with the "request" trigger and the request in its metadata. The request
+// runs in a trace task named after it, and its response carries its ID in the X-Flight-Record-Id header.
+// The recorder is stopped again once no recorded request is in flight, if the middleware started it.
+// Recorded requests are subject to Cooldown, MaxPerHour and the trigger cooldowns and budget, as clients
+// choose to be recorded.
+func (s *Service) RecordRequests(rr RequestRecording) func(http.Handler) http.Handler {
+	match := rr.Match
+	if match == nil {
+		match = func(r *http.Request) bool { return r.Header.Get(HeaderFlightRecord) == "1" }
+	}
+	requestID := rr.RequestID
+	if requestID == nil {
+		requestID = func(r *http.Request) string { return r.Header.Get(HeaderRequestID) }
+	}
+	cooldown := rr.Cooldown
+	if cooldown == 0 {
+		cooldown = defaultRequestCooldown
+	}
+	perHour := rr.MaxPerHour
+	if perHour == 0 {
+		perHour = defaultRequestsPerHour
+	}
+	limit := &hourlyLimit{max: perHour}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !match(r) || s.gateRefuses() {
+				h.ServeHTTP(w, r)
+				return
+			}
+			now := time.Now()
+			if !limit.allow(now) || !s.limiter.allow(RequestTrigger, cooldown, s.opts, now) || !s.beginRequestRecording() {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			id := requestID(r)
+			if id == "" {
+				id = s.newRequestID()
+			}
+			w.Header().Set(HeaderFlightRecordID, id)
+
+			ctx, task := rtrace.NewTask(r.Context(), "http "+r.Method+" "+r.URL.Path)
+			rtrace.Log(ctx, "request_id", id)
+			rec := &statusRecorder{ResponseWriter: w}
+			start := time.Now()
+			// Requests which panic are captured too, and release the recorder.
+			defer func() {
+				task.End()
+				request := RecordedRequest{
+					ID:       id,
+					Method:   r.Method,
+					Path:     r.URL.Path,
+					Status:   rec.code,
+					Duration: Duration(time.Since(start)),
+				}
+				if request.Status == 0 {
+					request.Status = http.StatusOK
+				}
+				// The snapshot is taken in the background, so it doesn't delay the end of the response.
+				captured := s.goBackground(func() {
+					defer s.endRequestRecording()
+					s.captureRequest(RequestTrigger, request)
+				})
+				if !captured {
+					s.endRequestRecording()
+				}
+			}()
+			h.ServeHTTP(rec, r.WithContext(ctx))
+		})
+	}
+}
+
+// newRequestID generates the ID of a recorded request without one
+func (s *Service) newRequestID() string {
+	return fmt.Sprintf("%s-%04d", time.Now().UTC().Format("20060102T150405Z"), s.requestSeq.Add(1))
+}
+
+// captureRequest captures the snapshot of a recorded request with the trigger and publishes the outcome
+func (s *Service) captureRequest(trigger string, request RecordedRequest) (SnapshotMeta, error) {
+	e := Event{Type: EventTriggerFired, Trigger: trigger}
+	meta, err := s.capture(s.ctx, trigger, &request)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if meta.ID != "" {
+		e.Snapshot = &meta
+	}
+	s.publish(e)
+	return meta, err
+}
+
+// beginRequestRecording starts the recorder for a recorded request if it isn't running,
+// it reports whether the request can be recorded
+func (s *Service) beginRequestRecording() bool {
+	s.requests.mu.Lock()
+	defer s.requests.mu.Unlock()
+
+	if s.requests.inFlight == 0 {
+		err := s.Start()
+		if err != nil && !errors.Is(err, ErrAlreadyRunning) {
+			return false
+		}
+		s.requests.started = err == nil
+	}
+	s.requests.inFlight++
+	return true
+}
+
+// endRequestRecording stops the recorder once the last recorded request in flight is captured, if it was started for them
+func (s *Service) endRequestRecording() {
+	s.requests.mu.Lock()
+	defer s.requests.mu.Unlock()
+
+	s.requests.inFlight--
+	if s.requests.inFlight == 0 && s.requests.started {
+		s.requests.started = false
+		s.Stop()
+	}
+}
@@ -0,0 +1,28 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecordRequestsDefaultCooldown(t *testing.T) {
+	s := NewService()
+	t.Cleanup(func() { s.Close() })
+	record := s.RecordRequests(RequestRecording{})
+	h := record(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	recorded := 0
+	for range 5 {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/checkout", nil)
+		r.Header.Set(HeaderFlightRecord, "1")
+		h.ServeHTTP(w, r)
+		if w.Header().Get(HeaderFlightRecordID) != "" {
+			recorded++
+		}
+	}
+	if recorded != 1 {
+		t.Fatalf("recorded %d of 5 requests sent at once, want 1 within the default cooldown", recorded)
+	}
+}
@@ -0,0 +1,124 @@
+package flightrecorder
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Route is an endpoint of the HTTP API, so adapters can register the handlers on other routers
+type Route struct {
+	Method  string           // HTTP method of the route
+	Path    string           // path below the prefix, with {name} wildcards, e.g. /snapshots/{id}
+	Admin   bool             // whether the route mutates the recorder or is expensive to serve
+	Handler http.HandlerFunc // reads wildcards with Request.PathValue
+}
+
+// Params returns the names of the wildcards of the route's path
+func (r Route) Params() []string {
+	var params []string
+	for segment := range strings.SplitSeq(r.Path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, strings.TrimSuffix(segment[1:len(segment)-1], "..."))
+		}
+	}
+	return params
+}
+
+// Routes returns the endpoints of the HTTP API under /v1, followed by their unversioned aliases,
+// without the endpoints disabled by WithDisabledEndpoints.
+// Routes that are not admin routes are the read-only endpoints registered by RegisterReadHandlers.
+func (s *Service) Routes() []Route {
+	return s.instanceRoutes(s.instrumentRoutes(s.styleRoutes(s.allowlistRoutes(s.corsRoutes(s.methodRoutes(s.lockRoutes(s.quotaRoutes(s.deprecateRoutes(s.disableRoutes(versionRoutes(s.routes())))))))))))
+}
+
+// routes returns the endpoints of the HTTP API, without the version
+func (s *Service) routes() []Route {
+	routes := []Route{
+		{http.MethodGet, "/status", false, s.handleStatus},
+		{http.MethodPost, "/start", true, s.handleStart},
+		{http.MethodPost, "/stop", true, s.handleStop},
+		{http.MethodPost, "/clear", true, s.handleClear},
+		{http.MethodPost, "/lock", true, s.handleLock},
+		{http.MethodPost, "/unlock", true, s.handleUnlock},
+		{http.MethodGet, "/snapshot", false, s.handleSnapshot},
+		{http.MethodPost, "/update", true, s.handleUpdate},
+		{http.MethodGet, "/config", false, s.handleConfig},
+		{http.MethodPatch, "/config", true, s.handleConfig},
+		{http.MethodPut, "/config", true, s.handleConfig},
+		{http.MethodGet, "/config/history", false, s.handleConfigHistory},
+		{http.MethodPost, "/log", true, s.handleLog},
+		{http.MethodPost, "/mark", true, s.handleMark},
+		{http.MethodGet, "/bundle", false, s.handleBundle},
+		{http.MethodGet, "/snapshots", false, s.handleSnapshots},
+		{http.MethodPost, "/snapshots", true, s.handleSnapshots},
+		{http.MethodDelete, "/snapshots", true, s.handleSnapshots},
+		{http.MethodGet, "/snapshots/{id}", false, s.handleStoredSnapshot},
+		{http.MethodDelete, "/snapshots/{id}", true, s.handleStoredSnapshot},
+		{http.MethodGet, "/snapshots/{id}/url", false, s.handleSignedURL},
+		{http.MethodGet, "/snapshots/{id}/metrics", false, s.handleSnapshotMetrics},
+		{http.MethodGet, "/snapshots/{id}/pprof", false, s.handleSnapshotProfile},
+		{http.MethodGet, "/snapshots/{id}/flame", false, s.handleSnapshotFlamegraph},
+		{http.MethodGet, "/analysis/goroutine-growth", false, s.handleGoroutineGrowth},
+		{http.MethodGet, "/sessions", false, s.handleSessions},
+		{http.MethodPost, "/sessions", true, s.handleSessions},
+		{http.MethodGet, "/events", false, s.handleEvents},
+		{http.MethodGet, "/overhead", true, s.handleOverhead},
+		{http.MethodPost, "/overhead", true, s.handleOverhead},
+		{http.MethodGet, "/healthz", false, s.handleHealthz},
+		{http.MethodGet, "/readyz", false, s.handleReadyz},
+		{http.MethodGet, "/openapi.json", false, s.handleOpenAPI},
+		{http.MethodGet, "/metrics", false, s.handleMetrics},
+		{http.MethodGet, "/grafana/", false, s.handleGrafana},
+		{http.MethodPost, "/grafana/metrics", false, s.handleGrafanaMetrics},
+		{http.MethodPost, "/grafana/query", false, s.handleGrafanaQuery},
+		{http.MethodPost, "/grafana/annotations", false, s.handleGrafanaAnnotations},
+	}
+	return append(routes, s.faultRoutes()...)
+}
+
+// RegisterHandlers registers the flight recorder HTTP handlers to the given mux
+func (s *Service) RegisterHandlers(mux *http.ServeMux) {
+	s.RegisterHandlersWithPrefix(mux, "/recorder")
+}
+
+// RegisterHandlersWithPrefix registers the flight recorder HTTP handlers with a custom prefix.
+// Each path is registered for all methods, the handlers reject the methods they don't support.
+func (s *Service) RegisterHandlersWithPrefix(mux *http.ServeMux, prefix string) {
+	registered := make(map[string]bool)
+	for _, route := range s.Routes() {
+		if !registered[route.Path] {
+			registered[route.Path] = true
+			mux.HandleFunc(prefix+route.Path, route.Handler)
+		}
+	}
+}
+
+// RegisterReadHandlers registers the read-only flight recorder HTTP handlers
+// (status, configuration and its history, snapshot, bundle, events, health, stored snapshot downloads and signed download URLs, metrics, profiles and flame graphs, goroutine growth, session history, OpenAPI document, handler metrics, Grafana data source) to the given mux
+func (s *Service) RegisterReadHandlers(mux *http.ServeMux) {
+	s.RegisterReadHandlersWithPrefix(mux, "/recorder")
+}
+
+// RegisterReadHandlersWithPrefix registers the read-only flight recorder HTTP handlers with a custom prefix
+func (s *Service) RegisterReadHandlersWithPrefix(mux *http.ServeMux, prefix string) {
+	for _, route := range s.Routes() {
+		if !route.Admin {
+			mux.HandleFunc(route.Method+" "+prefix+route.Path, route.Handler)
+		}
+	}
+}
+
+// RegisterAdminHandlers registers the mutating flight recorder HTTP handlers
+// (start, stop, clear, lock, unlock, update, configuration changes, log, mark, overhead measurement, session creation and stored snapshot capture and deletion) to the given mux
+func (s *Service) RegisterAdminHandlers(mux *http.ServeMux) {
+	s.RegisterAdminHandlersWithPrefix(mux, "/recorder")
+}
+
+// RegisterAdminHandlersWithPrefix registers the mutating flight recorder HTTP handlers with a custom prefix
+func (s *Service) RegisterAdminHandlersWithPrefix(mux *http.ServeMux, prefix string) {
+	for _, route := range s.Routes() {
+		if route.Admin {
+			mux.HandleFunc(route.Method+" "+prefix+route.Path, route.Handler)
+		}
+	}
+}
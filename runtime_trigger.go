@@ -0,0 +1,173 @@
+package flightrecorder
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"time"
+)
+
+const (
+	// gcPauseMetric is the runtime metric for GC stop-the-world pauses,
+	// the successor of /gc/pauses:seconds
+	gcPauseMetric = "/sched/pauses/total/gc:seconds"
+	// schedLatencyMetric is the runtime metric for time goroutines spend runnable before running
+	schedLatencyMetric = "/sched/latencies:seconds"
+
+	defaultRuntimeTriggerInterval   = 10 * time.Second
+	defaultRuntimeTriggerPercentile = 0.99
+)
+
+// Trigger names recorded on snapshots captured by the runtime trigger
+const (
+	GCPauseTrigger      = "gc-pause"
+	SchedLatencyTrigger = "sched-latency"
+)
+
+// RuntimeTrigger captures a snapshot when GC pause or scheduler latency percentiles,
+// sampled from runtime/metrics over each interval, exceed their thresholds.
+type RuntimeTrigger struct {
+	GCPause      time.Duration // GC pause threshold, 0 disables
+	SchedLatency time.Duration // scheduler latency threshold, 0 disables
+	Percentile   float64       // percentile compared against the thresholds (default 0.99)
+	Interval     time.Duration // sampling interval (default 10s)
+	Cooldown     time.Duration // minimum time between two snapshots of each runtime trigger
+}
+
+func (t RuntimeTrigger) enabled() bool {
+	return t.GCPause > 0 || t.SchedLatency > 0
+}
+
+// WithRuntimeTrigger sets the runtime metrics trigger.
+// Thresholds can later be changed with Update.
+func WithRuntimeTrigger(t RuntimeTrigger) Option {
+	return func(o *options) {
+		o.runtimeTrigger = t
+	}
+}
+
+// runtimeSampler computes latency percentiles over the interval between two samples
+type runtimeSampler struct {
+	samples []metrics.Sample
+	prev    map[string][]uint64
+}
+
+func newRuntimeSampler() *runtimeSampler {
+	return &runtimeSampler{
+		samples: []metrics.Sample{
+			{Name: gcPauseMetric},
+			{Name: schedLatencyMetric},
+		},
+		prev: make(map[string][]uint64),
+	}
+}
+
+// sample reads the metrics and returns the percentile of each metric since the previous sample.
+// Metrics without observations in the interval are omitted.
+func (rs *runtimeSampler) sample(percentile float64) map[string]time.Duration {
+	metrics.Read(rs.samples)
+
+	result := make(map[string]time.Duration)
+	for _, sample := range rs.samples {
+		if sample.Value.Kind() != metrics.KindFloat64Histogram {
+			continue
+		}
+		hist := sample.Value.Float64Histogram()
+
+		prev := rs.prev[sample.Name]
+		delta := make([]uint64, len(hist.Counts))
+		for i, count := range hist.Counts {
+			delta[i] = count
+			if i < len(prev) {
+				delta[i] -= prev[i]
+			}
+		}
+		rs.prev[sample.Name] = append(prev[:0], hist.Counts...)
+
+		if prev == nil {
+			// The first sample covers the whole process lifetime.
+			continue
+		}
+		if seconds, ok := histogramPercentile(delta, hist.Buckets, percentile); ok {
+			result[sample.Name] = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return result
+}
+
+// histogramPercentile returns the upper bound of the bucket holding the percentile
+func histogramPercentile(counts []uint64, buckets []float64, percentile float64) (float64, bool) {
+	var total uint64
+	for _, count := range counts {
+		total += count
+	}
+	if total == 0 {
+		return 0, false
+	}
+
+	target := uint64(math.Ceil(percentile * float64(total)))
+	var cumulative uint64
+	for i, count := range counts {
+		cumulative += count
+		if cumulative >= target {
+			if math.IsInf(buckets[i+1], 1) {
+				return buckets[i], true
+			}
+			return buckets[i+1], true
+		}
+	}
+	return buckets[len(buckets)-1], true
+}
+
+// startRuntimeTrigger starts the runtime metrics sampler once
+func (s *Service) startRuntimeTrigger() {
+	s.runtimeTriggerOnce.Do(func() {
+		if s.goBackground(func() { s.runRuntimeTrigger(s.ctx) }) {
+			s.health.runtimeTrigger.Store(true)
+		}
+	})
+}
+
+// runRuntimeTrigger samples runtime metrics until the service context is done
+func (s *Service) runRuntimeTrigger(ctx context.Context) {
+	defer s.health.runtimeTrigger.Store(false)
+
+	interval := s.opts.runtimeTrigger.Interval
+	if interval <= 0 {
+		interval = defaultRuntimeTriggerInterval
+	}
+	percentile := s.opts.runtimeTrigger.Percentile
+	if percentile <= 0 || percentile > 1 {
+		percentile = defaultRuntimeTriggerPercentile
+	}
+
+	sampler := newRuntimeSampler()
+	sampler.sample(percentile)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.RLock()
+		thresholds := s.runtimeTrigger
+		enabled := s.recorder.Enabled()
+		s.mu.RUnlock()
+
+		latencies := sampler.sample(percentile)
+		if !enabled || !thresholds.enabled() {
+			continue
+		}
+
+		if pause, ok := latencies[gcPauseMetric]; ok && thresholds.GCPause > 0 && pause > thresholds.GCPause {
+			s.fireTrigger(GCPauseTrigger, s.opts.runtimeTrigger.Cooldown)
+		} else if latency, ok := latencies[schedLatencyMetric]; ok && thresholds.SchedLatency > 0 && latency > thresholds.SchedLatency {
+			s.fireTrigger(SchedLatencyTrigger, s.opts.runtimeTrigger.Cooldown)
+		}
+	}
+}
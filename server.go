@@ -0,0 +1,196 @@
+package flightrecorder
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// serverReadHeaderTimeout bounds reading request headers on the dedicated server
+	serverReadHeaderTimeout = 10 * time.Second
+	// defaultUnixSocketMode only allows the owner of the process to use the socket
+	defaultUnixSocketMode os.FileMode = 0600
+)
+
+// listenOptions configures the listener of the dedicated server
+type listenOptions struct {
+	tlsCertFile string
+	tlsKeyFile  string
+	unixSocket  string
+	socketMode  os.FileMode
+
+	clientCAFile string
+	tlsConfig    *tls.Config
+}
+
+// WithTLS serves the dedicated server started by ListenAndServe over TLS.
+// TLS also allows it to listen on non-loopback addresses. The certificate and key
+// are reloaded when the files change, so rotated certificates apply without a restart.
+func WithTLS(certFile, keyFile string) Option {
+	return func(o *options) {
+		o.listen.tlsCertFile = certFile
+		o.listen.tlsKeyFile = keyFile
+	}
+}
+
+// WithUnixSocket makes ListenAndServe listen on a Unix socket at path instead of a TCP address,
+// for hosts where exposing another TCP port is prohibited. Access is controlled by the socket's
+// file permissions, by default only the user running the process (0600).
+func WithUnixSocket(path string) Option {
+	return func(o *options) {
+		o.listen.unixSocket = path
+	}
+}
+
+// WithUnixSocketMode sets the file permissions of the Unix socket, e.g. 0660 to allow
+// the group owning the socket directory (with the setgid bit) to use the control API.
+func WithUnixSocketMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.listen.socketMode = mode
+	}
+}
+
+// ListenAndServe starts the global service's flight recorder and serves its endpoints under /recorder
+// on a dedicated HTTP server, so applications without a mux can add recording in one line:
+//
+//	go flightrecorder.ListenAndServe("localhost:6061")
+//
+// The server only listens on loopback addresses (an empty host means 127.0.0.1) unless TLS is
+// configured with WithTLS, which WithClientCA extends to mutual TLS. With WithUnixSocket it listens
+// on the socket and addr is ignored.
+// Options configure the global service as with InitService.
+func ListenAndServe(addr string, opts ...Option) error {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var certs *certReloader
+	if o.listen.tlsCertFile != "" {
+		var err error
+		if certs, err = newCertReloader(o.listen.tlsCertFile, o.listen.tlsKeyFile, o.listen.clientCAFile); err != nil {
+			return err
+		}
+	} else if o.listen.clientCAFile != "" {
+		return errors.New("client certificate verification requires TLS")
+	}
+
+	ln, err := listen(addr, o.listen)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	s := InitService(opts...)
+	if err := s.Start(); err != nil && !errors.Is(err, ErrAlreadyRunning) {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: serverReadHeaderTimeout}
+
+	if certs != nil {
+		certs.onReload = s.health.recordTLSReload
+		s.health.recordTLSReload(nil)
+		server.TLSConfig = certs.tlsConfig(o.listen.tlsConfig)
+		return server.ServeTLS(ln, "", "")
+	}
+	return server.Serve(ln)
+}
+
+// listen creates the listener of the dedicated server
+func listen(addr string, o listenOptions) (net.Listener, error) {
+	if o.unixSocket != "" {
+		return listenUnix(o.unixSocket, o.socketMode)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if o.tlsCertFile == "" && !isLoopback(host) {
+		return nil, fmt.Errorf("refusing to listen on %s without TLS: only loopback addresses are allowed", host)
+	}
+	return net.Listen("tcp", net.JoinHostPort(host, port))
+}
+
+// listenUnix listens on a Unix socket with the file permissions, replacing a stale socket
+// left behind by a previous process but refusing to take over a socket still in use
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		mode = defaultUnixSocketMode
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("refusing to replace %s: not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	// The socket is created with the umask's permissions, so it is created in a directory only the
+	// process can access and moved into place once its permissions are set.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".flightrecorder-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	defer os.Remove(dir)
+	tmp := filepath.Join(dir, "socket")
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, mode); err != nil {
+		ln.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to move socket into place: %w", err)
+	}
+	return &unixListener{UnixListener: ln, path: path}, nil
+}
+
+// unixListener is a Unix socket listener moved to path, which it removes when closed
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,51 @@
+package flightrecorder
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "recorder.sock")
+
+	ln, err := listenUnix(path, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0640 {
+		t.Fatalf("socket mode = %v, want socket with 0640", info.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("directory has %d entries, want only the socket", len(entries))
+	}
+	if got := ln.Addr().String(); got != path {
+		t.Fatalf("Addr() = %q, want %q", got, path)
+	}
+
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if _, err := listenUnix(path, 0); err == nil {
+		t.Fatal("listening on a socket in use succeeded")
+	}
+
+	ln.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket not removed on close: %v", err)
+	}
+}
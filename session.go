@@ -0,0 +1,341 @@
+package flightrecorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// SessionTrigger is the trigger name of snapshots captured when a recording session ends
+const SessionTrigger = "session"
+
+// maxSessions bounds the ended sessions kept in the session history
+const maxSessions = 100
+
+// StartRequest is the optional payload of the start request. With a duration, the recording
+// is a session: the recorder stops by itself once the duration elapses, so it isn't left on
+// after debugging, and with Snapshot captures a snapshot first.
+type StartRequest struct {
+	Duration time.Duration `json:"duration,omitempty"`
+	Snapshot bool          `json:"snapshot,omitempty"`
+}
+
+// SessionRequest is the payload of the session creation request: who records and why,
+// for accountability of ad-hoc debugging. Without duration the session lasts until the recorder is stopped.
+type SessionRequest struct {
+	Name      string        `json:"name"`
+	Owner     string        `json:"owner,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	TicketURL string        `json:"ticket_url,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	Snapshot  bool          `json:"snapshot,omitempty"`
+}
+
+// Session is a recording session, from starting the recorder to stopping it
+type Session struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	TicketURL string    `json:"ticket_url,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Until     time.Time `json:"until,omitzero"`     // when the recorder stops, zero for sessions ended by Stop
+	EndedAt   time.Time `json:"ended_at,omitzero"`  // zero while the session is in progress
+	Snapshot  bool      `json:"snapshot,omitempty"` // whether a snapshot is captured when the session ends
+	// Snapshots are the IDs of the snapshots stored during the session
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+// recordingSession is the session in progress, done is closed when it ends
+type recordingSession struct {
+	Session
+	done chan struct{}
+}
+
+// MarshalJSON marshals the start request payload, with the duration as a Go duration
+func (r StartRequest) MarshalJSON() ([]byte, error) {
+	type Alias struct {
+		Duration string `json:"duration,omitempty"`
+		Snapshot bool   `json:"snapshot,omitempty"`
+	}
+	t := Alias{Snapshot: r.Snapshot}
+	if r.Duration > 0 {
+		t.Duration = r.Duration.String()
+	}
+	return json.Marshal(t)
+}
+
+// UnmarshalJSON unmarshals the start request payload
+func (r *StartRequest) UnmarshalJSON(data []byte) error {
+	type Alias struct {
+		Duration *string `json:"duration"`
+		Snapshot bool    `json:"snapshot"`
+	}
+	var t Alias
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+	duration, err := parseSessionDuration(t.Duration)
+	*r = StartRequest{Duration: duration, Snapshot: t.Snapshot}
+	return err
+}
+
+// MarshalJSON marshals the session request payload, with the duration as a Go duration
+func (r SessionRequest) MarshalJSON() ([]byte, error) {
+	type Alias SessionRequest
+	t := struct {
+		Alias
+		Duration string `json:"duration,omitempty"`
+	}{Alias: Alias(r)}
+	if r.Duration > 0 {
+		t.Duration = r.Duration.String()
+	}
+	return json.Marshal(t)
+}
+
+// UnmarshalJSON unmarshals the session request payload
+func (r *SessionRequest) UnmarshalJSON(data []byte) error {
+	type Alias SessionRequest
+	var t struct {
+		Alias
+		Duration *string `json:"duration"`
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return err
+	}
+	duration, err := parseSessionDuration(t.Duration)
+	*r = SessionRequest(t.Alias)
+	r.Duration = duration
+	return err
+}
+
+func parseSessionDuration(s *string) (time.Duration, error) {
+	if s == nil {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(*s)
+	if err != nil {
+		return 0, &ConfigError{Field: "duration", Message: fmt.Sprintf("%s should be a duration (e.g. 10m, 1h)", *s)}
+	}
+	return duration, nil
+}
+
+// validate checks the session request, named sessions require a name
+func (r SessionRequest) validate(named bool) error {
+	if named && r.Name == "" {
+		return &ConfigError{Field: "name", Message: "is required"}
+	}
+	if r.Duration < 0 || (!named && r.Duration == 0) {
+		return &ConfigError{Field: "duration", Message: fmt.Sprintf("%s must be positive", r.Duration)}
+	}
+	if r.TicketURL != "" {
+		u, err := url.Parse(r.TicketURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &ConfigError{Field: "ticket_url", Message: fmt.Sprintf("%s should be an http or https URL", r.TicketURL)}
+		}
+	}
+	return nil
+}
+
+// StartSession starts the flight recorder for the duration, after which it is stopped,
+// capturing a snapshot into the store (and sink) first with snapshot. Stop ends the session early.
+func (s *Service) StartSession(duration time.Duration, snapshot bool) error {
+	req := SessionRequest{Duration: duration, Snapshot: snapshot}
+	if err := req.validate(false); err != nil {
+		return err
+	}
+	_, err := s.startSession(req)
+	return err
+}
+
+// CreateSession starts the flight recorder in a named session, see SessionRequest.
+// Snapshots taken during the session carry its ID, and it is kept in the session history once ended.
+func (s *Service) CreateSession(req SessionRequest) (Session, error) {
+	if err := req.validate(true); err != nil {
+		return Session{}, err
+	}
+	return s.startSession(req)
+}
+
+func (s *Service) startSession(req SessionRequest) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.startLocked(); err != nil {
+		return Session{}, err
+	}
+	now := time.Now()
+	session := Session{
+		ID:        fmt.Sprintf("%s-%04d", now.UTC().Format("20060102T150405Z"), s.sessionSeq.Add(1)),
+		Name:      req.Name,
+		Owner:     req.Owner,
+		Reason:    req.Reason,
+		TicketURL: req.TicketURL,
+		StartedAt: now,
+		Snapshot:  req.Snapshot,
+	}
+	if req.Duration > 0 {
+		session.Until = now.Add(req.Duration)
+	}
+	s.startSessionLocked(session)
+	s.saveStateLocked()
+	s.publishStatusLocked(EventStarted)
+	return session, nil
+}
+
+// startSessionLocked makes the session the session in progress, ending it at its end time, s.mu must be held
+func (s *Service) startSessionLocked(session Session) {
+	current := &recordingSession{Session: session, done: make(chan struct{})}
+	s.session = current
+	s.goBackground(func() { s.runSession(s.ctx, current) })
+}
+
+// endSessionLocked ends the session in progress, if any, and moves it to the history, s.mu must be held
+func (s *Service) endSessionLocked() {
+	if s.session == nil {
+		return
+	}
+	close(s.session.done)
+	ended := s.session.Session
+	ended.EndedAt = time.Now()
+	s.sessions = append(s.sessions, ended)
+	if len(s.sessions) > maxSessions {
+		s.sessions = slices.Delete(s.sessions, 0, len(s.sessions)-maxSessions)
+	}
+	s.session = nil
+}
+
+// runSession stops the recorder when the session ends, unless it was ended otherwise or ctx is done
+func (s *Service) runSession(ctx context.Context, session *recordingSession) {
+	var expired <-chan time.Time
+	if !session.Until.IsZero() {
+		timer := time.NewTimer(time.Until(session.Until))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-session.done:
+		return
+	case <-expired:
+	}
+
+	if session.Snapshot {
+		e := Event{Type: EventTriggerFired, Trigger: SessionTrigger}
+		meta, err := s.Capture(SessionTrigger)
+		if err != nil {
+			e.Error = err.Error()
+		}
+		if meta.ID != "" {
+			e.Snapshot = &meta
+		}
+		s.publish(e)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The session may have been ended meanwhile, by Stop or a new session.
+	if s.session != session {
+		return
+	}
+	s.endSessionLocked()
+	if s.recorder.Enabled() {
+		s.recorder.Stop()
+	}
+	s.saveStateLocked()
+	s.publishStatusLocked(EventStopped)
+}
+
+// sessionStatus returns the session in progress, nil without session, s.mu must be held
+func (s *Service) sessionStatus() *Session {
+	if s.session == nil {
+		return nil
+	}
+	session := s.session.Session
+	session.Snapshots = slices.Clone(session.Snapshots)
+	return &session
+}
+
+// sessionIDLocked returns the ID of the session in progress, "" without session, s.mu must be held
+func (s *Service) sessionIDLocked() string {
+	if s.session == nil {
+		return ""
+	}
+	return s.session.ID
+}
+
+// recordSessionSnapshot adds a stored snapshot to the session it was taken in
+func (s *Service) recordSessionSnapshot(meta SnapshotMeta) {
+	if meta.Session == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.session != nil && s.session.ID == meta.Session {
+		s.session.Snapshots = append(s.session.Snapshots, meta.ID)
+		return
+	}
+	for i := range slices.Backward(s.sessions) {
+		if s.sessions[i].ID == meta.Session {
+			s.sessions[i].Snapshots = append(s.sessions[i].Snapshots, meta.ID)
+			return
+		}
+	}
+}
+
+// Sessions returns the recent sessions, oldest first, including the session in progress
+func (s *Service) Sessions() []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]Session, 0, len(s.sessions)+1)
+	for _, session := range s.sessions {
+		session.Snapshots = slices.Clone(session.Snapshots)
+		sessions = append(sessions, session)
+	}
+	if current := s.sessionStatus(); current != nil {
+		sessions = append(sessions, *current)
+	}
+	return sessions
+}
+
+func (s *Service) handleSessions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeCached(w, r, s.Sessions())
+
+	case http.MethodPost:
+		var req SessionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				err = fmt.Errorf("%w: invalid JSON payload", ErrInvalidRequest)
+			}
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		session, err := s.CreateSession(req)
+		if errors.Is(err, ErrNotLeader) {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeResponse(w, r, http.StatusCreated, session)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,103 @@
+package flightrecorder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ShutdownTrigger is the trigger of the snapshot taken on shutdown by WithSnapshotOnShutdown
+const ShutdownTrigger = "shutdown"
+
+// shutdownSignals are the signals Run shuts the service down on
+var shutdownSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+
+// defaultShutdownSnapshotTimeout bounds the shutdown snapshot when the context of Run is done
+const defaultShutdownSnapshotTimeout = 10 * time.Second
+
+// WithSnapshotOnShutdown takes a last snapshot when the service is shut down by Run or Shutdown and writes
+// it to sink, so terminated pods still leave their last seconds behind. A nil sink captures the snapshot like
+// Capture instead, written to the sink of the service and queued with WithSinkRetry when its write fails.
+func WithSnapshotOnShutdown(sink Sink) Option {
+	return func(o *options) {
+		o.shutdownSnapshot = true
+		o.shutdownSink = sink
+	}
+}
+
+// Run blocks until ctx is done or the process receives SIGTERM or SIGINT, then shuts the service down
+// with Shutdown. Its error is the error of Shutdown, nil when the service shut down cleanly.
+func (s *Service) Run(ctx context.Context) error {
+	signalCtx, stop := signal.NotifyContext(ctx, shutdownSignals...)
+	defer stop()
+
+	select {
+	case <-signalCtx.Done():
+	case <-s.ctx.Done():
+		// Closed by someone else, there is nothing left to shut down.
+		return nil
+	}
+	if ctx.Err() == nil {
+		s.logger().Info("flight recorder shutting down on signal")
+	}
+
+	// The snapshot is taken after ctx is done, it gets a deadline of its own.
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), defaultShutdownSnapshotTimeout)
+	defer cancel()
+	return s.Shutdown(shutdownCtx)
+}
+
+// Shutdown takes the snapshot of WithSnapshotOnShutdown, ending when ctx is done, and closes the service,
+// for hosts with a graceful shutdown of their own, e.g. registered with http.Server.RegisterOnShutdown.
+// The snapshot is skipped when the recorder isn't running or the recorder gate refuses triggers.
+func (s *Service) Shutdown(ctx context.Context) error {
+	var err error
+	if s.opts.shutdownSnapshot {
+		err = s.snapshotOnShutdown(ctx)
+		if err != nil {
+			s.logger().Warn("flight recorder shutdown snapshot failed", "error", err)
+		}
+	}
+	if closeErr := s.Close(); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+// snapshotOnShutdown takes the shutdown snapshot and writes it to the shutdown sink
+func (s *Service) snapshotOnShutdown(ctx context.Context) error {
+	s.mu.RLock()
+	running := s.recorder.Enabled()
+	s.mu.RUnlock()
+	if !running || s.gateRefuses() {
+		return nil
+	}
+	if s.opts.shutdownSink == nil {
+		meta, err := s.CaptureContext(ctx, ShutdownTrigger)
+		if err != nil {
+			return err
+		}
+		// The store goes away with the process, so the snapshot is lost unless the sink was written.
+		if meta.SinkError != "" {
+			return fmt.Errorf("failed to write snapshot %s to sink: %s", meta.ID, meta.SinkError)
+		}
+		s.logger().Info("flight recorder shutdown snapshot captured", "id", meta.ID, "name", meta.Name)
+		return nil
+	}
+
+	meta, data, err := s.snapshotFor(ctx, ShutdownTrigger, nil)
+	if err != nil {
+		return err
+	}
+	if meta, data, err = s.encryptSnapshot(meta, data); err != nil {
+		return err
+	}
+	if err := s.opts.shutdownSink.Write(ctx, meta, data); err != nil {
+		return fmt.Errorf("failed to write snapshot %s to shutdown sink: %w", meta.ID, err)
+	}
+	s.logger().Info("flight recorder shutdown snapshot written", "id", meta.ID, "name", meta.Name)
+	return nil
+}
@@ -0,0 +1,146 @@
+package flightrecorder
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSignedURLUnsupported is returned when the sink cannot sign download URLs
+var ErrSignedURLUnsupported = errors.New("sink does not support signed download URLs")
+
+const (
+	defaultSignedURLExpiry = 15 * time.Minute
+	maxSignedURLExpiry     = 7 * 24 * time.Hour
+)
+
+// URLSigner is implemented by sinks which can grant time-limited downloads of the snapshots they wrote,
+// so clients download snapshots from the storage instead of through the process. FileSink implements it,
+// sinks writing to object storage (e.g. S3 or GCS) can with the presigning of the storage.
+type URLSigner interface {
+	// SignURL returns a URL downloading the snapshot written by the sink, valid for expiry
+	SignURL(ctx context.Context, meta SnapshotMeta, expiry time.Duration) (string, error)
+}
+
+// SignedURL is a time-limited download URL of a snapshot
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SignedURL returns a download URL of a stored snapshot signed by the sink, valid for expiry.
+// It fails with ErrSignedURLUnsupported unless the sink implements URLSigner.
+func (s *Service) SignedURL(ctx context.Context, id string, expiry time.Duration) (SignedURL, error) {
+	signer, ok := s.opts.sink.(URLSigner)
+	if !ok {
+		return SignedURL{}, ErrSignedURLUnsupported
+	}
+	if expiry <= 0 || expiry > maxSignedURLExpiry {
+		return SignedURL{}, fmt.Errorf("%w: expiry %s should be positive and up to %s", ErrInvalidRequest, expiry, maxSignedURLExpiry)
+	}
+	snap, ok := s.store.get(id)
+	if !ok {
+		return SignedURL{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+
+	expiresAt := time.Now().Add(expiry)
+	url, err := signer.SignURL(ctx, snap.meta, expiry)
+	if err != nil {
+		return SignedURL{}, fmt.Errorf("failed to sign download URL of snapshot %s: %w", id, err)
+	}
+	return SignedURL{URL: url, ExpiresAt: expiresAt}, nil
+}
+
+func (s *Service) handleSignedURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	expiry := defaultSignedURLExpiry
+	if v := r.URL.Query().Get("expires"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%w: expires %q should be a duration", ErrInvalidRequest, v))
+			return
+		}
+		expiry = d
+	}
+
+	signed, err := s.SignedURL(r.Context(), r.PathValue("id"), expiry)
+	if err != nil {
+		code := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrInvalidRequest):
+			code = http.StatusBadRequest
+		case errors.Is(err, ErrSnapshotNotFound):
+			code = http.StatusNotFound
+		case errors.Is(err, ErrSignedURLUnsupported):
+			code = http.StatusNotImplemented
+		}
+		writeError(w, code, err)
+		return
+	}
+	s.recordDownload(r, r.PathValue("id"), DownloadSignedURL)
+	w.Header().Set("Cache-Control", "no-store")
+	writeResponse(w, r, http.StatusOK, signed)
+}
+
+// SignURL returns a URL of the FileServer downloading the snapshot, signed with URLSecret and valid for expiry.
+// It fails with ErrSignedURLUnsupported unless URL and URLSecret are set.
+func (f *FileSink) SignURL(_ context.Context, meta SnapshotMeta, expiry time.Duration) (string, error) {
+	if f.URL == "" || len(f.URLSecret) == 0 {
+		return "", ErrSignedURLUnsupported
+	}
+	name := path.Clean("/" + meta.Name)
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {f.signFile(name, expires)}}
+	return strings.TrimSuffix(f.URL, "/") + (&url.URL{Path: name}).EscapedPath() + "?" + query.Encode(), nil
+}
+
+// FileServer serves the files of Dir to GET and HEAD requests with a valid URL signed by SignURL,
+// answering 403 to unsigned and expired URLs. Mount it at URL, stripping the path of URL:
+//
+//	mux.Handle("/files/", http.StripPrefix("/files", sink.FileServer()))
+func (f *FileSink) FileServer() http.Handler {
+	files := http.FileServer(http.Dir(f.Dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if len(f.URLSecret) == 0 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		name := path.Clean("/" + r.URL.Path)
+		query := r.URL.Query()
+		expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		if err != nil || time.Now().Unix() > expires ||
+			!hmac.Equal([]byte(query.Get("signature")), []byte(f.signFile(name, query.Get("expires")))) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		r.URL.Path = name
+		files.ServeHTTP(w, r)
+	})
+}
+
+// signFile returns the signature of the download URL of a file until expires, in Unix seconds
+func (f *FileSink) signFile(name, expires string) string {
+	mac := hmac.New(sha256.New, f.URLSecret)
+	mac.Write([]byte(name))
+	mac.Write([]byte("\n"))
+	mac.Write([]byte(expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
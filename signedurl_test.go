@@ -0,0 +1,88 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSinkSignedURL(t *testing.T) {
+	sink := NewFileSink(t.TempDir())
+	files := httptest.NewServer(http.StripPrefix("/files", sink.FileServer()))
+	t.Cleanup(files.Close)
+	sink.URL = files.URL + "/files"
+	sink.URLSecret = []byte("secret")
+
+	s := NewService(WithSink(sink))
+	t.Cleanup(func() { s.Close() })
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := s.Capture("manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if data, err = os.ReadFile(filepath.Join(sink.Dir, filepath.FromSlash(meta.Name))); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot not written to the sink: %v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/recorder/snapshots/"+meta.ID+"/url?expires=1m", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /snapshots/{id}/url: got %d: %s", w.Code, w.Body)
+	}
+	var signed SignedURL
+	if err := json.Unmarshal(w.Body.Bytes(), &signed); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(url string) (int, []byte) {
+		t.Helper()
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+	if code, body := get(signed.URL); code != http.StatusOK || !bytes.Equal(body, data) {
+		t.Fatalf("signed URL: got %d with %d bytes, want 200 with the %d bytes of the snapshot", code, len(body), len(data))
+	}
+	if code, _ := get(strings.Replace(signed.URL, "signature=", "signature=0", 1)); code != http.StatusForbidden {
+		t.Fatalf("tampered URL: got %d, want 403", code)
+	}
+	if code, _ := get(strings.Split(signed.URL, "?")[0]); code != http.StatusForbidden {
+		t.Fatalf("unsigned URL: got %d, want 403", code)
+	}
+
+	expired, err := sink.SignURL(t.Context(), meta, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := get(expired); code != http.StatusForbidden {
+		t.Fatalf("expired URL: got %d, want 403", code)
+	}
+
+	sink.URL = ""
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/recorder/snapshots/"+meta.ID+"/url", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("GET /snapshots/{id}/url without URL: got %d, want 501", w.Code)
+	}
+}
@@ -0,0 +1,126 @@
+package flightrecorder
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Headers of the checksum and signature of snapshots, set on downloads and HTTPSink uploads
+const (
+	HeaderSnapshotSHA256    = "X-Snapshot-SHA256"    // hex encoded SHA-256 of the snapshot
+	HeaderSnapshotSignature = "X-Snapshot-Signature" // base64 encoded Ed25519 signature, see WithSnapshotSigning
+)
+
+// SignatureSuffix is appended to the names of the signature files FileSink writes next to signed snapshots
+const SignatureSuffix = ".sig"
+
+// signatureContext prefixes the signed digests, so snapshot signatures can't be replayed as signatures of other messages
+const signatureContext = "flightrecorder-snapshot-v1:"
+
+// ErrSnapshotSignature is returned by VerifySnapshot for unsigned, altered or forged snapshots
+var ErrSnapshotSignature = errors.New("invalid snapshot signature")
+
+// WithSnapshotSigning signs every snapshot with the Ed25519 key, so consumers can verify with the public key
+// that a snapshot was taken by the service and wasn't altered in shared storage. The signature is recorded in
+// the snapshot metadata and sent in HeaderSnapshotSignature, next to the checksum every snapshot has.
+func WithSnapshotSigning(key ed25519.PrivateKey) Option {
+	return func(o *options) {
+		o.signingKey = key
+	}
+}
+
+// SigningKeyFromEnv returns the Ed25519 private key base64 encoded in the environment variable, see DecodeSigningKey
+func SigningKeyFromEnv(name string) (ed25519.PrivateKey, error) {
+	return DecodeSigningKey(os.Getenv(name))
+}
+
+// DecodeSigningKey decodes a base64 encoded Ed25519 private key, either its 32 byte seed or the 64 byte key
+func DecodeSigningKey(encoded string) (ed25519.PrivateKey, error) {
+	key, err := decodeBase64Key(encoded, "signing key")
+	if err != nil {
+		return nil, err
+	}
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	default:
+		return nil, fmt.Errorf("invalid signing key: %d bytes, should be %d or %d", len(key), ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+}
+
+// DecodeVerifyKey decodes a base64 encoded Ed25519 public key
+func DecodeVerifyKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := decodeBase64Key(encoded, "verify key")
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid verify key: %d bytes, should be %d", len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func decodeBase64Key(encoded, kind string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, errors.New(kind + " is not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", kind, err)
+	}
+	return key, nil
+}
+
+// SnapshotChecksum returns the hex encoded SHA-256 of a snapshot, as recorded in its metadata
+func SnapshotChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// SignSnapshot returns the base64 encoded Ed25519 signature of a snapshot, which signs its SHA-256
+func SignSnapshot(key ed25519.PrivateKey, data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, append([]byte(signatureContext), sum[:]...)))
+}
+
+// VerifySnapshot verifies the signature of a snapshot made by SignSnapshot with the matching private key
+func VerifySnapshot(key ed25519.PublicKey, data []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: malformed signature", ErrSnapshotSignature)
+	}
+	sum := sha256.Sum256(data)
+	if !ed25519.Verify(key, append([]byte(signatureContext), sum[:]...), sig) {
+		return fmt.Errorf("%w: snapshot was altered or signed with another key", ErrSnapshotSignature)
+	}
+	return nil
+}
+
+// sealSnapshot records the checksum of the snapshot in its metadata, and its signature with WithSnapshotSigning.
+// It is called again whenever the content changes, e.g. when the snapshot is encrypted or trimmed.
+func (s *Service) sealSnapshot(meta *SnapshotMeta, data []byte) {
+	meta.SHA256 = SnapshotChecksum(data)
+	if s.opts.signingKey != nil {
+		meta.Signature = SignSnapshot(s.opts.signingKey, data)
+	}
+}
+
+// setChecksumHeaders sets the checksum and signature headers of snapshots which record them
+func setChecksumHeaders(h http.Header, meta SnapshotMeta) {
+	if meta.SHA256 != "" {
+		h.Set(HeaderSnapshotSHA256, meta.SHA256)
+	}
+	if meta.Signature != "" {
+		h.Set(HeaderSnapshotSignature, meta.Signature)
+	}
+}
@@ -0,0 +1,73 @@
+package flightrecorder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Sink receives snapshots captured by the service.
+// The snapshot name rendered from the name template is in meta.Name.
+type Sink interface {
+	Write(ctx context.Context, meta SnapshotMeta, data []byte) error
+}
+
+// FileSink writes snapshots as files under a directory.
+// With URL and URLSecret set it signs download URLs of the snapshots, served by its FileServer.
+type FileSink struct {
+	Dir       string
+	URL       string // base URL the FileServer is served at, e.g. https://host/files
+	URLSecret []byte // key of the signed download URLs
+}
+
+// NewFileSink creates a sink writing snapshots under dir
+func NewFileSink(dir string) *FileSink {
+	return &FileSink{Dir: dir}
+}
+
+// Write writes the snapshot to Dir/meta.Name, creating parent directories as needed,
+// and the signature of signed snapshots to Dir/meta.Name+SignatureSuffix
+func (f *FileSink) Write(ctx context.Context, meta SnapshotMeta, data []byte) error {
+	path := filepath.Join(f.Dir, filepath.FromSlash(meta.Name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	// The signature travels with the snapshot, for consumers of the directory to verify it.
+	if meta.Signature != "" {
+		if err := os.WriteFile(path+SignatureSuffix, []byte(meta.Signature+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write snapshot signature: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path,
+// so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeSink writes a snapshot to the sink of the service, unless a sink failure is injected,
+// and returns the results of the sinks of a MultiSink
+func (s *Service) writeSink(ctx context.Context, meta SnapshotMeta, data []byte) ([]SinkResult, error) {
+	if err := s.faults.sinkFault(); err != nil {
+		return nil, err
+	}
+	if multi, ok := s.opts.sink.(*MultiSink); ok {
+		return multi.WriteResults(ctx, meta, data)
+	}
+	return nil, s.opts.sink.Write(ctx, meta, data)
+}
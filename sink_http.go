@@ -0,0 +1,134 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHTTPSinkRetries = 3
+	defaultHTTPSinkBackoff = 500 * time.Millisecond
+)
+
+// Snapshot metadata headers sent by HTTPSink.
+// HeaderSnapshotEvents is also set on snapshot downloads when snapshots are validated,
+// HeaderSnapshotEncrypted ("aes-gcm") when they are encrypted, and HeaderSnapshotGoVersion always.
+const (
+	HeaderSnapshotID        = "X-Snapshot-ID"
+	HeaderSnapshotName      = "X-Snapshot-Name"
+	HeaderSnapshotTrigger   = "X-Snapshot-Trigger"
+	HeaderSnapshotCreatedAt = "X-Snapshot-Created-At"
+	HeaderSnapshotHostname  = "X-Snapshot-Hostname"
+	HeaderSnapshotEvents    = "X-Snapshot-Events"
+	HeaderSnapshotLabels    = "X-Snapshot-Labels" // URL query encoded, e.g. region=eu&service=checkout
+	HeaderSnapshotEncrypted = "X-Snapshot-Encrypted"
+	HeaderSnapshotGoVersion = "X-Snapshot-Go-Version" // Go version of the process which took the snapshot
+)
+
+// HTTPSink POSTs snapshots to a collector URL, with the snapshot metadata in headers.
+// Failed uploads (network errors, 429 and 5xx responses) are retried with exponential backoff.
+type HTTPSink struct {
+	URL         string
+	BearerToken string        // sent as an Authorization bearer token when set
+	Client      *http.Client  // defaults to a client with a 30s timeout
+	MaxRetries  int           // retries after the first attempt (default 3)
+	Backoff     time.Duration // initial backoff, doubled on every retry (default 500ms)
+}
+
+// NewHTTPSink creates a sink uploading snapshots to url
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		URL:        url,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: defaultHTTPSinkRetries,
+		Backoff:    defaultHTTPSinkBackoff,
+	}
+}
+
+// Write uploads the snapshot, retrying transient failures until they succeed,
+// the retries are exhausted or ctx is done.
+func (h *HTTPSink) Write(ctx context.Context, meta SnapshotMeta, data []byte) error {
+	backoff := h.Backoff
+	if backoff <= 0 {
+		backoff = defaultHTTPSinkBackoff
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = h.upload(ctx, meta, data)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= h.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// upload makes a single upload attempt and reports whether a failure is worth retrying
+func (h *HTTPSink) upload(ctx context.Context, meta SnapshotMeta, data []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("failed to create upload request: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(HeaderSnapshotID, meta.ID)
+	req.Header.Set(HeaderSnapshotName, meta.Name)
+	req.Header.Set(HeaderSnapshotTrigger, meta.Trigger)
+	req.Header.Set(HeaderSnapshotCreatedAt, meta.CreatedAt.UTC().Format(time.RFC3339Nano))
+	req.Header.Set(HeaderSnapshotHostname, hostname)
+	if meta.Events > 0 {
+		req.Header.Set(HeaderSnapshotEvents, strconv.Itoa(meta.Events))
+	}
+	if len(meta.Labels) > 0 {
+		req.Header.Set(HeaderSnapshotLabels, encodeLabels(meta.Labels))
+	}
+	if meta.Encrypted {
+		req.Header.Set(HeaderSnapshotEncrypted, encryptionAlgorithm)
+	}
+	if meta.Build != nil {
+		req.Header.Set(HeaderSnapshotGoVersion, meta.Build.GoVersion)
+	}
+	if meta.Instance != "" {
+		req.Header.Set(HeaderInstance, meta.Instance)
+	}
+	setChecksumHeaders(req.Header, meta)
+	if h.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.BearerToken)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("failed to upload snapshot: collector returned %s: %s", resp.Status, bytes.TrimSpace(body))
+}
@@ -0,0 +1,143 @@
+package flightrecorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SinkPolicy is when a snapshot written to several sinks counts as written, see MultiSink
+type SinkPolicy string
+
+const (
+	// SinkAllRequired fails the write unless every sink is written, the failed sinks are retried with WithSinkRetry
+	SinkAllRequired SinkPolicy = "all_required"
+	// SinkBestEffort succeeds once a sink is written, the failures of the others are only reported
+	SinkBestEffort SinkPolicy = "best_effort"
+)
+
+// NamedSink is a sink of a MultiSink, named in the sink results of the snapshot metadata
+type NamedSink struct {
+	Name string
+	Sink Sink
+}
+
+// SinkResult is the result of writing a snapshot to a sink of a MultiSink
+type SinkResult struct {
+	Sink      string    `json:"sink"`
+	WrittenAt time.Time `json:"written_at,omitzero"` // zero while the sink isn't written
+	Error     string    `json:"error,omitempty"`     // error of the last failed write
+}
+
+// MultiSink writes snapshots to several sinks concurrently, e.g. a local directory and a bucket, and reports
+// the result of every sink in the sinks field of the snapshot metadata. Sinks already written according
+// to the metadata are skipped, so retries of WithSinkRetry only write the sinks which failed.
+type MultiSink struct {
+	Sinks  []NamedSink
+	Policy SinkPolicy // default SinkAllRequired
+}
+
+// NewMultiSink creates a sink writing to the sinks with the policy
+func NewMultiSink(policy SinkPolicy, sinks ...NamedSink) *MultiSink {
+	return &MultiSink{Sinks: sinks, Policy: policy}
+}
+
+// WithSinks writes snapshots to several sinks, see MultiSink. It replaces the sink set by WithSink.
+func WithSinks(policy SinkPolicy, sinks ...NamedSink) Option {
+	return WithSink(NewMultiSink(policy, sinks...))
+}
+
+// Write writes the snapshot to the sinks, failing according to the policy
+func (m *MultiSink) Write(ctx context.Context, meta SnapshotMeta, data []byte) error {
+	_, err := m.WriteResults(ctx, meta, data)
+	return err
+}
+
+// WriteResults writes the snapshot to the sinks not written yet according to meta.Sinks, and returns
+// the results of every sink along with the error of the write according to the policy
+func (m *MultiSink) WriteResults(ctx context.Context, meta SnapshotMeta, data []byte) ([]SinkResult, error) {
+	results := make([]SinkResult, len(m.Sinks))
+	var wg sync.WaitGroup
+	for i, sink := range m.Sinks {
+		results[i] = SinkResult{Sink: sink.Name}
+		if written := meta.sinkWrittenAt(sink.Name); !written.IsZero() {
+			results[i].WrittenAt = written
+			continue
+		}
+		wg.Go(func() {
+			if err := sink.Sink.Write(ctx, meta, data); err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].WrittenAt = time.Now()
+		})
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.WrittenAt.IsZero() {
+			errs = append(errs, fmt.Errorf("sink %s: %s", r.Sink, r.Error))
+		}
+	}
+	if len(errs) == 0 || m.Policy == SinkBestEffort && len(errs) < len(results) {
+		return results, nil
+	}
+	return results, errors.Join(errs...)
+}
+
+// Check checks the sinks implementing SinkChecker, failing according to the policy
+func (m *MultiSink) Check(ctx context.Context) error {
+	var errs []error
+	for _, sink := range m.Sinks {
+		if checker, ok := sink.Sink.(SinkChecker); ok {
+			if err := checker.Check(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("sink %s: %w", sink.Name, err))
+			}
+		}
+	}
+	if m.Policy == SinkBestEffort && len(errs) < len(m.Sinks) {
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// SignURL signs a download URL with the first sink implementing URLSigner which wrote the snapshot
+func (m *MultiSink) SignURL(ctx context.Context, meta SnapshotMeta, expiry time.Duration) (string, error) {
+	for _, sink := range m.Sinks {
+		signer, ok := sink.Sink.(URLSigner)
+		if !ok {
+			continue
+		}
+		if len(meta.Sinks) > 0 && meta.sinkWrittenAt(sink.Name).IsZero() {
+			continue
+		}
+		return signer.SignURL(ctx, meta, expiry)
+	}
+	return "", ErrSignedURLUnsupported
+}
+
+// sinkWrittenAt returns when the snapshot was written to the named sink, zero if it wasn't
+func (meta SnapshotMeta) sinkWrittenAt(name string) time.Time {
+	for _, r := range meta.Sinks {
+		if r.Sink == name {
+			return r.WrittenAt
+		}
+	}
+	return time.Time{}
+}
+
+// setSinkResults records the sink results in the metadata of a stored snapshot
+func (st *snapshotStore) setSinkResults(id string, results []SinkResult) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for i, snap := range st.snapshots {
+		if snap.meta.ID == id {
+			st.snapshots[i].meta.Sinks = results
+			return
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProfileBackend is a continuous profiling server ProfileSink pushes to
+type ProfileBackend string
+
+const (
+	Pyroscope ProfileBackend = "pyroscope" // Pyroscope ingestion API, POST /ingest
+	Parca     ProfileBackend = "parca"     // Parca profile store API, POST /profiles/writeraw
+)
+
+// ProfileType is a profile derived from snapshots by ProfileSink
+type ProfileType string
+
+const (
+	ProfileCPU   ProfileType = "cpu"   // see ConvertToPprof
+	ProfileBlock ProfileType = "block" // see ConvertToBlockProfile
+)
+
+// profileKinds are the derived profiles of the profile types, with their Parca names
+var profileKinds = map[ProfileType]struct {
+	kind  profileKind
+	parca string
+}{
+	ProfileCPU:   {cpuProfile, "process_cpu"},
+	ProfileBlock: {blockProfile, "block"},
+}
+
+// ProfileSink derives CPU and block profiles from snapshots and pushes them to Pyroscope or Parca, so flight
+// recorder data lands in the continuous profiling UI teams already use. Profiles are labelled with the labels
+// of the snapshot, its trigger and the hostname, and cover the window of the snapshot. Combined with
+// WithContinuousExport, the backend receives a profile of every interval. Encrypted snapshots fail,
+// since profiles can't be derived from them.
+type ProfileSink struct {
+	URL         string         // base URL of the server, e.g. http://pyroscope:4040
+	Backend     ProfileBackend // Pyroscope (default) or Parca
+	AppName     string         // application name, default the "service" label of the snapshot or "flightrecorder"
+	Profiles    []ProfileType  // profiles pushed for each snapshot (default ProfileCPU and ProfileBlock)
+	BearerToken string         // sent as an Authorization bearer token when set
+	Header      http.Header    // extra request headers, e.g. X-Scope-OrgID of multi-tenant servers
+	Client      *http.Client   // defaults to a client with a 30s timeout
+}
+
+// NewProfileSink creates a sink pushing profiles to the backend at url
+func NewProfileSink(backend ProfileBackend, url string) *ProfileSink {
+	return &ProfileSink{
+		URL:     url,
+		Backend: backend,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Write derives the profiles from the snapshot and pushes them to the backend
+func (p *ProfileSink) Write(ctx context.Context, meta SnapshotMeta, data []byte) error {
+	if meta.Encrypted {
+		return fmt.Errorf("cannot derive profiles from encrypted snapshot %s", meta.ID)
+	}
+
+	profiles := p.Profiles
+	if len(profiles) == 0 {
+		profiles = []ProfileType{ProfileCPU, ProfileBlock}
+	}
+	for _, typ := range profiles {
+		kind, ok := profileKinds[typ]
+		if !ok {
+			return fmt.Errorf("unknown profile type %q, should be cpu or block", typ)
+		}
+
+		var profile bytes.Buffer
+		duration, err := convertProfile(bytes.NewReader(data), &profile, kind.kind)
+		if err != nil {
+			return fmt.Errorf("failed to derive %s profile of snapshot %s: %w", typ, meta.ID, err)
+		}
+
+		switch p.Backend {
+		case Pyroscope, "":
+			err = p.pushPyroscope(ctx, meta, typ, duration, profile.Bytes())
+		case Parca:
+			err = p.pushParca(ctx, meta, kind.parca, profile.Bytes())
+		default:
+			err = fmt.Errorf("unknown profile backend %q, should be pyroscope or parca", p.Backend)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to push %s profile of snapshot %s: %w", typ, meta.ID, err)
+		}
+	}
+	return nil
+}
+
+// labels returns the labels of the profiles of a snapshot
+func (p *ProfileSink) labels(meta SnapshotMeta) map[string]string {
+	labels := maps.Clone(meta.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels["trigger"] = meta.Trigger
+	if meta.Build != nil {
+		labels["hostname"] = meta.Build.Hostname
+	}
+	return labels
+}
+
+func (p *ProfileSink) appName(meta SnapshotMeta) string {
+	switch {
+	case p.AppName != "":
+		return p.AppName
+	case meta.Labels["service"] != "":
+		return meta.Labels["service"]
+	default:
+		return "flightrecorder"
+	}
+}
+
+// pushPyroscope pushes a profile to the Pyroscope ingestion API, as a multipart form with the profile
+// and its application name, e.g. checkout.cpu{region=eu,trigger=export}
+func (p *ProfileSink) pushPyroscope(ctx context.Context, meta SnapshotMeta, typ ProfileType, duration time.Duration, profile []byte) error {
+	labels := p.labels(meta)
+	pairs := make([]string, 0, len(labels))
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	part.Write(profile)
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	query := url.Values{
+		"name":    {p.appName(meta) + "." + string(typ) + "{" + strings.Join(pairs, ",") + "}"},
+		"from":    {strconv.FormatInt(meta.CreatedAt.Add(-duration).Unix(), 10)},
+		"until":   {strconv.FormatInt(meta.CreatedAt.Unix(), 10)},
+		"format":  {"pprof"},
+		"spyName": {"flightrecorder"},
+	}
+	return p.post(ctx, strings.TrimSuffix(p.URL, "/")+"/ingest?"+query.Encode(), form.FormDataContentType(), &body)
+}
+
+// pushParca pushes a profile to the Parca profile store API, the JSON form of WriteRawRequest
+func (p *ProfileSink) pushParca(ctx context.Context, meta SnapshotMeta, name string, profile []byte) error {
+	type label struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	labels := []label{{Name: "__name__", Value: name}, {Name: "job", Value: p.appName(meta)}}
+	for key, value := range p.labels(meta) {
+		labels = append(labels, label{Name: key, Value: value})
+	}
+	slices.SortFunc(labels[2:], func(a, b label) int { return strings.Compare(a.Name, b.Name) })
+
+	type sample struct {
+		RawProfile []byte `json:"rawProfile"` // base64 in JSON
+	}
+	request := map[string]any{
+		"normalized": false,
+		"series": []any{map[string]any{
+			"labels":  map[string]any{"labels": labels},
+			"samples": []sample{{RawProfile: profile}},
+		}},
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return p.post(ctx, strings.TrimSuffix(p.URL, "/")+"/profiles/writeraw", "application/json", bytes.NewReader(body))
+}
+
+// post makes a push request
+func (p *ProfileSink) post(ctx context.Context, target, contentType string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, body)
+	if err != nil {
+		return fmt.Errorf("failed to create push request: %w", err)
+	}
+	for key, values := range p.Header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	if p.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.BearerToken)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+}
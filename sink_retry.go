@@ -0,0 +1,271 @@
+package flightrecorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSinkRetryMaxQueued  = 100
+	defaultSinkRetryMinBackoff = time.Second
+	defaultSinkRetryMaxBackoff = 5 * time.Minute
+
+	queuedDataSuffix = ".trace"
+	queuedMetaSuffix = ".json"
+)
+
+// SinkRetry queues snapshots whose sink write failed on local disk and retries them with
+// exponential backoff in the background, so a transient sink outage doesn't lose the snapshot
+// which captured an incident. Queued snapshots survive restarts and are retried oldest first.
+type SinkRetry struct {
+	Dir        string        // directory of the queued snapshots, created as needed
+	MaxQueued  int           // maximum number of queued snapshots, the oldest are dropped (default 100)
+	MinBackoff time.Duration // backoff after the first failed retry, doubled on every failure (default 1s)
+	MaxBackoff time.Duration // maximum backoff between retries (default 5m)
+}
+
+func (r SinkRetry) enabled() bool {
+	return r.Dir != ""
+}
+
+// WithSinkRetry queues failed sink writes on disk and retries them in the background.
+// Capture succeeds once a snapshot whose sink write failed is queued.
+func WithSinkRetry(r SinkRetry) Option {
+	return func(o *options) {
+		o.sinkRetry = r
+	}
+}
+
+// retryingSink reports whether failed sink writes are queued and retried
+func (s *Service) retryingSink() bool {
+	return s.opts.sink != nil && s.opts.sinkRetry.enabled()
+}
+
+// sinkQueue holds the snapshots waiting to be written to the sink, as pairs of
+// data and metadata files named by the queued entry, oldest first
+type sinkQueue struct {
+	mu      sync.Mutex
+	entries []string
+	lastErr error
+	wake    chan struct{}
+}
+
+// load queues the snapshots left in the directory by a previous process
+func (q *sinkQueue) load(dir string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read sink queue: %w", err)
+	}
+	// The metadata is written last, so entries without it were never fully queued.
+	for _, f := range files {
+		if entry, ok := strings.CutSuffix(f.Name(), queuedMetaSuffix); ok {
+			q.entries = append(q.entries, entry)
+		}
+	}
+	slices.Sort(q.entries)
+	return nil
+}
+
+// enqueue writes the snapshot to the queue directory, dropping the oldest entries beyond MaxQueued
+func (q *sinkQueue) enqueue(r SinkRetry, meta SnapshotMeta, data []byte, now time.Time) error {
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create sink queue directory: %w", err)
+	}
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	// Entries sort by the time they were queued, also across restarts.
+	entry := strconv.FormatInt(now.UnixNano(), 10) + "-" + meta.ID
+	if err := writeFileAtomic(filepath.Join(r.Dir, entry+queuedDataSuffix), data); err != nil {
+		return fmt.Errorf("failed to queue snapshot: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(r.Dir, entry+queuedMetaSuffix), metaData); err != nil {
+		os.Remove(filepath.Join(r.Dir, entry+queuedDataSuffix))
+		return fmt.Errorf("failed to queue snapshot: %w", err)
+	}
+
+	maxQueued := r.MaxQueued
+	if maxQueued <= 0 {
+		maxQueued = defaultSinkRetryMaxQueued
+	}
+
+	q.mu.Lock()
+	q.entries = append(q.entries, entry)
+	var dropped []string
+	if len(q.entries) > maxQueued {
+		dropped = slices.Clone(q.entries[:len(q.entries)-maxQueued])
+		q.entries = slices.Delete(q.entries, 0, len(dropped))
+	}
+	q.mu.Unlock()
+
+	for _, entry := range dropped {
+		removeQueued(r.Dir, entry)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// oldest returns the oldest queued entry
+func (q *sinkQueue) oldest() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.entries) == 0 {
+		return "", false
+	}
+	return q.entries[0], true
+}
+
+// done removes an entry from the queue and records the result of its last write
+func (q *sinkQueue) done(entry string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if i := slices.Index(q.entries, entry); i >= 0 {
+		q.entries = slices.Delete(q.entries, i, i+1)
+	}
+	q.lastErr = err
+}
+
+// failed records the error of a retry which is going to be attempted again
+func (q *sinkQueue) failed(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.lastErr = err
+}
+
+// state returns the number of queued snapshots and the error of the last write
+func (q *sinkQueue) state() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries), q.lastErr
+}
+
+// runSinkRetry writes the queued snapshots to the sink, oldest first, until ctx is done
+func (s *Service) runSinkRetry(ctx context.Context) {
+	defer s.health.sinkRetry.Store(false)
+
+	r := s.opts.sinkRetry
+	minBackoff, maxBackoff := r.MinBackoff, r.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultSinkRetryMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultSinkRetryMaxBackoff
+	}
+
+	backoff := minBackoff
+	for {
+		entry, ok := s.sinkQueue.oldest()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.sinkQueue.wake:
+				continue
+			}
+		}
+
+		err := s.retrySinkWrite(ctx, entry)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			s.sinkQueue.done(entry, nil)
+			s.health.recordSinkWrite(nil)
+			backoff = minBackoff
+			continue
+		}
+		var corrupt *queueError
+		if errors.As(err, &corrupt) {
+			// A queued snapshot which can't be read will never be written, drop it.
+			s.sinkQueue.done(entry, err)
+			removeQueued(r.Dir, entry)
+			continue
+		}
+
+		s.sinkQueue.failed(err)
+		s.health.recordSinkWrite(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// queueError is the error of a queued snapshot which can't be read
+type queueError struct {
+	err error
+}
+
+func (e *queueError) Error() string {
+	return e.err.Error()
+}
+
+func (e *queueError) Unwrap() error {
+	return e.err
+}
+
+// retrySinkWrite writes a queued snapshot to the sink and removes it from the queue directory
+func (s *Service) retrySinkWrite(ctx context.Context, entry string) error {
+	dir := s.opts.sinkRetry.Dir
+	metaData, err := os.ReadFile(filepath.Join(dir, entry+queuedMetaSuffix))
+	if err != nil {
+		return &queueError{fmt.Errorf("failed to read queued snapshot %s: %w", entry, err)}
+	}
+	var meta SnapshotMeta
+	if err := json.Unmarshal(metaData, &meta); err != nil {
+		return &queueError{fmt.Errorf("invalid queued snapshot %s: %w", entry, err)}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, entry+queuedDataSuffix))
+	if err != nil {
+		return &queueError{fmt.Errorf("failed to read queued snapshot %s: %w", entry, err)}
+	}
+
+	results, err := s.writeSink(ctx, meta, data)
+	if results != nil {
+		meta.Sinks = results
+		s.store.setSinkResults(meta.ID, results)
+	}
+	if err != nil {
+		// The queued metadata records the sinks written, so the next retry skips them.
+		if results != nil {
+			if metaData, err := json.Marshal(meta); err == nil {
+				writeFileAtomic(filepath.Join(dir, entry+queuedMetaSuffix), metaData)
+			}
+		}
+		return fmt.Errorf("failed to write snapshot %s to sink: %w", meta.ID, err)
+	}
+	removeQueued(dir, entry)
+	return nil
+}
+
+// removeQueued deletes the files of a queued entry
+func removeQueued(dir, entry string) {
+	os.Remove(filepath.Join(dir, entry+queuedMetaSuffix))
+	os.Remove(filepath.Join(dir, entry+queuedDataSuffix))
+}
@@ -0,0 +1,201 @@
+package flightrecorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ContentTypeSnapshotStream is the content type of the streams of HTTPStreamSink
+const ContentTypeSnapshotStream = "application/x-flightrecorder-stream"
+
+const defaultStreamMaxAge = time.Hour
+
+// HTTPStreamSink streams snapshots to a collector URL over a single long-lived chunked POST request,
+// for continuous export without a request per chunk. Each snapshot is a frame of the stream: its
+// metadata as a line of JSON, followed by meta.Size bytes of trace. ReadSnapshotStream reads the frames.
+// A stream which fails is reopened by the next write, and streams are reopened after MaxAge, so
+// collectors can rotate their connections.
+type HTTPStreamSink struct {
+	URL         string
+	BearerToken string        // sent as an Authorization bearer token when set
+	Client      *http.Client  // defaults to http.DefaultClient, should have no timeout as the request lasts for the stream
+	MaxAge      time.Duration // time after which the stream is reopened (default 1h)
+
+	mu     sync.Mutex
+	stream *snapshotStream
+}
+
+// snapshotStream is an open stream request of HTTPStreamSink
+type snapshotStream struct {
+	w        *io.PipeWriter
+	openedAt time.Time
+	done     chan struct{}
+	err      error // result of the request, set when done is closed
+}
+
+// NewHTTPStreamSink creates a sink streaming snapshots to url
+func NewHTTPStreamSink(url string) *HTTPStreamSink {
+	return &HTTPStreamSink{URL: url, MaxAge: defaultStreamMaxAge}
+}
+
+// Write writes the snapshot as a frame of the stream, opening the stream as needed.
+// A write to a stream which was closed by the collector is retried once on a new stream.
+func (h *HTTPStreamSink) Write(ctx context.Context, meta SnapshotMeta, data []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	maxAge := h.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultStreamMaxAge
+	}
+	if h.stream != nil && time.Since(h.stream.openedAt) > maxAge {
+		h.closeLocked()
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if h.stream == nil {
+			h.stream = h.open()
+		}
+		if err = h.stream.writeFrame(ctx, meta, data); err == nil {
+			return nil
+		}
+		h.closeLocked()
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return fmt.Errorf("failed to stream snapshot: %w", err)
+}
+
+// Close ends the stream, it returns the error of the stream request if any
+func (h *HTTPStreamSink) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.closeLocked()
+}
+
+// open starts a stream request, its body is written by the frames
+func (h *HTTPStreamSink) open() *snapshotStream {
+	r, w := io.Pipe()
+	stream := &snapshotStream{w: w, openedAt: time.Now(), done: make(chan struct{})}
+	go func() {
+		defer close(stream.done)
+		stream.err = h.post(r)
+		// Writers still waiting for the body to be read fail instead of blocking.
+		r.CloseWithError(stream.err)
+	}()
+	return stream
+}
+
+// post makes the stream request, until the body is closed
+func (h *HTTPStreamSink) post(body io.Reader) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, body)
+	if err != nil {
+		return fmt.Errorf("failed to create stream request: %w", err)
+	}
+	req.Header.Set("Content-Type", ContentTypeSnapshotStream)
+	if h.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+h.BearerToken)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to stream snapshots: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("collector returned %s: %s", resp.Status, msg)
+}
+
+// closeLocked ends the stream and waits for the response of the collector
+func (h *HTTPStreamSink) closeLocked() error {
+	if h.stream == nil {
+		return nil
+	}
+	stream := h.stream
+	h.stream = nil
+	stream.w.Close()
+	<-stream.done
+	return stream.err
+}
+
+// writeFrame writes the snapshot to the stream, until ctx is done
+func (st *snapshotStream) writeFrame(ctx context.Context, meta SnapshotMeta, data []byte) error {
+	stop := context.AfterFunc(ctx, func() { st.w.CloseWithError(ctx.Err()) })
+	defer stop()
+
+	meta.Size = int64(len(data))
+	header, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if _, err := st.w.Write(append(header, '\n')); err != nil {
+		return st.writeErr(err)
+	}
+	if _, err := st.w.Write(data); err != nil {
+		return st.writeErr(err)
+	}
+	return nil
+}
+
+// writeErr returns the error of the stream request when a write failed because it ended
+func (st *snapshotStream) writeErr(err error) error {
+	select {
+	case <-st.done:
+		if st.err != nil {
+			return st.err
+		}
+		return errors.New("collector ended the stream")
+	default:
+		return err
+	}
+}
+
+// ReadSnapshotStream reads the frames of a stream written by HTTPStreamSink and calls f with each
+// snapshot, until the end of the stream or an error of f
+func ReadSnapshotStream(r io.Reader, f func(meta SnapshotMeta, body io.Reader) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read stream frame: %w", err)
+		}
+		var meta SnapshotMeta
+		if err := json.Unmarshal(line, &meta); err != nil {
+			return fmt.Errorf("invalid stream frame: %w", err)
+		}
+
+		body := &io.LimitedReader{R: br, N: meta.Size}
+		if err := f(meta, body); err != nil {
+			return err
+		}
+		// Skip what f left unread, so the next frame starts at its metadata.
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return fmt.Errorf("failed to read stream frame: %w", err)
+		}
+		if body.N > 0 {
+			return fmt.Errorf("failed to read stream frame %s: %w", meta.ID, io.ErrUnexpectedEOF)
+		}
+	}
+}
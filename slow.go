@@ -0,0 +1,151 @@
+package flightrecorder
+
+import (
+	"math/rand/v2"
+	"net/http"
+	rtrace "runtime/trace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SlowRequestTrigger is the trigger of the snapshots of slow requests captured by CaptureSlowRequests
+const SlowRequestTrigger = "slow-request"
+
+const defaultSlowRequestSampleRate = 0.01
+
+// SlowRequests configures CaptureSlowRequests
+type SlowRequests struct {
+	Threshold  time.Duration              // requests slower than the threshold, e.g. the latency SLO, are candidates
+	SampleRate float64                    // fraction of the slow requests captured (default 0.01)
+	MaxPerHour int                        // maximum number of snapshots per hour, 0 for no limit beyond WithTriggerBudget
+	Cooldown   time.Duration              // minimum time between two snapshots, on top of WithTriggerCooldown
+	Match      func(*http.Request) bool   // selects the requests observed (default all)
+	RequestID  func(*http.Request) string // ID of a request (default its X-Request-Id header, else a generated ID)
+}
+
+// SlowRequestStats counts the slow requests observed by CaptureSlowRequests
+type SlowRequestStats struct {
+	Slow     uint64 `json:"slow"`     // requests slower than the threshold
+	Sampled  uint64 `json:"sampled"`  // slow requests selected by the sample rate
+	Limited  uint64 `json:"limited"`  // sampled requests not captured because of MaxPerHour, the cooldowns or the budget
+	Captured uint64 `json:"captured"` // snapshots stored of slow requests
+}
+
+// slowRequestCounters are the counters of SlowRequestStats, shared by the CaptureSlowRequests middleware
+type slowRequestCounters struct {
+	enabled                          atomic.Bool
+	slow, sampled, limited, captured atomic.Uint64
+}
+
+func (c *slowRequestCounters) stats() *SlowRequestStats {
+	if !c.enabled.Load() {
+		return nil
+	}
+	return &SlowRequestStats{
+		Slow:     c.slow.Load(),
+		Sampled:  c.sampled.Load(),
+		Limited:  c.limited.Load(),
+		Captured: c.captured.Load(),
+	}
+}
+
+// hourlyLimit bounds the events within the last hour
+type hourlyLimit struct {
+	mu    sync.Mutex
+	max   int
+	times []time.Time
+}
+
+// allow reports whether an event is allowed now and records it if so
+func (l *hourlyLimit) allow(now time.Time) bool {
+	if l.max <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-time.Hour)
+	i := 0
+	for i < len(l.times) && !l.times[i].After(cutoff) {
+		i++
+	}
+	l.times = l.times[i:]
+	if len(l.times) >= l.max {
+		return false
+	}
+	l.times = append(l.times, now)
+	return true
+}
+
+// CaptureSlowRequests returns middleware catching tail latencies automatically: a sample of the requests
+// slower than the threshold are captured when they complete, with the "slow-request" trigger and the request
+// in the snapshot metadata. Sampling and MaxPerHour bound the overhead of snapshots under sustained slowness.
+// Unlike RecordRequests, the recorder isn't started for requests, so it should be running; requests run in a
+// trace task named after them. The counters are reported in the status as slow_requests.
+func (s *Service) CaptureSlowRequests(sr SlowRequests) func(http.Handler) http.Handler {
+	rate := sr.SampleRate
+	if rate <= 0 {
+		rate = defaultSlowRequestSampleRate
+	}
+	match := sr.Match
+	if match == nil {
+		match = func(*http.Request) bool { return true }
+	}
+	requestID := sr.RequestID
+	if requestID == nil {
+		requestID = func(r *http.Request) string { return r.Header.Get(HeaderRequestID) }
+	}
+	limit := &hourlyLimit{max: sr.MaxPerHour}
+	s.slowRequests.enabled.Store(true)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !match(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, task := rtrace.NewTask(r.Context(), "http "+r.Method+" "+r.URL.Path)
+			rec := &statusRecorder{ResponseWriter: w}
+			start := time.Now()
+			h.ServeHTTP(rec, r.WithContext(ctx))
+			task.End()
+
+			duration := time.Since(start)
+			if duration <= sr.Threshold {
+				return
+			}
+			s.slowRequests.slow.Add(1)
+			if rand.Float64() >= rate {
+				return
+			}
+			s.slowRequests.sampled.Add(1)
+			now := time.Now()
+			if s.gateRefuses() || !limit.allow(now) || !s.limiter.allow(SlowRequestTrigger, sr.Cooldown, s.opts, now) {
+				s.slowRequests.limited.Add(1)
+				return
+			}
+
+			request := RecordedRequest{
+				ID:       requestID(r),
+				Method:   r.Method,
+				Path:     r.URL.Path,
+				Status:   rec.code,
+				Duration: Duration(duration),
+			}
+			if request.ID == "" {
+				request.ID = s.newRequestID()
+			}
+			if request.Status == 0 {
+				request.Status = http.StatusOK
+			}
+			// The snapshot is taken in the background, so it doesn't delay the end of the response.
+			s.goBackground(func() {
+				if _, err := s.captureRequest(SlowRequestTrigger, request); err == nil {
+					s.slowRequests.captured.Add(1)
+				}
+			})
+		})
+	}
+}
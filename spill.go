@@ -0,0 +1,157 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// defaultSpillThreshold is the size above which snapshots are spilled when SnapshotSpill sets none
+const defaultSpillThreshold = 8 << 20
+
+// SnapshotSpill keeps large snapshots in temporary files instead of the heap, so capturing and
+// storing large buffers doesn't grow the heap of the instrumented process.
+// Spill files are removed when their snapshot leaves the store, and by Close.
+type SnapshotSpill struct {
+	Dir       string   // directory of the spill files (default os.TempDir())
+	Threshold ByteSize // snapshots larger than this are spilled (default 8MB)
+}
+
+// WithSnapshotSpill spills captures and stored snapshots larger than the threshold to disk.
+// Captures are written to a temporary file rather than a growing buffer, and read back into
+// a single allocation for filters, validation and sinks. Stored snapshots stay on disk until
+// they are downloaded or analyzed.
+func WithSnapshotSpill(spill SnapshotSpill) Option {
+	return func(o *options) {
+		o.spill = &spill
+	}
+}
+
+func (sp *SnapshotSpill) dir() string {
+	if sp.Dir == "" {
+		return os.TempDir()
+	}
+	return sp.Dir
+}
+
+func (sp *SnapshotSpill) threshold() int {
+	if sp.Threshold <= 0 {
+		return defaultSpillThreshold
+	}
+	return int(sp.Threshold)
+}
+
+// spillBuffer is a writer keeping up to threshold bytes in memory and the whole data
+// in a temporary file beyond that
+type spillBuffer struct {
+	spill *SnapshotSpill
+	buf   bytes.Buffer
+	file  *os.File
+	size  int64
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil && b.buf.Len()+len(p) <= b.spill.threshold() {
+		return b.buf.Write(p)
+	}
+	if b.file == nil {
+		file, err := os.CreateTemp(b.spill.dir(), "flightrecorder-*.trace")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create spill file: %w", err)
+		}
+		b.file = file
+		if _, err := file.Write(b.buf.Bytes()); err != nil {
+			return 0, fmt.Errorf("failed to write spill file: %w", err)
+		}
+		b.size = int64(b.buf.Len())
+		b.buf = bytes.Buffer{}
+	}
+	n, err := b.file.Write(p)
+	b.size += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("failed to write spill file: %w", err)
+	}
+	return n, nil
+}
+
+// Bytes returns the written data, read back in an allocation of its exact size when it was spilled
+func (b *spillBuffer) Bytes() ([]byte, error) {
+	if b.file == nil {
+		return b.buf.Bytes(), nil
+	}
+	data := make([]byte, b.size)
+	if _, err := b.file.ReadAt(data, 0); err != nil {
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	return data, nil
+}
+
+// Close removes the spill file, if any
+func (b *spillBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+// spillSnapshot returns the snapshot to store, kept in a spill file when it is larger than the threshold
+func (s *Service) spillSnapshot(meta SnapshotMeta, data []byte) (storedSnapshot, error) {
+	snap := storedSnapshot{meta: meta, size: int64(len(data)), analysis: &snapshotAnalysis{}}
+	if s.opts.spill == nil || len(data) <= s.opts.spill.threshold() {
+		snap.data = data
+		return snap, nil
+	}
+
+	file, err := os.CreateTemp(s.opts.spill.dir(), "flightrecorder-"+meta.ID+"-*.trace")
+	if err != nil {
+		return storedSnapshot{}, fmt.Errorf("failed to create spill file: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return storedSnapshot{}, fmt.Errorf("failed to write spill file: %w", err)
+	}
+	snap.file = file.Name()
+	return snap, nil
+}
+
+// bytes returns the data of the snapshot, read from its spill file when it was spilled
+func (snap storedSnapshot) bytes() ([]byte, error) {
+	if snap.file == "" {
+		return snap.data, nil
+	}
+	data, err := os.ReadFile(snap.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spilled snapshot %s: %w", snap.meta.ID, err)
+	}
+	return data, nil
+}
+
+// discard removes the spill file of a snapshot which left the store
+func (snap storedSnapshot) discard() {
+	if snap.file != "" {
+		os.Remove(snap.file)
+	}
+}
+
+// removeSpilled removes the spill files of the stored snapshots, which are dropped from the store
+func (st *snapshotStore) removeSpilled() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	kept := st.snapshots[:0]
+	for _, snap := range st.snapshots {
+		if snap.file == "" {
+			kept = append(kept, snap)
+			continue
+		}
+		snap.discard()
+		st.bytes -= snap.size
+	}
+	clear(st.snapshots[len(kept):])
+	st.snapshots = kept
+}
@@ -0,0 +1,163 @@
+package flightrecorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// persistedState is the configuration kept in the state file
+type persistedState struct {
+	Enabled               bool   `json:"enabled"`
+	Period                string `json:"period"`
+	Size                  string `json:"size"`
+	GCPauseThreshold      string `json:"gc_pause_threshold"`
+	SchedLatencyThreshold string `json:"sched_latency_threshold"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Session is the recording session in progress when the state was saved
+	Session *Session `json:"session,omitempty"`
+
+	// ReadOnly is the read-only lock of the service when the state was saved
+	ReadOnly *ReadOnlyLock `json:"read_only,omitempty"`
+
+	// ConfigHistory is the recent changes of the configuration, oldest first
+	ConfigHistory []ConfigChange `json:"config_history,omitempty"`
+}
+
+// WithStateFile persists the configuration (period, size, runtime trigger thresholds, labels, whether
+// the recorder is running, the read-only lock and the history of configuration changes) to path whenever it changes,
+// and restores it when the service is created.
+// With resume, a recorder which was running when the state was saved is started again.
+func WithStateFile(path string, resume bool) Option {
+	return func(o *options) {
+		o.stateFile = path
+		o.resumeRecording = resume
+	}
+}
+
+// saveStateLocked writes the configuration to the state file, s.mu must be held.
+// Failures are reported by the readiness check.
+func (s *Service) saveStateLocked() {
+	if s.opts.stateFile == "" {
+		return
+	}
+
+	state := persistedState{
+		Enabled:               s.recorder.Enabled(),
+		Period:                s.period.String(),
+		Size:                  formatMemoryUnits(s.size),
+		GCPauseThreshold:      s.runtimeTrigger.GCPause.String(),
+		SchedLatencyThreshold: s.runtimeTrigger.SchedLatency.String(),
+		Labels:                s.labels,
+		Session:               s.sessionStatus(),
+		ReadOnly:              s.readOnly,
+		ConfigHistory:         s.configHistory,
+	}
+	s.health.recordStateFile(writeStateFile(s.opts.stateFile, state))
+}
+
+func writeStateFile(path string, state persistedState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// Write atomically so a crash never leaves a partial state file.
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// restoreState applies the configuration of the state file and reports
+// whether the recorder was running when it was saved, and in which session
+func (s *Service) restoreState() (bool, *Session, error) {
+	data, err := os.ReadFile(s.opts.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, nil, fmt.Errorf("invalid state file %s: %w", s.opts.stateFile, err)
+	}
+
+	var req UpdateRequest
+	if err := req.UnmarshalJSON(data); err != nil {
+		return false, nil, fmt.Errorf("invalid state file %s: %w", s.opts.stateFile, err)
+	}
+	if err := s.Validate(req); err != nil {
+		return false, nil, fmt.Errorf("invalid state file %s: %w", s.opts.stateFile, err)
+	}
+
+	if req.Period != nil {
+		s.period = time.Duration(*req.Period)
+	}
+	if req.Size != nil {
+		s.size = int64(*req.Size)
+	}
+	if req.GCPauseThreshold != nil {
+		s.runtimeTrigger.GCPause = time.Duration(*req.GCPauseThreshold)
+	}
+	if req.SchedLatencyThreshold != nil {
+		s.runtimeTrigger.SchedLatency = time.Duration(*req.SchedLatencyThreshold)
+	}
+	if req.Labels != nil {
+		s.labels = req.Labels
+	}
+	if state.ReadOnly != nil {
+		s.readOnly = state.ReadOnly
+	}
+	s.restoreConfigHistory(state.ConfigHistory)
+	return state.Enabled, state.Session, nil
+}
+
+// resume starts the recorder again after a restart, for the rest of its session if it was in one.
+// A session which ended while the process was down is not resumed.
+func (s *Service) resume(session *Session) error {
+	if session == nil {
+		return s.Start()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !time.Now().Before(session.Until) {
+		s.saveStateLocked()
+		return nil
+	}
+	if err := s.startLocked(); err != nil {
+		return err
+	}
+	s.startSessionLocked(*session)
+	s.saveStateLocked()
+	s.publishStatusLocked(EventStarted)
+	return nil
+}
+
+// recordStateFile records the result of loading or saving the state file
+func (h *serviceHealth) recordStateFile(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.stateErr = err
+}
+
+func (h *serviceHealth) stateFileErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.stateErr
+}
@@ -0,0 +1,388 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrSnapshotNotFound is returned when a stored snapshot does not exist
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// defaultRetentionInterval is how often the janitor enforces the retention policy
+const defaultRetentionInterval = time.Minute
+
+// SnapshotMeta describes a snapshot taken by the service
+type SnapshotMeta struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+	Trigger   string    `json:"trigger"`
+	Events    int       `json:"events,omitempty"`  // number of trace events, set when snapshots are validated
+	Markers   []Marker  `json:"markers,omitempty"` // markers within the recorded window
+
+	Labels map[string]string `json:"labels,omitempty"` // labels of the service when the snapshot was taken
+
+	Encrypted bool `json:"encrypted,omitempty"` // whether the snapshot is encrypted, see WithEncryption
+
+	Session string           `json:"session,omitempty"` // ID of the recording session the snapshot was taken in
+	Request *RecordedRequest `json:"request,omitempty"` // request the snapshot was taken for, see RecordRequests
+	Build   *BuildInfo       `json:"build,omitempty"`   // process which took the snapshot
+
+	Instance string `json:"instance,omitempty"` // service instance which took the snapshot, see Service.Instance
+	Follower bool   `json:"follower,omitempty"` // whether the replica wasn't the leader, see WithRecorderGate
+
+	SHA256    string `json:"sha256,omitempty"`    // hex encoded SHA-256 of the snapshot as stored, encrypted if it is
+	Signature string `json:"signature,omitempty"` // base64 encoded Ed25519 signature, see WithSnapshotSigning
+
+	Sinks     []SinkResult `json:"sinks,omitempty"`      // results of the sinks of a MultiSink, see WithSinks
+	SinkError string       `json:"sink_error,omitempty"` // error of the failed sink write, unless queued by WithSinkRetry
+
+	Downloads []SnapshotDownload `json:"downloads,omitempty"` // recent downloads of the stored snapshot, oldest first
+}
+
+// RetentionPolicy bounds the snapshots kept in the snapshot store.
+// Zero values disable the corresponding limit.
+type RetentionPolicy struct {
+	MaxCount int           // maximum number of snapshots kept
+	MaxBytes int64         // maximum total bytes of snapshots kept
+	MaxAge   time.Duration // maximum age of a snapshot
+	Interval time.Duration // how often the janitor runs (default 1m)
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxCount > 0 || p.MaxBytes > 0 || p.MaxAge > 0
+}
+
+type storedSnapshot struct {
+	meta     SnapshotMeta
+	data     []byte // nil when the snapshot is spilled to file, see WithSnapshotSpill
+	file     string
+	size     int64
+	analysis *snapshotAnalysis
+}
+
+// snapshotStore keeps snapshots in memory, ordered oldest first
+type snapshotStore struct {
+	mu        sync.RWMutex
+	snapshots []storedSnapshot
+	bytes     int64 // total bytes of the snapshots
+}
+
+func (st *snapshotStore) list() []SnapshotMeta {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	metas := make([]SnapshotMeta, 0, len(st.snapshots))
+	for _, snap := range st.snapshots {
+		metas = append(metas, snap.meta)
+	}
+	return metas
+}
+
+func (st *snapshotStore) get(id string) (storedSnapshot, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	for _, snap := range st.snapshots {
+		if snap.meta.ID == id {
+			return snap, true
+		}
+	}
+	return storedSnapshot{}, false
+}
+
+// setSinkError records the error of the failed sink write in the metadata of a stored snapshot
+func (st *snapshotStore) setSinkError(id, err string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for i, snap := range st.snapshots {
+		if snap.meta.ID == id {
+			st.snapshots[i].meta.SinkError = err
+			return
+		}
+	}
+}
+
+func (st *snapshotStore) delete(id string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for i, snap := range st.snapshots {
+		if snap.meta.ID == id {
+			snap.discard()
+			st.bytes -= snap.size
+			st.snapshots = slices.Delete(st.snapshots, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+func (st *snapshotStore) purge() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	n := len(st.snapshots)
+	for _, snap := range st.snapshots {
+		snap.discard()
+	}
+	st.snapshots = nil
+	st.bytes = 0
+	return n
+}
+
+// enforce drops the oldest snapshots until the policy is satisfied
+// and returns the number of snapshots removed.
+func (st *snapshotStore) enforce(policy RetentionPolicy, now time.Time) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	total := st.bytes
+	removed := 0
+	for removed < len(st.snapshots) {
+		oldest := st.snapshots[removed]
+		expired := policy.MaxAge > 0 && now.Sub(oldest.meta.CreatedAt) > policy.MaxAge
+		tooMany := policy.MaxCount > 0 && len(st.snapshots)-removed > policy.MaxCount
+		tooLarge := policy.MaxBytes > 0 && total > policy.MaxBytes
+		if !expired && !tooMany && !tooLarge {
+			break
+		}
+		oldest.discard()
+		total -= oldest.size
+		removed++
+	}
+	st.snapshots = slices.Delete(st.snapshots, 0, removed)
+	st.bytes = total
+	return removed
+}
+
+// Capture takes a snapshot of the flight recorder, keeps it in the snapshot store
+// and writes it to the sink when one is configured.
+// A failed sink write doesn't fail the capture, as the snapshot is stored: it is recorded in the SinkError
+// of the metadata and reported by the readiness check, or queued with WithSinkRetry.
+// The trigger describes what caused the capture (e.g. "manual", "http").
+// With QuotaReject, snapshots which don't fit in the store quota fail with ErrQuotaExceeded
+// and are not written to the sink either.
+func (s *Service) Capture(trigger string) (SnapshotMeta, error) {
+	return s.CaptureContext(context.Background(), trigger)
+}
+
+// CaptureContext is Capture, with the retries of WithSnapshotRetry and the sink write ending when ctx is done.
+// A sink write cancelled by ctx is queued for retries with WithSinkRetry.
+func (s *Service) CaptureContext(ctx context.Context, trigger string) (SnapshotMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return SnapshotMeta{}, err
+	}
+	return s.capture(ctx, trigger, nil)
+}
+
+// capture implements CaptureContext, for a request recorded by RecordRequests if not nil
+func (s *Service) capture(ctx context.Context, trigger string, request *RecordedRequest) (SnapshotMeta, error) {
+	ctx, cancel := s.serviceContext(ctx)
+	defer cancel()
+
+	meta, data, err := s.snapshotFor(ctx, trigger, request)
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+	if meta, data, err = s.encryptSnapshot(meta, data); err != nil {
+		return SnapshotMeta{}, err
+	}
+
+	snap, err := s.spillSnapshot(meta, data)
+	if err != nil {
+		return SnapshotMeta{}, err
+	}
+	if _, err := s.store.addWithQuota(snap, s.opts.quota); err != nil {
+		snap.discard()
+		return SnapshotMeta{}, err
+	}
+
+	if s.opts.retention.enabled() {
+		s.store.enforce(s.opts.retention, time.Now())
+	}
+	s.recordSessionSnapshot(meta)
+	s.publish(Event{Type: EventSnapshotStored, Snapshot: &meta})
+
+	if s.opts.sink != nil {
+		results, err := s.writeSink(ctx, meta, data)
+		if results != nil {
+			meta.Sinks = results
+			s.store.setSinkResults(meta.ID, results)
+		}
+		s.health.recordSinkWrite(err)
+		if err != nil && s.retryingSink() {
+			queueErr := s.sinkQueue.enqueue(s.opts.sinkRetry, meta, data, time.Now())
+			if queueErr == nil {
+				s.sinkQueue.failed(err)
+				return meta, nil
+			}
+			err = errors.Join(err, queueErr)
+		}
+		if err != nil {
+			meta.SinkError = err.Error()
+			s.store.setSinkError(meta.ID, meta.SinkError)
+			s.logger().Warn("flight recorder sink write failed", "snapshot", meta.ID, "error", err)
+		}
+	}
+	return meta, nil
+}
+
+// Snapshots returns the metadata of all stored snapshots, oldest first
+func (s *Service) Snapshots() []SnapshotMeta {
+	return s.store.list()
+}
+
+// StoredSnapshot returns a stored snapshot by ID
+func (s *Service) StoredSnapshot(id string) (SnapshotMeta, []byte, error) {
+	snap, ok := s.store.get(id)
+	if !ok {
+		return SnapshotMeta{}, nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+	data, err := snap.bytes()
+	if err != nil {
+		return SnapshotMeta{}, nil, err
+	}
+	return snap.meta, data, nil
+}
+
+// DeleteSnapshot removes a stored snapshot by ID
+func (s *Service) DeleteSnapshot(id string) error {
+	if !s.store.delete(id) {
+		return fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+	return nil
+}
+
+// PurgeSnapshots removes all stored snapshots and returns how many were removed
+func (s *Service) PurgeSnapshots() int {
+	return s.store.purge()
+}
+
+// runJanitor enforces the retention policy until the service context is done
+func (s *Service) runJanitor(ctx context.Context) {
+	defer s.health.janitor.Store(false)
+
+	interval := s.opts.retention.Interval
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.store.enforce(s.opts.retention, now)
+		}
+	}
+}
+
+// PurgeResponse represents the response of a snapshot purge
+type PurgeResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+func (s *Service) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeCached(w, r, s.Snapshots())
+
+	case http.MethodPost:
+		ctx, cancel := s.snapshotContext(r)
+		defer cancel()
+		meta, err := s.capture(ctx, "http", nil)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrSnapshotVetoed) {
+				code = http.StatusConflict
+			}
+			if errors.Is(err, context.Canceled) || errors.Is(err, ErrSnapshotTimeout) {
+				code = http.StatusServiceUnavailable
+			}
+			if errors.Is(err, ErrSnapshotTimeout) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(snapshotRetryAfter/time.Second)))
+			}
+			if errors.Is(err, ErrQuotaExceeded) {
+				code = http.StatusInsufficientStorage
+			}
+			writeError(w, code, err)
+			return
+		}
+		writeResponse(w, r, http.StatusCreated, meta)
+
+	case http.MethodDelete:
+		deleted := s.PurgeSnapshots()
+		writeResponse(w, r, http.StatusOK, PurgeResponse{Deleted: deleted})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) handleStoredSnapshot(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		snap, ok := s.store.get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id))
+			return
+		}
+		content := io.ReadSeeker(bytes.NewReader(snap.data))
+		if snap.file != "" {
+			// Spilled snapshots are served from their file, without loading them on the heap.
+			file, err := os.Open(snap.file)
+			if err != nil {
+				writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id))
+				return
+			}
+			defer file.Close()
+			content = file
+		}
+		meta := snap.meta
+		// Stored snapshots never change, so their ID identifies the content.
+		// ServeContent handles the conditional and Range requests, so
+		// interrupted downloads of large snapshots can be resumed.
+		w.Header().Set("ETag", `"`+meta.ID+`"`)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", contentDisposition(meta.Name))
+		setEventsHeader(w, meta)
+		setGoVersionHeader(w, meta)
+		setChecksumHeaders(w.Header(), meta)
+		if meta.Encrypted {
+			w.Header().Set(HeaderSnapshotEncrypted, encryptionAlgorithm)
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		http.ServeContent(rec, r, meta.Name, meta.CreatedAt, content)
+		// Conditional requests answered 304 and HEAD requests transfer no data.
+		if r.Method == http.MethodGet && (rec.code == http.StatusOK || rec.code == http.StatusPartialContent) {
+			s.recordDownload(r, id, DownloadTrace)
+		}
+
+	case http.MethodDelete:
+		if err := s.DeleteSnapshot(id); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
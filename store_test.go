@@ -0,0 +1,45 @@
+package flightrecorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// failingSink fails every write
+type failingSink struct{}
+
+func (failingSink) Write(context.Context, SnapshotMeta, []byte) error {
+	return errors.New("bucket gone")
+}
+
+func TestCaptureSinkFailure(t *testing.T) {
+	s := NewService(WithSink(failingSink{}))
+	t.Cleanup(func() { s.Close() })
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/recorder/snapshots", nil))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /snapshots: got %d, want 201 for a stored snapshot: %s", w.Code, w.Body)
+	}
+	var meta SnapshotMeta
+	if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.SinkError != "bucket gone" {
+		t.Fatalf("sink_error = %q, want the error of the sink", meta.SinkError)
+	}
+
+	stored := s.Snapshots()
+	if len(stored) != 1 || stored[0].ID != meta.ID || stored[0].SinkError != "bucket gone" {
+		t.Fatalf("stored snapshots = %+v, want the snapshot with its sink error", stored)
+	}
+}
@@ -0,0 +1,276 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// FieldNaming is the naming of the JSON fields of the HTTP API
+type FieldNaming string
+
+const (
+	SnakeCase FieldNaming = "snake_case" // e.g. stored_snapshots, the default
+	CamelCase FieldNaming = "camelCase"  // e.g. storedSnapshots
+)
+
+// Envelope wraps the JSON responses with WithResponseEnvelope: successful responses in Data and errors in Error
+type Envelope struct {
+	Data  any            `json:"data"`
+	Error *ErrorResponse `json:"error"`
+}
+
+// WithResponseEnvelope wraps all JSON responses of the HTTP API in an Envelope, {"data": ..., "error": null}
+// or {"data": null, "error": {...}}, to match the conventions of existing APIs. Streams and binary
+// responses such as snapshots are not wrapped.
+func WithResponseEnvelope(enabled bool) Option {
+	return func(o *options) {
+		o.style.envelope = enabled
+	}
+}
+
+// WithFieldNaming sets the naming of the JSON fields of the HTTP API, in responses, request bodies and the
+// event stream (default SnakeCase). Only field names are renamed, not keys of maps such as labels.
+func WithFieldNaming(naming FieldNaming) Option {
+	return func(o *options) {
+		o.style.naming = naming
+	}
+}
+
+// responseStyle is the envelope and field naming of the HTTP API
+type responseStyle struct {
+	envelope bool
+	naming   FieldNaming
+}
+
+func (st responseStyle) plain() bool {
+	return !st.envelope && st.naming != CamelCase
+}
+
+// styledWriter carries the response style of a route to the functions writing its responses
+type styledWriter struct {
+	http.ResponseWriter
+	style responseStyle
+}
+
+// Unwrap lets http.ResponseController flush streamed responses such as the event stream
+func (w *styledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// responseStyleOf returns the response style of the route w responds to, the default style
+// outside the routes
+func responseStyleOf(w http.ResponseWriter) responseStyle {
+	for {
+		switch rw := w.(type) {
+		case *styledWriter:
+			return rw.style
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return responseStyle{}
+		}
+	}
+}
+
+// styleRoutes applies the response style to the handlers of routes, and with CamelCase renames
+// the fields of their JSON request bodies to the field names of the request types
+func (s *Service) styleRoutes(routes []Route) []Route {
+	style := s.opts.style
+	if style.plain() {
+		return routes
+	}
+	for i, route := range routes {
+		path := unversionedPath(route)
+		// The OpenAPI document and the Grafana data source have formats of their own.
+		if path == "/openapi.json" || strings.HasPrefix(path, "/grafana/") {
+			continue
+		}
+		h := route.Handler
+		// Paths are registered once for all methods, so the request bodies are renamed by method.
+		renames := make(map[string]map[string]string)
+		if style.naming == CamelCase {
+			for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch} {
+				if doc := routeDocs[method+" "+path]; doc.request != nil {
+					renames[method] = invert(fieldNames(reflect.TypeOf(doc.request)))
+				}
+			}
+		}
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			if renames := renames[r.Method]; renames != nil && r.Body != nil && r.ContentLength != 0 {
+				r.Body = renameBody(r.Body, renames)
+				r.ContentLength = -1
+			}
+			h(&styledWriter{ResponseWriter: w, style: style}, r)
+		}
+	}
+	return routes
+}
+
+// renameBody renames the fields of a JSON request body, other bodies are left as they are
+func renameBody(body io.ReadCloser, renames map[string]string) io.ReadCloser {
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return io.NopCloser(io.MultiReader(bytes.NewReader(data), errReader{err}))
+	}
+	if renamed, err := renameFields(data, renames); err == nil {
+		data = renamed
+	}
+	return io.NopCloser(bytes.NewReader(data))
+}
+
+// errReader fails reads with err
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// encode encodes a response as JSON in the style, in the envelope as data
+func (st responseStyle) encode(v any) ([]byte, error) {
+	return st.encodeEnvelope(v, Envelope{Data: v})
+}
+
+// encodeError encodes an error response as JSON in the style, in the envelope as error
+func (st responseStyle) encodeError(resp ErrorResponse) ([]byte, error) {
+	return st.encodeEnvelope(resp, Envelope{Error: &resp})
+}
+
+func (st responseStyle) encodeEnvelope(v any, envelope Envelope) ([]byte, error) {
+	var data []byte
+	var err error
+	if st.envelope {
+		data, err = json.Marshal(envelope)
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil || st.naming != CamelCase {
+		return data, err
+	}
+	return renameFields(data, fieldNames(reflect.TypeOf(v)))
+}
+
+// fieldNamesCache caches fieldNames by type
+var fieldNamesCache sync.Map
+
+// fieldNames maps the snake_case JSON field names of the structs reachable from t to their camelCase names
+func fieldNames(t reflect.Type) map[string]string {
+	if t == nil {
+		return nil
+	}
+	if names, ok := fieldNamesCache.Load(t); ok {
+		return names.(map[string]string)
+	}
+	names := make(map[string]string)
+	collectFieldNames(t, names, make(map[reflect.Type]bool))
+	fieldNamesCache.Store(t, names)
+	return names
+}
+
+func collectFieldNames(t reflect.Type, names map[string]string, seen map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		collectFieldNames(t.Elem(), names, seen)
+	case reflect.Struct:
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if strings.Contains(name, "_") {
+				names[name] = snakeToCamel(name)
+			}
+			collectFieldNames(field.Type, names, seen)
+		}
+	}
+}
+
+func snakeToCamel(name string) string {
+	words := strings.Split(name, "_")
+	for i := 1; i < len(words); i++ {
+		if words[i] != "" {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+	return strings.Join(words, "")
+}
+
+func invert(m map[string]string) map[string]string {
+	inverted := make(map[string]string, len(m))
+	for k, v := range m {
+		inverted[v] = k
+	}
+	return inverted
+}
+
+// renameFields renames the keys of the objects of a JSON document
+func renameFields(data []byte, renames map[string]string) ([]byte, error) {
+	if len(renames) == 0 {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := decodeNode(dec)
+	if err != nil {
+		return nil, err
+	}
+	node.rename(renames)
+
+	var buf bytes.Buffer
+	node.writeJSON(&buf)
+	return buf.Bytes(), nil
+}
+
+func (n *jsonNode) rename(renames map[string]string) {
+	for i, item := range n.items {
+		if n.object {
+			if renamed, ok := renames[n.keys[i]]; ok {
+				n.keys[i] = renamed
+			}
+		}
+		item.rename(renames)
+	}
+}
+
+// writeJSON writes the node as compact JSON
+func (n *jsonNode) writeJSON(w *bytes.Buffer) {
+	switch {
+	case n.object:
+		w.WriteByte('{')
+		for i, item := range n.items {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			key, _ := json.Marshal(n.keys[i])
+			w.Write(key)
+			w.WriteByte(':')
+			item.writeJSON(w)
+		}
+		w.WriteByte('}')
+	case n.array:
+		w.WriteByte('[')
+		for i, item := range n.items {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			item.writeJSON(w)
+		}
+		w.WriteByte(']')
+	default:
+		scalar, _ := json.Marshal(n.scalar)
+		w.Write(scalar)
+	}
+}
@@ -0,0 +1,193 @@
+package flightrecorder
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsReloadInterval is how often the certificate files are checked for changes
+const tlsReloadInterval = 10 * time.Second
+
+// WithClientCA makes the dedicated server started by ListenAndServe require client certificates
+// signed by the CAs in the PEM file (mutual TLS). It requires WithTLS.
+// The CA file is reloaded when it changes, like the server certificate.
+func WithClientCA(caFile string) Option {
+	return func(o *options) {
+		o.listen.clientCAFile = caFile
+	}
+}
+
+// WithTLSConfig sets the base TLS configuration of the dedicated server, e.g. the minimum version
+// or cipher suites. Certificates and client verification are still configured by WithTLS and WithClientCA:
+// the Certificates of config are ignored, as they would take precedence over the reloaded certificate.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(o *options) {
+		o.listen.tlsConfig = config
+	}
+}
+
+// certReloader serves the server certificate and verifies client certificates from files,
+// reloading them when their modification time changes so rotated certificates are picked up
+// without a restart. When a reload fails the previous certificates are kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	onReload func(error) // called with the result of every reload after the first
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	certMod   time.Time
+	caMod     time.Time
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newCertReloader(certFile, keyFile, caFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// tlsConfig returns the server TLS configuration based on base
+func (r *certReloader) tlsConfig(base *tls.Config) *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		config = base.Clone()
+	}
+	// GetCertificate is only called without Certificates, which would silently disable reloads.
+	config.Certificates = nil
+	config.GetCertificate = r.getCertificate
+	if r.caFile != "" {
+		// Chains are verified against the current CA pool, so a rotated CA file applies to new connections.
+		config.ClientAuth = tls.RequireAnyClientCert
+		config.VerifyConnection = r.verifyClient
+	}
+	return config
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maybeReloadLocked(time.Now())
+	return r.cert, nil
+}
+
+func (r *certReloader) verifyClient(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("client certificate required")
+	}
+
+	r.mu.Lock()
+	r.maybeReloadLocked(time.Now())
+	pool := r.clientCAs
+	r.mu.Unlock()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid client certificate: %w", err)
+	}
+	return nil
+}
+
+// maybeReloadLocked reloads the files at most every tlsReloadInterval, r.mu must be held
+func (r *certReloader) maybeReloadLocked(now time.Time) {
+	if now.Sub(r.checkedAt) < tlsReloadInterval {
+		return
+	}
+	err := r.reloadLocked(now)
+	if r.onReload != nil {
+		r.onReload(err)
+	}
+}
+
+func (r *certReloader) reload(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.reloadLocked(now)
+}
+
+// reloadLocked loads the files which changed since they were last loaded, r.mu must be held
+func (r *certReloader) reloadLocked(now time.Time) error {
+	r.checkedAt = now
+
+	certMod, err := modTime(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert == nil || !certMod.Equal(r.certMod) {
+		cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		r.cert, r.certMod = &cert, certMod
+	}
+
+	if r.caFile == "" {
+		return nil
+	}
+	caMod, err := modTime(r.caFile)
+	if err != nil {
+		return err
+	}
+	if r.clientCAs == nil || !caMod.Equal(r.caMod) {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates in client CA file %s", r.caFile)
+		}
+		r.clientCAs, r.caMod = pool, caMod
+	}
+	return nil
+}
+
+// modTime returns the latest modification time of the files
+func modTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to check TLS file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// recordTLSReload records the result of reloading the TLS certificates
+func (h *serviceHealth) recordTLSReload(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tlsConfigured = true
+	h.tlsErr = err
+}
+
+func (h *serviceHealth) tlsReloadErr() (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.tlsConfigured, h.tlsErr
+}
@@ -0,0 +1,86 @@
+package flightrecorder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for name and its key to dir
+func writeCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfigIgnoresBaseCertificates(t *testing.T) {
+	dir := t.TempDir()
+	baseCert, err := tls.LoadX509KeyPair(writeCert(t, dir, "base.example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeCert(t, dir, "reloaded.example")
+	r, err := newCertReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := &tls.Config{MinVersion: tls.VersionTLS13, Certificates: []tls.Certificate{baseCert}}
+	config := r.tlsConfig(base)
+	if len(config.Certificates) != 0 {
+		t.Fatal("base Certificates kept, they take precedence over the reloaded certificate")
+	}
+	if config.MinVersion != tls.VersionTLS13 {
+		t.Fatalf("MinVersion %x, want the base configuration's", config.MinVersion)
+	}
+	if len(base.Certificates) != 1 {
+		t.Fatal("base configuration modified")
+	}
+
+	cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: "reloaded.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "reloaded.example" {
+		t.Fatalf("served certificate of %s, want the reloaded certificate", leaf.Subject.CommonName)
+	}
+}
@@ -0,0 +1,29 @@
+package flightrecorder
+
+import (
+	"context"
+	rtrace "runtime/trace"
+	"strings"
+)
+
+// EventLogCategory is the trace log category of the recorder events echoed into the trace, e.g. snapshots
+// and configuration changes, so a trace shows on its own timeline when operators used the recorder
+const EventLogCategory = "flightrecorder.event"
+
+// traceEvent logs a recorder event into the trace in the EventLogCategory
+func traceEvent(format string, args ...any) {
+	rtrace.Logf(context.Background(), EventLogCategory, format, args...)
+}
+
+// traceConfigChange logs a configuration change into the trace, e.g. "config by=alice period=1s->2s"
+func traceConfigChange(changes []ConfigFieldChange, principal string) {
+	var b strings.Builder
+	b.WriteString("config")
+	if principal != "" {
+		b.WriteString(" by=" + principal)
+	}
+	for _, c := range changes {
+		b.WriteString(" " + c.Field + "=" + c.From + "->" + c.To)
+	}
+	traceEvent("%s", b.String())
+}
@@ -0,0 +1,191 @@
+package flightrecorder
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrorEvent is the event name counted for every error passed to ReportError
+const ErrorEvent = "error"
+
+// EventTrigger captures a snapshot when reported events reach a threshold within a sliding window,
+// e.g. "5 errors of class X within 30s".
+type EventTrigger struct {
+	Name      string        // name of the trigger, recorded as the snapshot trigger
+	Event     string        // event name counted from ReportEvent, or ErrorEvent for all reported errors
+	Error     error         // when set, counts reported errors matching it with errors.Is instead of Event
+	Threshold int           // number of events within Window which fires the trigger
+	Window    time.Duration // sliding window events are counted over
+	Cooldown  time.Duration // minimum time between two snapshots of this trigger
+}
+
+func (t EventTrigger) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("invalid trigger: name is required")
+	}
+	if t.Event == "" && t.Error == nil {
+		return fmt.Errorf("invalid trigger %s: event or error is required", t.Name)
+	}
+	if t.Threshold <= 0 {
+		return fmt.Errorf("invalid trigger %s: threshold must be positive", t.Name)
+	}
+	if t.Window <= 0 {
+		return fmt.Errorf("invalid trigger %s: window must be positive", t.Name)
+	}
+	return nil
+}
+
+func (t EventTrigger) matchesError(err error) bool {
+	if t.Error != nil {
+		return errors.Is(err, t.Error)
+	}
+	return t.Event == ErrorEvent
+}
+
+func (t EventTrigger) matchesEvent(name string) bool {
+	return t.Error == nil && t.Event == name
+}
+
+// eventCounter counts events of a trigger over its sliding window
+type eventCounter struct {
+	trigger EventTrigger
+	times   []time.Time
+}
+
+// add records an event and reports whether the trigger threshold was reached,
+// in which case the window starts over.
+func (c *eventCounter) add(now time.Time) bool {
+	cutoff := now.Add(-c.trigger.Window)
+	i := 0
+	for i < len(c.times) && !c.times[i].After(cutoff) {
+		i++
+	}
+	c.times = append(c.times[i:], now)
+
+	if len(c.times) >= c.trigger.Threshold {
+		c.times = nil
+		return true
+	}
+	return false
+}
+
+// triggerSet holds the event triggers of the service
+type triggerSet struct {
+	mu       sync.Mutex
+	counters []*eventCounter
+}
+
+// AddEventTrigger registers a trigger fed by ReportError and ReportEvent
+func (s *Service) AddEventTrigger(t EventTrigger) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	s.triggers.mu.Lock()
+	defer s.triggers.mu.Unlock()
+
+	s.triggers.counters = append(s.triggers.counters, &eventCounter{trigger: t})
+	return nil
+}
+
+// ReportError reports an application error to the event triggers
+func (s *Service) ReportError(err error) {
+	if err == nil {
+		return
+	}
+	s.report(func(t EventTrigger) bool { return t.matchesError(err) })
+}
+
+// ReportEvent reports a named application event to the event triggers
+func (s *Service) ReportEvent(name string) {
+	s.report(func(t EventTrigger) bool { return t.matchesEvent(name) })
+}
+
+func (s *Service) report(match func(EventTrigger) bool) {
+	now := time.Now()
+	var fired []EventTrigger
+
+	s.triggers.mu.Lock()
+	for _, c := range s.triggers.counters {
+		if match(c.trigger) && c.add(now) {
+			fired = append(fired, c.trigger)
+		}
+	}
+	s.triggers.mu.Unlock()
+
+	for _, t := range fired {
+		s.goBackground(func() { s.fireTrigger(t.Name, t.Cooldown) })
+	}
+}
+
+// triggerLimiter enforces trigger cooldowns and the hourly snapshot budget
+type triggerLimiter struct {
+	mu        sync.Mutex
+	lastFired map[string]time.Time
+	lastAny   time.Time
+	fired     []time.Time // snapshots taken by triggers within the last hour
+}
+
+// allow reports whether the trigger may capture a snapshot now and records it if so
+func (l *triggerLimiter) allow(name string, cooldown time.Duration, o options, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if last, ok := l.lastFired[name]; ok && cooldown > 0 && now.Sub(last) < cooldown {
+		return false
+	}
+	if o.triggerCooldown > 0 && !l.lastAny.IsZero() && now.Sub(l.lastAny) < o.triggerCooldown {
+		return false
+	}
+	l.prune(now)
+	if o.triggerBudget > 0 && len(l.fired) >= o.triggerBudget {
+		return false
+	}
+
+	if l.lastFired == nil {
+		l.lastFired = make(map[string]time.Time)
+	}
+	l.lastFired[name] = now
+	l.lastAny = now
+	l.fired = append(l.fired, now)
+	return true
+}
+
+// remaining returns the number of snapshots triggers may still take this hour
+func (l *triggerLimiter) remaining(budget int, now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	return max(budget-len(l.fired), 0)
+}
+
+func (l *triggerLimiter) prune(now time.Time) {
+	cutoff := now.Add(-time.Hour)
+	i := 0
+	for i < len(l.fired) && !l.fired[i].After(cutoff) {
+		i++
+	}
+	l.fired = l.fired[i:]
+}
+
+// fireTrigger captures a snapshot for the named trigger and publishes the outcome,
+// unless the recorder gate refuses, a cooldown is active or the hourly budget is spent.
+func (s *Service) fireTrigger(name string, cooldown time.Duration) {
+	if s.gateRefuses() || !s.limiter.allow(name, cooldown, s.opts, time.Now()) {
+		return
+	}
+
+	e := Event{Type: EventTriggerFired, Trigger: name}
+
+	meta, err := s.Capture(name)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if meta.ID != "" {
+		e.Snapshot = &meta
+	}
+	s.publish(e)
+}
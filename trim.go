@@ -0,0 +1,157 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Events of the Go execution trace format holding the trace clock frequency: in a batch of its
+// own before Go 1.25, in the sync batch starting generations since
+const (
+	traceEvFrequency     = 8
+	traceEvSync          = 50
+	traceEvClockSnapshot = 51
+)
+
+// traceBatch is a batch of a Go execution trace, or the marker of the end of a generation
+type traceBatch struct {
+	typ  byte
+	gen  uint64 // generation of the batch
+	ts   uint64 // timestamp of the batch, in trace clock ticks
+	data []byte // events of the batch
+	raw  []byte // the batch as encoded in the trace
+}
+
+// readTraceBatches splits a trace into its header and batches, without parsing the events of the batches
+func readTraceBatches(data []byte) ([]byte, []traceBatch, error) {
+	end := bytes.Index(data, []byte(traceHeaderSuffix))
+	if !bytes.HasPrefix(data, []byte("go 1.")) || end < 0 || end > 16 {
+		return nil, nil, fmt.Errorf("%w: not a Go execution trace", ErrInvalidSnapshot)
+	}
+	end += len(traceHeaderSuffix)
+
+	var batches []traceBatch
+	var gen uint64
+	r := bytes.NewReader(data[end:])
+	for r.Len() > 0 {
+		start := len(data) - r.Len()
+		typ, _ := r.ReadByte()
+		if typ == traceEvEndOfGeneration {
+			batches = append(batches, traceBatch{typ: typ, gen: gen, raw: data[start : start+1]})
+			continue
+		}
+		if typ != traceEvEventBatch && typ != traceEvExperimentalBatch {
+			return nil, nil, fmt.Errorf("%w: unexpected event %d at offset %d", ErrInvalidSnapshot, typ, start)
+		}
+		if typ == traceEvExperimentalBatch {
+			if _, err := r.ReadByte(); err != nil {
+				return nil, nil, fmt.Errorf("%w: truncated batch", ErrInvalidSnapshot)
+			}
+		}
+
+		var header [4]uint64 // generation, M ID, timestamp, size
+		for i := range header {
+			v, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: truncated batch header", ErrInvalidSnapshot)
+			}
+			header[i] = v
+		}
+		size := header[3]
+		if size > uint64(r.Len()) {
+			return nil, nil, fmt.Errorf("%w: truncated batch", ErrInvalidSnapshot)
+		}
+		batchStart := len(data) - r.Len()
+		r.Seek(int64(size), io.SeekCurrent)
+
+		gen = header[0]
+		batches = append(batches, traceBatch{
+			typ:  typ,
+			gen:  gen,
+			ts:   header[2],
+			data: data[batchStart : batchStart+int(size)],
+			raw:  data[start : batchStart+int(size)],
+		})
+	}
+	return data[:end], batches, nil
+}
+
+// TrimTrace keeps the trailing window of a trace, ending at its last batch, to produce smaller files when
+// the buffer holds far more history than needed. Traces are made of generations, self-contained spans of
+// about a second, so whole generations are kept: the trimmed trace covers the window and up to a generation
+// more. A trace shorter than the window is returned as is.
+func TrimTrace(data []byte, last time.Duration) ([]byte, error) {
+	header, batches, err := readTraceBatches(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The clock frequency, in ticks per second, converts the window to the timestamps of the batches.
+	var freq, latest uint64
+	for _, b := range batches {
+		latest = max(latest, b.ts)
+		if freq == 0 && b.typ == traceEvEventBatch {
+			freq = traceFrequency(b.data)
+		}
+	}
+	if freq == 0 {
+		return nil, fmt.Errorf("%w: trace without clock frequency", ErrInvalidSnapshot)
+	}
+	ticks := uint64(last.Seconds() * float64(freq))
+	if ticks >= latest {
+		return data, nil
+	}
+	cutoff := latest - ticks
+
+	// A generation is kept when its last batch is within the window, and so are the generations after it.
+	ends := make(map[uint64]uint64)
+	for _, b := range batches {
+		ends[b.gen] = max(ends[b.gen], b.ts)
+	}
+	first, found := uint64(0), false
+	for _, b := range batches {
+		if ends[b.gen] >= cutoff && (!found || b.gen < first) {
+			first, found = b.gen, true
+		}
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(header)
+	for _, b := range batches {
+		if b.gen >= first {
+			out.Write(b.raw)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// traceFrequency returns the trace clock frequency of a frequency or sync batch, 0 for other batches
+func traceFrequency(batch []byte) uint64 {
+	if len(batch) == 0 || batch[0] != traceEvFrequency && batch[0] != traceEvSync {
+		return 0
+	}
+	r := bytes.NewReader(batch)
+	if batch[0] == traceEvSync {
+		r.ReadByte()
+	}
+	for r.Len() > 0 {
+		typ, _ := r.ReadByte()
+		switch typ {
+		case traceEvFrequency:
+			freq, _ := binary.ReadUvarint(r)
+			return freq
+		case traceEvClockSnapshot: // timestamp, mono, sec, nsec
+			for range 4 {
+				if _, err := binary.ReadUvarint(r); err != nil {
+					return 0
+				}
+			}
+		default:
+			return 0
+		}
+	}
+	return 0
+}
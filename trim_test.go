@@ -0,0 +1,132 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// recordTrace records a trace spanning several generations with a real flight recorder
+func recordTrace(t *testing.T) []byte {
+	t.Helper()
+	recorder := trace.NewFlightRecorder()
+	if err := recorder.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Stop()
+	busy(2500 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if _, err := recorder.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// busy schedules goroutines for d, so the trace has events in every generation
+func busy(d time.Duration) {
+	for deadline := time.Now().Add(d); time.Now().Before(deadline); {
+		done := make(chan struct{})
+		go close(done)
+		<-done
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// parseTrace reads every event of a trace, failing the test if it does not parse
+func parseTrace(t *testing.T, data []byte) []trace.Event {
+	t.Helper()
+	reader, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []trace.Event
+	for {
+		ev, err := reader.ReadEvent()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("trace of %d bytes is corrupt after %d events: %v", len(data), len(events), err)
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestTrimTraceParses(t *testing.T) {
+	data := recordTrace(t)
+
+	trimmed, err := TrimTrace(data, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trimmed) >= len(data) {
+		t.Fatalf("trimmed trace of %d bytes, want less than the %d bytes of the trace", len(trimmed), len(data))
+	}
+	if events := parseTrace(t, trimmed); len(events) == 0 {
+		t.Fatal("trimmed trace has no events")
+	}
+
+	if whole, err := TrimTrace(data, time.Hour); err != nil || !bytes.Equal(whole, data) {
+		t.Fatalf("trace shorter than the window changed by TrimTrace (err %v)", err)
+	}
+}
+
+func TestSnapshotLastTrimmedBeforeHooks(t *testing.T) {
+	s := NewService(WithSnapshotValidation())
+	t.Cleanup(func() { s.Close() })
+	var hooked []SnapshotMeta
+	var hookedData [][]byte
+	s.OnSnapshot(func(meta SnapshotMeta, data io.Reader) {
+		b, _ := io.ReadAll(data)
+		hooked = append(hooked, meta)
+		hookedData = append(hookedData, b)
+	})
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	busy(2500 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/recorder/snapshot?last=100ms", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /snapshot?last=100ms: got %d, want 200: %s", w.Code, w.Body)
+	}
+	body := w.Body.Bytes()
+	parsed := parseTrace(t, body)
+
+	if len(hooked) != 1 {
+		t.Fatalf("%d OnSnapshot hooks ran, want 1", len(hooked))
+	}
+	if !bytes.Equal(hookedData[0], body) || hooked[0].Size != int64(len(body)) {
+		t.Fatalf("OnSnapshot hook got %d bytes (size %d), want the %d bytes of the trimmed snapshot", len(hookedData[0]), hooked[0].Size, len(body))
+	}
+	if got := w.Header().Get(HeaderSnapshotEvents); hooked[0].Events != len(parsed) || got != strconv.Itoa(len(parsed)) {
+		t.Fatalf("OnSnapshot hook got %d events, %s %q, want the %d events of the trimmed snapshot", hooked[0].Events, HeaderSnapshotEvents, got, len(parsed))
+	}
+
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type != EventSnapshot {
+				continue
+			}
+			if ev.Snapshot.Size != int64(len(body)) || ev.Snapshot.SHA256 != SnapshotChecksum(body) {
+				t.Fatalf("snapshot event of %d bytes, want the %d bytes of the trimmed snapshot", ev.Snapshot.Size, len(body))
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("no snapshot event")
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/exp/trace"
+)
+
+// ErrInvalidSnapshot is returned when snapshot validation finds an empty or corrupt trace
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// WithSnapshotValidation parses every snapshot before it is served or stored,
+// rejecting empty or corrupt traces with ErrInvalidSnapshot.
+// The number of events is recorded in the snapshot metadata.
+func WithSnapshotValidation() Option {
+	return func(o *options) {
+		o.validateSnapshots = true
+	}
+}
+
+// validateTrace parses the trace and returns its number of events
+func validateTrace(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("%w: trace is empty", ErrInvalidSnapshot)
+	}
+
+	reader, err := trace.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	events := 0
+	for {
+		_, err := reader.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return events, fmt.Errorf("%w: trace of %d bytes is corrupt after %d events: %v", ErrInvalidSnapshot, len(data), events, err)
+		}
+		events++
+	}
+	if events == 0 {
+		return 0, fmt.Errorf("%w: trace has no events", ErrInvalidSnapshot)
+	}
+	return events, nil
+}
+
+// setEventsHeader sets the event count header of validated snapshots
+func setEventsHeader(w http.ResponseWriter, meta SnapshotMeta) {
+	if meta.Events > 0 {
+		w.Header().Set(HeaderSnapshotEvents, strconv.Itoa(meta.Events))
+	}
+}
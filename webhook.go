@@ -0,0 +1,262 @@
+package flightrecorder
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWebhookRetries = 3
+	defaultWebhookBackoff = 500 * time.Millisecond
+
+	// defaultWebhookTolerance is the maximum age of a webhook accepted by VerifyWebhook
+	defaultWebhookTolerance = 5 * time.Minute
+)
+
+// Webhook headers. The signature is "sha256=" followed by the hex HMAC-SHA256
+// of the timestamp, a dot and the body, so receivers can reject replayed requests.
+const (
+	HeaderWebhookEvent     = "X-Flightrecorder-Event"
+	HeaderWebhookTimestamp = "X-Flightrecorder-Timestamp" // Unix seconds
+	HeaderWebhookSignature = "X-Flightrecorder-Signature"
+)
+
+// ErrWebhookSignature is returned by VerifyWebhook for unsigned, expired or forged requests
+var ErrWebhookSignature = errors.New("invalid webhook signature")
+
+// defaultWebhookEvents are the events posted to webhooks without Events
+var defaultWebhookEvents = []EventType{EventStarted, EventStopped, EventSnapshotStored, EventTriggerFired}
+
+// Webhook POSTs a JSON WebhookPayload to a URL on service events, e.g. to notify a team
+// when a trigger captured a snapshot. Failed deliveries (network errors, 429 and 5xx responses)
+// are retried with exponential backoff. Events are dropped for webhooks which fall behind.
+type Webhook struct {
+	URL     string
+	Headers map[string]string // sent with every request, e.g. Authorization
+	Secret  []byte            // signs requests with HMAC-SHA256 when set, see VerifyWebhook
+	// Events are the events posted (default started, stopped, snapshot_stored and trigger_fired)
+	Events []EventType
+	// BaseURL is where the recorder endpoints are served, e.g. https://checkout.internal/recorder,
+	// to link stored snapshots in payloads
+	BaseURL string
+	// Encode builds the request body from the payload, e.g. for chat or paging services (default JSON)
+	Encode func(WebhookPayload) ([]byte, error)
+	// Summarize adds the summary of stored snapshots to payloads, for Encode to report
+	Summarize  bool
+	Client     *http.Client  // defaults to a client with a 10s timeout
+	MaxRetries int           // retries after the first attempt (default 3, negative disables retries)
+	Backoff    time.Duration // initial backoff, doubled on every retry (default 500ms)
+}
+
+// WebhookPayload is the JSON body posted to webhooks: the event, where it happened
+// and, for stored snapshots, their ID and download URL
+type WebhookPayload struct {
+	Event
+	Hostname    string            `json:"hostname"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	SnapshotID  string            `json:"snapshot_id,omitempty"`
+	DownloadURL string            `json:"download_url,omitempty"`
+	// Summary summarizes the stored snapshot when the webhook sets Summarize
+	Summary *TraceSummary `json:"-"`
+}
+
+// WithWebhook posts service events to a webhook. Can be used more than once.
+func WithWebhook(w Webhook) Option {
+	return func(o *options) {
+		o.webhooks = append(o.webhooks, w)
+	}
+}
+
+func (w Webhook) wants(t EventType) bool {
+	if len(w.Events) == 0 {
+		return slices.Contains(defaultWebhookEvents, t)
+	}
+	return slices.Contains(w.Events, t)
+}
+
+// payload returns the payload of an event. Snapshots are only linked once stored,
+// live snapshots can't be downloaded later.
+func (w Webhook) payload(e Event, hostname string, labels map[string]string) WebhookPayload {
+	p := WebhookPayload{Event: e, Hostname: hostname, Labels: labels}
+	if e.Snapshot == nil {
+		return p
+	}
+	p.SnapshotID = e.Snapshot.ID
+	if w.BaseURL != "" && storedSnapshotEvent(e.Type) {
+		p.DownloadURL = strings.TrimSuffix(w.BaseURL, "/") + "/" + APIVersion + "/snapshots/" + url.PathEscape(e.Snapshot.ID)
+	}
+	return p
+}
+
+// storedSnapshotEvent reports whether the snapshot of events of the type is kept in the snapshot store
+func storedSnapshotEvent(t EventType) bool {
+	return t == EventSnapshotStored || t == EventTriggerFired || t == EventSnapshotDownloaded
+}
+
+// runWebhook delivers the events of a subscription to the webhook until ctx is done
+func (s *Service) runWebhook(ctx context.Context, w Webhook, events chan Event) {
+	defer s.events.unsubscribe(events)
+
+	for {
+		var e Event
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			e = ev
+		}
+		if !w.wants(e.Type) {
+			continue
+		}
+		s.mu.RLock()
+		labels := maps.Clone(s.labels)
+		s.mu.RUnlock()
+
+		p := w.payload(e, s.hostname, labels)
+		if w.Summarize && e.Snapshot != nil && storedSnapshotEvent(e.Type) {
+			// Snapshots deleted since can't be summarized, the payload is sent without summary.
+			if _, summary, err := s.SnapshotSummary(e.Snapshot.ID); err == nil {
+				p.Summary = &summary
+			}
+		}
+		encode := w.Encode
+		if encode == nil {
+			encode = func(p WebhookPayload) ([]byte, error) { return json.Marshal(p) }
+		}
+		body, err := encode(p)
+		if err == nil {
+			err = w.deliver(ctx, e.Type, body)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		s.health.recordWebhookDelivery(err)
+	}
+}
+
+// deliver posts the body, retrying transient failures until they succeed,
+// the retries are exhausted or ctx is done
+func (w Webhook) deliver(ctx context.Context, event EventType, body []byte) error {
+	backoff := w.Backoff
+	if backoff <= 0 {
+		backoff = defaultWebhookBackoff
+	}
+	retries := w.MaxRetries
+	if retries == 0 {
+		retries = defaultWebhookRetries
+	}
+	retries = max(retries, 0)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = w.post(ctx, event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (last error: %w)", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failure is worth retrying
+func (w Webhook) post(ctx context.Context, event EventType, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookEvent, string(event))
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+	if len(w.Secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderWebhookTimestamp, timestamp)
+		req.Header.Set(HeaderWebhookSignature, SignWebhook(w.Secret, timestamp, body))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("failed to deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("failed to deliver webhook: %s returned %s: %s", w.URL, resp.Status, bytes.TrimSpace(respBody))
+}
+
+func (h *serviceHealth) recordWebhookDelivery(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.webhookErr = err
+}
+
+func (h *serviceHealth) webhookDeliveryErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.webhookErr
+}
+
+// SignWebhook returns the signature of a webhook body sent at the timestamp in Unix seconds
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook request body received with the headers,
+// rejecting requests signed more than 5 minutes ago
+func VerifyWebhook(secret []byte, header http.Header, body []byte) error {
+	timestamp := header.Get(HeaderWebhookTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing or invalid timestamp", ErrWebhookSignature)
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > defaultWebhookTolerance || age < -defaultWebhookTolerance {
+		return fmt.Errorf("%w: timestamp is %v old", ErrWebhookSignature, age.Round(time.Second))
+	}
+	if !hmac.Equal([]byte(header.Get(HeaderWebhookSignature)), []byte(SignWebhook(secret, timestamp, body))) {
+		return fmt.Errorf("%w: signature mismatch", ErrWebhookSignature)
+	}
+	return nil
+}